@@ -7,6 +7,7 @@ import (
 	"chatelly-backend/internal/database"
 	"chatelly-backend/internal/models"
 	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/utils"
 )
 
 func main() {
@@ -27,7 +28,11 @@ func main() {
 	}
 
 	// Create auth service
-	authService := services.NewAuthService(database.DB, cfg)
+	keys, err := utils.LoadKeys(cfg.JWT)
+	if err != nil {
+		log.Fatal("Failed to load JWT signing keys:", err)
+	}
+	authService := services.NewAuthService(database.DB, cfg, keys)
 
 	// Create test user
 	testUser := &models.UserCreateRequest{
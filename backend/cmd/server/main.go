@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"time"
 
 	"chatelly-backend/internal/config"
 	"chatelly-backend/internal/database"
 	"chatelly-backend/internal/handlers"
 	"chatelly-backend/internal/middleware"
+	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/live"
+	"chatelly-backend/pkg/mailer"
+	"chatelly-backend/pkg/ratelimit"
 	"chatelly-backend/pkg/redis"
+	"chatelly-backend/pkg/storage"
+	"chatelly-backend/pkg/utils"
 	"chatelly-backend/pkg/websocket"
 
 	"github.com/gin-gonic/gin"
@@ -21,6 +29,13 @@ func main() {
 		log.Fatal("Failed to load configuration:", err)
 	}
 
+	// Tokens are signed and verified with these keys
+	keys, err := utils.LoadKeys(cfg.JWT)
+	if err != nil {
+		log.Fatal("Failed to load JWT signing keys:", err)
+	}
+	log.Printf("Signing tokens with key %s", keys.SigningKeyID())
+
 	// Connect to database
 	if err := database.Connect(cfg); err != nil {
 		log.Fatal("Failed to connect to database:", err)
@@ -31,16 +46,133 @@ func main() {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	// Full-text search over messages lives outside the GORM schema
+	searchService := services.NewSearchService(database.DB, cfg)
+	if err := searchService.EnsureIndex(); err != nil {
+		log.Fatal("Failed to set up message search:", err)
+	}
+	log.Printf("Message search using %s index", searchService.IndexName())
+
 	// Connect to Redis
+	redisConnected := true
 	if err := redis.Connect(cfg); err != nil {
 		log.Printf("Warning: Failed to connect to Redis: %v", err)
-		log.Println("Rate limiting will be disabled")
+		log.Println("Rate limits will be kept in memory on this server")
+		redisConnected = false
+	}
+
+	// Rate limits are shared by every server through Redis, and kept in memory
+	// while it is unreachable
+	var limiter ratelimit.Limiter = ratelimit.NewMemoryLimiter()
+	if redisConnected {
+		limiter = ratelimit.NewFallbackLimiter(ratelimit.NewRedisLimiter(redis.Client), limiter)
+	}
+
+	// Set up object storage for exports and chat attachments
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Fatal("Failed to set up storage:", err)
+	}
+
+	// Export jobs do not survive restarts; expired files are removed hourly
+	exportService := services.NewExportService(database.DB, cfg, store)
+	if err := exportService.FailInterruptedJobs(); err != nil {
+		log.Printf("Warning: Failed to mark interrupted export jobs: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := exportService.CleanupExpiredExports(context.Background()); err != nil {
+				log.Printf("Failed to clean up expired exports: %v", err)
+			}
+		}
+	}()
+
+	// Owner notifications are logged instead of sent when SMTP is not configured
+	mail := mailer.New(cfg)
+	if cfg.SMTP.Host == "" {
+		log.Println("SMTP is not configured; emails will be logged")
 	}
 
 	// Create WebSocket hub
 	hub := websocket.NewHub()
 	go hub.Run()
 
+	// Snoozed chats reopen once their snooze time passes
+	chatService := services.NewChatService(database.DB, cfg)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := chatService.WakeSnoozedChats(); err != nil {
+				log.Printf("Failed to wake snoozed chats: %v", err)
+			}
+		}
+	}()
+
+	// Agent consoles drive presence: the first connection brings an agent
+	// online, losing the last takes them offline
+	assignmentService := services.NewAssignmentService(database.DB, cfg, hub)
+	hub.SetAgentPresenceHandler(func(userID uint, connected bool) {
+		if err := assignmentService.HandleConsoleConnection(userID, connected); err != nil {
+			log.Printf("Failed to update presence for agent %d: %v", userID, err)
+		}
+	})
+
+	// Messages and receipts sent over the hub are stored with the chat
+	hub.SetMessageStore(services.NewHubMessageStore(database.DB, cfg))
+
+	// Reconnecting widgets replay what they missed from Redis, shared by all
+	// servers; the hub keeps the events in memory otherwise
+	if redisConnected {
+		hub.SetSessionBuffer(websocket.NewRedisBuffer(redis.Client, websocket.DefaultSessionBufferSize, websocket.DefaultSessionBufferTTL))
+	}
+
+	// Floods the hub refuses are recorded as error events
+	analyticsService := services.NewAnalyticsService(database.DB, cfg)
+	hub.SetLimitHandler(func(hit websocket.LimitHit) {
+		if err := analyticsService.RecordLimitHit(hit); err != nil {
+			log.Printf("Failed to record %s limit hit for website %d: %v", hit.Limit, hit.WebsiteID, err)
+		}
+	})
+
+	// Visitor messages matching a canned response's keywords get a bot reply
+	// when the website has bot replies turned on
+	cannedService := services.NewCannedResponseService(database.DB, cfg)
+	hub.SetBotResponder(func(websiteID uint, sessionID, content string) (string, bool) {
+		chat, err := chatService.GetChatBySession(websiteID, sessionID)
+		if err != nil {
+			return "", false
+		}
+		message, err := cannedService.BotReply(chat.ID, content)
+		if err != nil {
+			log.Printf("Failed to send bot reply in chat %d: %v", chat.ID, err)
+			return "", false
+		}
+		if message == nil {
+			return "", false
+		}
+		return message.Content, true
+	})
+
+	// Live analytics feed for dashboards, fed by services and the hub
+	feed := live.NewFeed(hub, live.DefaultInterval)
+	live.Default = feed
+	go feed.Run()
+
+	// API calls are metered against plan quotas and written in batches
+	usageService := services.NewUsageService(database.DB, cfg)
+	go func() {
+		ticker := time.NewTicker(services.UsageFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := usageService.Flush(); err != nil {
+				log.Printf("Failed to write API usage: %v", err)
+			}
+		}
+	}()
+
 	// Setup Gin router
 	if cfg.Server.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -48,6 +180,11 @@ func main() {
 
 	router := gin.Default()
 
+	// Client IPs come from X-Forwarded-For only when a trusted proxy sent it
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	// Add middleware
 	router.Use(middleware.RequestID())
 	router.Use(middleware.SecurityHeaders())
@@ -65,35 +202,64 @@ func main() {
 	})
 
 	// Initialize handlers
-	authHandlers := handlers.NewAuthHandlers(cfg)
+	authHandlers := handlers.NewAuthHandlers(cfg, mail, keys)
 	websiteHandlers := handlers.NewWebsiteHandlers(cfg)
-	chatHandlers := handlers.NewChatHandlers(cfg)
-	widgetHandlers := handlers.NewWidgetHandlers(cfg)
+	chatHandlers := handlers.NewChatHandlers(cfg, hub, mail)
+	widgetHandlers := handlers.NewWidgetHandlers(cfg, hub, mail)
 	analyticsHandlers := handlers.NewAnalyticsHandlers(cfg)
+	funnelHandlers := handlers.NewFunnelHandlers(cfg)
+	exportHandlers := handlers.NewExportHandlers(cfg, store)
+	liveHandlers := handlers.NewLiveHandlers(cfg, feed)
+	agentHandlers := handlers.NewAgentHandlers(cfg, hub)
+	contactHandlers := handlers.NewContactHandlers(cfg)
+	ticketHandlers := handlers.NewTicketHandlers(cfg, mail)
+	attachmentHandlers := handlers.NewAttachmentHandlers(cfg, store, hub)
+	cannedHandlers := handlers.NewCannedResponseHandlers(cfg)
+	usageHandlers := handlers.NewUsageHandlers(cfg, usageService)
+
+	// Public keys for other services to verify tokens with
+	router.GET("/.well-known/jwks.json", authHandlers.GetJWKS)
 
 	// API routes with rate limiting
 	api := router.Group("/api/v1")
-	api.Use(middleware.APIRateLimit(cfg))
+	api.Use(middleware.APIRateLimit(cfg, keys, limiter))
 	api.Use(middleware.ValidateContentType("application/json", "multipart/form-data"))
 	{
-		// Auth routes with brute force protection
+		// Auth routes; sign-ins are throttled by account and IP
 		auth := api.Group("/auth")
-		auth.Use(middleware.BruteForceProtection(cfg))
 		{
 			auth.POST("/register", authHandlers.Register)
 			auth.POST("/login", authHandlers.Login)
 			auth.POST("/refresh", authHandlers.RefreshToken)
 			auth.POST("/logout", authHandlers.Logout)
+			auth.POST("/mfa/verify", authHandlers.VerifyMFA)
+			auth.POST("/mfa/setup", authHandlers.BeginMFAEnrollment)
+			auth.POST("/mfa/enable", authHandlers.CompleteMFAEnrollment)
+			auth.GET("/sso/providers", authHandlers.GetSSOProviders)
+			auth.GET("/sso/:provider", authHandlers.BeginSSO)
+			auth.GET("/sso/:provider/callback", authHandlers.SSOCallback)
+			auth.POST("/sso/exchange", authHandlers.ExchangeSSO)
 		}
 
 		// Protected routes
 		protected := api.Group("/")
-		protected.Use(middleware.AuthRequired(cfg))
+		protected.Use(middleware.AuthRequired(keys), middleware.APIQuota(usageService))
 		{
 			// User routes
 			protected.GET("/user/profile", authHandlers.GetProfile)
 			protected.PUT("/user/profile", authHandlers.UpdateProfile)
-			protected.POST("/user/change-password", authHandlers.ChangePassword)
+			protected.POST("/user/change-password", middleware.RequireReauth(keys), authHandlers.ChangePassword)
+			protected.GET("/user/security-log", authHandlers.GetSecurityLog)
+			protected.POST("/user/reauthenticate", authHandlers.Reauthenticate)
+			protected.GET("/user/identities", authHandlers.GetIdentities)
+
+			// Two-factor authentication
+			protected.GET("/user/mfa", authHandlers.GetMFAStatus)
+			protected.POST("/user/mfa/setup", authHandlers.SetupMFA)
+			protected.POST("/user/mfa/enable", authHandlers.EnableMFA)
+			protected.POST("/user/mfa/disable", middleware.RequireReauth(keys), authHandlers.DisableMFA)
+			protected.POST("/user/mfa/recovery-codes", middleware.RequireReauth(keys), authHandlers.RegenerateRecoveryCodes)
+			protected.PUT("/user/mfa/enforcement", authHandlers.UpdateMFAEnforcement)
 
 			// Website routes
 			protected.GET("/websites", websiteHandlers.GetWebsites)
@@ -106,19 +272,69 @@ func main() {
 			protected.POST("/websites/:id/toggle-status", websiteHandlers.ToggleWebsiteStatus)
 			protected.GET("/websites/:id/stats", websiteHandlers.GetWebsiteStats)
 
-			protected.POST("/websites/:id/regenerate-key", websiteHandlers.RegenerateWidgetKey)
+			protected.POST("/websites/:id/regenerate-key", middleware.RequireReauth(keys), websiteHandlers.RegenerateWidgetKey)
+			protected.GET("/websites/:id/identity-secret", websiteHandlers.GetIdentitySecret)
+			protected.POST("/websites/:id/identity-secret/regenerate", middleware.RequireReauth(keys), websiteHandlers.RegenerateIdentitySecret)
 
 			// Chat routes
 			protected.GET("/websites/:id/chats", chatHandlers.GetChats)
 			protected.GET("/websites/:id/chats/search", chatHandlers.SearchChats)
+			protected.GET("/websites/:id/messages/search", chatHandlers.SearchMessages)
 			protected.GET("/websites/:id/chats/active", chatHandlers.GetActiveChats)
 			protected.GET("/websites/:id/chats/stats", chatHandlers.GetChatStats)
 			protected.GET("/websites/:id/chats/analytics", chatHandlers.GetChatAnalytics)
 			protected.GET("/chats/:id", chatHandlers.GetChat)
+			protected.PUT("/chats/:id", chatHandlers.UpdateChat)
 			protected.GET("/chats/:id/messages", chatHandlers.GetMessages)
+			protected.GET("/chats/:id/transcript", chatHandlers.GetTranscript)
+			protected.POST("/chats/:id/attachments", attachmentHandlers.UploadChatAttachment)
+			protected.GET("/chats/:id/attachments/:attachment_id", attachmentHandlers.DownloadChatAttachment)
 			protected.POST("/chats/:id/end", chatHandlers.EndChat)
+			protected.POST("/chats/:id/assign", chatHandlers.AssignChat)
+			protected.POST("/chats/:id/transfer", chatHandlers.TransferChat)
+			protected.POST("/chats/:id/unassign", chatHandlers.UnassignChat)
+			protected.GET("/chats/:id/notes", chatHandlers.GetNotes)
+			protected.POST("/chats/:id/notes", chatHandlers.CreateNote)
+			protected.PUT("/chats/:id/notes/:note_id", chatHandlers.UpdateNote)
+			protected.DELETE("/chats/:id/notes/:note_id", chatHandlers.DeleteNote)
 			protected.POST("/messages/:id/flag", chatHandlers.FlagMessage)
 
+			// Agent and routing routes
+			protected.GET("/websites/:id/agents", agentHandlers.GetAgents)
+			protected.POST("/websites/:id/agents", agentHandlers.AddAgent)
+			protected.PUT("/websites/:id/agents/:agent_id", agentHandlers.UpdateAgent)
+			protected.DELETE("/websites/:id/agents/:agent_id", agentHandlers.RemoveAgent)
+			protected.GET("/websites/:id/sso-domains", agentHandlers.GetSSODomains)
+			protected.POST("/websites/:id/sso-domains", agentHandlers.AddSSODomain)
+			protected.DELETE("/websites/:id/sso-domains/:domain_id", agentHandlers.RemoveSSODomain)
+			protected.POST("/websites/:id/sso-domains/:domain_id/verify", agentHandlers.VerifySSODomain)
+			protected.GET("/websites/:id/queue", agentHandlers.GetQueue)
+			protected.PUT("/websites/:id/routing", agentHandlers.UpdateRouting)
+			protected.GET("/agents/me/status", agentHandlers.GetMyStatus)
+			protected.PUT("/agents/me/status", agentHandlers.UpdateMyStatus)
+			protected.GET("/agents/me/chats", agentHandlers.GetMyChats)
+
+			// Contact routes
+			protected.GET("/websites/:id/contacts", contactHandlers.GetContacts)
+			protected.GET("/websites/:id/contacts/:contact_id", contactHandlers.GetContact)
+			protected.PUT("/websites/:id/contacts/:contact_id", contactHandlers.UpdateContact)
+			protected.DELETE("/websites/:id/contacts/:contact_id", contactHandlers.DeleteContact)
+			protected.POST("/websites/:id/contacts/:contact_id/merge", contactHandlers.MergeContact)
+			protected.GET("/websites/:id/leads", contactHandlers.GetLeads)
+
+			// Offline ticket routes
+			protected.GET("/websites/:id/tickets", ticketHandlers.GetTickets)
+			protected.PUT("/websites/:id/tickets/:ticket_id", ticketHandlers.UpdateTicket)
+
+			// Canned responses
+			protected.GET("/websites/:id/canned-responses", cannedHandlers.GetCannedResponses)
+			protected.POST("/websites/:id/canned-responses", cannedHandlers.CreateCannedResponse)
+			protected.GET("/websites/:id/canned-responses/stats", cannedHandlers.GetCannedResponseStats)
+			protected.PUT("/websites/:id/canned-responses/:response_id", cannedHandlers.UpdateCannedResponse)
+			protected.DELETE("/websites/:id/canned-responses/:response_id", cannedHandlers.DeleteCannedResponse)
+			protected.GET("/chats/:id/canned-responses", cannedHandlers.LookupCannedResponses)
+			protected.POST("/chats/:id/canned-responses/:response_id/use", cannedHandlers.UseCannedResponse)
+
 			// Subscription routes
 			protected.GET("/subscription", handlers.GetSubscription)
 			protected.POST("/subscription", handlers.CreateSubscription)
@@ -137,19 +353,75 @@ func main() {
 			protected.GET("/websites/:id/analytics/events", analyticsHandlers.GetEventsByType)
 			protected.GET("/websites/:id/analytics/visitors/:visitor_id", analyticsHandlers.GetVisitorJourney)
 			protected.GET("/websites/:id/analytics/realtime", analyticsHandlers.GetRealTimeMetrics)
+			protected.GET("/websites/:id/analytics/response-times", analyticsHandlers.GetResponseTimes)
+			protected.GET("/websites/:id/analytics/attribution", analyticsHandlers.GetConversionAttribution)
+			protected.GET("/websites/:id/analytics/event-definitions", analyticsHandlers.GetEventDefinitions)
+			protected.POST("/websites/:id/analytics/event-definitions", analyticsHandlers.CreateEventDefinition)
+			protected.PUT("/websites/:id/analytics/event-definitions/:definition_id", analyticsHandlers.UpdateEventDefinition)
+			protected.DELETE("/websites/:id/analytics/event-definitions/:definition_id", analyticsHandlers.DeleteEventDefinition)
+
+			// Funnel routes
+			protected.GET("/websites/:id/funnels", funnelHandlers.GetFunnels)
+			protected.POST("/websites/:id/funnels", funnelHandlers.CreateFunnel)
+			protected.GET("/websites/:id/funnels/:funnel_id", funnelHandlers.GetFunnel)
+			protected.PUT("/websites/:id/funnels/:funnel_id", funnelHandlers.UpdateFunnel)
+			protected.DELETE("/websites/:id/funnels/:funnel_id", funnelHandlers.DeleteFunnel)
+			protected.GET("/websites/:id/funnels/:funnel_id/report", funnelHandlers.GetFunnelReport)
 			protected.GET("/websites/:id/analytics/export", analyticsHandlers.ExportAnalytics)
+
+			// Raw data exports
+			protected.GET("/websites/:id/exports", exportHandlers.GetExportJobs)
+			protected.POST("/websites/:id/exports", exportHandlers.CreateExportJob)
+			protected.GET("/websites/:id/exports/stream", exportHandlers.StreamExport)
+			protected.GET("/websites/:id/exports/:job_id", exportHandlers.GetExportJob)
+			protected.GET("/websites/:id/exports/:job_id/download", exportHandlers.DownloadExport)
+		}
+
+		// Usage stays readable once the quota runs out
+		account := api.Group("/")
+		account.Use(middleware.AuthRequired(keys))
+		{
+			account.GET("/usage", usageHandlers.GetUsage)
+		}
+
+		// Monthly usage totals for billing
+		admin := api.Group("/admin")
+		admin.Use(middleware.AdminAuth(keys))
+		{
+			admin.GET("/usage", usageHandlers.GetMonthlyTotals)
+		}
+
+		// Live streams and the agent console accept the token as a query parameter
+		stream := api.Group("/")
+		stream.Use(middleware.StreamAuth(keys))
+		{
+			stream.GET("/websites/:id/live/sse", liveHandlers.StreamSSE)
+			stream.GET("/websites/:id/live/ws", liveHandlers.StreamWS)
+			stream.GET("/agents/me/ws", agentHandlers.StreamConsole)
 		}
 	}
 
 	// Widget routes (public) with widget-specific rate limiting
 	widget := router.Group("/widget")
-	widget.Use(middleware.WidgetRateLimit(cfg))
+	widget.Use(middleware.WidgetRateLimit(cfg, limiter))
 	{
 		// WebSocket endpoint for chat
 		widget.GET("/ws/:widget_key", func(c *gin.Context) {
 			widgetHandlers.HandleWebSocket(hub, c)
 		})
 
+		// Fallbacks for networks that block WebSockets: Server-Sent Events or
+		// long polling down, POST up
+		widget.GET("/stream/:widget_key", func(c *gin.Context) {
+			widgetHandlers.HandleEventStream(hub, c)
+		})
+		widget.GET("/poll/:widget_key", func(c *gin.Context) {
+			widgetHandlers.HandlePoll(hub, c)
+		})
+		widget.POST("/send/:widget_key", func(c *gin.Context) {
+			widgetHandlers.HandleSend(hub, c)
+		})
+
 		// Widget configuration
 		widget.GET("/config/:widget_key", widgetHandlers.GetWidgetConfig)
 
@@ -158,6 +430,26 @@ func main() {
 
 		// Event tracking (public)
 		widget.POST("/track/:widget_key", analyticsHandlers.TrackEvent)
+
+		// Visitor identification (public, optionally HMAC-verified)
+		widget.POST("/identify/:widget_key", widgetHandlers.Identify)
+
+		// Pre-chat form submission, which starts the chat
+		widget.POST("/prechat/:widget_key", widgetHandlers.SubmitPreChatForm)
+
+		// Offline form submission, emailed to the website owner
+		widget.POST("/offline/:widget_key", widgetHandlers.SubmitOfflineMessage)
+
+		// Transcript requests, emailed when the chat ends. Each sends an
+		// email, so they are limited by IP and by website.
+		widget.POST("/transcript/:widget_key",
+			middleware.RateLimit(limiter, "transcript_ip", ratelimit.Limit{Rate: 5, Period: time.Hour, Burst: 3}),
+			middleware.WidgetKeyRateLimit(limiter, "transcript_website", ratelimit.Limit{Rate: 100, Period: time.Hour, Burst: 20}),
+			widgetHandlers.RequestTranscript)
+
+		// Chat attachments, authorized by the visitor's session
+		widget.POST("/attachments/:widget_key", attachmentHandlers.UploadWidgetAttachment)
+		widget.GET("/attachments/:widget_key/:attachment_id", attachmentHandlers.DownloadWidgetAttachment)
 	}
 
 	// Start server
@@ -1,24 +1,37 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	JWT      JWTConfig
-	OpenAI   OpenAIConfig
+	Server      ServerConfig
+	Database    DatabaseConfig
+	Redis       RedisConfig
+	JWT         JWTConfig
+	OpenAI      OpenAIConfig
+	Storage     StorageConfig
+	Export      ExportConfig
+	SMTP        SMTPConfig
+	Attachments AttachmentConfig
+	RateLimit   RateLimitConfig
+	SSO         SSOConfig
 }
 
 type ServerConfig struct {
 	Port string
 	Host string
 	Env  string
+
+	// Proxies (IPs or CIDRs) whose X-Forwarded-For is believed; with none,
+	// the client IP is the connection's address
+	TrustedProxies []string
 }
 
 type DatabaseConfig struct {
@@ -38,27 +51,110 @@ type RedisConfig struct {
 }
 
 type JWTConfig struct {
-	Secret     string
-	Expiration int // hours
+	Secret          string
+	Expiration      int      // hours
+	PreviousSecrets []string // retired secrets, which still verify tokens until they expire
+	KeysDir         string   // RSA and Ed25519 private keys in PEM files named <key ID>.pem
+	SigningKey      string   // ID of the key in KeysDir to sign with (RS256 or EdDSA); the secret signs (HS256) when empty
 }
 
+// DefaultJWTSecret signs tokens in development when JWT_SECRET is not set.
+// Anyone can forge tokens with it, so the server refuses it in production.
+const DefaultJWTSecret = "your-secret-key"
+
+// MinJWTSecretLength is the shortest HS256 secret accepted in production, in
+// bytes; shorter secrets can be brute-forced from a single token
+const MinJWTSecretLength = 32
+
 type OpenAIConfig struct {
 	APIKey string
 	Model  string
 }
 
+type StorageConfig struct {
+	Driver      string // 'local' or 's3'
+	LocalPath   string
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3UseSSL    bool
+}
+
+type ExportConfig struct {
+	MaxSyncDays    int // larger ranges run as async jobs
+	BatchSize      int
+	LinkExpiration int // hours
+}
+
+type SMTPConfig struct {
+	Host     string // email is logged instead of sent when empty
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+type AttachmentConfig struct {
+	ClamdAddr string // clamd address for virus scanning, e.g. 'localhost:3310'; scanning is off when empty
+}
+
+// RateLimitConfig sets the request limits of route groups for clients that
+// are not signed in; signed-in users are limited by their plan
+type RateLimitConfig struct {
+	APIPerMinute    int
+	APIBurst        int
+	WidgetPerMinute int
+	WidgetBurst     int
+}
+
+// SSOConfig sets up signing in with identity providers. Each provider is
+// offered once its client ID is set.
+type SSOConfig struct {
+	APIURL string // public URL of this API, which providers redirect back to
+	AppURL string // dashboard URL signed-in users are sent back to
+	Google SSOProviderConfig
+	GitHub SSOProviderConfig
+	OIDC   SSOProviderConfig // any OpenID Connect provider, e.g. a customer's Okta or Entra ID
+}
+
+type SSOProviderConfig struct {
+	Name         string // shown on the sign-in button
+	Issuer       string // OpenID Connect issuer URL, for the generic provider
+	ClientID     string
+	ClientSecret string
+	TrustEmails  bool // treat the provider's emails as verified, for providers that do not say, such as Entra ID
+}
+
 func Load() (*Config, error) {
 	// Load .env file if it exists
 	godotenv.Load()
 
 	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
 	jwtExp, _ := strconv.Atoi(getEnv("JWT_EXPIRATION", "24"))
+	s3UseSSL, _ := strconv.ParseBool(getEnv("S3_USE_SSL", "true"))
+	exportMaxSyncDays, _ := strconv.Atoi(getEnv("EXPORT_MAX_SYNC_DAYS", "31"))
+	exportBatchSize, _ := strconv.Atoi(getEnv("EXPORT_BATCH_SIZE", "1000"))
+	exportLinkExp, _ := strconv.Atoi(getEnv("EXPORT_LINK_EXPIRATION", "24"))
+	apiPerMinute, _ := strconv.Atoi(getEnv("RATE_LIMIT_API_PER_MINUTE", "100"))
+	apiBurst, _ := strconv.Atoi(getEnv("RATE_LIMIT_API_BURST", "20"))
+	widgetPerMinute, _ := strconv.Atoi(getEnv("RATE_LIMIT_WIDGET_PER_MINUTE", "200"))
+	widgetBurst, _ := strconv.Atoi(getEnv("RATE_LIMIT_WIDGET_BURST", "50"))
+	oidcTrustEmails, _ := strconv.ParseBool(getEnv("OIDC_TRUST_EMAILS", "false"))
 
+	env := getEnv("ENV", "development")
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" && env != "production" {
+		jwtSecret = DefaultJWTSecret
+	}
 	config := &Config{
 		Server: ServerConfig{
 			Port: getEnv("PORT", "8080"),
 			Host: getEnv("HOST", "localhost"),
-			Env:  getEnv("ENV", "development"),
+			Env:  env,
+
+			TrustedProxies: getEnvList("TRUSTED_PROXIES"),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -75,21 +171,117 @@ func Load() (*Config, error) {
 			DB:       redisDB,
 		},
 		JWT: JWTConfig{
-			Secret:     getEnv("JWT_SECRET", "your-secret-key"),
-			Expiration: jwtExp,
+			Secret:          jwtSecret,
+			Expiration:      jwtExp,
+			PreviousSecrets: getEnvList("JWT_PREVIOUS_SECRETS"),
+			KeysDir:         getEnv("JWT_KEYS_DIR", ""),
+			SigningKey:      getEnv("JWT_SIGNING_KEY", ""),
 		},
 		OpenAI: OpenAIConfig{
 			APIKey: getEnv("OPENAI_API_KEY", ""),
 			Model:  getEnv("OPENAI_MODEL", "gpt-3.5-turbo"),
 		},
+		Storage: StorageConfig{
+			Driver:      getEnv("STORAGE_DRIVER", "local"),
+			LocalPath:   getEnv("STORAGE_LOCAL_PATH", "./data"),
+			S3Endpoint:  getEnv("S3_ENDPOINT", "s3.amazonaws.com"),
+			S3Region:    getEnv("S3_REGION", ""),
+			S3Bucket:    getEnv("S3_BUCKET", ""),
+			S3AccessKey: getEnv("S3_ACCESS_KEY", ""),
+			S3SecretKey: getEnv("S3_SECRET_KEY", ""),
+			S3UseSSL:    s3UseSSL,
+		},
+		Export: ExportConfig{
+			MaxSyncDays:    exportMaxSyncDays,
+			BatchSize:      exportBatchSize,
+			LinkExpiration: exportLinkExp,
+		},
+		SMTP: SMTPConfig{
+			Host:     getEnv("SMTP_HOST", ""),
+			Port:     getEnv("SMTP_PORT", "587"),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			From:     getEnv("SMTP_FROM", "Chatelly <noreply@chatelly.app>"),
+		},
+		Attachments: AttachmentConfig{
+			ClamdAddr: getEnv("CLAMD_ADDR", ""),
+		},
+		RateLimit: RateLimitConfig{
+			APIPerMinute:    apiPerMinute,
+			APIBurst:        apiBurst,
+			WidgetPerMinute: widgetPerMinute,
+			WidgetBurst:     widgetBurst,
+		},
+		SSO: SSOConfig{
+			APIURL: getEnv("SSO_API_URL", "http://localhost:8080"),
+			AppURL: getEnv("SSO_APP_URL", "https://app.chatelly.com"),
+			Google: SSOProviderConfig{
+				Name:         "Google",
+				ClientID:     getEnv("GOOGLE_CLIENT_ID", ""),
+				ClientSecret: getEnv("GOOGLE_CLIENT_SECRET", ""),
+			},
+			GitHub: SSOProviderConfig{
+				Name:         "GitHub",
+				ClientID:     getEnv("GITHUB_CLIENT_ID", ""),
+				ClientSecret: getEnv("GITHUB_CLIENT_SECRET", ""),
+			},
+			OIDC: SSOProviderConfig{
+				Name:         getEnv("OIDC_NAME", "Single sign-on"),
+				Issuer:       getEnv("OIDC_ISSUER", ""),
+				ClientID:     getEnv("OIDC_CLIENT_ID", ""),
+				ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+				TrustEmails:  oidcTrustEmails,
+			},
+		},
+	}
+
+	if env == "production" {
+		if err := config.JWT.validateForProduction(); err != nil {
+			return nil, err
+		}
 	}
 
 	return config, nil
 }
 
+// validateForProduction refuses settings that would let anyone forge tokens
+func (c JWTConfig) validateForProduction() error {
+	if c.Secret == DefaultJWTSecret {
+		return errors.New("JWT_SECRET must not be the default in production")
+	}
+	for _, secret := range c.PreviousSecrets {
+		if secret == DefaultJWTSecret {
+			return errors.New("JWT_PREVIOUS_SECRETS must not include the default secret in production")
+		}
+	}
+	if c.Secret == "" && c.SigningKey == "" {
+		return errors.New("JWT_SECRET or JWT_SIGNING_KEY must be set in production")
+	}
+	if c.Secret != "" && len(c.Secret) < MinJWTSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d bytes in production", MinJWTSecretLength)
+	}
+	for _, secret := range c.PreviousSecrets {
+		if len(secret) < MinJWTSecretLength {
+			return fmt.Errorf("JWT_PREVIOUS_SECRETS must each be at least %d bytes in production", MinJWTSecretLength)
+		}
+	}
+	return nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,35 @@
+package config
+
+import "testing"
+
+func TestLoad_JWTSecretInProduction(t *testing.T) {
+	tests := []struct {
+		name       string
+		secret     string
+		signingKey string
+		wantErr    bool
+	}{
+		{"unset", "", "", true},
+		{"default", DefaultJWTSecret, "", true},
+		{"short", "a-short-production-secret", "", true},
+		{"set", "a-long-random-production-secret-of-48-characters", "", false},
+		{"private key only", "", "rsa-2026", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("ENV", "production")
+		t.Setenv("JWT_SECRET", tt.secret)
+		t.Setenv("JWT_SIGNING_KEY", tt.signingKey)
+
+		_, err := Load()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Load() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	t.Setenv("ENV", "development")
+	t.Setenv("JWT_SECRET", "")
+	cfg, err := Load()
+	if err != nil || cfg.JWT.Secret != DefaultJWTSecret {
+		t.Errorf("expected the default secret in development, got %v, %v", cfg, err)
+	}
+}
@@ -46,6 +46,24 @@ func Migrate() error {
 		&models.Message{},
 		&models.Subscription{},
 		&models.Analytics{},
+		&models.Funnel{},
+		&models.EventDefinition{},
+		&models.ExportJob{},
+		&models.WebsiteAgent{},
+		&models.AgentPresence{},
+		&models.ChatNote{},
+		&models.Contact{},
+		&models.ContactVisitor{},
+		&models.OfflineTicket{},
+		&models.Attachment{},
+		&models.CannedResponse{},
+		&models.CannedResponseUsage{},
+		&models.APIUsage{},
+		&models.SecurityEvent{},
+		&models.MFARecoveryCode{},
+		&models.UserIdentity{},
+		&models.SSOLogin{},
+		&models.SSODomain{},
 	)
 
 	if err != nil {
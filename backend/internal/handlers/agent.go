@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/database"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AgentHandlers contains agent, presence and chat queue handlers
+type AgentHandlers struct {
+	hub               *websocket.Hub
+	assignmentService *services.AssignmentService
+	websiteService    *services.WebsiteService
+}
+
+// NewAgentHandlers creates new AgentHandlers
+func NewAgentHandlers(cfg *config.Config, hub *websocket.Hub) *AgentHandlers {
+	assignmentService := services.NewAssignmentService(database.DB, cfg, hub)
+	websiteService := services.NewWebsiteService(database.DB, cfg)
+	return &AgentHandlers{
+		hub:               hub,
+		assignmentService: assignmentService,
+		websiteService:    websiteService,
+	}
+}
+
+// GetAgents handles listing a website's agents with presence and workload
+func (h *AgentHandlers) GetAgents(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, false)
+	if !ok {
+		return
+	}
+
+	agents, err := h.assignmentService.GetAgents(websiteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agents": agents,
+	})
+}
+
+// AddAgent handles adding a user as an agent on a website
+func (h *AgentHandlers) AddAgent(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	var req models.AgentCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	agent, err := h.assignmentService.AddAgent(websiteID, &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "user not found":
+			status = http.StatusNotFound
+		case "agent already exists":
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Agent added successfully",
+		"agent":   agent,
+	})
+}
+
+// UpdateAgent handles changing an agent's chat cap
+func (h *AgentHandlers) UpdateAgent(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	agentID, err := strconv.ParseUint(c.Param("agent_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid agent ID"})
+		return
+	}
+
+	var req models.AgentUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	agent, err := h.assignmentService.UpdateAgent(uint(agentID), websiteID, &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "agent not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Agent updated successfully",
+		"agent":   agent,
+	})
+}
+
+// RemoveAgent handles removing an agent from a website
+func (h *AgentHandlers) RemoveAgent(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	agentID, err := strconv.ParseUint(c.Param("agent_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid agent ID"})
+		return
+	}
+
+	if err := h.assignmentService.RemoveAgent(uint(agentID), websiteID); err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "agent not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Agent removed successfully",
+	})
+}
+
+// GetSSODomains handles listing the email domains whose users join a website
+// as agents
+func (h *AgentHandlers) GetSSODomains(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	domains, err := h.assignmentService.GetSSODomains(websiteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"domains": domains,
+	})
+}
+
+// AddSSODomain handles letting users at an email domain join a website as
+// agents when they first sign in with an identity provider
+func (h *AgentHandlers) AddSSODomain(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	var req models.SSODomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	domain, err := h.assignmentService.AddSSODomain(websiteID, req.Domain)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "website not found":
+			status = http.StatusNotFound
+		case "domain already added", "domain verified by another website":
+			status = http.StatusConflict
+		case "invalid domain format", "domain is required", "domain belongs to a public email provider", "domain does not match the website":
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Domain added; publish its TXT record and verify it",
+		"domain":  domain,
+	})
+}
+
+// VerifySSODomain handles checking the DNS record that proves a website
+// controls an email domain
+func (h *AgentHandlers) VerifySSODomain(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	domainID, err := strconv.ParseUint(c.Param("domain_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain ID"})
+		return
+	}
+
+	domain, err := h.assignmentService.VerifySSODomain(c.Request.Context(), uint(domainID), websiteID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "domain not found":
+			status = http.StatusNotFound
+		case "verification record not found":
+			status = http.StatusUnprocessableEntity
+		case "domain verified by another website":
+			status = http.StatusConflict
+		case "failed to look up the verification record":
+			status = http.StatusBadGateway
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Domain verified successfully",
+		"domain":  domain,
+	})
+}
+
+// RemoveSSODomain handles stopping new users at an email domain joining a website
+func (h *AgentHandlers) RemoveSSODomain(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	domainID, err := strconv.ParseUint(c.Param("domain_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid domain ID"})
+		return
+	}
+
+	if err := h.assignmentService.RemoveSSODomain(uint(domainID), websiteID); err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "domain not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Domain removed successfully",
+	})
+}
+
+// GetQueue handles listing chats waiting for an agent
+func (h *AgentHandlers) GetQueue(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, false)
+	if !ok {
+		return
+	}
+
+	chats, err := h.assignmentService.GetQueue(websiteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chats": chats,
+		"total": len(chats),
+	})
+}
+
+// UpdateRouting handles changing a website's routing strategy
+func (h *AgentHandlers) UpdateRouting(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	var req models.RoutingUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := h.assignmentService.UpdateRoutingStrategy(websiteID, req.Strategy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Routing strategy updated successfully",
+		"routing_strategy": req.Strategy,
+	})
+}
+
+// GetMyStatus handles getting the current user's presence
+func (h *AgentHandlers) GetMyStatus(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	presence, err := h.assignmentService.GetPresence(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"presence": presence,
+	})
+}
+
+// UpdateMyStatus handles setting the current user's presence
+func (h *AgentHandlers) UpdateMyStatus(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.AgentStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	presence, err := h.assignmentService.SetPresence(userID.(uint), req.Status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Status updated successfully",
+		"presence": presence,
+	})
+}
+
+// GetMyChats handles listing the active chats assigned to the current user
+func (h *AgentHandlers) GetMyChats(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chats, err := h.assignmentService.GetAgentChats(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chats": chats,
+	})
+}
+
+// StreamConsole handles the agent console WebSocket, which receives assignment changes
+func (h *AgentHandlers) StreamConsole(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websocket.ServeAgentWS(h.hub, c.Writer, c.Request, userID.(uint))
+}
+
+// authorizeWebsite resolves the website from the route. Management requires
+// ownership; read access is also granted to the website's agents.
+func (h *AgentHandlers) authorizeWebsite(c *gin.Context, manage bool) (uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, false
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return 0, false
+	}
+
+	if manage {
+		err = h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint))
+	} else {
+		err = h.assignmentService.ValidateAgentAccess(uint(websiteID), userID.(uint))
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return 0, false
+	}
+
+	return uint(websiteID), true
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -17,15 +18,22 @@ import (
 type AnalyticsHandlers struct {
 	analyticsService *services.AnalyticsService
 	websiteService   *services.WebsiteService
+	contactService   *services.ContactService
+	exportService    *services.ExportService
 }
 
 // NewAnalyticsHandlers creates new AnalyticsHandlers
 func NewAnalyticsHandlers(cfg *config.Config) *AnalyticsHandlers {
 	analyticsService := services.NewAnalyticsService(database.DB, cfg)
 	websiteService := services.NewWebsiteService(database.DB, cfg)
+	contactService := services.NewContactService(database.DB, cfg)
+	// Event exports are streamed, never stored
+	exportService := services.NewExportService(database.DB, cfg, nil)
 	return &AnalyticsHandlers{
 		analyticsService: analyticsService,
 		websiteService:   websiteService,
+		contactService:   contactService,
+		exportService:    exportService,
 	}
 }
 
@@ -48,6 +56,19 @@ type EventsQuery struct {
 	PaginationQuery
 }
 
+// ExportQuery represents analytics export query parameters
+type ExportQuery struct {
+	AnalyticsQuery
+	EventType string `form:"event_type"`
+}
+
+// ResponseTimesQuery represents response time query parameters
+type ResponseTimesQuery struct {
+	StartDate string `form:"start_date" binding:"required"`
+	EndDate   string `form:"end_date" binding:"required"`
+	AgentID   uint   `form:"agent_id"`
+}
+
 // TrackEventRequest represents event tracking request
 type TrackEventRequest struct {
 	EventType string                 `json:"event_type" binding:"required"`
@@ -184,7 +205,12 @@ func (h *AnalyticsHandlers) GetEventsByType(c *gin.Context) {
 	}
 
 	// Validate event type
-	if !models.IsValidEventType(query.EventType) {
+	valid, err := models.IsValidEventTypeForWebsite(database.DB, uint(websiteID), query.EventType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !valid {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event type"})
 		return
 	}
@@ -202,10 +228,11 @@ func (h *AnalyticsHandlers) GetEventsByType(c *gin.Context) {
 		return
 	}
 
-	// Get events
-	events, total, err := h.analyticsService.GetEventsByType(uint(websiteID), query.EventType, startDate, endDate, query.Page, query.Limit)
+	// Get events, filtered on event_data properties given as filter[key]=value
+	filters := c.QueryMap("filter")
+	events, total, err := h.analyticsService.GetEventsByType(uint(websiteID), query.EventType, filters, startDate, endDate, query.Page, query.Limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -226,6 +253,138 @@ func (h *AnalyticsHandlers) GetEventsByType(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// Longest date range reports and exports cover
+const maxReportRange = 365 * 24 * time.Hour
+
+// GetResponseTimes handles getting first-response, reply and resolution times
+func (h *AnalyticsHandlers) GetResponseTimes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var query ResponseTimesQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Parse dates
+	startDate, err := time.Parse("2006-01-02", query.StartDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format (YYYY-MM-DD)"})
+		return
+	}
+
+	endDate, err := time.Parse("2006-01-02", query.EndDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format (YYYY-MM-DD)"})
+		return
+	}
+
+	// Same limit as the dashboard's days
+	if endDate.Before(startDate) || endDate.Sub(startDate) >= maxReportRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date range must be between 1 and 365 days"})
+		return
+	}
+
+	// Include the whole end day
+	endDate = endDate.Add(24*time.Hour - time.Nanosecond)
+
+	var agentID *uint
+	if query.AgentID > 0 {
+		agentID = &query.AgentID
+	}
+
+	// Get response times
+	report, err := h.analyticsService.GetResponseTimes(uint(websiteID), startDate, endDate, agentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response_times": report,
+	})
+}
+
+// GetConversionAttribution handles reporting whether converting visitors chatted first
+func (h *AnalyticsHandlers) GetConversionAttribution(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var query AnalyticsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Parse dates
+	startDate, err := time.Parse("2006-01-02", query.StartDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format (YYYY-MM-DD)"})
+		return
+	}
+
+	endDate, err := time.Parse("2006-01-02", query.EndDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format (YYYY-MM-DD)"})
+		return
+	}
+
+	if endDate.Before(startDate) || endDate.Sub(startDate) >= maxReportRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date range must be between 1 and 365 days"})
+		return
+	}
+
+	// Include the whole end day
+	endDate = endDate.Add(24*time.Hour - time.Nanosecond)
+
+	attribution, err := h.analyticsService.GetConversionAttribution(uint(websiteID), startDate, endDate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"attribution": attribution,
+	})
+}
+
 // GetVisitorJourney handles getting visitor journey
 func (h *AnalyticsHandlers) GetVisitorJourney(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -335,12 +494,6 @@ func (h *AnalyticsHandlers) TrackEvent(c *gin.Context) {
 		return
 	}
 
-	// Validate event type
-	if !models.IsValidEventType(req.EventType) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event type"})
-		return
-	}
-
 	// Get website by widget key
 	website, err := models.GetWebsiteByWidgetKey(database.DB, widgetKey)
 	if err != nil {
@@ -348,13 +501,19 @@ func (h *AnalyticsHandlers) TrackEvent(c *gin.Context) {
 		return
 	}
 
+	// Validate event type and data against built-in and custom definitions
+	eventData := models.AnalyticsData(req.EventData)
+	if err := h.analyticsService.ValidateEvent(website.ID, req.EventType, eventData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Get client information
 	userAgent := c.Request.UserAgent()
 	ip := getClientIP(c.Request)
 	referrer := c.Request.Referer()
 
 	// Track event
-	eventData := models.AnalyticsData(req.EventData)
 	if err := h.analyticsService.TrackEvent(
 		website.ID,
 		req.EventType,
@@ -369,16 +528,246 @@ func (h *AnalyticsHandlers) TrackEvent(c *gin.Context) {
 		return
 	}
 
+	// Make sure the visitor has a contact, so the journey shows up on it
+	if req.VisitorID != "" && len(req.VisitorID) <= models.MaxVisitorIDLength {
+		if _, err := h.contactService.ResolveVisitor(website.ID, req.VisitorID); err != nil {
+			log.Printf("Failed to resolve contact for visitor %s: %v", req.VisitorID, err)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Event tracked successfully",
 	})
 }
 
-// GetEventTypes handles getting valid event types
+// GetEventTypes handles getting valid event types. When website_id is given,
+// the website's custom event definitions are included.
 func (h *AnalyticsHandlers) GetEventTypes(c *gin.Context) {
-	eventTypes := models.GetValidEventTypes()
+	websiteIDParam := c.Query("website_id")
+	if websiteIDParam == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"event_types": models.GetValidEventTypes(),
+		})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(websiteIDParam, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	builtIn, definitions, err := h.analyticsService.GetEventTypes(uint(websiteID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	eventTypes := make([]string, 0, len(builtIn)+len(definitions))
+	eventTypes = append(eventTypes, builtIn...)
+	for _, definition := range definitions {
+		eventTypes = append(eventTypes, definition.Name)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"event_types":        eventTypes,
+		"custom_event_types": definitions,
+	})
+}
+
+// GetEventDefinitions handles listing custom event definitions for a website
+func (h *AnalyticsHandlers) GetEventDefinitions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	definitions, err := h.analyticsService.GetEventDefinitions(uint(websiteID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"event_types": eventTypes,
+		"event_definitions": definitions,
+	})
+}
+
+// CreateEventDefinition handles custom event definition creation
+func (h *AnalyticsHandlers) CreateEventDefinition(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req models.EventDefinitionCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := models.ValidateEventName(req.Name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := req.Schema.ValidateDefinition(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	definition, err := h.analyticsService.CreateEventDefinition(uint(websiteID), &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "event definition already exists" {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":          "Event definition created successfully",
+		"event_definition": definition,
+	})
+}
+
+// UpdateEventDefinition handles custom event definition updates
+func (h *AnalyticsHandlers) UpdateEventDefinition(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	definitionID, err := strconv.ParseUint(c.Param("definition_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event definition ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req models.EventDefinitionUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if req.Schema != nil {
+		if err := req.Schema.ValidateDefinition(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	definition, err := h.analyticsService.UpdateEventDefinition(uint(definitionID), uint(websiteID), &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "event definition not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Event definition updated successfully",
+		"event_definition": definition,
+	})
+}
+
+// DeleteEventDefinition handles custom event definition deletion
+func (h *AnalyticsHandlers) DeleteEventDefinition(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	definitionID, err := strconv.ParseUint(c.Param("definition_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event definition ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.analyticsService.DeleteEventDefinition(uint(definitionID), uint(websiteID)); err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "event definition not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Event definition deleted successfully",
 	})
 }
 
@@ -402,7 +791,7 @@ func (h *AnalyticsHandlers) ExportAnalytics(c *gin.Context) {
 		return
 	}
 
-	var query AnalyticsQuery
+	var query ExportQuery
 	if err := c.ShouldBindQuery(&query); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "Invalid query parameters",
@@ -424,6 +813,45 @@ func (h *AnalyticsHandlers) ExportAnalytics(c *gin.Context) {
 		return
 	}
 
+	if endDate.Before(startDate) || endDate.Sub(startDate) >= maxReportRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date range must be between 1 and 365 days"})
+		return
+	}
+
+	// Exporting by event type or event_data properties streams the matching raw events
+	filters := c.QueryMap("filter")
+	if query.EventType != "" || len(filters) > 0 {
+		job := &models.ExportJob{
+			WebsiteID: uint(websiteID),
+			UserID:    userID.(uint),
+			Dataset:   models.ExportDatasetEvents,
+			Format:    models.ExportFormatNDJSON,
+			StartDate: startDate,
+			// Include the whole end day
+			EndDate: endDate.Add(24*time.Hour - time.Nanosecond),
+			Filters: models.ExportFilters{
+				EventType:  query.EventType,
+				Properties: filters,
+			},
+		}
+		if err := h.exportService.ValidateExport(job); err != nil {
+			c.JSON(exportValidationStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		filename := "events_" + query.StartDate + "_to_" + query.EndDate + ".ndjson"
+		c.Header("Content-Type", models.ExportContentType(job.Format))
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		c.Status(http.StatusOK)
+
+		// Headers are sent with the first chunk, so failures can only be logged
+		if _, err := h.exportService.StreamExport(c.Request.Context(), c.Writer, job, c.Writer.Flush); err != nil {
+			log.Printf("Event export for website %d failed: %v", job.WebsiteID, err)
+			c.Abort()
+		}
+		return
+	}
+
 	// Get analytics for export
 	analytics, err := h.analyticsService.GetWebsiteAnalytics(uint(websiteID), startDate, endDate)
 	if err != nil {
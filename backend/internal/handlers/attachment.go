@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/database"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AttachmentHandlers contains chat file upload and download handlers
+type AttachmentHandlers struct {
+	attachmentService *services.AttachmentService
+	chatService       *services.ChatService
+	widgetService     *services.WidgetService
+}
+
+// NewAttachmentHandlers creates new AttachmentHandlers
+func NewAttachmentHandlers(cfg *config.Config, store storage.Storage, notifier services.VisitorNotifier) *AttachmentHandlers {
+	attachmentService := services.NewAttachmentService(database.DB, cfg, store, notifier)
+	chatService := services.NewChatService(database.DB, cfg)
+	widgetService := services.NewWidgetService(database.DB, cfg)
+	return &AttachmentHandlers{
+		attachmentService: attachmentService,
+		chatService:       chatService,
+		widgetService:     widgetService,
+	}
+}
+
+// AttachmentDownloadQuery represents attachment download query parameters
+type AttachmentDownloadQuery struct {
+	Thumbnail bool `form:"thumbnail"`
+}
+
+// WidgetAttachmentQuery represents widget attachment download query parameters
+type WidgetAttachmentQuery struct {
+	SessionID string `form:"session_id" binding:"required,max=128"`
+	AttachmentDownloadQuery
+}
+
+// UploadChatAttachment handles an agent sending a file to a chat
+func (h *AttachmentHandlers) UploadChatAttachment(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	// Validate chat access
+	if err := h.chatService.ValidateChatAccess(uint(chatID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	agentID := userID.(uint)
+	h.upload(c, uint(chatID), models.UploaderAgent, &agentID)
+}
+
+// DownloadChatAttachment handles downloading a chat's attachment or its
+// thumbnail from the dashboard
+func (h *AttachmentHandlers) DownloadChatAttachment(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return
+	}
+
+	attachmentID, err := strconv.ParseUint(c.Param("attachment_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID"})
+		return
+	}
+
+	var query AttachmentDownloadQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Validate chat access
+	if err := h.chatService.ValidateChatAccess(uint(chatID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	attachment, err := h.attachmentService.GetAttachment(uint(chatID), uint(attachmentID))
+	if err != nil {
+		c.JSON(attachmentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	h.serve(c, attachment, query.Thumbnail)
+}
+
+// UploadWidgetAttachment handles a visitor sending a file to their chat. The
+// visitor's session ID authenticates the upload (public endpoint).
+func (h *AttachmentHandlers) UploadWidgetAttachment(c *gin.Context) {
+	website, ok := h.widgetWebsite(c)
+	if !ok {
+		return
+	}
+
+	sessionID := c.PostForm("session_id")
+	if sessionID == "" || len(sessionID) > 128 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Session ID is required"})
+		return
+	}
+
+	chat, err := h.chatService.GetChatBySession(website.ID, sessionID)
+	if err != nil {
+		c.JSON(attachmentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	h.upload(c, chat.ID, models.UploaderVisitor, nil)
+}
+
+// DownloadWidgetAttachment handles a visitor downloading a file from their
+// own chat (public endpoint)
+func (h *AttachmentHandlers) DownloadWidgetAttachment(c *gin.Context) {
+	website, ok := h.widgetWebsite(c)
+	if !ok {
+		return
+	}
+
+	attachmentID, err := strconv.ParseUint(c.Param("attachment_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID"})
+		return
+	}
+
+	var query WidgetAttachmentQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	attachment, err := h.attachmentService.GetVisitorAttachment(website.ID, query.SessionID, uint(attachmentID))
+	if err != nil {
+		c.JSON(attachmentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	h.serve(c, attachment, query.Thumbnail)
+}
+
+// widgetWebsite resolves the website from the widget key in the route
+func (h *AttachmentHandlers) widgetWebsite(c *gin.Context) (*models.Website, bool) {
+	widgetKey := c.Param("widget_key")
+	if widgetKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Widget key is required"})
+		return nil, false
+	}
+
+	website, err := h.widgetService.GetWidgetConfig(widgetKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid widget key"})
+		return nil, false
+	}
+
+	return website, true
+}
+
+// upload stores the multipart "file" field, with the optional "message"
+// field as its caption
+func (h *AttachmentHandlers) upload(c *gin.Context, chatID uint, uploaderType string, uploadedBy *uint) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+
+	message, err := h.attachmentService.Upload(c.Request.Context(), chatID, &services.AttachmentUpload{
+		Filename:     fileHeader.Filename,
+		Body:         file,
+		Caption:      c.PostForm("message"),
+		UploaderType: uploaderType,
+		UploadedBy:   uploadedBy,
+	})
+	if err != nil {
+		c.JSON(attachmentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": message,
+	})
+}
+
+// serve sends an attachment, redirecting to the storage backend when it
+// supports signed URLs. Only images are shown inline.
+func (h *AttachmentHandlers) serve(c *gin.Context, attachment *models.Attachment, thumbnail bool) {
+	url, body, err := h.attachmentService.OpenAttachment(c.Request.Context(), attachment, thumbnail)
+	if err != nil {
+		c.JSON(attachmentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if url != "" {
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+	defer body.Close()
+
+	contentType := attachment.ContentType
+	size := attachment.Size
+	if thumbnail {
+		contentType = "image/jpeg"
+		size = -1
+	}
+
+	disposition := "attachment"
+	if attachment.IsImage() {
+		disposition = "inline"
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.DataFromReader(http.StatusOK, size, contentType, body, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// attachmentErrorStatus maps attachment service errors to HTTP statuses
+func attachmentErrorStatus(err error) int {
+	message := err.Error()
+	switch {
+	case message == "chat not found", message == "attachment not found", message == "thumbnail not found":
+		return http.StatusNotFound
+	case message == "chat is not active":
+		return http.StatusConflict
+	case message == "file is empty", message == "message is too long":
+		return http.StatusBadRequest
+	case message == "file is infected":
+		return http.StatusUnprocessableEntity
+	case strings.HasPrefix(message, "file is too large"):
+		return http.StatusRequestEntityTooLarge
+	case strings.HasPrefix(message, "file type"):
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusInternalServerError
+}
@@ -1,26 +1,47 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"chatelly-backend/internal/config"
 	"chatelly-backend/internal/database"
 	"chatelly-backend/internal/models"
 	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/mailer"
+	"chatelly-backend/pkg/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 // AuthHandlers contains authentication-related handlers
 type AuthHandlers struct {
-	authService *services.AuthService
+	authService     *services.AuthService
+	mfaService      *services.MFAService
+	ssoService      *services.SSOService
+	securityService *services.SecurityService
+	keys            *utils.KeySet
+	appURL          string
+	secureCookies   bool
 }
 
 // NewAuthHandlers creates new AuthHandlers
-func NewAuthHandlers(cfg *config.Config) *AuthHandlers {
-	authService := services.NewAuthService(database.DB, cfg)
+func NewAuthHandlers(cfg *config.Config, mail mailer.Mailer, keys *utils.KeySet) *AuthHandlers {
+	authService := services.NewAuthService(database.DB, cfg, keys)
+	mfaService := services.NewMFAService(database.DB, cfg)
+	ssoService := services.NewSSOService(database.DB, cfg)
+	securityService := services.NewSecurityService(database.DB, cfg, mail)
 	return &AuthHandlers{
-		authService: authService,
+		authService:     authService,
+		mfaService:      mfaService,
+		ssoService:      ssoService,
+		securityService: securityService,
+		keys:            keys,
+		appURL:          strings.TrimSuffix(cfg.SSO.AppURL, "/"),
+		secureCookies:   cfg.Server.Env == "production",
 	}
 }
 
@@ -82,25 +103,91 @@ func (h *AuthHandlers) Login(c *gin.Context) {
 		return
 	}
 
+	// Refuse attempts on locked accounts, and retries that come too fast
+	attempt := loginAttempt(c, req.Email)
+	if !h.checkLogin(c, attempt) {
+		return
+	}
+
 	// Authenticate user
-	user, tokens, err := h.authService.LoginUser(req.Email, req.Password)
+	result, err := h.authService.LoginUser(req.Email, req.Password)
 	if err != nil {
 		status := http.StatusInternalServerError
 		if err.Error() == "invalid email or password" {
 			status = http.StatusUnauthorized
+			h.recordFailure(attempt, err.Error())
 		}
 		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
+	h.respondSession(c, result, attempt)
+}
+
+// respondSession returns the tokens of a sign-in, or the MFA token for its
+// second step
+func (h *AuthHandlers) respondSession(c *gin.Context, result *services.LoginResult, attempt services.LoginAttempt) {
+	// The sign-in continues at /auth/mfa/verify or /auth/mfa/enable
+	if result.MFARequired || result.MFAEnrollmentRequired {
+		c.JSON(http.StatusOK, gin.H{
+			"message":                 "Two-factor authentication required",
+			"mfa_required":            result.MFARequired,
+			"mfa_enrollment_required": result.MFAEnrollmentRequired,
+			"mfa_token":               result.MFAToken,
+		})
+		return
+	}
+
+	h.recordSuccess(result.User, attempt)
+
 	// Return user data and tokens
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Login successful",
-		"user":    user.ToResponse(),
-		"tokens":  tokens,
+		"user":    result.User.ToResponse(),
+		"tokens":  result.Tokens,
 	})
 }
 
+// recordFailure logs a failed sign-in, emailing the user if it locks their
+// account
+func (h *AuthHandlers) recordFailure(attempt services.LoginAttempt, reason string) {
+	lock, err := h.securityService.RecordFailure(attempt, reason)
+	if err != nil {
+		log.Printf("Failed to record failed sign-in: %v", err)
+	} else if lock != nil {
+		h.securityService.NotifyAsync(lock)
+	}
+}
+
+// recordSuccess logs a sign-in, emailing the user if it is from a new device
+func (h *AuthHandlers) recordSuccess(user *models.User, attempt services.LoginAttempt) {
+	newDevice, err := h.securityService.RecordSuccess(user, attempt)
+	if err != nil {
+		log.Printf("Failed to record sign-in for user %d: %v", user.ID, err)
+	} else if newDevice != nil {
+		h.securityService.NotifyAsync(newDevice)
+	}
+}
+
+// checkLogin refuses a sign-in step on a locked account or a retry that came
+// too fast, reporting whether it may go ahead
+func (h *AuthHandlers) checkLogin(c *gin.Context, attempt services.LoginAttempt) bool {
+	wait, err := h.securityService.CheckLogin(attempt)
+	if err == nil {
+		return true
+	}
+
+	switch err.Error() {
+	case "account is temporarily locked":
+		respondRetryLater(c, http.StatusLocked, err, wait)
+	case "too many failed attempts", "too many failed sign-ins from this network":
+		respondRetryLater(c, http.StatusTooManyRequests, err, wait)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return false
+}
+
 // RefreshToken handles token refresh
 func (h *AuthHandlers) RefreshToken(c *gin.Context) {
 	var req RefreshRequest
@@ -115,7 +202,11 @@ func (h *AuthHandlers) RefreshToken(c *gin.Context) {
 	// Refresh tokens
 	tokens, err := h.authService.RefreshTokens(req.RefreshToken)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		response := gin.H{"error": err.Error()}
+		if err.Error() == "two-factor authentication is required" {
+			response["mfa_enrollment_required"] = true
+		}
+		c.JSON(http.StatusUnauthorized, response)
 		return
 	}
 
@@ -204,11 +295,73 @@ func (h *AuthHandlers) ChangePassword(c *gin.Context) {
 		return
 	}
 
+	h.recordChange(userID.(uint), models.SecurityEventPasswordChanged, loginAttempt(c, c.GetString("email")))
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Password changed successfully",
 	})
 }
 
+// GetSecurityLog handles listing the user's sign-ins and security changes
+func (h *AuthHandlers) GetSecurityLog(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var query PaginationQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	events, total, err := h.securityService.GetSecurityLog(userID.(uint), query.Page, query.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Calculate total pages
+	totalPages := int(total) / query.Limit
+	if int(total)%query.Limit > 0 {
+		totalPages++
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Data:       events,
+		Page:       query.Page,
+		Limit:      query.Limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
+// loginAttempt describes where a request came from for the security log. The
+// location comes from the geolocation headers of a CDN in front of the API.
+func loginAttempt(c *gin.Context, email string) services.LoginAttempt {
+	return services.LoginAttempt{
+		Email:     email,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Country:   c.GetHeader("CF-IPCountry"),
+		City:      c.GetHeader("CF-IPCity"),
+	}
+}
+
+// respondRetryLater refuses a request that may be retried after wait
+func respondRetryLater(c *gin.Context, status int, err error, wait time.Duration) {
+	retryAfter := int((wait + time.Second - 1) / time.Second)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(status, gin.H{
+		"error":       err.Error(),
+		"retry_after": retryAfter,
+	})
+}
+
 // Logout handles user logout (token invalidation would be handled by client)
 func (h *AuthHandlers) Logout(c *gin.Context) {
 	// In a stateless JWT system, logout is typically handled client-side
@@ -218,4 +371,18 @@ func (h *AuthHandlers) Logout(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Logged out successfully",
 	})
-}
\ No newline at end of file
+}
+
+// GetJWKS handles publishing the public keys tokens are signed with, for
+// other services to verify them
+func (h *AuthHandlers) GetJWKS(c *gin.Context) {
+	keys, err := h.keys.JWKS()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Kept short, as new keys are published before tokens are signed with them
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys)
+}
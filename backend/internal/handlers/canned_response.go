@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/database"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CannedResponseHandlers contains saved replies library handlers
+type CannedResponseHandlers struct {
+	cannedService     *services.CannedResponseService
+	websiteService    *services.WebsiteService
+	assignmentService *services.AssignmentService
+	chatService       *services.ChatService
+}
+
+// NewCannedResponseHandlers creates new CannedResponseHandlers
+func NewCannedResponseHandlers(cfg *config.Config) *CannedResponseHandlers {
+	cannedService := services.NewCannedResponseService(database.DB, cfg)
+	websiteService := services.NewWebsiteService(database.DB, cfg)
+	assignmentService := services.NewAssignmentService(database.DB, cfg, nil)
+	chatService := services.NewChatService(database.DB, cfg)
+	return &CannedResponseHandlers{
+		cannedService:     cannedService,
+		websiteService:    websiteService,
+		assignmentService: assignmentService,
+		chatService:       chatService,
+	}
+}
+
+// CannedResponsesQuery represents canned response list query parameters
+type CannedResponsesQuery struct {
+	Category string `form:"category" binding:"max=50"`
+	Query    string `form:"q" binding:"max=100"`
+}
+
+// CannedLookupQuery represents agent console lookup query parameters
+type CannedLookupQuery struct {
+	Query string `form:"q" binding:"max=100"`
+}
+
+// CannedStatsQuery represents canned response statistics query parameters
+type CannedStatsQuery struct {
+	Days int `form:"days,default=30" binding:"min=1,max=365"`
+}
+
+// GetCannedResponses handles listing a website's canned responses. The
+// website's agents can read the library.
+func (h *CannedResponseHandlers) GetCannedResponses(c *gin.Context) {
+	websiteID, _, ok := h.authorizeWebsite(c, false)
+	if !ok {
+		return
+	}
+
+	var query CannedResponsesQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	responses, categories, err := h.cannedService.GetResponses(websiteID, query.Category, query.Query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"canned_responses": responses,
+		"categories":       categories,
+	})
+}
+
+// CreateCannedResponse handles adding a canned response. Only the website
+// owner manages the library.
+func (h *CannedResponseHandlers) CreateCannedResponse(c *gin.Context) {
+	websiteID, userID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	var req models.CannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := models.ValidateCannedResponse(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.cannedService.CreateResponse(websiteID, userID, &req)
+	if err != nil {
+		c.JSON(cannedErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":         "Canned response created successfully",
+		"canned_response": response,
+	})
+}
+
+// UpdateCannedResponse handles replacing a canned response
+func (h *CannedResponseHandlers) UpdateCannedResponse(c *gin.Context) {
+	websiteID, _, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	responseID, err := strconv.ParseUint(c.Param("response_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid canned response ID"})
+		return
+	}
+
+	var req models.CannedResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := models.ValidateCannedResponse(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.cannedService.UpdateResponse(websiteID, uint(responseID), &req)
+	if err != nil {
+		c.JSON(cannedErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Canned response updated successfully",
+		"canned_response": response,
+	})
+}
+
+// DeleteCannedResponse handles deleting a canned response
+func (h *CannedResponseHandlers) DeleteCannedResponse(c *gin.Context) {
+	websiteID, _, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	responseID, err := strconv.ParseUint(c.Param("response_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid canned response ID"})
+		return
+	}
+
+	if err := h.cannedService.DeleteResponse(websiteID, uint(responseID)); err != nil {
+		c.JSON(cannedErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Canned response deleted successfully"})
+}
+
+// GetCannedResponseStats handles canned response usage statistics
+func (h *CannedResponseHandlers) GetCannedResponseStats(c *gin.Context) {
+	websiteID, _, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	var query CannedStatsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	stats, err := h.cannedService.GetUsageStats(websiteID, query.Days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stats": stats,
+		"days":  query.Days,
+	})
+}
+
+// LookupCannedResponses handles agent console suggestions while typing in
+// a chat, rendered for that chat's visitor
+func (h *CannedResponseHandlers) LookupCannedResponses(c *gin.Context) {
+	chatID, userID, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	var query CannedLookupQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	responses, err := h.cannedService.LookupResponses(chatID, userID, query.Query)
+	if err != nil {
+		c.JSON(cannedErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"canned_responses": responses})
+}
+
+// UseCannedResponse handles an agent inserting a canned response into a
+// chat, returning the rendered text and counting the use
+func (h *CannedResponseHandlers) UseCannedResponse(c *gin.Context) {
+	chatID, userID, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	responseID, err := strconv.ParseUint(c.Param("response_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid canned response ID"})
+		return
+	}
+
+	response, err := h.cannedService.UseResponse(chatID, userID, uint(responseID))
+	if err != nil {
+		c.JSON(cannedErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"canned_response": response})
+}
+
+// authorizeWebsite resolves the website from the route. Owners manage the
+// library; agents may only read it.
+func (h *CannedResponseHandlers) authorizeWebsite(c *gin.Context, ownerOnly bool) (uint, uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, 0, false
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return 0, 0, false
+	}
+
+	if ownerOnly {
+		err = h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint))
+	} else {
+		err = h.assignmentService.ValidateAgentAccess(uint(websiteID), userID.(uint))
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return 0, 0, false
+	}
+
+	return uint(websiteID), userID.(uint), true
+}
+
+// authorizeChat resolves the chat from the route and checks access
+func (h *CannedResponseHandlers) authorizeChat(c *gin.Context) (uint, uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, 0, false
+	}
+
+	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return 0, 0, false
+	}
+
+	// Validate chat access
+	if err := h.chatService.ValidateChatAccess(uint(chatID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return 0, 0, false
+	}
+
+	return uint(chatID), userID.(uint), true
+}
+
+// cannedErrorStatus maps canned response service errors to HTTP statuses
+func cannedErrorStatus(err error) int {
+	switch err.Error() {
+	case "canned response not found", "chat not found", "website not found":
+		return http.StatusNotFound
+	case "shortcut already exists":
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
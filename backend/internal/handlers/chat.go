@@ -1,30 +1,43 @@
 package handlers
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"chatelly-backend/internal/config"
 	"chatelly-backend/internal/database"
+	"chatelly-backend/internal/models"
 	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/mailer"
 
 	"github.com/gin-gonic/gin"
 )
 
 // ChatHandlers contains chat-related handlers
 type ChatHandlers struct {
-	chatService    *services.ChatService
-	websiteService *services.WebsiteService
+	chatService       *services.ChatService
+	websiteService    *services.WebsiteService
+	assignmentService *services.AssignmentService
+	searchService     *services.SearchService
+	transcriptService *services.TranscriptService
 }
 
 // NewChatHandlers creates new ChatHandlers
-func NewChatHandlers(cfg *config.Config) *ChatHandlers {
+func NewChatHandlers(cfg *config.Config, notifier services.AgentNotifier, mail mailer.Mailer) *ChatHandlers {
 	chatService := services.NewChatService(database.DB, cfg)
 	websiteService := services.NewWebsiteService(database.DB, cfg)
+	assignmentService := services.NewAssignmentService(database.DB, cfg, notifier)
+	searchService := services.NewSearchService(database.DB, cfg)
+	transcriptService := services.NewTranscriptService(database.DB, cfg, mail)
 	return &ChatHandlers{
-		chatService:    chatService,
-		websiteService: websiteService,
+		chatService:       chatService,
+		websiteService:    websiteService,
+		assignmentService: assignmentService,
+		searchService:     searchService,
+		transcriptService: transcriptService,
 	}
 }
 
@@ -41,8 +54,27 @@ type ChatAnalyticsQuery struct {
 
 // SearchChatsQuery represents search chats query parameters
 type SearchChatsQuery struct {
-	Query  string `form:"q"`
-	Status string `form:"status" binding:"omitempty,oneof=active ended"`
+	Query    string   `form:"q"`
+	Status   string   `form:"status" binding:"omitempty,oneof=active ended open pending snoozed resolved closed"`
+	Priority string   `form:"priority" binding:"omitempty,oneof=low normal high urgent"`
+	Tags     []string `form:"tag"`
+	PaginationQuery
+}
+
+// TranscriptQuery represents transcript download query parameters
+type TranscriptQuery struct {
+	Format string `form:"format,default=txt" binding:"oneof=txt html pdf"`
+}
+
+// SearchMessagesQuery represents full-text message search query parameters
+type SearchMessagesQuery struct {
+	Query     string `form:"q" binding:"required,max=200"`
+	StartDate string `form:"start_date"`
+	EndDate   string `form:"end_date"`
+	Sender    string `form:"sender" binding:"omitempty,oneof=user bot agent"`
+	Language  string `form:"language"`
+	Tag       string `form:"tag"`
+	Flagged   *bool  `form:"flagged"`
 	PaginationQuery
 }
 
@@ -214,11 +246,332 @@ func (h *ChatHandlers) EndChat(c *gin.Context) {
 		return
 	}
 
+	// Free the agent's slot for queued chats
+	if err := h.assignmentService.ReleaseChat(uint(chatID)); err != nil {
+		log.Printf("Failed to release chat %d: %v", chatID, err)
+	}
+
+	// Email the transcript to the website's recipients and the visitor
+	h.transcriptService.SendChatEndedTranscriptsAsync(uint(chatID))
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Chat ended successfully",
 	})
 }
 
+// GetTranscript handles downloading a chat transcript as txt, html or pdf
+func (h *ChatHandlers) GetTranscript(c *gin.Context) {
+	chatID, _, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	var query TranscriptQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	transcript, err := h.transcriptService.GetTranscript(chatID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "chat not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, contentType, err := services.RenderTranscript(transcript, query.Format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="chat-%d-transcript.%s"`, chatID, query.Format))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// UpdateChat handles triage updates: status, snooze, priority and tags
+func (h *ChatHandlers) UpdateChat(c *gin.Context) {
+	chatID, _, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	var req models.ChatUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	chat, err := h.chatService.UpdateChat(chatID, &req)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch err.Error() {
+		case "chat not found":
+			status = http.StatusNotFound
+		case "chat is closed":
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Closing ends the chat, which frees the agent's slot and sends the transcript
+	if !chat.IsActive {
+		if err := h.assignmentService.ReleaseChat(chatID); err != nil {
+			log.Printf("Failed to release chat %d: %v", chatID, err)
+		}
+		h.transcriptService.SendChatEndedTranscriptsAsync(chatID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Chat updated successfully",
+		"chat":    chat,
+	})
+}
+
+// GetNotes handles listing a chat's internal notes
+func (h *ChatHandlers) GetNotes(c *gin.Context) {
+	chatID, _, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	notes, err := h.chatService.GetNotes(chatID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notes": notes,
+	})
+}
+
+// CreateNote handles adding an internal note to a chat
+func (h *ChatHandlers) CreateNote(c *gin.Context) {
+	chatID, userID, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	var req models.ChatNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	note, err := h.chatService.CreateNote(chatID, userID, req.Content)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "note content is required" {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Note added successfully",
+		"note":    note,
+	})
+}
+
+// UpdateNote handles editing an internal note
+func (h *ChatHandlers) UpdateNote(c *gin.Context) {
+	chatID, userID, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	noteID, err := strconv.ParseUint(c.Param("note_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+		return
+	}
+
+	var req models.ChatNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	note, err := h.chatService.UpdateNote(uint(noteID), chatID, userID, req.Content)
+	if err != nil {
+		c.JSON(noteErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Note updated successfully",
+		"note":    note,
+	})
+}
+
+// DeleteNote handles deleting an internal note
+func (h *ChatHandlers) DeleteNote(c *gin.Context) {
+	chatID, userID, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	noteID, err := strconv.ParseUint(c.Param("note_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid note ID"})
+		return
+	}
+
+	if err := h.chatService.DeleteNote(uint(noteID), chatID, userID); err != nil {
+		c.JSON(noteErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Note deleted successfully",
+	})
+}
+
+// noteErrorStatus maps note errors to HTTP statuses
+func noteErrorStatus(err error) int {
+	switch err.Error() {
+	case "note not found":
+		return http.StatusNotFound
+	case "only the author can change a note":
+		return http.StatusForbidden
+	case "note content is required":
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// AssignChat handles assigning a chat to an agent, or routing it when no agent is given
+func (h *ChatHandlers) AssignChat(c *gin.Context) {
+	chatID, _, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	var req models.ChatAssignRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Validation failed",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	chat, err := h.assignmentService.AssignChat(chatID, req.AgentID)
+	if err != nil {
+		c.JSON(assignmentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Chat assigned successfully",
+		"chat":    chat,
+	})
+}
+
+// TransferChat handles transferring a chat to another agent
+func (h *ChatHandlers) TransferChat(c *gin.Context) {
+	chatID, _, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	var req models.ChatTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	chat, err := h.assignmentService.TransferChat(chatID, req.AgentID)
+	if err != nil {
+		c.JSON(assignmentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Chat transferred successfully",
+		"chat":    chat,
+	})
+}
+
+// UnassignChat handles taking a chat away from its agent
+func (h *ChatHandlers) UnassignChat(c *gin.Context) {
+	chatID, _, ok := h.authorizeChat(c)
+	if !ok {
+		return
+	}
+
+	chat, err := h.assignmentService.UnassignChat(chatID)
+	if err != nil {
+		c.JSON(assignmentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Chat unassigned successfully",
+		"chat":    chat,
+	})
+}
+
+// authorizeChat resolves the chat from the route and checks access, returning
+// the chat and user IDs
+func (h *ChatHandlers) authorizeChat(c *gin.Context) (uint, uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, 0, false
+	}
+
+	chatID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat ID"})
+		return 0, 0, false
+	}
+
+	// Validate chat access
+	if err := h.chatService.ValidateChatAccess(uint(chatID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return 0, 0, false
+	}
+
+	return uint(chatID), userID.(uint), true
+}
+
+// assignmentErrorStatus maps assignment service errors to HTTP statuses
+func assignmentErrorStatus(err error) int {
+	switch err.Error() {
+	case "chat not found", "agent not found":
+		return http.StatusNotFound
+	case "chat is not active", "chat is not assigned", "chat is already assigned",
+		"chat is already assigned to this agent", "agent is offline", "agent is at capacity",
+		"no agent is available":
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
 // GetChatStats handles getting chat statistics
 func (h *ChatHandlers) GetChatStats(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -344,7 +697,12 @@ func (h *ChatHandlers) SearchChats(c *gin.Context) {
 	}
 
 	// Search chats
-	chats, total, err := h.chatService.SearchChats(uint(websiteID), query.Query, query.Status, query.Page, query.Limit)
+	chats, total, err := h.chatService.SearchChats(uint(websiteID), models.ChatSearchFilters{
+		Query:    query.Query,
+		Status:   query.Status,
+		Priority: query.Priority,
+		Tags:     query.Tags,
+	}, query.Page, query.Limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -367,6 +725,87 @@ func (h *ChatHandlers) SearchChats(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// SearchMessages handles full-text search over message content, grouped by chat
+func (h *ChatHandlers) SearchMessages(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var query SearchMessagesQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	filters := models.MessageSearchFilters{
+		Query:    query.Query,
+		Sender:   query.Sender,
+		Language: query.Language,
+		Tag:      query.Tag,
+		Flagged:  query.Flagged,
+	}
+	if query.StartDate != "" {
+		startDate, err := time.Parse("2006-01-02", query.StartDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format (YYYY-MM-DD)"})
+			return
+		}
+		filters.StartDate = &startDate
+	}
+	if query.EndDate != "" {
+		endDate, err := time.Parse("2006-01-02", query.EndDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format (YYYY-MM-DD)"})
+			return
+		}
+		// Include the whole end day
+		endDate = endDate.Add(24*time.Hour - time.Nanosecond)
+		filters.EndDate = &endDate
+	}
+
+	results, total, err := h.searchService.SearchMessages(uint(websiteID), filters, query.Page, query.Limit)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "search query is required", "search query is too long":
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Calculate total pages
+	totalPages := int(total) / query.Limit
+	if int(total)%query.Limit > 0 {
+		totalPages++
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Data:       results,
+		Page:       query.Page,
+		Limit:      query.Limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
 // FlagMessage handles flagging a message for moderation
 func (h *ChatHandlers) FlagMessage(c *gin.Context) {
 	_, exists := c.Get("user_id")
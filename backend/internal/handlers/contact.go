@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/database"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContactHandlers contains contact handlers
+type ContactHandlers struct {
+	contactService    *services.ContactService
+	leadService       *services.LeadService
+	websiteService    *services.WebsiteService
+	assignmentService *services.AssignmentService
+}
+
+// NewContactHandlers creates new ContactHandlers
+func NewContactHandlers(cfg *config.Config) *ContactHandlers {
+	contactService := services.NewContactService(database.DB, cfg)
+	leadService := services.NewLeadService(database.DB, cfg)
+	websiteService := services.NewWebsiteService(database.DB, cfg)
+	assignmentService := services.NewAssignmentService(database.DB, cfg, nil)
+	return &ContactHandlers{
+		contactService:    contactService,
+		leadService:       leadService,
+		websiteService:    websiteService,
+		assignmentService: assignmentService,
+	}
+}
+
+// ContactsQuery represents contact list query parameters
+type ContactsQuery struct {
+	Query          string `form:"q" binding:"max=200"`
+	IdentifiedOnly bool   `form:"identified"`
+	PaginationQuery
+}
+
+// LeadsQuery represents lead list query parameters. Both dates are optional.
+type LeadsQuery struct {
+	StartDate string `form:"start_date"`
+	EndDate   string `form:"end_date"`
+	PaginationQuery
+}
+
+// ContactProfileQuery represents the optional journey window of a contact profile
+type ContactProfileQuery struct {
+	StartDate string `form:"start_date"`
+	EndDate   string `form:"end_date"`
+}
+
+// GetContacts handles listing and searching a website's contacts
+func (h *ContactHandlers) GetContacts(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, false)
+	if !ok {
+		return
+	}
+
+	var query ContactsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	contacts, total, err := h.contactService.GetContacts(websiteID, query.Query, query.IdentifiedOnly, query.Page, query.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Calculate total pages
+	totalPages := int(total) / query.Limit
+	if int(total)%query.Limit > 0 {
+		totalPages++
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Data:       contacts,
+		Page:       query.Page,
+		Limit:      query.Limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
+// GetContact handles getting a contact's profile: details, every chat and
+// the event journey across all of their visitor IDs. The journey covers the
+// whole history unless start_date and end_date are given.
+func (h *ContactHandlers) GetContact(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, false)
+	if !ok {
+		return
+	}
+
+	contactID, ok := parseContactID(c)
+	if !ok {
+		return
+	}
+
+	var query ContactProfileQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	startDate, endDate := time.Time{}, time.Now()
+	if query.StartDate != "" {
+		parsed, err := time.Parse("2006-01-02", query.StartDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format (YYYY-MM-DD)"})
+			return
+		}
+		startDate = parsed
+	}
+	if query.EndDate != "" {
+		parsed, err := time.Parse("2006-01-02", query.EndDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format (YYYY-MM-DD)"})
+			return
+		}
+		endDate = parsed.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	profile, err := h.contactService.GetContactProfile(websiteID, contactID, startDate, endDate)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "contact not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateContact handles editing a contact's details
+func (h *ContactHandlers) UpdateContact(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, false)
+	if !ok {
+		return
+	}
+
+	contactID, ok := parseContactID(c)
+	if !ok {
+		return
+	}
+
+	var req models.ContactUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := models.ValidateContactAttributes(req.Attributes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contact, err := h.contactService.UpdateContact(websiteID, contactID, &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "contact not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Contact updated successfully",
+		"contact": contact,
+	})
+}
+
+// MergeContact handles merging another contact into this one
+func (h *ContactHandlers) MergeContact(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	contactID, ok := parseContactID(c)
+	if !ok {
+		return
+	}
+
+	var req models.ContactMergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	contact, err := h.contactService.MergeContacts(websiteID, contactID, req.SourceID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "contact not found":
+			status = http.StatusNotFound
+		case "cannot merge a contact into itself":
+			status = http.StatusBadRequest
+		case "contacts have different identities":
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Contacts merged successfully",
+		"contact": contact,
+	})
+}
+
+// DeleteContact handles deleting a contact
+func (h *ContactHandlers) DeleteContact(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, true)
+	if !ok {
+		return
+	}
+
+	contactID, ok := parseContactID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.contactService.DeleteContact(websiteID, contactID); err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "contact not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Contact deleted successfully",
+	})
+}
+
+// GetLeads handles listing contacts captured by pre-chat forms, newest first.
+// Leads are exported through the exports endpoints with the leads dataset.
+func (h *ContactHandlers) GetLeads(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c, false)
+	if !ok {
+		return
+	}
+
+	var query LeadsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	var startDate, endDate *time.Time
+	if query.StartDate != "" {
+		parsed, err := time.Parse("2006-01-02", query.StartDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format (YYYY-MM-DD)"})
+			return
+		}
+		startDate = &parsed
+	}
+	if query.EndDate != "" {
+		parsed, err := time.Parse("2006-01-02", query.EndDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format (YYYY-MM-DD)"})
+			return
+		}
+		// Include the whole end day
+		parsed = parsed.Add(24*time.Hour - time.Nanosecond)
+		endDate = &parsed
+	}
+
+	leads, total, err := h.leadService.GetLeads(websiteID, startDate, endDate, query.Page, query.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Calculate total pages
+	totalPages := int(total) / query.Limit
+	if int(total)%query.Limit > 0 {
+		totalPages++
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Data:       leads,
+		Page:       query.Page,
+		Limit:      query.Limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
+// authorizeWebsite resolves the website from the route. Merging and deleting
+// require ownership; the website's agents can view and edit contacts.
+func (h *ContactHandlers) authorizeWebsite(c *gin.Context, manage bool) (uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, false
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return 0, false
+	}
+
+	if manage {
+		err = h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint))
+	} else {
+		err = h.assignmentService.ValidateAgentAccess(uint(websiteID), userID.(uint))
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return 0, false
+	}
+
+	return uint(websiteID), true
+}
+
+// parseContactID reads the contact ID from the route
+func parseContactID(c *gin.Context) (uint, bool) {
+	contactID, err := strconv.ParseUint(c.Param("contact_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contact ID"})
+		return 0, false
+	}
+	return uint(contactID), true
+}
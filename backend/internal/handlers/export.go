@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/database"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportHandlers contains raw data export handlers
+type ExportHandlers struct {
+	exportService  *services.ExportService
+	websiteService *services.WebsiteService
+}
+
+// NewExportHandlers creates new ExportHandlers
+func NewExportHandlers(cfg *config.Config, store storage.Storage) *ExportHandlers {
+	exportService := services.NewExportService(database.DB, cfg, store)
+	websiteService := services.NewWebsiteService(database.DB, cfg)
+	return &ExportHandlers{
+		exportService:  exportService,
+		websiteService: websiteService,
+	}
+}
+
+// exportJobResponse adds a download link to finished export jobs
+func exportJobResponse(job *models.ExportJob) gin.H {
+	response := gin.H{"job": job}
+	if job.Status == models.ExportStatusCompleted {
+		response["download_url"] = fmt.Sprintf("/api/v1/websites/%d/exports/%d/download", job.WebsiteID, job.ID)
+	}
+	return response
+}
+
+// exportValidationStatus maps a ValidateExport error to its HTTP status
+func exportValidationStatus(err error) int {
+	if errors.Is(err, services.ErrExportCheckFailed) {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// parseExportRequest validates an export request into an unsaved export job
+func (h *ExportHandlers) parseExportRequest(c *gin.Context, websiteID, userID uint, req *models.ExportRequest) (*models.ExportJob, bool) {
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format (YYYY-MM-DD)"})
+		return nil, false
+	}
+
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format (YYYY-MM-DD)"})
+		return nil, false
+	}
+
+	job := &models.ExportJob{
+		WebsiteID: websiteID,
+		UserID:    userID,
+		Dataset:   req.Dataset,
+		Format:    req.Format,
+		StartDate: startDate,
+		// Include the whole end day
+		EndDate: endDate.Add(24*time.Hour - time.Nanosecond),
+		Filters: models.ExportFilters{
+			EventType:  req.EventType,
+			Properties: req.Filters,
+		},
+	}
+
+	if err := h.exportService.ValidateExport(job); err != nil {
+		c.JSON(exportValidationStatus(err), gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return job, true
+}
+
+// StreamExport handles direct exports. Small date ranges are streamed with chunked
+// encoding; larger ranges are queued as an export job and answered with 202 Accepted.
+func (h *ExportHandlers) StreamExport(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req models.ExportRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+	req.Filters = c.QueryMap("filter")
+
+	job, ok := h.parseExportRequest(c, uint(websiteID), userID.(uint), &req)
+	if !ok {
+		return
+	}
+
+	if !h.exportService.IsSyncExport(job.StartDate, job.EndDate) {
+		if err := h.exportService.StartExportJob(job); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Location", fmt.Sprintf("/api/v1/websites/%d/exports/%d", job.WebsiteID, job.ID))
+		c.JSON(http.StatusAccepted, exportJobResponse(job))
+		return
+	}
+
+	filename := fmt.Sprintf("%s_%s_to_%s.%s", job.Dataset, req.StartDate, req.EndDate, job.Format)
+	c.Header("Content-Type", models.ExportContentType(job.Format))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Status(http.StatusOK)
+
+	// Headers are sent with the first chunk, so failures can only be logged
+	if _, err := h.exportService.StreamExport(c.Request.Context(), c.Writer, job, c.Writer.Flush); err != nil {
+		log.Printf("Export stream for website %d failed: %v", job.WebsiteID, err)
+		c.Abort()
+	}
+}
+
+// CreateExportJob handles queueing an asynchronous export
+func (h *ExportHandlers) CreateExportJob(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req models.ExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	job, ok := h.parseExportRequest(c, uint(websiteID), userID.(uint), &req)
+	if !ok {
+		return
+	}
+
+	if err := h.exportService.StartExportJob(job); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/api/v1/websites/%d/exports/%d", job.WebsiteID, job.ID))
+	c.JSON(http.StatusAccepted, exportJobResponse(job))
+}
+
+// GetExportJobs handles listing export jobs for a website
+func (h *ExportHandlers) GetExportJobs(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	jobs, err := h.exportService.GetExportJobs(uint(websiteID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": jobs,
+	})
+}
+
+// GetExportJob handles polling the status of an export job
+func (h *ExportHandlers) GetExportJob(c *gin.Context) {
+	job, ok := h.loadExportJob(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, exportJobResponse(job))
+}
+
+// DownloadExport handles downloading a finished export, redirecting to the
+// storage backend when it supports signed URLs
+func (h *ExportHandlers) DownloadExport(c *gin.Context) {
+	job, ok := h.loadExportJob(c)
+	if !ok {
+		return
+	}
+
+	url, body, err := h.exportService.OpenExport(c.Request.Context(), job)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "export is not ready":
+			status = http.StatusConflict
+		case "export has expired":
+			status = http.StatusGone
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if url != "" {
+		c.Redirect(http.StatusFound, url)
+		return
+	}
+	defer body.Close()
+
+	filename := fmt.Sprintf("%s_%s_to_%s.%s", job.Dataset, job.StartDate.Format("2006-01-02"), job.EndDate.Format("2006-01-02"), job.Format)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.DataFromReader(http.StatusOK, job.FileSize, models.ExportContentType(job.Format), body, nil)
+}
+
+// loadExportJob resolves the website and export job from the route
+func (h *ExportHandlers) loadExportJob(c *gin.Context) (*models.ExportJob, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return nil, false
+	}
+
+	jobID, err := strconv.ParseUint(c.Param("job_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export job ID"})
+		return nil, false
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	job, err := h.exportService.GetExportJob(uint(jobID), uint(websiteID))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "export job not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return job, true
+}
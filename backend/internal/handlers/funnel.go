@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/database"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FunnelHandlers contains funnel-related handlers
+type FunnelHandlers struct {
+	funnelService  *services.FunnelService
+	websiteService *services.WebsiteService
+}
+
+// NewFunnelHandlers creates new FunnelHandlers
+func NewFunnelHandlers(cfg *config.Config) *FunnelHandlers {
+	funnelService := services.NewFunnelService(database.DB, cfg)
+	websiteService := services.NewWebsiteService(database.DB, cfg)
+	return &FunnelHandlers{
+		funnelService:  funnelService,
+		websiteService: websiteService,
+	}
+}
+
+// GetFunnels handles listing funnels for a website
+func (h *FunnelHandlers) GetFunnels(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	funnels, err := h.funnelService.GetFunnelsByWebsiteID(uint(websiteID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"funnels": funnels,
+	})
+}
+
+// CreateFunnel handles funnel creation
+func (h *FunnelHandlers) CreateFunnel(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req models.FunnelCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := models.ValidateFunnelSteps(req.Steps); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	funnel, err := h.funnelService.CreateFunnel(uint(websiteID), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Funnel created successfully",
+		"funnel":  funnel,
+	})
+}
+
+// GetFunnel handles getting a single funnel
+func (h *FunnelHandlers) GetFunnel(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	funnelID, err := strconv.ParseUint(c.Param("funnel_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid funnel ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	funnel, err := h.funnelService.GetFunnelByID(uint(funnelID), uint(websiteID))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "funnel not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"funnel": funnel,
+	})
+}
+
+// UpdateFunnel handles funnel updates
+func (h *FunnelHandlers) UpdateFunnel(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	funnelID, err := strconv.ParseUint(c.Param("funnel_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid funnel ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req models.FunnelUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if len(req.Steps) > 0 {
+		if err := models.ValidateFunnelSteps(req.Steps); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	funnel, err := h.funnelService.UpdateFunnel(uint(funnelID), uint(websiteID), &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "funnel not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Funnel updated successfully",
+		"funnel":  funnel,
+	})
+}
+
+// DeleteFunnel handles funnel deletion
+func (h *FunnelHandlers) DeleteFunnel(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	funnelID, err := strconv.ParseUint(c.Param("funnel_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid funnel ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.funnelService.DeleteFunnel(uint(funnelID), uint(websiteID)); err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "funnel not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Funnel deleted successfully",
+	})
+}
+
+// GetFunnelReport handles getting step-by-step drop-off for a funnel
+func (h *FunnelHandlers) GetFunnelReport(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	funnelID, err := strconv.ParseUint(c.Param("funnel_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid funnel ID"})
+		return
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var query AnalyticsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Parse dates
+	startDate, err := time.Parse("2006-01-02", query.StartDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format (YYYY-MM-DD)"})
+		return
+	}
+
+	endDate, err := time.Parse("2006-01-02", query.EndDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format (YYYY-MM-DD)"})
+		return
+	}
+
+	if endDate.Before(startDate) || endDate.Sub(startDate) >= maxReportRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date range must be between 1 and 365 days"})
+		return
+	}
+
+	// Include the whole end day
+	endDate = endDate.Add(24*time.Hour - time.Nanosecond)
+
+	funnel, err := h.funnelService.GetFunnelByID(uint(funnelID), uint(websiteID))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "funnel not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.funnelService.GetFunnelReport(funnel, startDate, endDate)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
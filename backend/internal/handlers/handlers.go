@@ -137,7 +137,12 @@ func HandleWebSocket(hub *websocket.Hub, c *gin.Context) {
 		return
 	}
 
-	websocket.ServeWS(hub, c.Writer, c.Request, sessionID, website.ID)
+	websocket.ServeWS(hub, c.Writer, c.Request, websocket.Visitor{
+		SessionID:      sessionID,
+		WebsiteID:      website.ID,
+		IP:             c.ClientIP(),
+		MaxConnections: website.MaxUsers,
+	})
 }
 
 func GetWidgetConfig(c *gin.Context) {
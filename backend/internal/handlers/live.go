@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/database"
+	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/live"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LiveHandlers contains live analytics stream handlers
+type LiveHandlers struct {
+	feed           *live.Feed
+	websiteService *services.WebsiteService
+}
+
+// NewLiveHandlers creates new LiveHandlers
+func NewLiveHandlers(cfg *config.Config, feed *live.Feed) *LiveHandlers {
+	websiteService := services.NewWebsiteService(database.DB, cfg)
+	return &LiveHandlers{
+		feed:           feed,
+		websiteService: websiteService,
+	}
+}
+
+// StreamSSE handles live analytics over server-sent events
+func (h *LiveHandlers) StreamSSE(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c)
+	if !ok {
+		return
+	}
+
+	h.feed.ServeSSE(c.Writer, c.Request, websiteID)
+}
+
+// StreamWS handles live analytics over WebSocket
+func (h *LiveHandlers) StreamWS(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c)
+	if !ok {
+		return
+	}
+
+	h.feed.ServeWS(c.Writer, c.Request, websiteID)
+}
+
+// authorizeWebsite resolves the website from the route and checks ownership
+func (h *LiveHandlers) authorizeWebsite(c *gin.Context) (uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, false
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return 0, false
+	}
+
+	// Validate website ownership
+	if err := h.websiteService.ValidateWebsiteOwnership(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return 0, false
+	}
+
+	return uint(websiteID), true
+}
@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"chatelly-backend/internal/models"
+	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VerifyMFA handles the second step of a sign-in: a code from the user's
+// authenticator, or a recovery code
+func (h *AuthHandlers) VerifyMFA(c *gin.Context) {
+	var req models.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.authService.MFATokenUser(req.MFAToken, utils.StepTokenMFAChallenge)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Codes are throttled like passwords
+	attempt := loginAttempt(c, user.Email)
+	if !h.checkLogin(c, attempt) {
+		return
+	}
+
+	tokens, err := h.authService.VerifyMFA(user, req.Code, req.RecoveryCode)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "verification code required":
+			status = http.StatusBadRequest
+		case "invalid verification code", "invalid recovery code":
+			status = http.StatusUnauthorized
+			h.recordFailure(attempt, err.Error())
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.recordSuccess(user, attempt)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Login successful",
+		"user":    user.ToResponse(),
+		"tokens":  tokens,
+	})
+}
+
+// BeginMFAEnrollment handles starting two-factor setup for a user who must
+// set it up before signing in
+func (h *AuthHandlers) BeginMFAEnrollment(c *gin.Context) {
+	var req models.MFATokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.authService.MFATokenUser(req.MFAToken, utils.StepTokenMFAEnrollment)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.beginMFASetup(c, user.ID)
+}
+
+// CompleteMFAEnrollment handles confirming two-factor setup during sign-in,
+// which finishes the sign-in
+func (h *AuthHandlers) CompleteMFAEnrollment(c *gin.Context) {
+	var req models.MFAEnableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.authService.MFATokenUser(req.MFAToken, utils.StepTokenMFAEnrollment)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Codes are throttled like passwords
+	attempt := loginAttempt(c, user.Email)
+	if !h.checkLogin(c, attempt) {
+		return
+	}
+
+	tokens, recoveryCodes, err := h.authService.CompleteMFAEnrollment(user, req.Code)
+	if err != nil {
+		if err.Error() == "invalid verification code" {
+			h.recordFailure(attempt, err.Error())
+		}
+		respondMFAError(c, err)
+		return
+	}
+
+	h.recordChange(user.ID, models.SecurityEventMFAEnabled, attempt)
+	h.recordSuccess(user, attempt)
+
+	user.MFAEnabled = true
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"user":           user.ToResponse(),
+		"tokens":         tokens,
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// Reauthenticate handles re-entering credentials before a sensitive action.
+// The returned token goes in the X-Reauth-Token header of the action.
+func (h *AuthHandlers) Reauthenticate(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.ReauthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Passwords and codes are throttled like sign-ins
+	attempt := loginAttempt(c, c.GetString("email"))
+	if !h.checkLogin(c, attempt) {
+		return
+	}
+
+	token, err := h.authService.Reauthenticate(userID.(uint), &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "verification code required":
+			status = http.StatusBadRequest
+		case "invalid password", "invalid verification code", "invalid recovery code":
+			status = http.StatusBadRequest
+			h.recordFailure(attempt, err.Error())
+		case "user not found":
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reauth_token": token,
+		"expires_in":   int(services.ReauthTokenTTL.Seconds()),
+	})
+}
+
+// GetMFAStatus handles getting the user's two-factor authentication status
+func (h *AuthHandlers) GetMFAStatus(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	status, err := h.mfaService.GetStatus(userID.(uint))
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mfa": status})
+}
+
+// SetupMFA handles starting two-factor setup
+func (h *AuthHandlers) SetupMFA(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	h.beginMFASetup(c, userID.(uint))
+}
+
+func (h *AuthHandlers) beginMFASetup(c *gin.Context, userID uint) {
+	setup, err := h.mfaService.BeginSetup(userID)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Scan the QR code with your authenticator app, then enter a code from it",
+		"setup":   setup,
+	})
+}
+
+// EnableMFA handles confirming two-factor setup with a first code
+func (h *AuthHandlers) EnableMFA(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.MFAEnableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Codes are throttled like passwords
+	attempt := loginAttempt(c, c.GetString("email"))
+	if !h.checkLogin(c, attempt) {
+		return
+	}
+
+	recoveryCodes, err := h.mfaService.Enable(userID.(uint), req.Code)
+	if err != nil {
+		if err.Error() == "invalid verification code" {
+			h.recordFailure(attempt, err.Error())
+		}
+		respondMFAError(c, err)
+		return
+	}
+
+	h.recordChange(userID.(uint), models.SecurityEventMFAEnabled, attempt)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// DisableMFA handles turning off two-factor authentication
+func (h *AuthHandlers) DisableMFA(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if err := h.mfaService.Disable(userID.(uint)); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	h.recordChange(userID.(uint), models.SecurityEventMFADisabled, loginAttempt(c, c.GetString("email")))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodes handles replacing the user's recovery codes
+func (h *AuthHandlers) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	recoveryCodes, err := h.mfaService.RegenerateRecoveryCodes(userID.(uint))
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Recovery codes regenerated",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// UpdateMFAEnforcement handles requiring two-factor authentication of the
+// agents on the user's websites
+func (h *AuthHandlers) UpdateMFAEnforcement(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req models.MFAEnforcementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := h.mfaService.SetEnforcement(userID.(uint), *req.Required); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Two-factor enforcement updated",
+		"require_agent_mfa": *req.Required,
+	})
+}
+
+// recordChange logs a change to the security of a user's account
+func (h *AuthHandlers) recordChange(userID uint, eventType string, attempt services.LoginAttempt) {
+	if err := h.securityService.RecordChange(userID, eventType, attempt); err != nil {
+		log.Printf("Failed to record %s for user %d: %v", eventType, userID, err)
+	}
+}
+
+// respondMFAError maps a two-factor service error to a response
+func respondMFAError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch err.Error() {
+	case "user not found":
+		status = http.StatusNotFound
+	case "two-factor authentication is already enabled",
+		"stop requiring two-factor authentication of your agents first":
+		status = http.StatusConflict
+	case "two-factor authentication is required by a website you work on":
+		status = http.StatusForbidden
+	case "two-factor authentication is not set up",
+		"two-factor authentication is not enabled",
+		"invalid verification code",
+		"enable two-factor authentication on your own account first":
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"chatelly-backend/internal/models"
+	"chatelly-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Binds a sign-in's state to the browser that started it, so nobody can
+	// finish their own sign-in in someone else's browser
+	ssoStateCookie = "sso_state"
+	ssoCookiePath  = "/api/v1/auth/sso"
+)
+
+// GetSSOProviders handles listing the identity providers users can sign in with
+func (h *AuthHandlers) GetSSOProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"providers": h.ssoService.Providers(),
+	})
+}
+
+// BeginSSO handles sending the browser to an identity provider to sign in
+func (h *AuthHandlers) BeginSSO(c *gin.Context) {
+	authURL, state, err := h.ssoService.Begin(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		if err.Error() == "unknown sign-in provider" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Failed to start sign-in with %s: %v", c.Param("provider"), err)
+		h.redirectToApp(c, url.Values{"error": {"Sign-in is unavailable, please try again later"}})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, state, int(services.SSOLoginTTL.Seconds()), ssoCookiePath, "", h.secureCookies, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// SSOCallback handles the identity provider sending the browser back, and
+// passes the dashboard a one-time code for the session
+func (h *AuthHandlers) SSOCallback(c *gin.Context) {
+	state, _ := c.Cookie(ssoStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, "", -1, ssoCookiePath, "", h.secureCookies, true)
+
+	if c.Query("error") != "" {
+		h.redirectToApp(c, url.Values{"error": {"Sign-in was cancelled"}})
+		return
+	}
+	if state == "" || c.Query("state") != state || c.Query("code") == "" {
+		h.redirectToApp(c, url.Values{"error": {"invalid or expired sign-in"}})
+		return
+	}
+
+	code, err := h.ssoService.Complete(c.Request.Context(), c.Param("provider"), state, c.Query("code"))
+	if err != nil {
+		switch err.Error() {
+		case "unknown sign-in provider", "invalid or expired sign-in", "sign-in with the provider failed",
+			"email not verified by the provider", "account is deactivated":
+			h.redirectToApp(c, url.Values{"error": {err.Error()}})
+		default:
+			log.Printf("Failed to finish sign-in with %s: %v", c.Param("provider"), err)
+			h.redirectToApp(c, url.Values{"error": {"Sign-in failed, please try again"}})
+		}
+		return
+	}
+
+	h.redirectToApp(c, url.Values{"code": {code}})
+}
+
+// redirectToApp sends the browser to the dashboard's sign-in page
+func (h *AuthHandlers) redirectToApp(c *gin.Context, params url.Values) {
+	c.Redirect(http.StatusFound, h.appURL+"/auth/sso/callback?"+params.Encode())
+}
+
+// ExchangeSSO handles the dashboard exchanging the code from SSOCallback for
+// the same response as Login
+func (h *AuthHandlers) ExchangeSSO(c *gin.Context) {
+	var req models.SSOExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	user, err := h.ssoService.Exchange(req.Code)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "invalid or expired sign-in code":
+			status = http.StatusUnauthorized
+		case "account is deactivated":
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.authService.StartSession(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.respondSession(c, result, loginAttempt(c, user.Email))
+}
+
+// GetIdentities handles listing the identity providers linked to the user
+func (h *AuthHandlers) GetIdentities(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	identities, err := h.ssoService.GetIdentities(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"identities": identities,
+	})
+}
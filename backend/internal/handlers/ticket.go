@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/database"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/mailer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TicketHandlers contains offline ticket handlers
+type TicketHandlers struct {
+	offlineService    *services.OfflineService
+	assignmentService *services.AssignmentService
+}
+
+// NewTicketHandlers creates new TicketHandlers
+func NewTicketHandlers(cfg *config.Config, mail mailer.Mailer) *TicketHandlers {
+	offlineService := services.NewOfflineService(database.DB, cfg, mail)
+	assignmentService := services.NewAssignmentService(database.DB, cfg, nil)
+	return &TicketHandlers{
+		offlineService:    offlineService,
+		assignmentService: assignmentService,
+	}
+}
+
+// TicketsQuery represents ticket list query parameters
+type TicketsQuery struct {
+	Status string `form:"status" binding:"omitempty,oneof=open closed"`
+	PaginationQuery
+}
+
+// GetTickets handles listing a website's offline tickets
+func (h *TicketHandlers) GetTickets(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c)
+	if !ok {
+		return
+	}
+
+	var query TicketsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	tickets, total, err := h.offlineService.GetTickets(websiteID, query.Status, query.Page, query.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Calculate total pages
+	totalPages := int(total) / query.Limit
+	if int(total)%query.Limit > 0 {
+		totalPages++
+	}
+
+	c.JSON(http.StatusOK, PaginatedResponse{
+		Data:       tickets,
+		Page:       query.Page,
+		Limit:      query.Limit,
+		Total:      total,
+		TotalPages: totalPages,
+	})
+}
+
+// UpdateTicket handles opening and closing a ticket
+func (h *TicketHandlers) UpdateTicket(c *gin.Context) {
+	websiteID, ok := h.authorizeWebsite(c)
+	if !ok {
+		return
+	}
+
+	ticketID, err := strconv.ParseUint(c.Param("ticket_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return
+	}
+
+	var req models.OfflineTicketUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	ticket, err := h.offlineService.UpdateTicket(websiteID, uint(ticketID), req.Status)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "ticket not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Ticket updated successfully",
+		"ticket":  ticket,
+	})
+}
+
+// authorizeWebsite resolves the website from the route; the website's agents
+// handle its tickets
+func (h *TicketHandlers) authorizeWebsite(c *gin.Context) (uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, false
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return 0, false
+	}
+
+	if err := h.assignmentService.ValidateAgentAccess(uint(websiteID), userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return 0, false
+	}
+
+	return uint(websiteID), true
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UsageHandlers contains API usage handlers
+type UsageHandlers struct {
+	usageService *services.UsageService
+}
+
+// NewUsageHandlers creates new UsageHandlers. The usage service is the one
+// metering calls, so reports include what it has not written yet.
+func NewUsageHandlers(cfg *config.Config, usageService *services.UsageService) *UsageHandlers {
+	return &UsageHandlers{
+		usageService: usageService,
+	}
+}
+
+// UsageQuery represents usage query parameters
+type UsageQuery struct {
+	Month string `form:"month" binding:"omitempty,len=7"` // YYYY-MM
+}
+
+// GetUsage handles reporting the user's API usage over a month against their
+// plan's quota
+func (h *UsageHandlers) GetUsage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var query UsageQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query parameters",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	report, err := h.usageService.GetUsage(userID.(uint), c.GetString("plan"), query.Month)
+	if err != nil {
+		if err.Error() == "invalid month" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetMonthlyTotals handles listing every user's API calls over a month, for
+// billing
+func (h *UsageHandlers) GetMonthlyTotals(c *gin.Context) {
+	var query UsageQuery
+	if err := c.ShouldBindQuery(&query); err != nil || query.Month == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A month is required, as YYYY-MM"})
+		return
+	}
+
+	// Include the calls still waiting to be written
+	if err := h.usageService.Flush(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	totals, err := h.usageService.GetMonthlyTotals(query.Month)
+	if err != nil {
+		if err.Error() == "invalid month" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"month":  query.Month,
+		"totals": totals,
+	})
+}
@@ -188,6 +188,21 @@ func (h *WebsiteHandlers) UpdateWebsite(c *gin.Context) {
 		return
 	}
 
+	if err := models.ValidatePreChatForm(req.Settings.PreChatForm); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.ValidateBusinessHours(req.Settings.BusinessHours, req.Settings.Timezone, req.Settings.Holidays); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.ValidateTranscriptSettings(req.Settings.Transcripts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Update website
 	website, err := h.websiteService.UpdateWebsite(uint(websiteID), userID.(uint), &req)
 	if err != nil {
@@ -258,6 +273,21 @@ func (h *WebsiteHandlers) UpdateWebsiteSettings(c *gin.Context) {
 		return
 	}
 
+	if err := models.ValidatePreChatForm(settings.PreChatForm); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.ValidateBusinessHours(settings.BusinessHours, settings.Timezone, settings.Holidays); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.ValidateTranscriptSettings(settings.Transcripts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Update settings
 	website, err := h.websiteService.UpdateWebsiteSettings(uint(websiteID), userID.(uint), settings)
 	if err != nil {
@@ -405,6 +435,65 @@ func (h *WebsiteHandlers) RegenerateWidgetKey(c *gin.Context) {
 	})
 }
 
+// GetIdentitySecret handles revealing the secret used to sign identify calls
+func (h *WebsiteHandlers) GetIdentitySecret(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	secret, err := h.websiteService.GetIdentitySecret(uint(websiteID), userID.(uint))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "website not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"identity_secret": secret,
+	})
+}
+
+// RegenerateIdentitySecret handles replacing the identity secret
+func (h *WebsiteHandlers) RegenerateIdentitySecret(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	websiteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid website ID"})
+		return
+	}
+
+	secret, err := h.websiteService.RegenerateIdentitySecret(uint(websiteID), userID.(uint))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "website not found" {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Identity secret regenerated successfully",
+		"identity_secret": secret,
+	})
+}
+
 // SearchWebsites handles searching websites
 func (h *WebsiteHandlers) SearchWebsites(c *gin.Context) {
 	userID, exists := c.Get("user_id")
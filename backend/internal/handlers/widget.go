@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
+	"time"
 
 	"chatelly-backend/internal/config"
 	"chatelly-backend/internal/database"
 	"chatelly-backend/internal/models"
 	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/mailer"
 	"chatelly-backend/pkg/websocket"
 
 	"github.com/gin-gonic/gin"
@@ -14,20 +17,35 @@ import (
 
 // WidgetHandlers contains widget-related handlers
 type WidgetHandlers struct {
-	widgetService  *services.WidgetService
-	websiteService *services.WebsiteService
-	chatService    *services.ChatService
+	widgetService     *services.WidgetService
+	websiteService    *services.WebsiteService
+	chatService       *services.ChatService
+	assignmentService *services.AssignmentService
+	contactService    *services.ContactService
+	leadService       *services.LeadService
+	offlineService    *services.OfflineService
+	transcriptService *services.TranscriptService
 }
 
 // NewWidgetHandlers creates new WidgetHandlers
-func NewWidgetHandlers(cfg *config.Config) *WidgetHandlers {
+func NewWidgetHandlers(cfg *config.Config, notifier services.AgentNotifier, mail mailer.Mailer) *WidgetHandlers {
 	widgetService := services.NewWidgetService(database.DB, cfg)
 	websiteService := services.NewWebsiteService(database.DB, cfg)
 	chatService := services.NewChatService(database.DB, cfg)
+	assignmentService := services.NewAssignmentService(database.DB, cfg, notifier)
+	contactService := services.NewContactService(database.DB, cfg)
+	leadService := services.NewLeadService(database.DB, cfg)
+	offlineService := services.NewOfflineService(database.DB, cfg, mail)
+	transcriptService := services.NewTranscriptService(database.DB, cfg, mail)
 	return &WidgetHandlers{
-		widgetService:  widgetService,
-		websiteService: websiteService,
-		chatService:    chatService,
+		widgetService:     widgetService,
+		websiteService:    websiteService,
+		chatService:       chatService,
+		assignmentService: assignmentService,
+		contactService:    contactService,
+		leadService:       leadService,
+		offlineService:    offlineService,
+		transcriptService: transcriptService,
 	}
 }
 
@@ -50,7 +68,9 @@ func (h *WidgetHandlers) GetWidgetConfig(c *gin.Context) {
 		return
 	}
 
-	// Return configuration
+	// Return configuration. The status reflects business hours right now; the
+	// widget switches to the offline form while offline.
+	status := website.Settings.StatusAt(time.Now())
 	c.JSON(http.StatusOK, gin.H{
 		"widget_key": website.WidgetKey,
 		"website": gin.H{
@@ -58,8 +78,9 @@ func (h *WidgetHandlers) GetWidgetConfig(c *gin.Context) {
 			"name":   website.Name,
 			"domain": website.Domain,
 		},
-		"settings": website.Settings,
+		"settings": website.Settings.Public(),
 		"is_active": website.IsActive,
+		"status": status,
 	})
 }
 
@@ -94,45 +115,335 @@ func (h *WidgetHandlers) GetWidgetScript(c *gin.Context) {
 
 // HandleWebSocket handles WebSocket connections for chat (public endpoint)
 func (h *WidgetHandlers) HandleWebSocket(hub *websocket.Hub, c *gin.Context) {
+	visitor, ok := h.startSession(c)
+	if !ok {
+		return
+	}
+
+	// Serve WebSocket connection
+	websocket.ServeWS(hub, c.Writer, c.Request, visitor)
+}
+
+// HandleEventStream streams chat messages as Server-Sent Events to widgets
+// that cannot open a WebSocket (public endpoint)
+func (h *WidgetHandlers) HandleEventStream(hub *websocket.Hub, c *gin.Context) {
+	visitor, ok := h.startSession(c)
+	if !ok {
+		return
+	}
+
+	websocket.ServeSSE(hub, c.Writer, c.Request, visitor)
+}
+
+// HandlePoll long-polls chat messages for widgets that can neither open a
+// WebSocket nor an event stream (public endpoint). The first poll opens the
+// stream; later ones name it.
+func (h *WidgetHandlers) HandlePoll(hub *websocket.Hub, c *gin.Context) {
+	streamID := c.Query("stream_id")
+	if streamID == "" {
+		visitor, ok := h.startSession(c)
+		if !ok {
+			return
+		}
+		websocket.ServePoll(hub, c.Writer, c.Request, visitor, "")
+		return
+	}
+
+	website, err := h.widgetService.GetWidgetConfig(c.Param("widget_key"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid widget key"})
+		return
+	}
+
+	visitor := websocket.Visitor{SessionID: c.Query("session_id"), WebsiteID: website.ID, IP: c.ClientIP()}
+	websocket.ServePoll(hub, c.Writer, c.Request, visitor, streamID)
+}
+
+// HandleSend takes a message sent on an event stream or polling stream
+// (public endpoint)
+func (h *WidgetHandlers) HandleSend(hub *websocket.Hub, c *gin.Context) {
+	streamID := c.Query("stream_id")
+	if streamID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Stream ID is required"})
+		return
+	}
+
+	website, err := h.widgetService.GetWidgetConfig(c.Param("widget_key"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid widget key"})
+		return
+	}
+
+	visitor := websocket.Visitor{SessionID: c.Query("session_id"), WebsiteID: website.ID, IP: c.ClientIP()}
+	websocket.ServeSend(hub, c.Writer, c.Request, visitor, streamID)
+}
+
+// startSession validates a widget connection and creates or resumes the
+// visitor's chat, whichever transport the widget connects over
+func (h *WidgetHandlers) startSession(c *gin.Context) (websocket.Visitor, bool) {
 	widgetKey := c.Param("widget_key")
 	sessionID := c.Query("session_id")
+	visitorID := c.Query("visitor_id")
+	if len(visitorID) > models.MaxVisitorIDLength {
+		visitorID = ""
+	}
 
 	if widgetKey == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Widget key is required"})
-		return
+		return websocket.Visitor{}, false
 	}
 
 	if sessionID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Session ID is required"})
-		return
+		return websocket.Visitor{}, false
 	}
 
 	// Validate widget key and get website
 	website, err := h.widgetService.GetWidgetConfig(widgetKey)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid widget key"})
-		return
+		return websocket.Visitor{}, false
+	}
+
+	// With a pre-chat form, chats are started by submitting the form
+	if website.Settings.PreChatForm.Enabled {
+		active, err := h.chatService.HasActiveChat(website.ID, sessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up chat session"})
+			return websocket.Visitor{}, false
+		}
+		if !active {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Pre-chat form must be submitted first"})
+			return websocket.Visitor{}, false
+		}
 	}
 
 	// Create or get chat session
-	visitorIP := getClientIP(c.Request)
+	visitorIP := c.ClientIP()
 	userAgent := c.Request.UserAgent()
 	language := c.Request.Header.Get("Accept-Language")
 	if language == "" {
 		language = "en"
 	}
 
-	chat, err := h.chatService.CreateOrGetChat(website.ID, sessionID, visitorIP, userAgent, language)
+	chat, err := h.chatService.CreateOrGetChat(website.ID, sessionID, visitorID, visitorIP, userAgent, language)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat session"})
-		return
+		return websocket.Visitor{}, false
+	}
+
+	// Hand new chats to an agent, or queue them
+	if chat.AssignedAgentID == nil && chat.QueuedAt == nil {
+		if _, err := h.assignmentService.RouteChat(chat.ID); err != nil {
+			log.Printf("Failed to route chat %d: %v", chat.ID, err)
+		}
 	}
 
 	// Store chat ID in context for WebSocket handlers
 	c.Set("chat_id", chat.ID)
 
-	// Serve WebSocket connection
-	websocket.ServeWS(hub, c.Writer, c.Request, sessionID, website.ID)
+	// The hub caps the website's concurrent visitor connections
+	return websocket.Visitor{
+		SessionID:      sessionID,
+		WebsiteID:      website.ID,
+		VisitorID:      visitorID,
+		IP:             visitorIP,
+		MaxConnections: website.MaxUsers,
+	}, true
+}
+
+// Identify handles attaching identity details to a widget visitor (public endpoint)
+func (h *WidgetHandlers) Identify(c *gin.Context) {
+	widgetKey := c.Param("widget_key")
+	if widgetKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Widget key is required"})
+		return
+	}
+
+	var req models.ContactIdentifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := models.ValidateContactAttributes(req.Attributes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	website, err := h.widgetService.GetWidgetConfig(widgetKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid widget key"})
+		return
+	}
+
+	contact, err := h.contactService.Identify(website.ID, &req)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "external_id or email is required", "invalid visitor ID":
+			status = http.StatusBadRequest
+		case "invalid identity hash", "identity hash is required":
+			status = http.StatusUnauthorized
+		case "website not found":
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The widget only learns which contact it is, not what is stored about them
+	c.JSON(http.StatusOK, gin.H{
+		"contact_id": contact.ID,
+		"verified":   contact.Verified,
+	})
+}
+
+// SubmitPreChatForm handles a pre-chat form submission, which starts the
+// visitor's chat (public endpoint)
+func (h *WidgetHandlers) SubmitPreChatForm(c *gin.Context) {
+	widgetKey := c.Param("widget_key")
+	if widgetKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Widget key is required"})
+		return
+	}
+
+	var req models.PreChatSubmission
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	website, err := h.widgetService.GetWidgetConfig(widgetKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid widget key"})
+		return
+	}
+
+	form := website.Settings.PreChatForm
+	if !form.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pre-chat form is not enabled"})
+		return
+	}
+	if _, err := models.ValidatePreChatSubmission(form, req.Values, req.Consent); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get client information
+	language := c.Request.Header.Get("Accept-Language")
+	if language == "" {
+		language = "en"
+	}
+
+	chat, err := h.leadService.SubmitPreChatForm(website, &req, getClientIP(c.Request), c.Request.UserAgent(), language, c.Request.Referer())
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err.Error() == "pre-chat form already submitted" {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Pre-chat form submitted successfully",
+		"chat_id": chat.ID,
+	})
+}
+
+// SubmitOfflineMessage handles the offline form, turning the message into a
+// ticket that is emailed to the website owner (public endpoint)
+func (h *WidgetHandlers) SubmitOfflineMessage(c *gin.Context) {
+	widgetKey := c.Param("widget_key")
+	if widgetKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Widget key is required"})
+		return
+	}
+
+	var req models.OfflineTicketRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	website, err := h.widgetService.GetWidgetConfig(widgetKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid widget key"})
+		return
+	}
+
+	ticket, err := h.offlineService.CreateTicket(website, &req, getClientIP(c.Request), c.Request.UserAgent())
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "message is required", "message is too long", "invalid visitor ID":
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Email delivery must not hold up the visitor
+	h.offlineService.NotifyOwnerAsync(ticket)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   "Message received",
+		"ticket_id": ticket.ID,
+	})
+}
+
+// RequestTranscript handles a visitor asking for their chat transcript by
+// email, to an address they already left. It is sent when the chat ends, or
+// right away for ended chats (public endpoint).
+func (h *WidgetHandlers) RequestTranscript(c *gin.Context) {
+	widgetKey := c.Param("widget_key")
+	if widgetKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Widget key is required"})
+		return
+	}
+
+	var req models.TranscriptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Validation failed",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	website, err := h.widgetService.GetWidgetConfig(widgetKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid widget key"})
+		return
+	}
+
+	if err := h.transcriptService.RequestTranscript(c.Request.Context(), website, &req); err != nil {
+		status := http.StatusInternalServerError
+		switch err.Error() {
+		case "transcript requests are not enabled", "chat not found":
+			status = http.StatusNotFound
+		case "transcript already sent":
+			status = http.StatusConflict
+		case "no email address for this chat", "email does not match the chat":
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Transcript will be emailed",
+	})
 }
 
 // GetAvailableThemes handles getting available widget themes (protected endpoint)
@@ -165,6 +476,12 @@ func (h *WidgetHandlers) ValidateWidgetSettings(c *gin.Context) {
 		CustomCSS          string            `json:"custom_css"`
 		AllowedDomains     []string          `json:"allowed_domains"`
 		BusinessHours      map[string]string `json:"business_hours"`
+		Timezone           string            `json:"timezone"`
+		Holidays           []string          `json:"holidays"`
+		IdentityVerification bool            `json:"identity_verification"`
+		PreChatForm        models.PreChatForm `json:"pre_chat_form"`
+		Transcripts        models.TranscriptSettings `json:"transcripts"`
+		CannedBotReplies   bool              `json:"canned_bot_replies"`
 	}
 
 	if err := c.ShouldBindJSON(&settings); err != nil {
@@ -188,6 +505,12 @@ func (h *WidgetHandlers) ValidateWidgetSettings(c *gin.Context) {
 		CustomCSS:          settings.CustomCSS,
 		AllowedDomains:     settings.AllowedDomains,
 		BusinessHours:      settings.BusinessHours,
+		Timezone:           settings.Timezone,
+		Holidays:           settings.Holidays,
+		IdentityVerification: settings.IdentityVerification,
+		PreChatForm:        settings.PreChatForm,
+		Transcripts:        settings.Transcripts,
+		CannedBotReplies:   settings.CannedBotReplies,
 	}
 
 	// Validate settings
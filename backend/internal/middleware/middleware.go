@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"chatelly-backend/internal/config"
-	"chatelly-backend/pkg/redis"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/internal/services"
+	"chatelly-backend/pkg/ratelimit"
 	"chatelly-backend/pkg/utils"
 
 	"github.com/gin-gonic/gin"
@@ -30,7 +32,7 @@ func CORS(cfg *config.Config) gin.HandlerFunc {
 		}
 		
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Reauth-Token")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400") // 24 hours
 
@@ -84,7 +86,7 @@ func Recovery() gin.HandlerFunc {
 }
 
 // AuthRequired middleware
-func AuthRequired(cfg *config.Config) gin.HandlerFunc {
+func AuthRequired(keys *utils.KeySet) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		
@@ -97,7 +99,7 @@ func AuthRequired(cfg *config.Config) gin.HandlerFunc {
 		}
 
 		// Validate access token
-		claims, err := utils.ValidateAccessToken(tokenString, cfg)
+		claims, err := utils.ValidateAccessToken(tokenString, keys)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
@@ -113,92 +115,192 @@ func AuthRequired(cfg *config.Config) gin.HandlerFunc {
 	}
 }
 
-// RateLimitConfig represents rate limiting configuration
-type RateLimitConfig struct {
-	RequestsPerMinute int
-	RequestsPerHour   int
-	BurstSize         int
-}
+// StreamAuth authenticates long-lived streams. Browsers cannot set headers on
+// EventSource or WebSocket requests, so the access token may also be passed as
+// the access_token query parameter.
+func StreamAuth(keys *utils.KeySet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := c.Query("access_token")
+		if tokenString == "" {
+			var err error
+			tokenString, err = utils.ExtractTokenFromHeader(c.GetHeader("Authorization"))
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+		}
+
+		// Validate access token
+		claims, err := utils.ValidateAccessToken(tokenString, keys)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.Abort()
+			return
+		}
+
+		// Set user information in context
+		c.Set("user_id", claims.UserID)
+		c.Set("email", claims.Email)
+		c.Set("plan", claims.Plan)
 
-// GetDefaultRateLimits returns default rate limits
-func GetDefaultRateLimits() RateLimitConfig {
-	return RateLimitConfig{
-		RequestsPerMinute: 60,
-		RequestsPerHour:   1000,
-		BurstSize:         10,
+		c.Next()
 	}
 }
 
-// RateLimit middleware with Redis backend
-func RateLimit(cfg *config.Config) gin.HandlerFunc {
-	limits := GetDefaultRateLimits()
-	
+// ReauthHeader carries the token from re-authenticating to sensitive actions
+const ReauthHeader = "X-Reauth-Token"
+
+// RequireReauth requires a signed-in user to have re-entered their
+// credentials recently. It runs after AuthRequired.
+func RequireReauth(keys *utils.KeySet) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get client identifier (IP or user ID)
-		clientID := getClientIdentifier(c)
-		
-		// Check rate limits
-		if isRateLimited(clientID, limits) {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded",
-				"retry_after": 60,
+		claims, err := utils.ValidateStepToken(c.GetHeader(ReauthHeader), utils.StepTokenReauth, keys)
+		if err != nil || claims.UserID != c.GetUint("user_id") {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":           "Re-authentication required",
+				"reauth_required": true,
 			})
 			c.Abort()
 			return
 		}
-		
+
 		c.Next()
 	}
 }
 
-// APIRateLimit middleware for API endpoints with stricter limits
-func APIRateLimit(cfg *config.Config) gin.HandlerFunc {
-	limits := RateLimitConfig{
-		RequestsPerMinute: 100,
-		RequestsPerHour:   2000,
-		BurstSize:         20,
-	}
-	
+// The limits a 429 response names
+const (
+	LimitRate            = "rate"
+	LimitMonthlyAPICalls = "monthly_api_calls"
+)
+
+// RateLimit limits each client to limit, counted separately for each named
+// route group. Signed-in users are counted by user, anyone else by IP.
+func RateLimit(limiter ratelimit.Limiter, group string, limit ratelimit.Limit) gin.HandlerFunc {
+	return rateLimit(limiter, group, func(c *gin.Context) (string, ratelimit.Limit) {
+		return getClientIdentifier(c), limit
+	})
+}
+
+// APIRateLimit limits API requests. Signed-in users get their plan's limit
+// wherever they connect from; anyone else is limited by IP.
+func APIRateLimit(cfg *config.Config, keys *utils.KeySet, limiter ratelimit.Limiter) gin.HandlerFunc {
+	anonymous := ratelimit.PerMinute(cfg.RateLimit.APIPerMinute, cfg.RateLimit.APIBurst)
+
+	return rateLimit(limiter, "api", func(c *gin.Context) (string, ratelimit.Limit) {
+		// This runs before AuthRequired, so look at the token here. An invalid
+		// one is limited by IP, and rejected later.
+		tokenString, err := utils.ExtractTokenFromHeader(c.GetHeader("Authorization"))
+		if err == nil {
+			if claims, err := utils.ValidateAccessToken(tokenString, keys); err == nil {
+				plan := models.GetPlanLimits(claims.Plan)
+				return fmt.Sprintf("user:%d", claims.UserID), ratelimit.PerMinute(plan.RequestsPerMinute, plan.RequestBurst)
+			}
+		}
+		return getClientIdentifier(c), anonymous
+	})
+}
+
+// WidgetRateLimit limits widget requests by IP, with more lenient limits
+func WidgetRateLimit(cfg *config.Config, limiter ratelimit.Limiter) gin.HandlerFunc {
+	return RateLimit(limiter, "widget", ratelimit.PerMinute(cfg.RateLimit.WidgetPerMinute, cfg.RateLimit.WidgetBurst))
+}
+
+// WidgetKeyRateLimit limits requests to each website's widget, whoever
+// makes them
+func WidgetKeyRateLimit(limiter ratelimit.Limiter, group string, limit ratelimit.Limit) gin.HandlerFunc {
+	return rateLimit(limiter, group, func(c *gin.Context) (string, ratelimit.Limit) {
+		return "widget:" + c.Param("widget_key"), limit
+	})
+}
+
+// rateLimit limits requests by the key and limit lookup returns. Every
+// response carries the RateLimit headers; refused ones also get Retry-After.
+func rateLimit(limiter ratelimit.Limiter, group string, lookup func(c *gin.Context) (string, ratelimit.Limit)) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		clientID := getClientIdentifier(c)
-		
-		if isRateLimited(clientID, limits) {
+		key, limit := lookup(c)
+
+		result, err := limiter.Allow(c.Request.Context(), group+":"+key, limit)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Rate limiter unavailable"})
+			c.Abort()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", limit.Rate, ceilSeconds(limit.Period), result.Limit))
+
+		if !result.Allowed {
+			retryAfter := ceilSeconds(result.RetryAfter)
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
 			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "API rate limit exceeded",
-				"retry_after": 60,
+				"error":       fmt.Sprintf("Rate limit of %s exceeded", limit),
+				"limit":       LimitRate,
+				"retry_after": retryAfter,
 			})
 			c.Abort()
 			return
 		}
-		
+
 		c.Next()
 	}
 }
 
-// WidgetRateLimit middleware for widget endpoints with more lenient limits
-func WidgetRateLimit(cfg *config.Config) gin.HandlerFunc {
-	limits := RateLimitConfig{
-		RequestsPerMinute: 200,
-		RequestsPerHour:   5000,
-		BurstSize:         50,
-	}
-	
+// APIQuota meters signed-in users' API calls against their plan's monthly
+// quota. It must run after AuthRequired.
+func APIQuota(usage *services.UsageService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		clientID := getClientIdentifier(c)
-		
-		if isRateLimited(clientID, limits) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.Next()
+			return
+		}
+
+		plan := models.GetPlanLimits(c.GetString("plan"))
+		result, err := usage.RecordCall(userID.(uint), plan.MonthlyAPICalls)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check API quota"})
+			c.Abort()
+			return
+		}
+
+		if result.Quota >= 0 {
+			c.Header("X-Quota-Limit", strconv.FormatInt(result.Quota, 10))
+			remaining := result.Quota - result.Used
+			if remaining < 0 {
+				remaining = 0
+			}
+			c.Header("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+			c.Header("X-Quota-Reset", result.ResetsAt.Format(time.RFC3339))
+		}
+
+		if !result.Allowed {
+			retryAfter := ceilSeconds(time.Until(result.ResetsAt))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
 			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Widget rate limit exceeded",
-				"retry_after": 60,
+				"error":       fmt.Sprintf("Monthly quota of %d API calls on the %s plan exceeded", result.Quota, plan.Plan),
+				"limit":       LimitMonthlyAPICalls,
+				"quota":       result.Quota,
+				"used":        result.Used,
+				"resets_at":   result.ResetsAt,
+				"retry_after": retryAfter,
 			})
 			c.Abort()
 			return
 		}
-		
+
 		c.Next()
 	}
 }
 
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
 // getClientIdentifier returns client identifier for rate limiting
 func getClientIdentifier(c *gin.Context) string {
 	// Try to get user ID first
@@ -211,42 +313,6 @@ func getClientIdentifier(c *gin.Context) string {
 	return fmt.Sprintf("ip:%s", ip)
 }
 
-// isRateLimited checks if client is rate limited
-func isRateLimited(clientID string, limits RateLimitConfig) bool {
-	// Check minute limit
-	minuteKey := fmt.Sprintf("rate_limit:minute:%s", clientID)
-	minuteCount, err := redis.Incr(minuteKey)
-	if err != nil {
-		// If Redis is down, allow request but log error
-		return false
-	}
-	
-	if minuteCount == 1 {
-		redis.Expire(minuteKey, time.Minute)
-	}
-	
-	if minuteCount > int64(limits.RequestsPerMinute) {
-		return true
-	}
-	
-	// Check hour limit
-	hourKey := fmt.Sprintf("rate_limit:hour:%s", clientID)
-	hourCount, err := redis.Incr(hourKey)
-	if err != nil {
-		return false
-	}
-	
-	if hourCount == 1 {
-		redis.Expire(hourKey, time.Hour)
-	}
-	
-	if hourCount > int64(limits.RequestsPerHour) {
-		return true
-	}
-	
-	return false
-}
-
 // SecurityHeaders middleware adds security headers
 func SecurityHeaders() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -380,7 +446,7 @@ func WebsiteAuth() gin.HandlerFunc {
 }
 
 // AdminAuth middleware for admin endpoints
-func AdminAuth(cfg *config.Config) gin.HandlerFunc {
+func AdminAuth(keys *utils.KeySet) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// First check if user is authenticated
 		authHeader := c.GetHeader("Authorization")
@@ -392,7 +458,7 @@ func AdminAuth(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		claims, err := utils.ValidateAccessToken(tokenString, cfg)
+		claims, err := utils.ValidateAccessToken(tokenString, keys)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
@@ -413,51 +479,3 @@ func AdminAuth(cfg *config.Config) gin.HandlerFunc {
 		c.Next()
 	}
 }
-
-// BruteForceProtection middleware protects against brute force attacks
-func BruteForceProtection(cfg *config.Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Only apply to login endpoints
-		if !strings.Contains(c.Request.URL.Path, "/auth/login") {
-			c.Next()
-			return
-		}
-		
-		clientIP := c.ClientIP()
-		key := fmt.Sprintf("brute_force:%s", clientIP)
-		
-		// Check failed attempts
-		attemptsStr, err := redis.Get(key)
-		if err != nil && err.Error() != "redis: nil" {
-			// Redis error, allow request
-			c.Next()
-			return
-		}
-		
-		attempts := 0
-		if attemptsStr != "" {
-			attempts, _ = strconv.Atoi(attemptsStr)
-		}
-		
-		// Block if too many attempts
-		if attempts >= 5 {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Too many failed login attempts",
-				"retry_after": 900, // 15 minutes
-			})
-			c.Abort()
-			return
-		}
-		
-		c.Next()
-		
-		// Check if login failed (status 401)
-		if c.Writer.Status() == http.StatusUnauthorized {
-			attempts++
-			redis.Set(key, attempts, 15*time.Minute)
-		} else if c.Writer.Status() == http.StatusOK {
-			// Successful login, reset counter
-			redis.Del(key)
-		}
-	}
-}
\ No newline at end of file
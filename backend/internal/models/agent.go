@@ -0,0 +1,132 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultMaxConcurrentChats is the chat cap for agents added without one
+const DefaultMaxConcurrentChats = 5
+
+// Agent presence statuses
+const (
+	AgentStatusOnline  = "online"
+	AgentStatusAway    = "away"
+	AgentStatusOffline = "offline"
+)
+
+// Chat routing strategies
+const (
+	// RoutingRoundRobin assigns to the available agent who was assigned least recently
+	RoutingRoundRobin = "round_robin"
+	// RoutingLeastBusy assigns to the available agent with the fewest active chats
+	RoutingLeastBusy = "least_busy"
+	// RoutingSticky assigns to the agent who last handled the visitor, falling back to least busy
+	RoutingSticky = "sticky"
+)
+
+// WebsiteAgent grants a user access to a website's chats and makes them eligible for routing
+type WebsiteAgent struct {
+	ID                 uint       `json:"id" gorm:"primaryKey"`
+	WebsiteID          uint       `json:"website_id" gorm:"not null;uniqueIndex:idx_website_agents_website_user"`
+	UserID             uint       `json:"user_id" gorm:"not null;uniqueIndex:idx_website_agents_website_user;index"`
+	MaxConcurrentChats int        `json:"max_concurrent_chats" gorm:"default:5"`
+	LastAssignedAt     *time.Time `json:"last_assigned_at"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+
+	// Relationships
+	Website Website `json:"-" gorm:"foreignKey:WebsiteID"`
+	User    User    `json:"-" gorm:"foreignKey:UserID"`
+}
+
+// AgentPresence is a user's availability for new chats, shared across websites
+type AgentPresence struct {
+	UserID     uint      `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	Status     string    `json:"status" gorm:"not null;default:'offline'"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// AgentCreateRequest represents the request payload for adding an agent to a website
+type AgentCreateRequest struct {
+	Email              string `json:"email" binding:"required,email"`
+	MaxConcurrentChats int    `json:"max_concurrent_chats" binding:"omitempty,min=1,max=100"`
+}
+
+// AgentUpdateRequest represents the request payload for agent updates
+type AgentUpdateRequest struct {
+	MaxConcurrentChats int `json:"max_concurrent_chats" binding:"required,min=1,max=100"`
+}
+
+// AgentStatusRequest represents the request payload for presence updates
+type AgentStatusRequest struct {
+	Status string `json:"status" binding:"required,oneof=online away offline"`
+}
+
+// ChatAssignRequest represents the request payload for assigning a chat. Without
+// an agent ID the chat is routed using the website's strategy.
+type ChatAssignRequest struct {
+	AgentID uint `json:"agent_id"`
+}
+
+// ChatTransferRequest represents the request payload for transferring a chat
+type ChatTransferRequest struct {
+	AgentID uint `json:"agent_id" binding:"required"`
+}
+
+// RoutingUpdateRequest represents the request payload for changing a website's routing strategy
+type RoutingUpdateRequest struct {
+	Strategy string `json:"strategy" binding:"required,oneof=round_robin least_busy sticky"`
+}
+
+// AgentResponse represents an agent with current presence and workload
+type AgentResponse struct {
+	ID                 uint       `json:"id"`
+	UserID             uint       `json:"user_id"`
+	Name               string     `json:"name"`
+	Email              string     `json:"email"`
+	Status             string     `json:"status"`
+	ActiveChats        int64      `json:"active_chats"`
+	MaxConcurrentChats int        `json:"max_concurrent_chats"`
+	LastAssignedAt     *time.Time `json:"last_assigned_at"`
+}
+
+// IsValidAgentStatus checks if a presence status is supported
+func IsValidAgentStatus(status string) bool {
+	switch status {
+	case AgentStatusOnline, AgentStatusAway, AgentStatusOffline:
+		return true
+	}
+	return false
+}
+
+// IsValidRoutingStrategy checks if a routing strategy is supported
+func IsValidRoutingStrategy(strategy string) bool {
+	switch strategy {
+	case RoutingRoundRobin, RoutingLeastBusy, RoutingSticky:
+		return true
+	}
+	return false
+}
+
+// BeforeSave is a GORM hook that applies the default chat cap
+func (a *WebsiteAgent) BeforeSave(tx *gorm.DB) error {
+	if a.MaxConcurrentChats <= 0 {
+		a.MaxConcurrentChats = DefaultMaxConcurrentChats
+	}
+	return nil
+}
+
+// BeforeSave is a GORM hook that validates the presence status
+func (p *AgentPresence) BeforeSave(tx *gorm.DB) error {
+	if p.Status == "" {
+		p.Status = AgentStatusOffline
+	}
+	if !IsValidAgentStatus(p.Status) {
+		return errors.New("invalid agent status")
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -51,6 +52,24 @@ func (ad *AnalyticsData) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, ad)
 }
 
+// ConversionValue returns the monetary value stored in event_data.value, if any
+func (ad AnalyticsData) ConversionValue() float64 {
+	switch v := ad["value"].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case string:
+		var f float64
+		if _, err := fmt.Sscanf(v, "%g", &f); err == nil {
+			return f
+		}
+	}
+	return 0
+}
+
 // Analytics event types
 const (
 	EventTypePageView      = "page_view"
@@ -136,6 +155,53 @@ type ResponseTimeMetric struct {
 	TimeRange string  `json:"time_range"`
 	AvgTime   float64 `json:"avg_time"`
 	Count     int64   `json:"count"`
+	Responder string  `json:"responder,omitempty"` // 'human' or 'bot'
+}
+
+// ResponseTimeReport represents response and resolution times computed from message timestamps
+type ResponseTimeReport struct {
+	WebsiteID         uint                  `json:"website_id"`
+	StartDate         time.Time             `json:"start_date"`
+	EndDate           time.Time             `json:"end_date"`
+	AvgFirstResponse  float64               `json:"avg_first_response"`
+	AvgReplyTime      float64               `json:"avg_reply_time"`
+	AvgResolutionTime float64               `json:"avg_resolution_time"`
+	ResolvedChats     int64                 `json:"resolved_chats"`
+	FirstResponse     []ResponseTimeMetric  `json:"first_response"`
+	ReplyTimes        []ResponseTimeMetric  `json:"reply_times"`
+	Agents            []AgentResponseMetric `json:"agents"`
+}
+
+// AgentResponseMetric represents response times for a single agent
+type AgentResponseMetric struct {
+	AgentID          uint    `json:"agent_id"`
+	FirstResponses   int64   `json:"first_responses"`
+	AvgFirstResponse float64 `json:"avg_first_response"`
+	Replies          int64   `json:"replies"`
+	AvgReplyTime     float64 `json:"avg_reply_time"`
+}
+
+// Responder types used to split response time metrics
+const (
+	ResponderHuman = "human"
+	ResponderBot   = "bot"
+)
+
+// ResponseTimeRanges lists the buckets response times are grouped into, in order
+var ResponseTimeRanges = []string{"0-30s", "30s-1m", "1-2m", "2m+"}
+
+// GetResponseTimeRange returns the bucket a response time in seconds falls into
+func GetResponseTimeRange(seconds float64) string {
+	switch {
+	case seconds < 30:
+		return "0-30s"
+	case seconds < 60:
+		return "30s-1m"
+	case seconds < 120:
+		return "1-2m"
+	default:
+		return "2m+"
+	}
 }
 
 // WebsiteAnalytics represents comprehensive website analytics
@@ -190,6 +256,7 @@ type ConversionMetrics struct {
 	EmailCaptures      int64   `json:"email_captures"`
 	LeadGeneration     int64   `json:"lead_generation"`
 	SalesConversions   int64   `json:"sales_conversions"`
+	Revenue            float64 `json:"revenue"`
 }
 
 // TemporalMetrics represents time-based metrics
@@ -208,11 +275,15 @@ type WeeklyMetric struct {
 
 // BeforeCreate is a GORM hook that runs before creating analytics
 func (a *Analytics) BeforeCreate(tx *gorm.DB) error {
-	// Validate event type
-	if !IsValidEventType(a.EventType) {
+	// Validate event type against built-in and website-defined types
+	valid, err := IsValidEventTypeForWebsite(tx.Session(&gorm.Session{NewDB: true}), a.WebsiteID, a.EventType)
+	if err != nil {
+		return err
+	}
+	if !valid {
 		return errors.New("invalid event type")
 	}
-	
+
 	return nil
 }
 
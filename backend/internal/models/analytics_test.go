@@ -0,0 +1,25 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestGetResponseTimeRange(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{0, "0-30s"},
+		{29.9, "0-30s"},
+		{30, "30s-1m"},
+		{60, "1-2m"},
+		{119, "1-2m"},
+		{120, "2m+"},
+	}
+
+	for _, tt := range tests {
+		if got := GetResponseTimeRange(tt.seconds); got != tt.want {
+			t.Errorf("GetResponseTimeRange(%v) = %v, want %v", tt.seconds, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Attachment uploaders
+const (
+	UploaderVisitor = "visitor"
+	UploaderAgent   = "agent"
+)
+
+// Attachment scan statuses
+const (
+	ScanStatusClean     = "clean"
+	ScanStatusUnscanned = "unscanned" // no virus scanner is configured
+)
+
+// MaxAttachmentSize caps uploads on every plan; uploads must fit in the 10MB
+// request limit together with the multipart envelope
+const MaxAttachmentSize = 8 << 20
+
+// MaxAttachmentCaptionLength caps the message sent along with an attachment
+const MaxAttachmentCaptionLength = 2000
+
+// Attachment types by family. Files are identified by their content, not the
+// name or type the client sends.
+var (
+	ImageAttachmentTypes    = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+	DocumentAttachmentTypes = []string{"application/pdf", "text/plain"}
+	OfficeAttachmentTypes   = []string{
+		"text/csv",
+		"application/zip",
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	}
+)
+
+// officeExtensions maps Office Open XML extensions to their content types;
+// the files themselves sniff as zip archives
+var officeExtensions = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+// Attachment is a file uploaded to a chat. The file and its thumbnail live
+// in object storage.
+type Attachment struct {
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	WebsiteID    uint           `json:"website_id" gorm:"not null;index"`
+	ChatID       uint           `json:"chat_id" gorm:"not null;index"`
+	MessageID    *uint          `json:"message_id,omitempty" gorm:"index"`
+	UploaderType string         `json:"uploader_type" gorm:"not null"` // 'visitor' or 'agent'
+	UploadedBy   *uint          `json:"uploaded_by,omitempty"`         // dashboard user for agent uploads
+	Filename     string         `json:"filename" gorm:"not null"`
+	ContentType  string         `json:"content_type" gorm:"not null"`
+	Size         int64          `json:"size"`
+	SHA256       string         `json:"sha256" gorm:"size:64"`
+	StorageKey   string         `json:"-" gorm:"not null"`
+	ThumbnailKey string         `json:"-"`
+	HasThumbnail bool           `json:"has_thumbnail" gorm:"-"`
+	Width        int            `json:"width,omitempty"`
+	Height       int            `json:"height,omitempty"`
+	ScanStatus   string         `json:"scan_status" gorm:"not null;default:'unscanned'"`
+	CreatedAt    time.Time      `json:"created_at"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// AfterFind exposes whether a thumbnail exists without leaking its key
+func (a *Attachment) AfterFind(tx *gorm.DB) error {
+	a.HasThumbnail = a.ThumbnailKey != ""
+	return nil
+}
+
+// IsImage reports whether the attachment is shown inline as an image
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// AttachmentContentType refines a sniffed content type using the file
+// extension where the content alone is ambiguous. Only the family the content
+// was sniffed as can be refined, so a renamed file cannot change its type.
+func AttachmentContentType(sniffed, filename string) string {
+	contentType := strings.TrimSpace(strings.Split(sniffed, ";")[0])
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	switch contentType {
+	case "application/zip":
+		if officeType, ok := officeExtensions[ext]; ok {
+			return officeType
+		}
+	case "text/plain":
+		if ext == ".csv" {
+			return "text/csv"
+		}
+	}
+
+	return contentType
+}
+
+// SanitizeAttachmentFilename keeps the base name of an uploaded file without
+// control characters or quotes, so it is safe in headers and storage
+func SanitizeAttachmentFilename(filename string) string {
+	filename = filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = strings.TrimSpace(filename)
+
+	if runes := []rune(filename); len(runes) > 255 {
+		ext := []rune(filepath.Ext(filename))
+		if len(ext) > 16 {
+			ext = nil
+		}
+		filename = string(runes[:255-len(ext)]) + string(ext)
+	}
+
+	if filename == "" || strings.Trim(filename, ".") == "" {
+		return "file"
+	}
+	return filename
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttachmentContentType(t *testing.T) {
+	tests := []struct {
+		sniffed  string
+		filename string
+		want     string
+	}{
+		{"image/png", "photo.png", "image/png"},
+		{"application/zip", "report.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"application/zip", "REPORT.XLSX", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"application/zip", "archive.zip", "application/zip"},
+		{"text/plain; charset=utf-8", "export.csv", "text/csv"},
+		{"text/plain; charset=utf-8", "notes.txt", "text/plain"},
+		// Extensions cannot change what the content was sniffed as
+		{"text/html; charset=utf-8", "page.csv", "text/html"},
+		{"image/png", "photo.docx", "image/png"},
+	}
+
+	for _, tt := range tests {
+		if got := AttachmentContentType(tt.sniffed, tt.filename); got != tt.want {
+			t.Errorf("AttachmentContentType(%q, %q) = %q, want %q", tt.sniffed, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeAttachmentFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"photo.png", "photo.png"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\jane\cv.pdf`, "cv.pdf"},
+		{"in\"voice\r\n.pdf", "invoice.pdf"},
+		{"", "file"},
+		{"..", "file"},
+	}
+
+	for _, tt := range tests {
+		if got := SanitizeAttachmentFilename(tt.filename); got != tt.want {
+			t.Errorf("SanitizeAttachmentFilename(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+
+	long := SanitizeAttachmentFilename(strings.Repeat("a", 300) + ".pdf")
+	if len(long) != 255 || !strings.HasSuffix(long, ".pdf") {
+		t.Errorf("expected long names to be cut to 255 characters keeping the extension, got %d characters", len(long))
+	}
+}
+
+func TestPlanLimitsAttachmentTypes(t *testing.T) {
+	free := GetPlanLimits("free")
+	if !free.AllowsAttachmentType("image/png") || free.AllowsAttachmentType("application/pdf") {
+		t.Errorf("free plans should only allow images")
+	}
+
+	pro := GetPlanLimits("pro")
+	if !pro.AllowsAttachmentType("text/csv") || pro.AllowsAttachmentType("text/html") || pro.AllowsAttachmentType("image/svg+xml") {
+		t.Errorf("unexpected pro attachment types %v", pro.AttachmentTypes)
+	}
+	if pro.MaxAttachmentSize > MaxAttachmentSize {
+		t.Errorf("plan attachment size %d exceeds the %d cap", pro.MaxAttachmentSize, MaxAttachmentSize)
+	}
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// BusinessHoursClosed marks a day without opening hours
+	BusinessHoursClosed = "closed"
+
+	// MaxBusinessHourRanges caps the opening ranges on a single day
+	MaxBusinessHourRanges = 6
+
+	// MaxHolidays caps the holidays stored on a website
+	MaxHolidays = 100
+
+	// holidayLayout is the date format holidays are stored in
+	holidayLayout = "2006-01-02"
+)
+
+// businessDays maps the day names used in business hours to weekdays
+var businessDays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// TimeRange is an opening range within a day, in minutes since midnight.
+// End is exclusive and may be 24:00.
+type TimeRange struct {
+	Start int
+	End   int
+}
+
+// BusinessStatus tells whether a website is staffed right now
+type BusinessStatus struct {
+	Online      bool       `json:"online"`
+	NextOpening *time.Time `json:"next_opening,omitempty"` // nil while online or when the website never opens
+	Timezone    string     `json:"timezone"`
+}
+
+// ParseBusinessHoursDay parses a day's opening hours: "closed", a single
+// range such as "09:00-17:00", or several comma-separated ranges such as
+// "09:00-12:00,13:00-17:00". Ranges are returned in order.
+func ParseBusinessHoursDay(value string) ([]TimeRange, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, BusinessHoursClosed) {
+		return nil, nil
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) > MaxBusinessHourRanges {
+		return nil, fmt.Errorf("at most %d ranges per day", MaxBusinessHourRanges)
+	}
+
+	ranges := make([]TimeRange, 0, len(parts))
+	for _, part := range parts {
+		bounds := strings.Split(strings.TrimSpace(part), "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid range '%s' (use HH:MM-HH:MM)", part)
+		}
+
+		start, err := parseClock(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		if start >= end {
+			return nil, fmt.Errorf("range '%s' must end after it starts", strings.TrimSpace(part))
+		}
+
+		ranges = append(ranges, TimeRange{Start: start, End: end})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].Start < ranges[i-1].End {
+			return nil, errors.New("ranges must not overlap")
+		}
+	}
+
+	return ranges, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight; 24:00 is the end of the day
+func parseClock(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if len(value) != 5 || value[2] != ':' || !isDigits(value[:2]) || !isDigits(value[3:]) {
+		return 0, fmt.Errorf("invalid time '%s' (use HH:MM)", value)
+	}
+
+	hours := int(value[0]-'0')*10 + int(value[1]-'0')
+	minutes := int(value[3]-'0')*10 + int(value[4]-'0')
+	if hours == 24 && minutes == 0 {
+		return 24 * 60, nil
+	}
+	if hours > 23 || minutes > 59 {
+		return 0, fmt.Errorf("invalid time '%s' (use HH:MM)", value)
+	}
+	return hours*60 + minutes, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateBusinessHours checks opening hours, the timezone and holidays.
+// Day names are lowercase English weekdays.
+func ValidateBusinessHours(hours map[string]string, timezone string, holidays []string) error {
+	for day, value := range hours {
+		if _, ok := businessDays[day]; !ok {
+			return fmt.Errorf("invalid business day '%s'", day)
+		}
+		if _, err := ParseBusinessHoursDay(value); err != nil {
+			return fmt.Errorf("invalid business hours for %s: %w", day, err)
+		}
+	}
+
+	if _, err := time.LoadLocation(timezone); err != nil || strings.EqualFold(timezone, "local") {
+		return fmt.Errorf("invalid timezone '%s'", timezone)
+	}
+
+	if len(holidays) > MaxHolidays {
+		return fmt.Errorf("at most %d holidays", MaxHolidays)
+	}
+	for _, holiday := range holidays {
+		if _, err := time.Parse(holidayLayout, holiday); err != nil {
+			return fmt.Errorf("invalid holiday '%s' (use YYYY-MM-DD)", holiday)
+		}
+	}
+
+	return nil
+}
+
+// Location returns the website's timezone, UTC when unset or unknown
+func (ws WebsiteSettings) Location() *time.Location {
+	if ws.Timezone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(ws.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// HasBusinessHours reports whether opening hours are configured. Websites
+// without business hours are always online.
+func (ws WebsiteSettings) HasBusinessHours() bool {
+	return len(ws.BusinessHours) > 0
+}
+
+// IsOpenAt reports whether the website is open at t in its own timezone.
+// Days missing from the business hours and holidays are closed.
+func (ws WebsiteSettings) IsOpenAt(t time.Time) bool {
+	if !ws.HasBusinessHours() {
+		return true
+	}
+
+	local := t.In(ws.Location())
+	minute := local.Hour()*60 + local.Minute()
+	for _, r := range ws.openingRanges(local) {
+		if minute >= r.Start && minute < r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpening returns when the website next opens after t, or nil when it is
+// open at t or has no opening hours within a year
+func (ws WebsiteSettings) NextOpening(t time.Time) *time.Time {
+	if ws.IsOpenAt(t) {
+		return nil
+	}
+
+	location := ws.Location()
+	local := t.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	for offset := 0; offset <= 366; offset++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, location)
+		for _, r := range ws.openingRanges(day) {
+			if offset == 0 && r.Start <= minute {
+				continue
+			}
+			opening := time.Date(day.Year(), day.Month(), day.Day(), r.Start/60, r.Start%60, 0, 0, location)
+			return &opening
+		}
+	}
+	return nil
+}
+
+// StatusAt returns the website's business status at t
+func (ws WebsiteSettings) StatusAt(t time.Time) BusinessStatus {
+	status := BusinessStatus{
+		Online:   ws.IsOpenAt(t),
+		Timezone: ws.Location().String(),
+	}
+	if !status.Online {
+		status.NextOpening = ws.NextOpening(t)
+	}
+	return status
+}
+
+// openingRanges returns the opening ranges of the local day containing t.
+// Invalid day values are treated as closed.
+func (ws WebsiteSettings) openingRanges(t time.Time) []TimeRange {
+	date := t.Format(holidayLayout)
+	for _, holiday := range ws.Holidays {
+		if holiday == date {
+			return nil
+		}
+	}
+
+	weekday := strings.ToLower(t.Weekday().String())
+	ranges, err := ParseBusinessHoursDay(ws.BusinessHours[weekday])
+	if err != nil {
+		return nil
+	}
+	return ranges
+}
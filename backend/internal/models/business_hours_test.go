@@ -0,0 +1,117 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBusinessHoursDay(t *testing.T) {
+	ranges, err := ParseBusinessHoursDay("13:00-17:30, 09:00-12:00")
+	if err != nil {
+		t.Fatalf("ParseBusinessHoursDay() error = %v", err)
+	}
+	if len(ranges) != 2 || ranges[0] != (TimeRange{Start: 540, End: 720}) || ranges[1] != (TimeRange{Start: 780, End: 1050}) {
+		t.Errorf("ParseBusinessHoursDay() = %+v, want sorted ranges", ranges)
+	}
+
+	if ranges, err := ParseBusinessHoursDay("Closed"); err != nil || ranges != nil {
+		t.Errorf("ParseBusinessHoursDay(Closed) = %+v, %v", ranges, err)
+	}
+	if ranges, err := ParseBusinessHoursDay("00:00-24:00"); err != nil || ranges[0].End != 24*60 {
+		t.Errorf("ParseBusinessHoursDay(00:00-24:00) = %+v, %v", ranges, err)
+	}
+
+	invalid := []string{"9:00-17:00", "09:00", "09:00-25:00", "17:00-09:00", "09:00-09:00", "09:00-12:00,11:00-13:00", "+9:00-17:00", "open"}
+	for _, value := range invalid {
+		if _, err := ParseBusinessHoursDay(value); err == nil {
+			t.Errorf("ParseBusinessHoursDay(%q) expected error", value)
+		}
+	}
+}
+
+func TestValidateBusinessHours(t *testing.T) {
+	settings := GetDefaultWebsiteSettings()
+	if err := ValidateBusinessHours(settings.BusinessHours, settings.Timezone, settings.Holidays); err != nil {
+		t.Errorf("ValidateBusinessHours() error = %v for the defaults", err)
+	}
+	if err := ValidateBusinessHours(nil, "", nil); err != nil {
+		t.Errorf("ValidateBusinessHours() error = %v for empty settings", err)
+	}
+
+	tests := []struct {
+		name     string
+		hours    map[string]string
+		timezone string
+		holidays []string
+	}{
+		{"bad day", map[string]string{"Monday": "09:00-17:00"}, "UTC", nil},
+		{"bad range", map[string]string{"monday": "9-5"}, "UTC", nil},
+		{"bad timezone", nil, "Mars/Olympus", nil},
+		{"local timezone", nil, "Local", nil},
+		{"bad holiday", nil, "UTC", []string{"25/12/2026"}},
+	}
+	for _, tt := range tests {
+		if err := ValidateBusinessHours(tt.hours, tt.timezone, tt.holidays); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestWebsiteSettings_IsOpenAt(t *testing.T) {
+	settings := WebsiteSettings{
+		BusinessHours: map[string]string{
+			"monday":  "09:00-12:00,13:00-17:00",
+			"tuesday": "09:00-17:00",
+			"friday":  "closed",
+		},
+		Timezone: "America/New_York",
+		Holidays: []string{"2026-03-10"},
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"monday morning", time.Date(2026, 3, 2, 9, 0, 0, 0, newYork), true},
+		{"monday lunch", time.Date(2026, 3, 2, 12, 30, 0, 0, newYork), false},
+		{"monday closing time", time.Date(2026, 3, 2, 17, 0, 0, 0, newYork), false},
+		{"in UTC", time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC), true}, // 10:00 in New York
+		{"friday", time.Date(2026, 3, 6, 10, 0, 0, 0, newYork), false},
+		{"day not listed", time.Date(2026, 3, 4, 10, 0, 0, 0, newYork), false},
+		{"holiday", time.Date(2026, 3, 10, 10, 0, 0, 0, newYork), false},
+	}
+	for _, tt := range tests {
+		if got := settings.IsOpenAt(tt.at); got != tt.want {
+			t.Errorf("%s: IsOpenAt() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if !(WebsiteSettings{}).IsOpenAt(time.Now()) {
+		t.Errorf("expected websites without business hours to be always open")
+	}
+
+	// Over lunch the website reopens at 13:00; on Friday evening the next
+	// opening skips the weekend and the Tuesday holiday doesn't matter
+	next := settings.NextOpening(time.Date(2026, 3, 2, 12, 30, 0, 0, newYork))
+	if next == nil || !next.Equal(time.Date(2026, 3, 2, 13, 0, 0, 0, newYork)) {
+		t.Errorf("NextOpening() = %v, want 13:00 the same day", next)
+	}
+	next = settings.NextOpening(time.Date(2026, 3, 6, 18, 0, 0, 0, newYork))
+	if next == nil || !next.Equal(time.Date(2026, 3, 9, 9, 0, 0, 0, newYork)) {
+		t.Errorf("NextOpening() = %v, want Monday 09:00", next)
+	}
+	next = settings.NextOpening(time.Date(2026, 3, 9, 18, 0, 0, 0, newYork))
+	if next == nil || !next.Equal(time.Date(2026, 3, 16, 9, 0, 0, 0, newYork)) {
+		t.Errorf("NextOpening() = %v, want the Monday after the holiday", next)
+	}
+
+	status := settings.StatusAt(time.Date(2026, 3, 2, 10, 0, 0, 0, newYork))
+	if !status.Online || status.NextOpening != nil || status.Timezone != "America/New_York" {
+		t.Errorf("StatusAt() = %+v, want online", status)
+	}
+}
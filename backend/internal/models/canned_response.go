@@ -0,0 +1,313 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxCannedResponseLength caps the text of a canned response and each translation
+	MaxCannedResponseLength = 5000
+
+	// MaxCannedKeywords caps the bot keywords of a canned response
+	MaxCannedKeywords = 20
+
+	// MaxCannedTranslations caps the languages a canned response is translated to
+	MaxCannedTranslations = 30
+)
+
+var (
+	cannedShortcutRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+	cannedLanguageRegex = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+
+	// cannedVariableRegex matches {{visitor.name}} and {{visitor.name|fallback}}
+	cannedVariableRegex = regexp.MustCompile(`\{\{\s*([a-z]+\.[a-z_]+)\s*(?:\|([^{}]*))?\}\}`)
+)
+
+// Canned response variables
+const (
+	CannedVarVisitorName  = "visitor.name"
+	CannedVarVisitorEmail = "visitor.email"
+	CannedVarWebsiteName  = "website.name"
+	CannedVarAgentName    = "agent.name"
+)
+
+var cannedVariables = map[string]bool{
+	CannedVarVisitorName:  true,
+	CannedVarVisitorEmail: true,
+	CannedVarWebsiteName:  true,
+	CannedVarAgentName:    true,
+}
+
+// CannedTranslations holds a canned response's text by language code
+type CannedTranslations map[string]string
+
+// Implement database/sql/driver.Valuer interface for JSONB
+func (ct CannedTranslations) Value() (driver.Value, error) {
+	if ct == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(ct)
+}
+
+// Implement database/sql.Scanner interface for JSONB
+func (ct *CannedTranslations) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, ct)
+}
+
+// CannedKeywords are the words and phrases the bot matches visitor messages against
+type CannedKeywords []string
+
+// Implement database/sql/driver.Valuer interface for JSONB
+func (ck CannedKeywords) Value() (driver.Value, error) {
+	if ck == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(ck)
+}
+
+// Implement database/sql.Scanner interface for JSONB
+func (ck *CannedKeywords) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, ck)
+}
+
+// CannedResponse is a saved reply agents insert by shortcut. Responses
+// belong to one website or are shared across all of the owner's websites.
+type CannedResponse struct {
+	ID           uint               `json:"id" gorm:"primaryKey"`
+	OwnerID      uint               `json:"owner_id" gorm:"not null;index"`
+	WebsiteID    *uint              `json:"website_id" gorm:"index"` // nil for responses shared across the owner's websites
+	Shortcut     string             `json:"shortcut" gorm:"not null;index"`
+	Title        string             `json:"title" gorm:"not null"`
+	Category     string             `json:"category" gorm:"index"`
+	Content      string             `json:"content" gorm:"type:text;not null"`
+	Translations CannedTranslations `json:"translations" gorm:"type:jsonb"`
+	Keywords     CannedKeywords     `json:"keywords" gorm:"type:jsonb"`
+	BotEnabled   bool               `json:"bot_enabled" gorm:"default:false"` // the bot may send it when a keyword matches
+	UsageCount   int64              `json:"usage_count" gorm:"default:0"`
+	LastUsedAt   *time.Time         `json:"last_used_at"`
+	CreatedBy    uint               `json:"created_by"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt     `json:"-" gorm:"index"`
+}
+
+// CannedResponseUsage records a canned response being sent in a chat
+type CannedResponseUsage struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ResponseID uint      `json:"response_id" gorm:"not null;index"`
+	WebsiteID  uint      `json:"website_id" gorm:"not null;index"`
+	ChatID     uint      `json:"chat_id" gorm:"index"`
+	UserID     *uint     `json:"user_id,omitempty"` // the agent; nil when the bot sent it
+	UsedAt     time.Time `json:"used_at" gorm:"index"`
+}
+
+// CannedResponseRequest represents creating or replacing a canned response
+type CannedResponseRequest struct {
+	Shortcut     string            `json:"shortcut" binding:"required,max=32"`
+	Title        string            `json:"title" binding:"required,max=100"`
+	Category     string            `json:"category" binding:"max=50"`
+	Content      string            `json:"content" binding:"required,max=5000"`
+	Translations map[string]string `json:"translations"`
+	Keywords     []string          `json:"keywords"`
+	BotEnabled   bool              `json:"bot_enabled"`
+	Shared       bool              `json:"shared"` // available on all of the owner's websites
+}
+
+// RenderedCannedResponse is a canned response with its variables filled in
+// for a chat, in the visitor's language where a translation exists
+type RenderedCannedResponse struct {
+	ID       uint   `json:"id"`
+	Shortcut string `json:"shortcut"`
+	Title    string `json:"title"`
+	Category string `json:"category"`
+	Language string `json:"language,omitempty"` // empty for the default text
+	Content  string `json:"content"`
+}
+
+// CannedResponseStats summarizes how often a canned response was used
+type CannedResponseStats struct {
+	ResponseID uint       `json:"response_id"`
+	Shortcut   string     `json:"shortcut"`
+	Title      string     `json:"title"`
+	Uses       int64      `json:"uses"`
+	AgentUses  int64      `json:"agent_uses"`
+	BotUses    int64      `json:"bot_uses"`
+	Agents     int64      `json:"agents"` // distinct agents who used it
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// ValidateCannedResponse checks a canned response request and normalizes its
+// shortcut, translation languages and keywords
+func ValidateCannedResponse(req *CannedResponseRequest) error {
+	req.Shortcut = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(req.Shortcut), "/"))
+	if !cannedShortcutRegex.MatchString(req.Shortcut) {
+		return errors.New("shortcut must be 1-32 lowercase letters, digits, '-' or '_'")
+	}
+
+	req.Title = strings.TrimSpace(req.Title)
+	req.Category = strings.TrimSpace(req.Category)
+	if req.Title == "" {
+		return errors.New("title is required")
+	}
+
+	if strings.TrimSpace(req.Content) == "" {
+		return errors.New("content is required")
+	}
+	if err := validateCannedText(req.Content); err != nil {
+		return err
+	}
+
+	if len(req.Translations) > MaxCannedTranslations {
+		return fmt.Errorf("at most %d translations", MaxCannedTranslations)
+	}
+	translations := make(map[string]string, len(req.Translations))
+	for language, text := range req.Translations {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if !cannedLanguageRegex.MatchString(language) {
+			return fmt.Errorf("invalid translation language '%s'", language)
+		}
+		if strings.TrimSpace(text) == "" {
+			return fmt.Errorf("translation '%s' is empty", language)
+		}
+		if len(text) > MaxCannedResponseLength {
+			return fmt.Errorf("translation '%s' is too long", language)
+		}
+		if err := validateCannedText(text); err != nil {
+			return fmt.Errorf("translation '%s': %w", language, err)
+		}
+		translations[language] = text
+	}
+	req.Translations = translations
+
+	if len(req.Keywords) > MaxCannedKeywords {
+		return fmt.Errorf("at most %d keywords", MaxCannedKeywords)
+	}
+	keywords := make([]string, 0, len(req.Keywords))
+	seen := make(map[string]bool)
+	for _, keyword := range req.Keywords {
+		normalized := strings.Join(MatchWords(keyword), " ")
+		if normalized == "" || len(normalized) > 100 {
+			return fmt.Errorf("invalid keyword '%s'", keyword)
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			keywords = append(keywords, normalized)
+		}
+	}
+	req.Keywords = keywords
+
+	if req.BotEnabled && len(req.Keywords) == 0 {
+		return errors.New("bot replies need at least one keyword")
+	}
+
+	return nil
+}
+
+// validateCannedText rejects unknown variables
+func validateCannedText(text string) error {
+	for _, match := range cannedVariableRegex.FindAllStringSubmatch(text, -1) {
+		if !cannedVariables[match[1]] {
+			return fmt.Errorf("unknown variable '%s'", match[1])
+		}
+	}
+	return nil
+}
+
+// Localize picks the translation for a language such as "pt-BR" or an
+// Accept-Language header, falling back from the regional variant to the base
+// language and then to the default text. It returns the language used.
+func (r *CannedResponse) Localize(language string) (string, string) {
+	tag := strings.ToLower(strings.TrimSpace(strings.Split(strings.Split(language, ",")[0], ";")[0]))
+	tag = strings.ReplaceAll(tag, "_", "-")
+	if tag == "" {
+		return r.Content, ""
+	}
+
+	if text, ok := r.Translations[tag]; ok {
+		return text, tag
+	}
+	if base, _, found := strings.Cut(tag, "-"); found {
+		if text, ok := r.Translations[base]; ok {
+			return text, base
+		}
+	}
+	return r.Content, ""
+}
+
+// RenderCannedText fills in {{variables}}. Variables without a value use
+// their fallback, as in {{visitor.name|there}}, or are left empty.
+func RenderCannedText(text string, values map[string]string) string {
+	return cannedVariableRegex.ReplaceAllStringFunc(text, func(match string) string {
+		parts := cannedVariableRegex.FindStringSubmatch(match)
+		if value := values[parts[1]]; value != "" {
+			return value
+		}
+		return strings.TrimSpace(parts[2])
+	})
+}
+
+// MatchesKeyword reports whether a normalized keyword appears in a message
+// as whole words
+func MatchesKeyword(words []string, keyword string) bool {
+	keywordWords := strings.Fields(keyword)
+	if len(keywordWords) == 0 {
+		return false
+	}
+	for i := 0; i+len(keywordWords) <= len(words); i++ {
+		matched := true
+		for j, word := range keywordWords {
+			if words[i+j] != word {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchWords splits text into lowercase words for keyword matching
+func MatchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,106 @@
+package models
+
+import "testing"
+
+func TestValidateCannedResponse(t *testing.T) {
+	req := &CannedResponseRequest{
+		Shortcut:     " /Refund ",
+		Title:        " Refunds ",
+		Content:      "Hi {{visitor.name|there}}, refunds take 5 days.",
+		Translations: map[string]string{"PT-BR": "Olá {{visitor.name|}}!"},
+		Keywords:     []string{"Refund!", "money  back", "refund"},
+		BotEnabled:   true,
+	}
+	if err := ValidateCannedResponse(req); err != nil {
+		t.Fatalf("expected a valid request, got %v", err)
+	}
+	if req.Shortcut != "refund" || req.Title != "Refunds" {
+		t.Errorf("expected normalized shortcut and title, got %q and %q", req.Shortcut, req.Title)
+	}
+	if _, ok := req.Translations["pt-br"]; !ok {
+		t.Errorf("expected lowercase translation languages, got %v", req.Translations)
+	}
+	if len(req.Keywords) != 2 || req.Keywords[0] != "refund" || req.Keywords[1] != "money back" {
+		t.Errorf("expected normalized unique keywords, got %v", req.Keywords)
+	}
+
+	invalid := []CannedResponseRequest{
+		{Shortcut: "has space", Title: "T", Content: "text"},
+		{Shortcut: "ok", Title: "T", Content: "Hi {{visitor.phone}}"},
+		{Shortcut: "ok", Title: "T", Content: "text", Translations: map[string]string{"english": "text"}},
+		{Shortcut: "ok", Title: "T", Content: "text", Translations: map[string]string{"fr": " "}},
+		{Shortcut: "ok", Title: "T", Content: "text", BotEnabled: true},
+		{Shortcut: "ok", Title: "T", Content: "text", Keywords: []string{"!!!"}},
+	}
+	for i := range invalid {
+		if err := ValidateCannedResponse(&invalid[i]); err == nil {
+			t.Errorf("expected request %d to be rejected", i)
+		}
+	}
+}
+
+func TestCannedResponseLocalize(t *testing.T) {
+	response := &CannedResponse{
+		Content:      "Hello",
+		Translations: CannedTranslations{"pt": "Olá", "pt-br": "Oi", "de": "Hallo"},
+	}
+
+	tests := []struct {
+		language string
+		text     string
+		used     string
+	}{
+		{"pt-BR", "Oi", "pt-br"},
+		{"pt_PT", "Olá", "pt"},
+		{"de-CH,de;q=0.9,en;q=0.8", "Hallo", "de"},
+		{"fr", "Hello", ""},
+		{"", "Hello", ""},
+	}
+
+	for _, tt := range tests {
+		text, used := response.Localize(tt.language)
+		if text != tt.text || used != tt.used {
+			t.Errorf("Localize(%q) = %q, %q, want %q, %q", tt.language, text, used, tt.text, tt.used)
+		}
+	}
+}
+
+func TestRenderCannedText(t *testing.T) {
+	values := map[string]string{
+		CannedVarVisitorName: "Jane",
+		CannedVarWebsiteName: "Example",
+	}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Hi {{visitor.name}}!", "Hi Jane!"},
+		{"Hi {{ visitor.name | there }}!", "Hi Jane!"},
+		{"We'll email {{visitor.email|you}}.", "We'll email you."},
+		{"{{agent.name}} from {{website.name}}", " from Example"},
+	}
+
+	for _, tt := range tests {
+		if got := RenderCannedText(tt.text, values); got != tt.want {
+			t.Errorf("RenderCannedText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesKeyword(t *testing.T) {
+	words := MatchWords("Hi, how do I get my MONEY back?")
+
+	if !MatchesKeyword(words, "money back") {
+		t.Errorf("expected the phrase to match")
+	}
+	if MatchesKeyword(words, "mone") {
+		t.Errorf("expected keywords to match whole words only")
+	}
+	if MatchesKeyword(words, "back money") {
+		t.Errorf("expected phrase words to match in order")
+	}
+	if MatchesKeyword(words, "") {
+		t.Errorf("expected an empty keyword not to match")
+	}
+}
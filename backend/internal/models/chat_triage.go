@@ -0,0 +1,166 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Chat statuses
+const (
+	ChatStatusOpen     = "open"
+	ChatStatusPending  = "pending" // waiting on the visitor
+	ChatStatusSnoozed  = "snoozed" // hidden until SnoozedUntil, then reopened
+	ChatStatusResolved = "resolved"
+	ChatStatusClosed   = "closed" // ended; cannot be reopened
+)
+
+// Chat priorities
+const (
+	ChatPriorityLow    = "low"
+	ChatPriorityNormal = "normal"
+	ChatPriorityHigh   = "high"
+	ChatPriorityUrgent = "urgent"
+)
+
+const (
+	// MaxChatTags caps the tags on a single chat
+	MaxChatTags = 20
+
+	// MaxNoteLength caps internal note content
+	MaxNoteLength = 5000
+)
+
+var chatTagRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9 _-]{0,49}$`)
+
+// ChatTags is a set of free-form labels stored as a JSON array
+type ChatTags []string
+
+// Implement database/sql/driver.Valuer interface for JSONB
+func (ct ChatTags) Value() (driver.Value, error) {
+	if ct == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(ct)
+}
+
+// Implement database/sql.Scanner interface for JSONB
+func (ct *ChatTags) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, ct)
+}
+
+// ChatNote is an internal note on a chat. Notes are only visible to the
+// website's team and are never sent to the visitor.
+type ChatNote struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ChatID    uint      `json:"chat_id" gorm:"not null;index"`
+	UserID    uint      `json:"user_id" gorm:"not null"`
+	Content   string    `json:"content" gorm:"type:text;not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	Chat Chat `json:"-" gorm:"foreignKey:ChatID"`
+	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// ChatUpdateRequest represents the request payload for chat triage updates.
+// Omitted fields are left unchanged.
+type ChatUpdateRequest struct {
+	Status       *string    `json:"status" binding:"omitempty,oneof=open pending snoozed resolved closed"`
+	SnoozedUntil *time.Time `json:"snoozed_until"`
+	Priority     *string    `json:"priority" binding:"omitempty,oneof=low normal high urgent"`
+	Tags         *[]string  `json:"tags"`
+}
+
+// ChatNoteRequest represents the request payload for creating or editing a note
+type ChatNoteRequest struct {
+	Content string `json:"content" binding:"required,max=5000"`
+}
+
+// ChatSearchFilters narrows chat searches. Empty fields match everything.
+type ChatSearchFilters struct {
+	Query    string
+	Status   string // a chat status, or "active"/"ended" for the legacy filter
+	Priority string
+	Tags     []string // chats must carry every tag
+}
+
+// IsValidChatStatus checks if a chat status is supported
+func IsValidChatStatus(status string) bool {
+	switch status {
+	case ChatStatusOpen, ChatStatusPending, ChatStatusSnoozed, ChatStatusResolved, ChatStatusClosed:
+		return true
+	}
+	return false
+}
+
+// IsValidChatPriority checks if a chat priority is supported
+func IsValidChatPriority(priority string) bool {
+	switch priority {
+	case ChatPriorityLow, ChatPriorityNormal, ChatPriorityHigh, ChatPriorityUrgent:
+		return true
+	}
+	return false
+}
+
+// NormalizeChatTags lowercases, trims and de-duplicates tags, preserving order
+func NormalizeChatTags(tags []string) (ChatTags, error) {
+	normalized := make(ChatTags, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if !chatTagRegex.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag '%s': use up to 50 letters, digits, spaces, '-' or '_'", tag)
+		}
+
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > MaxChatTags {
+		return nil, fmt.Errorf("a chat can have at most %d tags", MaxChatTags)
+	}
+
+	return normalized, nil
+}
+
+// ValidateStatusChange checks a status transition. Closed chats are final and
+// snoozing requires a wake-up time in the future.
+func ValidateStatusChange(from, to string, snoozedUntil *time.Time, now time.Time) error {
+	if !IsValidChatStatus(to) {
+		return errors.New("invalid chat status")
+	}
+
+	if from == ChatStatusClosed && to != ChatStatusClosed {
+		return errors.New("chat is closed")
+	}
+
+	if to == ChatStatusSnoozed && (snoozedUntil == nil || !snoozedUntil.After(now)) {
+		return errors.New("snoozed_until must be in the future")
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeChatTags(t *testing.T) {
+	tags, err := NormalizeChatTags([]string{" VIP ", "billing", "vip", "", "follow-up"})
+	if err != nil {
+		t.Fatalf("NormalizeChatTags() error = %v", err)
+	}
+	if want := (ChatTags{"vip", "billing", "follow-up"}); !reflect.DeepEqual(tags, want) {
+		t.Errorf("NormalizeChatTags() = %v, want %v", tags, want)
+	}
+
+	if _, err := NormalizeChatTags([]string{"bad/tag"}); err == nil {
+		t.Error("expected error for invalid characters")
+	}
+
+	tooMany := make([]string, MaxChatTags+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("a", i+1)
+	}
+	if _, err := NormalizeChatTags(tooMany); err == nil {
+		t.Error("expected error for too many tags")
+	}
+}
+
+func TestValidateStatusChange(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	tests := []struct {
+		name         string
+		from, to     string
+		snoozedUntil *time.Time
+		wantErr      string
+	}{
+		{"resolve", ChatStatusOpen, ChatStatusResolved, nil, ""},
+		{"reopen resolved", ChatStatusResolved, ChatStatusOpen, nil, ""},
+		{"snooze", ChatStatusOpen, ChatStatusSnoozed, &future, ""},
+		{"snooze without time", ChatStatusOpen, ChatStatusSnoozed, nil, "must be in the future"},
+		{"snooze in the past", ChatStatusPending, ChatStatusSnoozed, &past, "must be in the future"},
+		{"reopen closed", ChatStatusClosed, ChatStatusOpen, nil, "chat is closed"},
+		{"unknown status", ChatStatusOpen, "archived", nil, "invalid chat status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateStatusChange(tt.from, tt.to, tt.snoozedUntil, now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateStatusChange() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateStatusChange() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
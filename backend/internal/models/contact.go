@@ -0,0 +1,204 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxContactAttributes caps the custom attributes stored on a contact
+	MaxContactAttributes = 50
+
+	// MaxAttributeKeyLength caps custom attribute names
+	MaxAttributeKeyLength = 64
+
+	// MaxAttributeValueLength caps string attribute values
+	MaxAttributeValueLength = 1024
+
+	// MaxVisitorIDLength caps the visitor IDs accepted from the widget
+	MaxVisitorIDLength = 128
+)
+
+// Contact is a person talking to a website. Every widget visitor ID links to
+// exactly one contact, so a contact gathers all sessions and chats of the
+// same person. Anonymous contacts are merged into identified ones once the
+// visitor is identified.
+type Contact struct {
+	ID             uint              `json:"id" gorm:"primaryKey"`
+	WebsiteID      uint              `json:"website_id" gorm:"not null;index"`
+	ExternalID     string            `json:"external_id" gorm:"index"` // the website's own user ID
+	Email          string            `json:"email" gorm:"index"`
+	Name           string            `json:"name"`
+	Attributes     ContactAttributes `json:"attributes" gorm:"type:jsonb"`
+	Verified       bool              `json:"verified" gorm:"default:false"` // identity confirmed with the website's identity secret
+	MergedIntoID   *uint             `json:"merged_into_id,omitempty" gorm:"index"`
+	LeadCapturedAt *time.Time        `json:"lead_captured_at" gorm:"index"` // first time the contact left an email in a form
+	ConsentGivenAt *time.Time        `json:"consent_given_at"`
+	ConsentText    string            `json:"consent_text,omitempty"` // the consent wording the contact agreed to
+	FirstSeenAt    time.Time         `json:"first_seen_at"`
+	LastSeenAt     time.Time         `json:"last_seen_at"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt    `json:"-" gorm:"index"`
+
+	// Relationships
+	Website  Website          `json:"-" gorm:"foreignKey:WebsiteID"`
+	Visitors []ContactVisitor `json:"visitors,omitempty" gorm:"foreignKey:ContactID"`
+}
+
+// ContactVisitor links a widget visitor ID to the contact it belongs to
+type ContactVisitor struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ContactID uint      `json:"contact_id" gorm:"not null;index"`
+	WebsiteID uint      `json:"website_id" gorm:"not null;uniqueIndex:idx_contact_visitors_website_visitor"`
+	VisitorID string    `json:"visitor_id" gorm:"not null;uniqueIndex:idx_contact_visitors_website_visitor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ContactAttributes holds custom attributes about a contact
+type ContactAttributes map[string]interface{}
+
+// Implement database/sql/driver.Valuer interface for JSONB
+func (ca ContactAttributes) Value() (driver.Value, error) {
+	if ca == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(ca)
+}
+
+// Implement database/sql.Scanner interface for JSONB
+func (ca *ContactAttributes) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, ca)
+}
+
+// ContactIdentifyRequest represents the identify call from the widget.
+// IdentityHash is the hex HMAC-SHA256 of the external ID (or the email when
+// there is no external ID), keyed with the website's identity secret.
+type ContactIdentifyRequest struct {
+	VisitorID    string                 `json:"visitor_id" binding:"required,max=128"`
+	ExternalID   string                 `json:"external_id" binding:"omitempty,max=255"`
+	Email        string                 `json:"email" binding:"omitempty,email,max=255"`
+	Name         string                 `json:"name" binding:"omitempty,max=255"`
+	Attributes   map[string]interface{} `json:"attributes"`
+	IdentityHash string                 `json:"identity_hash" binding:"omitempty,max=128"`
+}
+
+// ContactUpdateRequest represents the request payload for contact updates.
+// Attributes are merged into the existing ones; a null value removes one.
+type ContactUpdateRequest struct {
+	Email      *string                `json:"email" binding:"omitempty,email,max=255"`
+	Name       *string                `json:"name" binding:"omitempty,max=255"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+// ContactMergeRequest represents the request payload for merging a contact into another
+type ContactMergeRequest struct {
+	SourceID uint `json:"source_id" binding:"required"`
+}
+
+// ContactProfile is a contact with everything known about them: chats from
+// all of their sessions and the events they triggered along the way
+type ContactProfile struct {
+	Contact    Contact     `json:"contact"`
+	VisitorIDs []string    `json:"visitor_ids"`
+	Chats      []Chat      `json:"chats"`
+	Journey    []Analytics `json:"journey"`
+}
+
+// IsIdentified reports whether the contact has been identified by the website
+func (c *Contact) IsIdentified() bool {
+	return c.ExternalID != "" || c.Email != ""
+}
+
+// IdentityKey returns the value the identity hash is computed over
+func (r *ContactIdentifyRequest) IdentityKey() string {
+	if r.ExternalID != "" {
+		return r.ExternalID
+	}
+	return r.Email
+}
+
+// ValidateContactAttributes checks custom attributes. Values must be strings,
+// numbers, booleans or null (null removes an attribute on update).
+func ValidateContactAttributes(attributes map[string]interface{}) error {
+	if len(attributes) > MaxContactAttributes {
+		return fmt.Errorf("a contact can have at most %d attributes", MaxContactAttributes)
+	}
+
+	for key, value := range attributes {
+		if key == "" || len(key) > MaxAttributeKeyLength {
+			return fmt.Errorf("attribute names must be 1 to %d characters", MaxAttributeKeyLength)
+		}
+
+		switch v := value.(type) {
+		case nil, bool, float64, int, int64:
+		case string:
+			if len(v) > MaxAttributeValueLength {
+				return fmt.Errorf("attribute '%s' is longer than %d characters", key, MaxAttributeValueLength)
+			}
+		default:
+			return fmt.Errorf("attribute '%s' must be a string, number or boolean", key)
+		}
+	}
+
+	return nil
+}
+
+// GenerateIdentitySecret generates a new secret for verifying identify calls
+func (w *Website) GenerateIdentitySecret() error {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+
+	w.IdentitySecret = hex.EncodeToString(randomBytes)
+	return nil
+}
+
+// ComputeIdentityHash returns the hex HMAC-SHA256 of value keyed with secret.
+// Websites compute the same hash on their server and pass it to identify.
+func ComputeIdentityHash(secret, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyIdentityHash checks an identity hash in constant time
+func VerifyIdentityHash(secret, value, hash string) bool {
+	if secret == "" || value == "" || hash == "" {
+		return false
+	}
+	expected := ComputeIdentityHash(secret, value)
+	return hmac.Equal([]byte(expected), []byte(hash))
+}
+
+// BeforeSave is a GORM hook that runs before saving a contact
+func (c *Contact) BeforeSave(tx *gorm.DB) error {
+	if c.Attributes == nil {
+		c.Attributes = ContactAttributes{}
+	}
+	return ValidateContactAttributes(c.Attributes)
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyIdentityHash(t *testing.T) {
+	secret := "website-secret"
+	hash := ComputeIdentityHash(secret, "user-42")
+
+	// Known HMAC-SHA256 vectors let websites check their own signing code
+	if got := ComputeIdentityHash("key", "The quick brown fox jumps over the lazy dog"); got != "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8" {
+		t.Errorf("ComputeIdentityHash() = %s", got)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		value  string
+		hash   string
+		want   bool
+	}{
+		{"valid", secret, "user-42", hash, true},
+		{"other user", secret, "user-43", hash, false},
+		{"other secret", "another-secret", "user-42", hash, false},
+		{"uppercase hash", secret, "user-42", strings.ToUpper(hash), false},
+		{"no secret", "", "user-42", ComputeIdentityHash("", "user-42"), false},
+		{"no hash", secret, "user-42", "", false},
+	}
+	for _, tt := range tests {
+		if got := VerifyIdentityHash(tt.secret, tt.value, tt.hash); got != tt.want {
+			t.Errorf("%s: VerifyIdentityHash() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateContactAttributes(t *testing.T) {
+	valid := map[string]interface{}{"plan": "pro", "seats": float64(5), "trial": false, "removed": nil}
+	if err := ValidateContactAttributes(valid); err != nil {
+		t.Errorf("ValidateContactAttributes() error = %v", err)
+	}
+
+	invalid := []map[string]interface{}{
+		{"nested": map[string]interface{}{"a": 1}},
+		{"list": []interface{}{"a"}},
+		{"": "empty key"},
+		{strings.Repeat("k", MaxAttributeKeyLength+1): "long key"},
+		{"long": strings.Repeat("v", MaxAttributeValueLength+1)},
+	}
+	for _, attributes := range invalid {
+		if err := ValidateContactAttributes(attributes); err == nil {
+			t.Errorf("expected error for %v", attributes)
+		}
+	}
+
+	tooMany := make(map[string]interface{}, MaxContactAttributes+1)
+	for i := 0; i <= MaxContactAttributes; i++ {
+		tooMany[strings.Repeat("a", i+1)] = i
+	}
+	if err := ValidateContactAttributes(tooMany); err == nil {
+		t.Error("expected error for too many attributes")
+	}
+}
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaxEventDataSize is the largest event_data payload accepted for any event, in bytes
+const MaxEventDataSize = 16 * 1024
+
+// EventDefinition represents a custom analytics event type defined by a website
+type EventDefinition struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	WebsiteID   uint           `json:"website_id" gorm:"not null;uniqueIndex:idx_event_definitions_website_name"`
+	Name        string         `json:"name" gorm:"not null;uniqueIndex:idx_event_definitions_website_name"`
+	Description string         `json:"description"`
+	Schema      EventSchema    `json:"schema" gorm:"type:jsonb"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Relationships
+	Website Website `json:"website,omitempty" gorm:"foreignKey:WebsiteID"`
+}
+
+// EventSchema is a JSON Schema subset describing the event_data of a custom event
+type EventSchema struct {
+	Properties           map[string]EventPropertySchema `json:"properties,omitempty"`
+	Required             []string                       `json:"required,omitempty"`
+	AdditionalProperties *bool                          `json:"additional_properties,omitempty"` // defaults to true
+	MaxPayloadSize       int                            `json:"max_payload_size,omitempty"`      // bytes, capped at MaxEventDataSize
+}
+
+// EventPropertySchema describes a single event_data property
+type EventPropertySchema struct {
+	Type      string `json:"type"` // string, number, integer, boolean, object or array
+	MaxLength int    `json:"max_length,omitempty"`
+}
+
+// Implement database/sql/driver.Valuer interface for JSONB
+func (es EventSchema) Value() (driver.Value, error) {
+	return json.Marshal(es)
+}
+
+// Implement database/sql.Scanner interface for JSONB
+func (es *EventSchema) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, es)
+}
+
+// EventDefinitionCreateRequest represents the request payload for custom event creation
+type EventDefinitionCreateRequest struct {
+	Name        string      `json:"name" binding:"required"`
+	Description string      `json:"description" binding:"omitempty,max=500"`
+	Schema      EventSchema `json:"schema"`
+}
+
+// EventDefinitionUpdateRequest represents the request payload for custom event updates
+type EventDefinitionUpdateRequest struct {
+	Description *string      `json:"description" binding:"omitempty,max=500"`
+	Schema      *EventSchema `json:"schema"`
+}
+
+var (
+	eventNameRegex    = regexp.MustCompile(`^[a-z][a-z0-9_]{1,63}$`)
+	eventPropertyType = map[string]bool{
+		"string":  true,
+		"number":  true,
+		"integer": true,
+		"boolean": true,
+		"object":  true,
+		"array":   true,
+	}
+)
+
+// ValidateEventName validates the name of a custom event type
+func ValidateEventName(name string) error {
+	if !eventNameRegex.MatchString(name) {
+		return errors.New("event name must be 2-64 lowercase letters, digits or underscores and start with a letter")
+	}
+
+	if IsValidEventType(name) {
+		return errors.New("event name conflicts with a built-in event type")
+	}
+
+	return nil
+}
+
+// ValidateDefinition checks that the schema itself is well formed
+func (es EventSchema) ValidateDefinition() error {
+	for name, property := range es.Properties {
+		if !eventPropertyType[property.Type] {
+			return fmt.Errorf("property '%s' has invalid type '%s'", name, property.Type)
+		}
+		if property.MaxLength < 0 {
+			return fmt.Errorf("property '%s' has negative max_length", name)
+		}
+	}
+
+	for _, key := range es.Required {
+		if key == "" {
+			return errors.New("required keys cannot be empty")
+		}
+	}
+
+	if es.MaxPayloadSize < 0 || es.MaxPayloadSize > MaxEventDataSize {
+		return fmt.Errorf("max_payload_size must be between 0 and %d bytes", MaxEventDataSize)
+	}
+
+	return nil
+}
+
+// Validate checks event data against the schema
+func (es EventSchema) Validate(data AnalyticsData) error {
+	limit := MaxEventDataSize
+	if es.MaxPayloadSize > 0 {
+		limit = es.MaxPayloadSize
+	}
+	if err := ValidateEventDataSize(data, limit); err != nil {
+		return err
+	}
+
+	for _, key := range es.Required {
+		if _, ok := data[key]; !ok {
+			return fmt.Errorf("missing required property '%s'", key)
+		}
+	}
+
+	// Check keys in a stable order so errors are deterministic
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		property, ok := es.Properties[key]
+		if !ok {
+			if es.AdditionalProperties != nil && !*es.AdditionalProperties {
+				return fmt.Errorf("unknown property '%s'", key)
+			}
+			continue
+		}
+
+		if err := property.validate(data[key]); err != nil {
+			return fmt.Errorf("property '%s' %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+func (ps EventPropertySchema) validate(value interface{}) error {
+	valid := false
+	switch ps.Type {
+	case "string":
+		s, ok := value.(string)
+		if ok && ps.MaxLength > 0 && len(s) > ps.MaxLength {
+			return fmt.Errorf("exceeds max length of %d", ps.MaxLength)
+		}
+		valid = ok
+	case "number":
+		_, valid = value.(float64)
+	case "integer":
+		f, ok := value.(float64)
+		valid = ok && f == float64(int64(f))
+	case "boolean":
+		_, valid = value.(bool)
+	case "object":
+		_, valid = value.(map[string]interface{})
+	case "array":
+		_, valid = value.([]interface{})
+	}
+
+	if !valid {
+		return fmt.Errorf("must be of type %s", ps.Type)
+	}
+
+	return nil
+}
+
+// ValidateEventDataSize checks the encoded size of event data
+func ValidateEventDataSize(data AnalyticsData, limit int) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("invalid event data: %w", err)
+	}
+
+	if len(encoded) > limit {
+		return fmt.Errorf("event data too large (max %d bytes)", limit)
+	}
+
+	return nil
+}
+
+// BeforeSave is a GORM hook that runs before saving an event definition
+func (ed *EventDefinition) BeforeSave(tx *gorm.DB) error {
+	if err := ValidateEventName(ed.Name); err != nil {
+		return err
+	}
+
+	return ed.Schema.ValidateDefinition()
+}
+
+// GetEventDefinition finds a website's custom event definition by name
+func GetEventDefinition(db *gorm.DB, websiteID uint, name string) (*EventDefinition, error) {
+	var definition EventDefinition
+	if err := db.Where("website_id = ? AND name = ?", websiteID, name).First(&definition).Error; err != nil {
+		return nil, err
+	}
+
+	return &definition, nil
+}
+
+// IsValidEventTypeForWebsite checks if an event type is built in or defined by the website
+func IsValidEventTypeForWebsite(db *gorm.DB, websiteID uint, eventType string) (bool, error) {
+	if IsValidEventType(eventType) {
+		return true, nil
+	}
+
+	var count int64
+	err := db.Model(&EventDefinition{}).
+		Where("website_id = ? AND name = ?", websiteID, eventType).
+		Count(&count).Error
+	return count > 0, err
+}
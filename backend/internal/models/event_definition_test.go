@@ -0,0 +1,60 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEventSchema_Validate(t *testing.T) {
+	strict := false
+	schema := EventSchema{
+		Properties: map[string]EventPropertySchema{
+			"plan":  {Type: "string", MaxLength: 10},
+			"seats": {Type: "integer"},
+			"trial": {Type: "boolean"},
+		},
+		Required:             []string{"plan"},
+		AdditionalProperties: &strict,
+		MaxPayloadSize:       64,
+	}
+
+	tests := []struct {
+		name    string
+		data    AnalyticsData
+		wantErr string
+	}{
+		{"valid", AnalyticsData{"plan": "pro", "seats": 3.0, "trial": true}, ""},
+		{"missing required", AnalyticsData{"seats": 3.0}, "missing required property 'plan'"},
+		{"wrong type", AnalyticsData{"plan": "pro", "seats": "3"}, "property 'seats' must be of type integer"},
+		{"not an integer", AnalyticsData{"plan": "pro", "seats": 1.5}, "property 'seats' must be of type integer"},
+		{"too long", AnalyticsData{"plan": "enterprise-plus"}, "exceeds max length"},
+		{"unknown property", AnalyticsData{"plan": "pro", "coupon": "x"}, "unknown property 'coupon'"},
+		{"too large", AnalyticsData{"plan": "pro", "trial": true, "seats": 1.0, "extra": strings.Repeat("x", 64)}, "too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate(tt.data)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateEventName(t *testing.T) {
+	if err := ValidateEventName("trial_started"); err != nil {
+		t.Errorf("ValidateEventName(trial_started) error = %v", err)
+	}
+	for _, name := range []string{"", "Trial", "1st_event", "has-dash", EventTypeConversion} {
+		if err := ValidateEventName(name); err == nil {
+			t.Errorf("ValidateEventName(%q) should fail", name)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExportJob represents an asynchronous raw data export
+type ExportJob struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	WebsiteID   uint           `json:"website_id" gorm:"not null;index"`
+	UserID      uint           `json:"user_id" gorm:"not null;index"`
+	Dataset     string         `json:"dataset" gorm:"not null"` // 'events', 'chats', 'messages' or 'leads'
+	Format      string         `json:"format" gorm:"not null"`  // 'csv', 'ndjson' or 'parquet'
+	StartDate   time.Time      `json:"start_date"`
+	EndDate     time.Time      `json:"end_date"`
+	Filters     ExportFilters  `json:"filters" gorm:"type:jsonb"`
+	Status      string         `json:"status" gorm:"not null;default:'pending';index"`
+	StorageKey  string         `json:"-"`
+	RowCount    int64          `json:"row_count"`
+	FileSize    int64          `json:"file_size"`
+	Error       string         `json:"error,omitempty"`
+	StartedAt   *time.Time     `json:"started_at"`
+	CompletedAt *time.Time     `json:"completed_at"`
+	ExpiresAt   *time.Time     `json:"expires_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Relationships
+	Website Website `json:"website,omitempty" gorm:"foreignKey:WebsiteID"`
+}
+
+// ExportFilters narrows the rows of an export
+type ExportFilters struct {
+	EventType  string            `json:"event_type,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// Implement database/sql/driver.Valuer interface for JSONB
+func (ef ExportFilters) Value() (driver.Value, error) {
+	return json.Marshal(ef)
+}
+
+// Implement database/sql.Scanner interface for JSONB
+func (ef *ExportFilters) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, ef)
+}
+
+// Export datasets
+const (
+	ExportDatasetEvents   = "events"
+	ExportDatasetChats    = "chats"
+	ExportDatasetMessages = "messages"
+	ExportDatasetLeads    = "leads"
+)
+
+// Export formats
+const (
+	ExportFormatCSV     = "csv"
+	ExportFormatNDJSON  = "ndjson"
+	ExportFormatParquet = "parquet"
+)
+
+// Export job statuses
+const (
+	ExportStatusPending   = "pending"
+	ExportStatusRunning   = "running"
+	ExportStatusCompleted = "completed"
+	ExportStatusFailed    = "failed"
+)
+
+// ExportRequest represents the request payload for an export
+type ExportRequest struct {
+	Dataset   string            `json:"dataset" form:"dataset" binding:"required,oneof=events chats messages leads"`
+	Format    string            `json:"format" form:"format" binding:"required,oneof=csv ndjson parquet"`
+	StartDate string            `json:"start_date" form:"start_date" binding:"required"`
+	EndDate   string            `json:"end_date" form:"end_date" binding:"required"`
+	EventType string            `json:"event_type" form:"event_type"`
+	Filters   map[string]string `json:"filters" form:"-"`
+}
+
+// ExportContentType returns the MIME type for an export format
+func ExportContentType(format string) string {
+	switch format {
+	case ExportFormatCSV:
+		return "text/csv"
+	case ExportFormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// IsFinished reports whether the job has stopped running
+func (j *ExportJob) IsFinished() bool {
+	return j.Status == ExportStatusCompleted || j.Status == ExportStatusFailed
+}
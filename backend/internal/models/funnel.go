@@ -0,0 +1,166 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Funnel represents an ordered sequence of analytics events a website tracks conversions through
+type Funnel struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	WebsiteID uint           `json:"website_id" gorm:"not null;index"`
+	Name      string         `json:"name" gorm:"not null"`
+	Steps     FunnelSteps    `json:"steps" gorm:"type:jsonb"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Relationships
+	Website Website `json:"website,omitempty" gorm:"foreignKey:WebsiteID"`
+}
+
+// FunnelStep represents a single funnel step matched against analytics events
+type FunnelStep struct {
+	Name      string                 `json:"name"`
+	EventType string                 `json:"event_type"`
+	Filters   map[string]interface{} `json:"filters,omitempty"` // event_data key/value pairs that must match
+}
+
+// FunnelSteps is an ordered list of funnel steps
+type FunnelSteps []FunnelStep
+
+// Implement database/sql/driver.Valuer interface for JSONB
+func (fs FunnelSteps) Value() (driver.Value, error) {
+	return json.Marshal(fs)
+}
+
+// Implement database/sql.Scanner interface for JSONB
+func (fs *FunnelSteps) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, fs)
+}
+
+// FunnelCreateRequest represents the request payload for funnel creation
+type FunnelCreateRequest struct {
+	Name  string       `json:"name" binding:"required,min=2,max=100"`
+	Steps []FunnelStep `json:"steps" binding:"required"`
+}
+
+// FunnelUpdateRequest represents the request payload for funnel updates
+type FunnelUpdateRequest struct {
+	Name  string       `json:"name" binding:"omitempty,min=2,max=100"`
+	Steps []FunnelStep `json:"steps" binding:"omitempty"`
+}
+
+// FunnelReport represents step-by-step funnel results over a date range
+type FunnelReport struct {
+	FunnelID    uint                  `json:"funnel_id"`
+	Name        string                `json:"name"`
+	StartDate   time.Time             `json:"start_date"`
+	EndDate     time.Time             `json:"end_date"`
+	Steps       []FunnelStepResult    `json:"steps"`
+	Attribution ConversionAttribution `json:"attribution"`
+}
+
+// FunnelStepResult represents how many visitors reached a funnel step
+type FunnelStepResult struct {
+	Name           string  `json:"name"`
+	EventType      string  `json:"event_type"`
+	Visitors       int64   `json:"visitors"`
+	ConversionRate float64 `json:"conversion_rate"` // percentage of visitors from the first step
+	DropOff        int64   `json:"drop_off"`        // visitors lost since the previous step
+	DropOffRate    float64 `json:"drop_off_rate"`
+}
+
+// ConversionAttribution reports whether converting visitors chatted before converting
+type ConversionAttribution struct {
+	ConvertingVisitors int64   `json:"converting_visitors"`
+	ChattedFirst       int64   `json:"chatted_first"`
+	NotChatted         int64   `json:"not_chatted"`
+	ChattingVisitors   int64   `json:"chatting_visitors"`
+	ChatToConversion   float64 `json:"chat_to_conversion"` // percentage of chatting visitors who converted afterwards
+	Revenue            float64 `json:"revenue"`
+	ChatRevenue        float64 `json:"chat_revenue"` // revenue from visitors who chatted first
+}
+
+// Funnel limits
+const (
+	MinFunnelSteps = 2
+	MaxFunnelSteps = 10
+)
+
+// ValidateFunnelSteps validates funnel step definitions
+func ValidateFunnelSteps(steps []FunnelStep) error {
+	if len(steps) < MinFunnelSteps {
+		return fmt.Errorf("funnel requires at least %d steps", MinFunnelSteps)
+	}
+
+	if len(steps) > MaxFunnelSteps {
+		return fmt.Errorf("funnel supports at most %d steps", MaxFunnelSteps)
+	}
+
+	for i, step := range steps {
+		if step.EventType == "" {
+			return fmt.Errorf("step %d: event type is required", i+1)
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether an event satisfies this step
+func (fs FunnelStep) Matches(eventType string, data AnalyticsData) bool {
+	if eventType != fs.EventType {
+		return false
+	}
+
+	for key, want := range fs.Filters {
+		got, ok := data[key]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// BeforeSave is a GORM hook that runs before saving a funnel
+func (f *Funnel) BeforeSave(tx *gorm.DB) error {
+	// Default step names to their event type
+	for i := range f.Steps {
+		if f.Steps[i].Name == "" {
+			f.Steps[i].Name = f.Steps[i].EventType
+		}
+	}
+
+	if err := ValidateFunnelSteps(f.Steps); err != nil {
+		return err
+	}
+
+	// Steps may use built-in or website-defined event types
+	db := tx.Session(&gorm.Session{NewDB: true})
+	for i, step := range f.Steps {
+		valid, err := IsValidEventTypeForWebsite(db, f.WebsiteID, step.EventType)
+		if err != nil {
+			return err
+		}
+		if !valid {
+			return fmt.Errorf("step %d: invalid event type '%s'", i+1, step.EventType)
+		}
+	}
+
+	return nil
+}
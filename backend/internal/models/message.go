@@ -1,6 +1,8 @@
 package models
 
 import (
+	"errors"
+	"regexp"
 	"time"
 
 	"gorm.io/gorm"
@@ -9,19 +11,52 @@ import (
 // Message represents a chat message
 type Message struct {
 	ID              uint           `json:"id" gorm:"primaryKey"`
-	ChatID          uint           `json:"chat_id" gorm:"not null"`
+	ChatID          uint           `json:"chat_id" gorm:"not null;uniqueIndex:idx_messages_chat_client_id,priority:1"`
+	ClientMessageID *string        `json:"client_message_id,omitempty" gorm:"size:64;uniqueIndex:idx_messages_chat_client_id,priority:2"` // sender-generated, makes resends idempotent
 	Content         string         `json:"content" gorm:"not null"`
 	OriginalContent string         `json:"original_content"`
-	Sender          string         `json:"sender" gorm:"not null"` // 'user' or 'bot'
+	Sender          string         `json:"sender" gorm:"not null"` // 'user', 'bot' or 'agent'
+	SenderID        *uint          `json:"sender_id,omitempty" gorm:"index"` // dashboard user for agent replies
 	Language        string         `json:"language"`
 	Translated      bool           `json:"translated" gorm:"default:false"`
 	Moderated       bool           `json:"moderated" gorm:"default:false"`
 	Flagged         bool           `json:"flagged" gorm:"default:false"`
-	Timestamp       time.Time      `json:"timestamp"`
+	Timestamp       time.Time      `json:"timestamp" gorm:"index"`
+	DeliveredAt     *time.Time     `json:"delivered_at"` // reached the other side of the chat
+	ReadAt          *time.Time     `json:"read_at"`
+	EditedAt        *time.Time     `json:"edited_at,omitempty"`
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `json:"-" gorm:"index"`
 
 	// Relationships
-	Chat Chat `json:"chat,omitempty" gorm:"foreignKey:ChatID"`
-}
\ No newline at end of file
+	Chat        Chat         `json:"chat,omitempty" gorm:"foreignKey:ChatID"`
+	Attachments []Attachment `json:"attachments,omitempty" gorm:"foreignKey:MessageID"`
+}
+
+// Message sender types
+const (
+	SenderUser  = "user"
+	SenderBot   = "bot"
+	SenderAgent = "agent"
+)
+
+// Message receipt statuses, sent by the side of the chat that received the message
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// MaxMessageLength caps the text of a chat message
+const MaxMessageLength = 5000
+
+var clientMessageIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateClientMessageID checks the ID a client generates for a message it
+// sends. An empty ID is allowed; such messages are not deduplicated.
+func ValidateClientMessageID(id string) error {
+	if id != "" && !clientMessageIDRegex.MatchString(id) {
+		return errors.New("client message ID must be 1-64 letters, digits, '-' or '_'")
+	}
+	return nil
+}
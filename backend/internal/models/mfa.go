@@ -0,0 +1,82 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Recovery codes a user gets when enabling two-factor authentication
+const RecoveryCodeCount = 10
+
+// MFARecoveryCode is a one-time code that signs a user in when they lose
+// their authenticator. Only its hash is stored.
+type MFARecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"not null;index"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MFASetup is what an authenticator app needs to add an account
+type MFASetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth:// URI, shown as a QR code
+}
+
+// MFAVerifyRequest represents the second step of a sign-in: a code from the
+// authenticator, or a recovery code
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code" binding:"omitempty,max=10"`
+	RecoveryCode string `json:"recovery_code" binding:"omitempty,max=20"`
+}
+
+// MFATokenRequest represents starting two-factor setup during sign-in
+type MFATokenRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// MFAEnableRequest represents confirming two-factor setup with a first code.
+// The MFA token is only needed when enrolling during sign-in.
+type MFAEnableRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code" binding:"required,max=10"`
+}
+
+// MFAEnforcementRequest represents requiring two-factor authentication of the
+// agents on a user's websites
+type MFAEnforcementRequest struct {
+	Required *bool `json:"required" binding:"required"`
+}
+
+// ReauthRequest represents re-entering credentials before a sensitive action
+type ReauthRequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code" binding:"omitempty,max=10"`
+	RecoveryCode string `json:"recovery_code" binding:"omitempty,max=20"`
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCode returns a new recovery code, such as "kr4m-2xq7-bjdw"
+func GenerateRecoveryCode() (string, error) {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(recoveryEncoding.EncodeToString(bytes))[:12]
+	return code[:4] + "-" + code[4:8] + "-" + code[8:], nil
+}
+
+// HashRecoveryCode hashes a recovery code for storage. Codes are random, so
+// a fast hash is enough; dashes, spaces and case are ignored.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
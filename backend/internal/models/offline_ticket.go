@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Offline ticket statuses
+const (
+	TicketStatusOpen   = "open"
+	TicketStatusClosed = "closed"
+)
+
+// MaxTicketMessageLength caps the message of an offline ticket
+const MaxTicketMessageLength = 5000
+
+// OfflineTicket is a message left through the widget while a website is
+// offline. The website owner is emailed about every new ticket.
+type OfflineTicket struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	WebsiteID uint           `json:"website_id" gorm:"not null;index"`
+	ContactID *uint          `json:"contact_id" gorm:"index"`
+	VisitorID string         `json:"visitor_id" gorm:"index"`
+	Name      string         `json:"name"`
+	Email     string         `json:"email" gorm:"not null"`
+	Message   string         `json:"message" gorm:"type:text;not null"`
+	Status    string         `json:"status" gorm:"default:'open';index"` // open or closed
+	PageURL   string         `json:"page_url"`
+	VisitorIP string         `json:"-"`
+	UserAgent string         `json:"user_agent"`
+	EmailedAt *time.Time     `json:"emailed_at"` // when the owner notification was sent
+	ClosedAt  *time.Time     `json:"closed_at"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Relationships
+	Website Website `json:"-" gorm:"foreignKey:WebsiteID"`
+}
+
+// OfflineTicketRequest represents an offline form submission from the widget
+type OfflineTicketRequest struct {
+	VisitorID string `json:"visitor_id" binding:"max=128"`
+	Name      string `json:"name" binding:"omitempty,max=255"`
+	Email     string `json:"email" binding:"required,email,max=255"`
+	Message   string `json:"message" binding:"required,max=5000"`
+	PageURL   string `json:"page_url" binding:"omitempty,max=2048"`
+}
+
+// OfflineTicketUpdateRequest represents the request payload for ticket updates
+type OfflineTicketUpdateRequest struct {
+	Status string `json:"status" binding:"required,oneof=open closed"`
+}
@@ -12,6 +12,14 @@ type PlanLimits struct {
 	Analytics       bool   `json:"analytics"`
 	APIAccess       bool   `json:"api_access"`
 	PrioritySupport bool   `json:"priority_support"`
+
+	MaxAttachmentSize int64    `json:"max_attachment_size"` // bytes
+	AttachmentTypes   []string `json:"attachment_types"`
+
+	// API requests a minute on average and at once, and API calls a month
+	RequestsPerMinute int   `json:"requests_per_minute"`
+	RequestBurst      int   `json:"request_burst"`
+	MonthlyAPICalls   int64 `json:"monthly_api_calls"`
 }
 
 // GetPlanLimits returns the limits for a given plan
@@ -29,6 +37,13 @@ func GetPlanLimits(plan string) PlanLimits {
 			Analytics:       false,
 			APIAccess:       false,
 			PrioritySupport: false,
+
+			MaxAttachmentSize: 1 << 20,
+			AttachmentTypes:   ImageAttachmentTypes,
+
+			RequestsPerMinute: 60,
+			RequestBurst:      20,
+			MonthlyAPICalls:   10000,
 		}
 	case "starter":
 		return PlanLimits{
@@ -42,6 +57,13 @@ func GetPlanLimits(plan string) PlanLimits {
 			Analytics:       true,
 			APIAccess:       false,
 			PrioritySupport: false,
+
+			MaxAttachmentSize: 4 << 20,
+			AttachmentTypes:   attachmentTypes(ImageAttachmentTypes, DocumentAttachmentTypes),
+
+			RequestsPerMinute: 120,
+			RequestBurst:      30,
+			MonthlyAPICalls:   100000,
 		}
 	case "pro":
 		return PlanLimits{
@@ -55,6 +77,13 @@ func GetPlanLimits(plan string) PlanLimits {
 			Analytics:       true,
 			APIAccess:       true,
 			PrioritySupport: false,
+
+			MaxAttachmentSize: MaxAttachmentSize,
+			AttachmentTypes:   attachmentTypes(ImageAttachmentTypes, DocumentAttachmentTypes, OfficeAttachmentTypes),
+
+			RequestsPerMinute: 300,
+			RequestBurst:      60,
+			MonthlyAPICalls:   1000000,
 		}
 	case "pro_max":
 		return PlanLimits{
@@ -68,12 +97,38 @@ func GetPlanLimits(plan string) PlanLimits {
 			Analytics:       true,
 			APIAccess:       true,
 			PrioritySupport: true,
+
+			MaxAttachmentSize: MaxAttachmentSize,
+			AttachmentTypes:   attachmentTypes(ImageAttachmentTypes, DocumentAttachmentTypes, OfficeAttachmentTypes),
+
+			RequestsPerMinute: 600,
+			RequestBurst:      120,
+			MonthlyAPICalls:   -1, // unlimited
 		}
 	default:
 		return GetPlanLimits("free")
 	}
 }
 
+// AllowsAttachmentType checks a sniffed content type against the plan's
+// attachment allow list
+func (p PlanLimits) AllowsAttachmentType(contentType string) bool {
+	for _, allowed := range p.AttachmentTypes {
+		if contentType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func attachmentTypes(families ...[]string) []string {
+	var types []string
+	for _, family := range families {
+		types = append(types, family...)
+	}
+	return types
+}
+
 // GetAllPlans returns all available plans with their limits
 func GetAllPlans() []PlanLimits {
 	return []PlanLimits{
@@ -93,4 +148,4 @@ func IsValidPlan(plan string) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
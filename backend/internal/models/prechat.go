@@ -0,0 +1,214 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// Pre-chat field types
+const (
+	PreChatFieldText     = "text"
+	PreChatFieldEmail    = "email"
+	PreChatFieldPhone    = "phone"
+	PreChatFieldTextarea = "textarea"
+	PreChatFieldSelect   = "select"
+)
+
+const (
+	// MaxPreChatFields caps the fields on a pre-chat form
+	MaxPreChatFields = 10
+
+	// MaxPreChatOptions caps the options of a select field
+	MaxPreChatOptions = 50
+
+	// DefaultPreChatMaxLength is the value limit for fields without max_length
+	DefaultPreChatMaxLength = 255
+
+	// MaxPreChatMaxLength is the largest max_length a field may set
+	MaxPreChatMaxLength = 2000
+
+	// MaxConsentTextLength caps the consent checkbox text
+	MaxConsentTextLength = 500
+)
+
+var (
+	preChatFieldNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+	phoneRegex            = regexp.MustCompile(`^\+?[0-9][0-9 ().-]{4,24}$`)
+)
+
+// PreChatForm configures the form visitors fill in before a chat starts.
+// Fields named "email" and "name" also fill in the visitor's contact.
+type PreChatForm struct {
+	Enabled         bool           `json:"enabled"`
+	Fields          []PreChatField `json:"fields"`
+	ConsentText     string         `json:"consent_text"`     // shown next to a consent checkbox when set
+	ConsentRequired bool           `json:"consent_required"` // the checkbox must be ticked to start a chat
+}
+
+// PreChatField is a single pre-chat form field
+type PreChatField struct {
+	Name      string   `json:"name"`
+	Label     string   `json:"label"`
+	Type      string   `json:"type"`
+	Required  bool     `json:"required"`
+	Options   []string `json:"options,omitempty"`    // select fields only
+	MaxLength int      `json:"max_length,omitempty"` // defaults to DefaultPreChatMaxLength
+}
+
+// PreChatValues holds the submitted pre-chat form values by field name
+type PreChatValues map[string]string
+
+// Implement database/sql/driver.Valuer interface for JSONB
+func (pv PreChatValues) Value() (driver.Value, error) {
+	if pv == nil {
+		return nil, nil
+	}
+	return json.Marshal(pv)
+}
+
+// Implement database/sql.Scanner interface for JSONB
+func (pv *PreChatValues) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(bytes, pv)
+}
+
+// PreChatSubmission represents a pre-chat form submission from the widget
+type PreChatSubmission struct {
+	VisitorID string            `json:"visitor_id" binding:"max=128"`
+	SessionID string            `json:"session_id" binding:"required,max=128"`
+	Values    map[string]string `json:"values"`
+	Consent   bool              `json:"consent"`
+}
+
+// ValidatePreChatForm checks a pre-chat form configuration
+func ValidatePreChatForm(form PreChatForm) error {
+	if len(form.Fields) > MaxPreChatFields {
+		return fmt.Errorf("a pre-chat form can have at most %d fields", MaxPreChatFields)
+	}
+	if form.Enabled && len(form.Fields) == 0 && form.ConsentText == "" {
+		return errors.New("an enabled pre-chat form needs at least one field")
+	}
+
+	seen := make(map[string]bool, len(form.Fields))
+	for _, field := range form.Fields {
+		if !preChatFieldNameRegex.MatchString(field.Name) {
+			return fmt.Errorf("invalid field name '%s': use up to 32 lowercase letters, digits or '_'", field.Name)
+		}
+		if seen[field.Name] {
+			return fmt.Errorf("duplicate field '%s'", field.Name)
+		}
+		seen[field.Name] = true
+
+		if strings.TrimSpace(field.Label) == "" || len(field.Label) > 100 {
+			return fmt.Errorf("field '%s' needs a label of at most 100 characters", field.Name)
+		}
+		if field.MaxLength < 0 || field.MaxLength > MaxPreChatMaxLength {
+			return fmt.Errorf("field '%s' max_length must be between 0 and %d", field.Name, MaxPreChatMaxLength)
+		}
+
+		switch field.Type {
+		case PreChatFieldText, PreChatFieldEmail, PreChatFieldPhone, PreChatFieldTextarea:
+			if len(field.Options) > 0 {
+				return errors.New("only select fields can have options")
+			}
+		case PreChatFieldSelect:
+			if len(field.Options) == 0 || len(field.Options) > MaxPreChatOptions {
+				return fmt.Errorf("select field '%s' needs 1 to %d options", field.Name, MaxPreChatOptions)
+			}
+		default:
+			return fmt.Errorf("invalid type '%s' for field '%s'", field.Type, field.Name)
+		}
+	}
+
+	if len(form.ConsentText) > MaxConsentTextLength {
+		return fmt.Errorf("consent text too long (max %d characters)", MaxConsentTextLength)
+	}
+	if form.ConsentRequired && strings.TrimSpace(form.ConsentText) == "" {
+		return errors.New("required consent needs a consent text")
+	}
+
+	return nil
+}
+
+// ValidatePreChatSubmission checks submitted values against the form and
+// returns them trimmed, with empty optional fields dropped
+func ValidatePreChatSubmission(form PreChatForm, values map[string]string, consent bool) (PreChatValues, error) {
+	fields := make(map[string]PreChatField, len(form.Fields))
+	for _, field := range form.Fields {
+		fields[field.Name] = field
+	}
+	for name := range values {
+		if _, ok := fields[name]; !ok {
+			return nil, fmt.Errorf("unknown field '%s'", name)
+		}
+	}
+
+	cleaned := make(PreChatValues, len(form.Fields))
+	for _, field := range form.Fields {
+		value := strings.TrimSpace(values[field.Name])
+		if value == "" {
+			if field.Required {
+				return nil, fmt.Errorf("%s is required", field.Label)
+			}
+			continue
+		}
+
+		maxLength := field.MaxLength
+		if maxLength == 0 {
+			maxLength = DefaultPreChatMaxLength
+		}
+		if len([]rune(value)) > maxLength {
+			return nil, fmt.Errorf("%s is too long (max %d characters)", field.Label, maxLength)
+		}
+
+		switch field.Type {
+		case PreChatFieldEmail:
+			address, err := mail.ParseAddress(value)
+			if err != nil || address.Address != value {
+				return nil, fmt.Errorf("%s must be a valid email address", field.Label)
+			}
+			value = strings.ToLower(value)
+		case PreChatFieldPhone:
+			if !phoneRegex.MatchString(value) {
+				return nil, fmt.Errorf("%s must be a valid phone number", field.Label)
+			}
+		case PreChatFieldSelect:
+			valid := false
+			for _, option := range field.Options {
+				if option == value {
+					valid = true
+					break
+				}
+			}
+			if !valid {
+				return nil, fmt.Errorf("%s has an invalid option", field.Label)
+			}
+		}
+
+		cleaned[field.Name] = value
+	}
+
+	if form.ConsentRequired && !consent {
+		return nil, errors.New("consent is required")
+	}
+
+	return cleaned, nil
+}
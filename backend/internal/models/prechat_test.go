@@ -0,0 +1,101 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func testPreChatForm() PreChatForm {
+	return PreChatForm{
+		Enabled: true,
+		Fields: []PreChatField{
+			{Name: "name", Label: "Name", Type: PreChatFieldText, Required: true},
+			{Name: "email", Label: "Email", Type: PreChatFieldEmail, Required: true},
+			{Name: "phone", Label: "Phone", Type: PreChatFieldPhone},
+			{Name: "topic", Label: "Topic", Type: PreChatFieldSelect, Options: []string{"Sales", "Support"}},
+			{Name: "question", Label: "Question", Type: PreChatFieldTextarea, MaxLength: 20},
+		},
+		ConsentText:     "I agree to the privacy policy",
+		ConsentRequired: true,
+	}
+}
+
+func TestValidatePreChatForm(t *testing.T) {
+	if err := ValidatePreChatForm(testPreChatForm()); err != nil {
+		t.Errorf("ValidatePreChatForm() error = %v", err)
+	}
+	if err := ValidatePreChatForm(PreChatForm{}); err != nil {
+		t.Errorf("ValidatePreChatForm() error = %v for a disabled form", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(form *PreChatForm)
+	}{
+		{"bad name", func(form *PreChatForm) { form.Fields[0].Name = "Full Name" }},
+		{"duplicate name", func(form *PreChatForm) { form.Fields[1].Name = "name" }},
+		{"missing label", func(form *PreChatForm) { form.Fields[0].Label = " " }},
+		{"bad type", func(form *PreChatForm) { form.Fields[0].Type = "date" }},
+		{"select without options", func(form *PreChatForm) { form.Fields[3].Options = nil }},
+		{"options on text", func(form *PreChatForm) { form.Fields[0].Options = []string{"a"} }},
+		{"max length", func(form *PreChatForm) { form.Fields[4].MaxLength = MaxPreChatMaxLength + 1 }},
+		{"consent without text", func(form *PreChatForm) { form.ConsentText = "" }},
+		{"empty form", func(form *PreChatForm) { form.Fields, form.ConsentText, form.ConsentRequired = nil, "", false }},
+	}
+	for _, tt := range tests {
+		form := testPreChatForm()
+		tt.modify(&form)
+		if err := ValidatePreChatForm(form); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestValidatePreChatSubmission(t *testing.T) {
+	form := testPreChatForm()
+	valid := map[string]string{
+		"name":  " Jane ",
+		"email": "Jane@Example.com",
+		"phone": "+44 20 7946 0958",
+		"topic": "Sales",
+	}
+
+	values, err := ValidatePreChatSubmission(form, valid, true)
+	if err != nil {
+		t.Fatalf("ValidatePreChatSubmission() error = %v", err)
+	}
+	if values["name"] != "Jane" || values["email"] != "jane@example.com" {
+		t.Errorf("expected trimmed, lowercased values, got %v", values)
+	}
+	if _, ok := values["question"]; ok {
+		t.Errorf("expected empty optional fields to be dropped, got %v", values)
+	}
+
+	with := func(key, value string) map[string]string {
+		values := make(map[string]string, len(valid)+1)
+		for k, v := range valid {
+			values[k] = v
+		}
+		values[key] = value
+		return values
+	}
+	tests := []struct {
+		name    string
+		values  map[string]string
+		consent bool
+	}{
+		{"missing required", with("name", "  "), true},
+		{"bad email", with("email", "jane@"), true},
+		{"display name email", with("email", "Jane <jane@example.com>"), true},
+		{"bad phone", with("phone", "call me"), true},
+		{"bad option", with("topic", "Other"), true},
+		{"too long", with("question", strings.Repeat("a", 21)), true},
+		{"unknown field", with("company", "Acme"), true},
+		{"no consent", valid, false},
+	}
+	for _, tt := range tests {
+		if _, err := ValidatePreChatSubmission(form, tt.values, tt.consent); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
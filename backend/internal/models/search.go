@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+const (
+	// MaxSearchQueryLength caps full-text search input
+	MaxSearchQueryLength = 200
+
+	// MaxMatchesPerChat caps the snippets returned for each chat in search results
+	MaxMatchesPerChat = 3
+)
+
+// MessageSearchFilters narrows a full-text message search. Empty fields match everything.
+type MessageSearchFilters struct {
+	Query     string
+	StartDate *time.Time
+	EndDate   *time.Time
+	Sender    string
+	Language  string
+	Tag       string
+	Flagged   *bool
+}
+
+// MessageSearchMatch is a matching message with a highlighted snippet. Snippets
+// are HTML-escaped with matches wrapped in <mark> tags.
+type MessageSearchMatch struct {
+	MessageID uint      `json:"message_id"`
+	Sender    string    `json:"sender"`
+	Timestamp time.Time `json:"timestamp"`
+	Snippet   string    `json:"snippet"`
+	Score     float64   `json:"score"`
+}
+
+// ChatSearchResult groups the matching messages of one chat
+type ChatSearchResult struct {
+	Chat       Chat                 `json:"chat"`
+	Score      float64              `json:"score"`
+	MatchCount int64                `json:"match_count"`
+	Matches    []MessageSearchMatch `json:"matches"`
+}
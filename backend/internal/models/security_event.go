@@ -0,0 +1,42 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// Security event types
+const (
+	SecurityEventLoginSucceeded  = "login_succeeded"
+	SecurityEventLoginFailed     = "login_failed"
+	SecurityEventLoginBlocked    = "login_blocked" // refused before the password was checked
+	SecurityEventAccountLocked   = "account_locked"
+	SecurityEventNewDevice       = "new_device"
+	SecurityEventPasswordChanged = "password_changed"
+	SecurityEventMFAEnabled      = "mfa_enabled"
+	SecurityEventMFADisabled     = "mfa_disabled"
+)
+
+// SecurityEvent is an entry in a user's security log: a sign-in attempt or a
+// change to their account's security. Attempts on emails without an account
+// are kept with no user, so they still count against the IP.
+type SecurityEvent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"-" gorm:"index:idx_security_events_user_created"`  // zero when no account matched
+	Email     string    `json:"-" gorm:"index:idx_security_events_email_created"` // lowercased, as attempted
+	Type      string    `json:"type" gorm:"not null"`
+	Reason    string    `json:"reason,omitempty"` // why a sign-in failed or was refused
+	IP        string    `json:"ip" gorm:"index:idx_security_events_ip_created"`
+	UserAgent string    `json:"user_agent"`
+	Device    string    `json:"device"` // identifies the browser across sign-ins
+	Country   string    `json:"country,omitempty"`
+	City      string    `json:"city,omitempty"`
+	CreatedAt time.Time `json:"created_at" gorm:"index:idx_security_events_user_created;index:idx_security_events_email_created;index:idx_security_events_ip_created"`
+}
+
+// DeviceID identifies a browser by its user agent
+func DeviceID(userAgent string) string {
+	sum := sha256.Sum256([]byte(userAgent))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Single sign-on providers
+const (
+	SSOProviderGoogle = "google"
+	SSOProviderGitHub = "github"
+	SSOProviderOIDC   = "oidc"
+)
+
+// UserIdentity links a user to their account at an identity provider
+type UserIdentity struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	Provider  string    `json:"provider" gorm:"not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Subject   string    `json:"-" gorm:"not null;uniqueIndex:idx_user_identities_provider_subject"` // the user's ID at the provider
+	Email     string    `json:"email"`                                                              // as the provider last reported it
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SSOLogin tracks a sign-in through an identity provider, from sending the
+// user there to the dashboard picking up their session
+type SSOLogin struct {
+	ID           uint      `gorm:"primaryKey"`
+	StateHash    string    `gorm:"uniqueIndex;not null"` // of the state sent to the provider
+	Provider     string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"` // PKCE
+	Nonce        string    `gorm:"not null"`
+	UserID       uint      // set once the provider signed the user in
+	CodeHash     string    `gorm:"index"` // of the one-time code the dashboard exchanges for a session
+	ExpiresAt    time.Time `gorm:"not null;index"`
+	CreatedAt    time.Time
+}
+
+// DNS TXT record that proves a website controls an email domain: the value
+// goes on a record named after the domain with the prefix
+const (
+	SSODomainRecordPrefix = "_chatelly-verification."
+	ssoDomainRecordValue  = "chatelly-verification="
+)
+
+// SSODomain lets users with verified emails at a domain join a website as
+// agents the first time they sign in with an identity provider. It only
+// takes effect once the website proved it controls the domain by publishing
+// a DNS TXT record, and only one website can verify each domain.
+type SSODomain struct {
+	ID                uint       `json:"id" gorm:"primaryKey"`
+	WebsiteID         uint       `json:"website_id" gorm:"not null;uniqueIndex:idx_sso_domains_website_domain"`
+	Domain            string     `json:"domain" gorm:"not null;uniqueIndex:idx_sso_domains_website_domain;index"`
+	VerificationToken string     `json:"-" gorm:"not null;default:''"`
+	VerifiedAt        *time.Time `json:"verified_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+
+	// The TXT record to publish for verification
+	RecordName  string `json:"record_name" gorm:"-"`
+	RecordValue string `json:"record_value" gorm:"-"`
+}
+
+// GenerateVerificationToken generates the token the domain's TXT record
+// must carry
+func (d *SSODomain) GenerateVerificationToken() error {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+
+	d.VerificationToken = hex.EncodeToString(randomBytes)
+	d.setRecord()
+	return nil
+}
+
+// AfterFind exposes the TXT record to publish
+func (d *SSODomain) AfterFind(tx *gorm.DB) error {
+	d.setRecord()
+	return nil
+}
+
+func (d *SSODomain) setRecord() {
+	d.RecordName = SSODomainRecordPrefix + d.Domain
+	d.RecordValue = ""
+	if d.VerificationToken != "" {
+		d.RecordValue = ssoDomainRecordValue + d.VerificationToken
+	}
+}
+
+// HasVerificationRecord reports whether the TXT records found for the
+// domain include its verification record
+func (d *SSODomain) HasVerificationRecord(records []string) bool {
+	if d.VerificationToken == "" {
+		return false
+	}
+	expected := ssoDomainRecordValue + d.VerificationToken
+	for _, record := range records {
+		if strings.TrimSpace(record) == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// SSOProviderInfo describes a provider users can sign in with
+type SSOProviderInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// SSOExchangeRequest represents the dashboard picking up a session after a
+// sign-in through an identity provider
+type SSOExchangeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// SSODomainRequest represents adding an email domain to a website
+type SSODomainRequest struct {
+	Domain string `json:"domain" binding:"required"`
+}
+
+// Domains of public email providers, which no website can claim
+var publicEmailDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+	"outlook.com":    true,
+	"hotmail.com":    true,
+	"live.com":       true,
+	"yahoo.com":      true,
+	"icloud.com":     true,
+	"me.com":         true,
+	"aol.com":        true,
+	"proton.me":      true,
+	"protonmail.com": true,
+	"gmx.com":        true,
+	"mail.com":       true,
+	"yandex.com":     true,
+}
+
+// NormalizeDomain lowercases a domain and strips any scheme, path and
+// leading www.
+func NormalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "http://")
+	domain = strings.TrimPrefix(domain, "https://")
+	if i := strings.IndexAny(domain, "/:?#"); i >= 0 {
+		domain = domain[:i]
+	}
+	return strings.TrimPrefix(strings.TrimSuffix(domain, "."), "www.")
+}
+
+// EmailDomain returns the normalized domain of an email address
+func EmailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return NormalizeDomain(email[at+1:])
+}
+
+// ValidateSSODomain checks a website may let users at an email domain join
+// it: the domain must be the website's own, and not a public email
+// provider's. Parent domains are not allowed, as without the public suffix
+// list shop.example.co.uk could not be kept from claiming co.uk.
+func ValidateSSODomain(domain, websiteDomain string) error {
+	if err := ValidateDomain(domain); err != nil {
+		return err
+	}
+	if publicEmailDomains[domain] {
+		return errors.New("domain belongs to a public email provider")
+	}
+
+	if NormalizeDomain(websiteDomain) != domain {
+		return errors.New("domain does not match the website")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+)
+
+// Transcript formats
+const (
+	TranscriptFormatText = "txt"
+	TranscriptFormatHTML = "html"
+	TranscriptFormatPDF  = "pdf"
+)
+
+// MaxTranscriptRecipients caps the owner addresses transcripts are sent to
+const MaxTranscriptRecipients = 10
+
+// TranscriptSettings configures who receives chat transcripts
+type TranscriptSettings struct {
+	VisitorRequests bool     `json:"visitor_requests"` // the widget offers to email the visitor a transcript
+	OwnerEmails     []string `json:"owner_emails"`     // every ended chat is sent to these addresses
+}
+
+// TranscriptRequest represents a visitor asking the widget for a transcript
+type TranscriptRequest struct {
+	SessionID string `json:"session_id" binding:"required,max=128"`
+	Email     string `json:"email" binding:"omitempty,email,max=255"` // one the visitor already left; the first one when empty
+}
+
+// Transcript is a chat with its messages, ready to be rendered
+type Transcript struct {
+	ChatID      uint              `json:"chat_id"`
+	WebsiteName string            `json:"website_name"`
+	StartedAt   time.Time         `json:"started_at"`
+	EndedAt     *time.Time        `json:"ended_at"`
+	Entries     []TranscriptEntry `json:"entries"`
+}
+
+// TranscriptEntry is a single message in a transcript
+type TranscriptEntry struct {
+	Sender    string    `json:"sender"` // display name: Visitor, Bot or the agent's name
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ValidateTranscriptSettings checks the transcript recipients
+func ValidateTranscriptSettings(settings TranscriptSettings) error {
+	if len(settings.OwnerEmails) > MaxTranscriptRecipients {
+		return fmt.Errorf("at most %d transcript recipients", MaxTranscriptRecipients)
+	}
+	for _, email := range settings.OwnerEmails {
+		address, err := mail.ParseAddress(email)
+		if err != nil || address.Address != email {
+			return fmt.Errorf("invalid transcript recipient '%s'", email)
+		}
+	}
+	return nil
+}
+
+// ValidateTranscriptFormat checks a transcript format
+func ValidateTranscriptFormat(format string) error {
+	switch format {
+	case TranscriptFormatText, TranscriptFormatHTML, TranscriptFormatPDF:
+		return nil
+	default:
+		return errors.New("invalid transcript format (use txt, html or pdf)")
+	}
+}
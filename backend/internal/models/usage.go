@@ -0,0 +1,64 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Layouts of API usage dates and billing months, in UTC
+const (
+	UsageDateLayout  = "2006-01-02"
+	UsageMonthLayout = "2006-01"
+)
+
+// APIUsage counts a user's API calls on one day, for quotas and billing
+type APIUsage struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_api_usage_user_date"`
+	Date      string    `json:"date" gorm:"size:10;not null;uniqueIndex:idx_api_usage_user_date"` // YYYY-MM-DD
+	Calls     int64     `json:"calls" gorm:"not null;default:0"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// APIUsageDay is a day's calls in a usage report
+type APIUsageDay struct {
+	Date  string `json:"date"`
+	Calls int64  `json:"calls"`
+}
+
+// APIUsageReport is a user's API usage over a billing month
+type APIUsageReport struct {
+	Month     string        `json:"month"`
+	Plan      string        `json:"plan"`
+	Calls     int64         `json:"calls"`
+	Quota     int64         `json:"quota"`     // -1 for unlimited
+	Remaining int64         `json:"remaining"` // -1 for unlimited
+	ResetsAt  time.Time     `json:"resets_at"`
+	Days      []APIUsageDay `json:"days"`
+
+	// The plan's rate limit
+	RequestsPerMinute int `json:"requests_per_minute"`
+	RequestBurst      int `json:"request_burst"`
+}
+
+// APIUsageTotal is a user's calls over a billing month, for billing
+type APIUsageTotal struct {
+	UserID uint   `json:"user_id"`
+	Month  string `json:"month"`
+	Calls  int64  `json:"calls"`
+}
+
+// ParseUsageMonth parses a YYYY-MM billing month and returns when it starts
+// and ends
+func ParseUsageMonth(month string) (time.Time, time.Time, error) {
+	start, err := time.Parse(UsageMonthLayout, month)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("invalid month")
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// UsageMonth returns the billing month a time falls in
+func UsageMonth(t time.Time) string {
+	return t.UTC().Format(UsageMonthLayout)
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"regexp"
 	"time"
@@ -21,6 +23,12 @@ type User struct {
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 
+	// Two-factor authentication
+	MFAEnabled      bool   `json:"mfa_enabled" gorm:"default:false"`
+	MFASecret       string `json:"-"`                                      // set when setup starts; in use once a code is verified
+	MFALastCounter  int64  `json:"-"`                                      // TOTP step of the last code used, so none is used twice
+	RequireAgentMFA bool   `json:"require_agent_mfa" gorm:"default:false"` // agents on the user's websites must use 2FA
+
 	// Relationships
 	Websites []Website `json:"websites,omitempty" gorm:"foreignKey:UserID"`
 }
@@ -46,6 +54,9 @@ type UserResponse struct {
 	IsActive  bool      `json:"is_active"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	MFAEnabled      bool `json:"mfa_enabled"`
+	RequireAgentMFA bool `json:"require_agent_mfa"`
 }
 
 // HashPassword hashes the user's password using bcrypt
@@ -63,6 +74,23 @@ func (u *User) HashPassword(password string) error {
 	return nil
 }
 
+// SetUnusablePassword gives a user created through single sign-on a random
+// password, so they can only sign in with their identity provider
+func (u *User) SetUnusablePassword() error {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(random)), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	u.Password = string(hashedPassword)
+	return nil
+}
+
 // CheckPassword verifies if the provided password matches the user's hashed password
 func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
@@ -79,6 +107,9 @@ func (u *User) ToResponse() UserResponse {
 		IsActive:  u.IsActive,
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
+
+		MFAEnabled:      u.MFAEnabled,
+		RequireAgentMFA: u.RequireAgentMFA,
 	}
 }
 
@@ -25,6 +25,8 @@ type Website struct {
 	MaxUsers    int             `json:"max_users" gorm:"default:100"`
 	IsActive    bool            `json:"is_active" gorm:"default:true"`
 	Settings    WebsiteSettings `json:"settings" gorm:"type:jsonb"`
+	RoutingStrategy string      `json:"routing_strategy" gorm:"default:'round_robin'"`
+	IdentitySecret  string      `json:"-"` // HMAC key for verifying widget identify calls
 	CreatedAt   time.Time       `json:"created_at"`
 	UpdatedAt   time.Time       `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt  `json:"-" gorm:"index"`
@@ -59,6 +61,7 @@ type WebsiteResponse struct {
 	MaxUsers  int             `json:"max_users"`
 	IsActive  bool            `json:"is_active"`
 	Settings  WebsiteSettings `json:"settings"`
+	RoutingStrategy string    `json:"routing_strategy"`
 	CreatedAt time.Time       `json:"created_at"`
 	UpdatedAt time.Time       `json:"updated_at"`
 }
@@ -75,7 +78,13 @@ type WebsiteSettings struct {
 	ModerationEnabled  bool              `json:"moderation_enabled"`
 	CustomCSS          string            `json:"custom_css"`
 	AllowedDomains     []string          `json:"allowed_domains"`
-	BusinessHours      map[string]string `json:"business_hours"`
+	BusinessHours      map[string]string `json:"business_hours"` // day name to "closed" or ranges like "09:00-12:00,13:00-17:00"
+	Timezone           string            `json:"timezone"`       // IANA name the business hours are in; UTC when empty
+	Holidays           []string          `json:"holidays"`       // YYYY-MM-DD dates the website is closed all day
+	IdentityVerification bool            `json:"identity_verification"` // reject identify calls without a valid identity hash
+	PreChatForm        PreChatForm       `json:"pre_chat_form"`
+	Transcripts        TranscriptSettings `json:"transcripts"`
+	CannedBotReplies   bool              `json:"canned_bot_replies"` // the bot answers visitor messages matching a canned response's keywords
 }
 
 // Implement database/sql/driver.Valuer interface for JSONB
@@ -97,6 +106,12 @@ func (ws *WebsiteSettings) Scan(value interface{}) error {
 	return json.Unmarshal(bytes, ws)
 }
 
+// Public returns the settings that are safe to show to visitors
+func (ws WebsiteSettings) Public() WebsiteSettings {
+	ws.Transcripts.OwnerEmails = nil
+	return ws
+}
+
 // GenerateWidgetKey generates a unique widget key for the website
 func (w *Website) GenerateWidgetKey() error {
 	// Generate UUID-based widget key
@@ -167,6 +182,7 @@ func (w *Website) ToResponse() WebsiteResponse {
 		MaxUsers:  w.MaxUsers,
 		IsActive:  w.IsActive,
 		Settings:  w.Settings,
+		RoutingStrategy: w.RoutingStrategy,
 		CreatedAt: w.CreatedAt,
 		UpdatedAt: w.UpdatedAt,
 	}
@@ -201,6 +217,10 @@ func (w *Website) BeforeCreate(tx *gorm.DB) error {
 		w.Settings = GetDefaultWebsiteSettings()
 	}
 	
+	if w.RoutingStrategy == "" {
+		w.RoutingStrategy = RoutingRoundRobin
+	}
+	
 	return nil
 }
 
@@ -238,6 +258,8 @@ func GetDefaultWebsiteSettings() WebsiteSettings {
 			"saturday":  "closed",
 			"sunday":    "closed",
 		},
+		Timezone:           "UTC",
+		Holidays:           []string{},
 	}
 }
 
@@ -274,12 +296,26 @@ type Chat struct {
 	ID         uint           `json:"id" gorm:"primaryKey"`
 	WebsiteID  uint           `json:"website_id" gorm:"not null"`
 	SessionID  string         `json:"session_id" gorm:"uniqueIndex;not null"`
+	VisitorID  string         `json:"visitor_id" gorm:"index"` // persists across sessions in the widget
 	VisitorIP  string         `json:"visitor_ip"`
 	UserAgent  string         `json:"user_agent"`
 	Language   string         `json:"language"`
 	IsActive   bool           `json:"is_active" gorm:"default:true"`
+	Status     string         `json:"status" gorm:"default:'open';index"`
+	Priority   string         `json:"priority" gorm:"default:'normal'"`
+	Tags       ChatTags       `json:"tags" gorm:"type:jsonb"`
+	SnoozedUntil *time.Time   `json:"snoozed_until"`
+	ResolvedAt *time.Time     `json:"resolved_at"`
 	StartedAt  time.Time      `json:"started_at"`
 	EndedAt    *time.Time     `json:"ended_at"`
+	AssignedAgentID *uint     `json:"assigned_agent_id" gorm:"index"`
+	AssignedAt *time.Time     `json:"assigned_at"`
+	QueuedAt   *time.Time     `json:"queued_at"` // set while waiting for an available agent
+	ContactID  *uint          `json:"contact_id" gorm:"index"`
+	PreChatData PreChatValues `json:"pre_chat_data,omitempty" gorm:"type:jsonb"`
+	PreChatSubmittedAt *time.Time `json:"pre_chat_submitted_at,omitempty"`
+	TranscriptEmail  string     `json:"transcript_email,omitempty"` // visitor address the transcript goes to when the chat ends
+	TranscriptSentAt *time.Time `json:"transcript_sent_at,omitempty"`
 	CreatedAt  time.Time      `json:"created_at"`
 	UpdatedAt  time.Time      `json:"updated_at"`
 	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
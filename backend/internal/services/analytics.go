@@ -1,11 +1,16 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"time"
 
 	"chatelly-backend/internal/config"
 	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/live"
+	"chatelly-backend/pkg/websocket"
 
 	"gorm.io/gorm"
 )
@@ -26,6 +31,10 @@ func NewAnalyticsService(db *gorm.DB, cfg *config.Config) *AnalyticsService {
 
 // TrackEvent tracks an analytics event
 func (s *AnalyticsService) TrackEvent(websiteID uint, eventType string, eventData models.AnalyticsData, visitorID, sessionID, userAgent, ip, referrer string) error {
+	if err := s.ValidateEvent(websiteID, eventType, eventData); err != nil {
+		return err
+	}
+
 	// Get location data from IP (placeholder - would integrate with GeoIP service)
 	country, city := s.getLocationFromIP(ip)
 	
@@ -43,7 +52,28 @@ func (s *AnalyticsService) TrackEvent(websiteID uint, eventType string, eventDat
 		CreatedAt: time.Now(),
 	}
 	
-	return s.db.Create(analytics).Error
+	if err := s.db.Create(analytics).Error; err != nil {
+		return err
+	}
+	
+	// Notify live dashboards
+	live.PublishEvent(websiteID, eventType)
+	
+	return nil
+}
+
+// RecordLimitHit records a message or connection the chat hub refused as an
+// error event, so floods show up in the website's analytics
+func (s *AnalyticsService) RecordLimitHit(hit websocket.LimitHit) error {
+	eventData := models.AnalyticsData{
+		"error": "rate_limited",
+		"limit": hit.Limit,
+	}
+	if hit.MessageType != "" {
+		eventData["message_type"] = hit.MessageType
+	}
+
+	return s.TrackEvent(hit.WebsiteID, models.EventTypeError, eventData, hit.VisitorID, hit.SessionID, hit.UserAgent, hit.IP, "")
 }
 
 // GetDashboardMetrics returns dashboard metrics for a website
@@ -93,8 +123,12 @@ func (s *AnalyticsService) GetDashboardMetrics(websiteID uint, days int) (*model
 		metrics.ConversionRate = float64(totalConversions) / float64(metrics.TotalVisitors) * 100
 	}
 	
-	// Average chat duration (placeholder - would need chat duration tracking)
-	metrics.AvgChatDuration = 0 // TODO: Implement chat duration calculation
+	// Response and resolution times from message timestamps
+	responseTimes, err := s.GetResponseTimes(websiteID, startDate, time.Now(), nil)
+	if err != nil {
+		return nil, err
+	}
+	metrics.AvgChatDuration = responseTimes.AvgResolutionTime
 	
 	// Top pages
 	topPages, err := s.getTopPages(websiteID, startDate, 10)
@@ -133,13 +167,8 @@ func (s *AnalyticsService) GetDashboardMetrics(websiteID uint, days int) (*model
 		{Rating: 1, Count: 3},
 	}
 	
-	// Response times (placeholder)
-	metrics.ResponseTimes = []models.ResponseTimeMetric{
-		{TimeRange: "0-30s", AvgTime: 15.5, Count: 120},
-		{TimeRange: "30s-1m", AvgTime: 45.2, Count: 80},
-		{TimeRange: "1-2m", AvgTime: 90.1, Count: 45},
-		{TimeRange: "2m+", AvgTime: 180.5, Count: 25},
-	}
+	// Response times
+	metrics.ResponseTimes = responseTimes.FirstResponse
 	
 	return metrics, nil
 }
@@ -198,14 +227,157 @@ func (s *AnalyticsService) GetWebsiteAnalytics(websiteID uint, startDate, endDat
 	return analytics, nil
 }
 
+// GetResponseTimes computes first-response, reply and resolution times for chats
+// started in the given range. When agentID is set, only that agent's replies are counted.
+func (s *AnalyticsService) GetResponseTimes(websiteID uint, startDate, endDate time.Time, agentID *uint) (*models.ResponseTimeReport, error) {
+	report := &models.ResponseTimeReport{
+		WebsiteID: websiteID,
+		StartDate: startDate,
+		EndDate:   endDate,
+	}
+
+	// Each visitor turn (the user messages since the last reply) is answered
+	// by the next bot or agent message. Pairing them up runs in the database
+	// so only one row per reply is read; window functions keep it portable
+	// across Postgres and SQLite.
+	seconds := secondsBetween(s.db, "asked_at", "replied_at")
+	query := s.db.Raw(`
+		WITH ordered AS (
+			SELECT messages.chat_id, messages.sender, messages.sender_id, messages.timestamp,
+				COALESCE(SUM(CASE WHEN messages.sender <> ? THEN 1 ELSE 0 END) OVER (
+					PARTITION BY messages.chat_id ORDER BY messages.timestamp, messages.id
+					ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING
+				), 0) AS turn
+			FROM messages
+			JOIN chats ON messages.chat_id = chats.id
+			WHERE chats.website_id = ? AND chats.started_at BETWEEN ? AND ? AND messages.deleted_at IS NULL
+		),
+		turns AS (
+			SELECT chat_id, turn,
+				MIN(CASE WHEN sender = ? THEN timestamp END) AS asked_at,
+				MAX(CASE WHEN sender <> ? THEN timestamp END) AS replied_at,
+				MAX(CASE WHEN sender <> ? THEN sender END) AS sender,
+				MAX(CASE WHEN sender <> ? THEN sender_id END) AS sender_id
+			FROM ordered
+			GROUP BY chat_id, turn
+		),
+		replies AS (
+			SELECT sender, sender_id, `+seconds+` AS seconds,
+				CASE WHEN ROW_NUMBER() OVER (PARTITION BY chat_id ORDER BY turn) = 1 THEN 1 ELSE 0 END AS first
+			FROM turns
+			WHERE asked_at IS NOT NULL AND replied_at IS NOT NULL
+		)
+		SELECT sender, sender_id, seconds, first FROM replies`,
+		models.SenderUser, websiteID, startDate, endDate,
+		models.SenderUser, models.SenderUser, models.SenderUser, models.SenderUser)
+	if agentID != nil {
+		// Replies by anyone else still answer the turn, so they are
+		// filtered out only once paired up
+		query = s.db.Raw("SELECT * FROM (?) AS replies WHERE sender_id = ?", query, *agentID)
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	firstResponse := newResponseTimeBuckets()
+	replies := newResponseTimeBuckets()
+	agents := make(map[uint]*models.AgentResponseMetric)
+	var agentOrder []uint
+
+	agentMetric := func(id uint) *models.AgentResponseMetric {
+		if m, ok := agents[id]; ok {
+			return m
+		}
+		m := &models.AgentResponseMetric{AgentID: id}
+		agents[id] = m
+		agentOrder = append(agentOrder, id)
+		return m
+	}
+
+	for rows.Next() {
+		var reply struct {
+			Sender   string
+			SenderID *uint
+			Seconds  float64
+			First    bool
+		}
+		if err := s.db.ScanRows(rows, &reply); err != nil {
+			return nil, err
+		}
+
+		responder := models.ResponderBot
+		if reply.Sender != models.SenderBot {
+			responder = models.ResponderHuman
+		}
+
+		if reply.First {
+			firstResponse.add(responder, reply.Seconds)
+		}
+		replies.add(responder, reply.Seconds)
+
+		if reply.SenderID != nil {
+			m := agentMetric(*reply.SenderID)
+			if reply.First {
+				m.AvgFirstResponse += reply.Seconds
+				m.FirstResponses++
+			}
+			m.AvgReplyTime += reply.Seconds
+			m.Replies++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report.FirstResponse = firstResponse.metrics()
+	report.AvgFirstResponse = firstResponse.average()
+	report.ReplyTimes = replies.metrics()
+	report.AvgReplyTime = replies.average()
+
+	report.Agents = make([]models.AgentResponseMetric, 0, len(agentOrder))
+	for _, id := range agentOrder {
+		m := agents[id]
+		if m.FirstResponses > 0 {
+			m.AvgFirstResponse /= float64(m.FirstResponses)
+		}
+		if m.Replies > 0 {
+			m.AvgReplyTime /= float64(m.Replies)
+		}
+		report.Agents = append(report.Agents, *m)
+	}
+
+	// Resolution time (chat start to end)
+	avgResolution, resolved, err := averageChatDuration(s.db, websiteID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	report.AvgResolutionTime = avgResolution
+	report.ResolvedChats = resolved
+
+	return report, nil
+}
+
+// GetConversionAttribution reports whether converting visitors chatted before converting
+func (s *AnalyticsService) GetConversionAttribution(websiteID uint, startDate, endDate time.Time) (*models.ConversionAttribution, error) {
+	return conversionAttribution(s.db, websiteID, startDate, endDate)
+}
+
 // GetEventsByType returns events filtered by type
-func (s *AnalyticsService) GetEventsByType(websiteID uint, eventType string, startDate, endDate time.Time, page, limit int) ([]models.Analytics, int64, error) {
+func (s *AnalyticsService) GetEventsByType(websiteID uint, eventType string, properties map[string]string, startDate, endDate time.Time, page, limit int) ([]models.Analytics, int64, error) {
 	var events []models.Analytics
 	var total int64
 	
 	query := s.db.Model(&models.Analytics{}).
 		Where("website_id = ? AND event_type = ? AND created_at BETWEEN ? AND ?", websiteID, eventType, startDate, endDate)
 	
+	query, err := filterEventData(query, properties)
+	if err != nil {
+		return nil, 0, err
+	}
+	
 	// Count total
 	if err := query.Count(&total).Error; err != nil {
 		return nil, 0, err
@@ -223,11 +395,141 @@ func (s *AnalyticsService) GetEventsByType(websiteID uint, eventType string, sta
 	return events, total, nil
 }
 
+// ValidateEvent checks an event against built-in types and the website's custom definitions
+func (s *AnalyticsService) ValidateEvent(websiteID uint, eventType string, eventData models.AnalyticsData) error {
+	if models.IsValidEventType(eventType) {
+		return models.ValidateEventDataSize(eventData, models.MaxEventDataSize)
+	}
+
+	definition, err := models.GetEventDefinition(s.db, websiteID, eventType)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("invalid event type")
+		}
+		return err
+	}
+
+	if err := definition.Schema.Validate(eventData); err != nil {
+		return fmt.Errorf("invalid event data: %w", err)
+	}
+
+	return nil
+}
+
+// GetEventTypes returns built-in event types and the website's custom event definitions
+func (s *AnalyticsService) GetEventTypes(websiteID uint) ([]string, []models.EventDefinition, error) {
+	definitions, err := s.GetEventDefinitions(websiteID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return models.GetValidEventTypes(), definitions, nil
+}
+
+// GetEventDefinitions retrieves all custom event definitions for a website
+func (s *AnalyticsService) GetEventDefinitions(websiteID uint) ([]models.EventDefinition, error) {
+	var definitions []models.EventDefinition
+	if err := s.db.Where("website_id = ?", websiteID).
+		Order("name ASC").
+		Find(&definitions).Error; err != nil {
+		return nil, err
+	}
+
+	return definitions, nil
+}
+
+// GetEventDefinitionByID retrieves a custom event definition scoped to a website
+func (s *AnalyticsService) GetEventDefinitionByID(definitionID, websiteID uint) (*models.EventDefinition, error) {
+	var definition models.EventDefinition
+	if err := s.db.Where("id = ? AND website_id = ?", definitionID, websiteID).First(&definition).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("event definition not found")
+		}
+		return nil, err
+	}
+
+	return &definition, nil
+}
+
+// CreateEventDefinition creates a custom event type for a website
+func (s *AnalyticsService) CreateEventDefinition(websiteID uint, req *models.EventDefinitionCreateRequest) (*models.EventDefinition, error) {
+	// Check the name is not taken
+	var count int64
+	if err := s.db.Model(&models.EventDefinition{}).
+		Where("website_id = ? AND name = ?", websiteID, req.Name).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("event definition already exists")
+	}
+
+	definition := &models.EventDefinition{
+		WebsiteID:   websiteID,
+		Name:        req.Name,
+		Description: req.Description,
+		Schema:      req.Schema,
+	}
+
+	// Save to database (BeforeSave hook validates name and schema)
+	if err := s.db.Create(definition).Error; err != nil {
+		return nil, fmt.Errorf("failed to create event definition: %w", err)
+	}
+
+	return definition, nil
+}
+
+// UpdateEventDefinition updates a custom event type's description or schema
+func (s *AnalyticsService) UpdateEventDefinition(definitionID, websiteID uint, req *models.EventDefinitionUpdateRequest) (*models.EventDefinition, error) {
+	definition, err := s.GetEventDefinitionByID(definitionID, websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Description != nil {
+		definition.Description = *req.Description
+	}
+
+	if req.Schema != nil {
+		definition.Schema = *req.Schema
+	}
+
+	if err := s.db.Save(definition).Error; err != nil {
+		return nil, fmt.Errorf("failed to update event definition: %w", err)
+	}
+
+	return definition, nil
+}
+
+// DeleteEventDefinition deletes a custom event type. Events already tracked are kept.
+func (s *AnalyticsService) DeleteEventDefinition(definitionID, websiteID uint) error {
+	definition, err := s.GetEventDefinitionByID(definitionID, websiteID)
+	if err != nil {
+		return err
+	}
+
+	// Hard delete so the name can be defined again
+	if err := s.db.Unscoped().Delete(definition).Error; err != nil {
+		return fmt.Errorf("failed to delete event definition: %w", err)
+	}
+
+	return nil
+}
+
 // GetVisitorJourney returns the journey of a specific visitor
 func (s *AnalyticsService) GetVisitorJourney(websiteID uint, visitorID string, startDate, endDate time.Time) ([]models.Analytics, error) {
-	var events []models.Analytics
+	return s.GetVisitorsJourney(websiteID, []string{visitorID}, startDate, endDate)
+}
+
+// GetVisitorsJourney returns the combined events of several visitor IDs
+// belonging to the same person, oldest first
+func (s *AnalyticsService) GetVisitorsJourney(websiteID uint, visitorIDs []string, startDate, endDate time.Time) ([]models.Analytics, error) {
+	events := []models.Analytics{}
+	if len(visitorIDs) == 0 {
+		return events, nil
+	}
 	
-	if err := s.db.Where("website_id = ? AND visitor_id = ? AND created_at BETWEEN ? AND ?", websiteID, visitorID, startDate, endDate).
+	if err := s.db.Where("website_id = ? AND visitor_id IN ? AND created_at BETWEEN ? AND ?", websiteID, visitorIDs, startDate, endDate).
 		Order("created_at ASC").
 		Find(&events).Error; err != nil {
 		return nil, err
@@ -465,9 +767,15 @@ func (s *AnalyticsService) getEngagementMetrics(websiteID uint, startDate, endDa
 		return metrics, err
 	}
 	
+	// Average chat duration
+	avgDuration, _, err := averageChatDuration(s.db, websiteID, startDate, endDate)
+	if err != nil {
+		return metrics, err
+	}
+	metrics.AvgChatDuration = avgDuration
+
 	// Placeholder values
 	metrics.AvgMessagesPerChat = 5.2
-	metrics.AvgChatDuration = 180.5
 	metrics.ReturnVisitors = 45
 	metrics.EngagementRate = 12.5
 	
@@ -491,11 +799,45 @@ func (s *AnalyticsService) getConversionMetrics(websiteID uint, startDate, endDa
 		return metrics, err
 	}
 	
-	// Placeholder values
-	metrics.ConversionRate = 2.5
-	metrics.ChatToConversion = 15.8
-	metrics.LeadGeneration = 25
-	metrics.SalesConversions = 8
+	// Leads (distinct visitors who left an email)
+	if err := s.db.Model(&models.Analytics{}).
+		Where("website_id = ? AND event_type = ? AND created_at BETWEEN ? AND ?", websiteID, models.EventTypeEmailCapture, startDate, endDate).
+		Distinct("visitor_id").
+		Count(&metrics.LeadGeneration).Error; err != nil {
+		return metrics, err
+	}
+
+	// Conversions carrying a monetary value
+	var conversions []models.Analytics
+	if err := s.db.Select("event_data").
+		Where("website_id = ? AND event_type = ? AND created_at BETWEEN ? AND ?", websiteID, models.EventTypeConversion, startDate, endDate).
+		Find(&conversions).Error; err != nil {
+		return metrics, err
+	}
+	for _, conversion := range conversions {
+		if value := conversion.EventData.ConversionValue(); value > 0 {
+			metrics.SalesConversions++
+			metrics.Revenue += value
+		}
+	}
+
+	// Conversion rate (converting visitors / visitors)
+	attribution, err := conversionAttribution(s.db, websiteID, startDate, endDate)
+	if err != nil {
+		return metrics, err
+	}
+	metrics.ChatToConversion = attribution.ChatToConversion
+
+	var visitors int64
+	if err := s.db.Model(&models.Analytics{}).
+		Where("website_id = ? AND created_at BETWEEN ? AND ?", websiteID, startDate, endDate).
+		Distinct("visitor_id").
+		Count(&visitors).Error; err != nil {
+		return metrics, err
+	}
+	if visitors > 0 {
+		metrics.ConversionRate = float64(attribution.ConvertingVisitors) / float64(visitors) * 100
+	}
 	
 	return metrics, nil
 }
@@ -531,6 +873,113 @@ func (s *AnalyticsService) getTemporalMetrics(websiteID uint, startDate, endDate
 	return metrics, nil
 }
 
+// averageChatDuration returns the average duration in seconds of ended chats
+// started in the given range, along with the number of chats considered
+func averageChatDuration(db *gorm.DB, websiteID uint, startDate, endDate time.Time) (float64, int64, error) {
+	var result struct {
+		Average float64
+		Chats   int64
+	}
+	if err := db.Model(&models.Chat{}).
+		Select("COALESCE(AVG("+secondsBetween(db, "started_at", "ended_at")+"), 0) AS average, COUNT(*) AS chats").
+		Where("website_id = ? AND ended_at IS NOT NULL AND started_at BETWEEN ? AND ?", websiteID, startDate, endDate).
+		Scan(&result).Error; err != nil {
+		return 0, 0, err
+	}
+
+	return result.Average, result.Chats, nil
+}
+
+// secondsBetween returns an SQL expression for the seconds between two
+// timestamp columns
+func secondsBetween(db *gorm.DB, from, to string) string {
+	if db.Dialector.Name() == "postgres" {
+		return fmt.Sprintf("CAST(EXTRACT(EPOCH FROM (%s - %s)) AS DOUBLE PRECISION)", to, from)
+	}
+	return fmt.Sprintf("(unixepoch(%s, 'subsec') - unixepoch(%s, 'subsec'))", to, from)
+}
+
+// responseTimeBuckets accumulates response times per bucket and responder
+type responseTimeBuckets struct {
+	totals map[string]float64
+	counts map[string]int64
+	sum    float64
+	n      int64
+}
+
+func newResponseTimeBuckets() *responseTimeBuckets {
+	return &responseTimeBuckets{
+		totals: make(map[string]float64),
+		counts: make(map[string]int64),
+	}
+}
+
+func (b *responseTimeBuckets) add(responder string, seconds float64) {
+	key := responder + "|" + models.GetResponseTimeRange(seconds)
+	b.totals[key] += seconds
+	b.counts[key]++
+	b.sum += seconds
+	b.n++
+}
+
+func (b *responseTimeBuckets) average() float64 {
+	if b.n == 0 {
+		return 0
+	}
+	return b.sum / float64(b.n)
+}
+
+// metrics returns every bucket for both responders, including empty ones,
+// so charts always receive the same series
+func (b *responseTimeBuckets) metrics() []models.ResponseTimeMetric {
+	var result []models.ResponseTimeMetric
+	for _, responder := range []string{models.ResponderHuman, models.ResponderBot} {
+		for _, timeRange := range models.ResponseTimeRanges {
+			key := responder + "|" + timeRange
+			metric := models.ResponseTimeMetric{
+				TimeRange: timeRange,
+				Count:     b.counts[key],
+				Responder: responder,
+			}
+			if metric.Count > 0 {
+				metric.AvgTime = b.totals[key] / float64(metric.Count)
+			}
+			result = append(result, metric)
+		}
+	}
+	return result
+}
+
+var eventPropertyKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+// filterEventData restricts a query to events whose event_data properties equal the given
+// values. Values are compared as text so query string filters match numbers and strings alike.
+func filterEventData(query *gorm.DB, properties map[string]string) (*gorm.DB, error) {
+	// Apply filters in a stable order
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !eventPropertyKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid property filter '%s'", key)
+		}
+
+		// Keys are validated above, so they are safe to inline
+		var expr string
+		if query.Dialector.Name() == "postgres" {
+			expr = fmt.Sprintf("event_data->>'%s' = ?", key)
+		} else {
+			expr = fmt.Sprintf("CAST(json_extract(CAST(event_data AS TEXT), '$.%s') AS TEXT) = ?", key)
+		}
+		query = query.Where(expr, properties[key])
+	}
+
+	return query, nil
+}
+
 func (s *AnalyticsService) getLocationFromIP(ip string) (country, city string) {
 	// Placeholder implementation - would integrate with GeoIP service
 	// For now, return default values
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/websocket"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect database: %v", err)
+	}
+
+	// Migrate the schema
+	if err := db.AutoMigrate(&models.User{}, &models.Website{}, &models.Chat{}, &models.Message{}, &models.Attachment{}, &models.Analytics{}, &models.EventDefinition{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return db
+}
+
+func createTestWebsite(t *testing.T, db *gorm.DB) *models.Website {
+	t.Helper()
+
+	user := &models.User{
+		Email:    "owner@example.com",
+		Password: "$2a$10$abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ01",
+		Name:     "Owner",
+	}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	website := &models.Website{UserID: user.ID, Name: "Example", Domain: "example.com"}
+	if err := db.Create(website).Error; err != nil {
+		t.Fatalf("failed to create website: %v", err)
+	}
+	return website
+}
+
+func TestAnalyticsService_GetResponseTimes(t *testing.T) {
+	db := setupTestDB(t)
+	website := createTestWebsite(t, db)
+	service := NewAnalyticsService(db, &config.Config{})
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	ended := start.Add(10 * time.Minute)
+	agentID := uint(7)
+
+	// Chat 1: bot greets, visitor writes, agent answers after 20s, visitor writes twice, bot answers after 90s
+	chat1 := &models.Chat{WebsiteID: website.ID, SessionID: "s1", StartedAt: start, EndedAt: &ended}
+	// Chat 2: visitor writes, bot answers after 45s, never ended
+	chat2 := &models.Chat{WebsiteID: website.ID, SessionID: "s2", StartedAt: start}
+	for _, chat := range []*models.Chat{chat1, chat2} {
+		if err := db.Create(chat).Error; err != nil {
+			t.Fatalf("failed to create chat: %v", err)
+		}
+	}
+
+	messages := []models.Message{
+		{ChatID: chat1.ID, Content: "hi", Sender: models.SenderBot, Timestamp: start},
+		{ChatID: chat1.ID, Content: "help", Sender: models.SenderUser, Timestamp: start.Add(5 * time.Second)},
+		{ChatID: chat1.ID, Content: "sure", Sender: models.SenderAgent, SenderID: &agentID, Timestamp: start.Add(25 * time.Second)},
+		{ChatID: chat1.ID, Content: "more", Sender: models.SenderUser, Timestamp: start.Add(30 * time.Second)},
+		{ChatID: chat1.ID, Content: "again", Sender: models.SenderUser, Timestamp: start.Add(40 * time.Second)},
+		{ChatID: chat1.ID, Content: "done", Sender: models.SenderBot, Timestamp: start.Add(120 * time.Second)},
+		{ChatID: chat2.ID, Content: "hello", Sender: models.SenderUser, Timestamp: start},
+		{ChatID: chat2.ID, Content: "hey", Sender: models.SenderBot, Timestamp: start.Add(45 * time.Second)},
+	}
+	if err := db.Create(&messages).Error; err != nil {
+		t.Fatalf("failed to create messages: %v", err)
+	}
+
+	report, err := service.GetResponseTimes(website.ID, start.Add(-time.Minute), time.Now(), nil)
+	if err != nil {
+		t.Fatalf("GetResponseTimes() error = %v", err)
+	}
+
+	if report.AvgFirstResponse != 32.5 {
+		t.Errorf("AvgFirstResponse = %v, want 32.5", report.AvgFirstResponse)
+	}
+	if want := (20.0 + 90.0 + 45.0) / 3; report.AvgReplyTime != want {
+		t.Errorf("AvgReplyTime = %v, want %v", report.AvgReplyTime, want)
+	}
+	if report.AvgResolutionTime != 600 || report.ResolvedChats != 1 {
+		t.Errorf("resolution = %v over %d chats, want 600 over 1", report.AvgResolutionTime, report.ResolvedChats)
+	}
+
+	if got := len(report.FirstResponse); got != 2*len(models.ResponseTimeRanges) {
+		t.Fatalf("len(FirstResponse) = %d, want %d", got, 2*len(models.ResponseTimeRanges))
+	}
+	counts := make(map[string]int64)
+	for _, metric := range report.FirstResponse {
+		counts[metric.Responder+" "+metric.TimeRange] = metric.Count
+	}
+	if counts["human 0-30s"] != 1 || counts["bot 30s-1m"] != 1 {
+		t.Errorf("unexpected first response buckets: %v", counts)
+	}
+
+	if len(report.Agents) != 1 || report.Agents[0].AgentID != agentID || report.Agents[0].AvgFirstResponse != 20 {
+		t.Errorf("unexpected agent metrics: %+v", report.Agents)
+	}
+
+	// Filtering by agent only counts that agent's replies
+	report, err = service.GetResponseTimes(website.ID, start.Add(-time.Minute), time.Now(), &agentID)
+	if err != nil {
+		t.Fatalf("GetResponseTimes() error = %v", err)
+	}
+	if report.AvgFirstResponse != 20 || report.AvgReplyTime != 20 {
+		t.Errorf("agent filter: first = %v, reply = %v, want 20 and 20", report.AvgFirstResponse, report.AvgReplyTime)
+	}
+}
+
+func TestAnalyticsService_CustomEvents(t *testing.T) {
+	db := setupTestDB(t)
+	website := createTestWebsite(t, db)
+	service := NewAnalyticsService(db, &config.Config{})
+
+	_, err := service.CreateEventDefinition(website.ID, &models.EventDefinitionCreateRequest{
+		Name: "trial_started",
+		Schema: models.EventSchema{
+			Properties: map[string]models.EventPropertySchema{
+				"plan":  {Type: "string"},
+				"seats": {Type: "integer"},
+			},
+			Required: []string{"plan"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateEventDefinition() error = %v", err)
+	}
+
+	if _, err := service.CreateEventDefinition(website.ID, &models.EventDefinitionCreateRequest{Name: "trial_started"}); err == nil {
+		t.Error("CreateEventDefinition() with a duplicate name should fail")
+	}
+
+	track := func(eventType string, data models.AnalyticsData) error {
+		return service.TrackEvent(website.ID, eventType, data, "v1", "s1", "", "", "")
+	}
+
+	if err := track("trial_started", models.AnalyticsData{"plan": "pro", "seats": 5.0}); err != nil {
+		t.Errorf("TrackEvent() valid custom event error = %v", err)
+	}
+	if err := track("trial_started", models.AnalyticsData{"plan": "basic", "seats": 1.0}); err != nil {
+		t.Errorf("TrackEvent() valid custom event error = %v", err)
+	}
+	if err := track("trial_started", models.AnalyticsData{"seats": 5.0}); err == nil {
+		t.Error("TrackEvent() without a required property should fail")
+	}
+	if err := track("undefined_event", nil); err == nil {
+		t.Error("TrackEvent() with an undefined event type should fail")
+	}
+
+	builtIn, custom, err := service.GetEventTypes(website.ID)
+	if err != nil {
+		t.Fatalf("GetEventTypes() error = %v", err)
+	}
+	if len(builtIn) != len(models.GetValidEventTypes()) || len(custom) != 1 || custom[0].Name != "trial_started" {
+		t.Errorf("GetEventTypes() = %v, %+v", builtIn, custom)
+	}
+
+	start, end := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	events, total, err := service.GetEventsByType(website.ID, "trial_started", map[string]string{"plan": "pro", "seats": "5"}, start, end, 1, 10)
+	if err != nil {
+		t.Fatalf("GetEventsByType() error = %v", err)
+	}
+	if total != 1 || len(events) != 1 || events[0].EventData["plan"] != "pro" {
+		t.Errorf("GetEventsByType() = %d events (total %d), want the pro event", len(events), total)
+	}
+
+	if _, _, err := service.GetEventsByType(website.ID, "trial_started", map[string]string{"plan'--": "x"}, start, end, 1, 10); err == nil {
+		t.Error("GetEventsByType() with an invalid property key should fail")
+	}
+
+	export := &models.ExportJob{
+		WebsiteID: website.ID,
+		Dataset:   models.ExportDatasetEvents,
+		Format:    models.ExportFormatNDJSON,
+		StartDate: start,
+		EndDate:   end,
+		Filters:   models.ExportFilters{Properties: map[string]string{"plan": "basic"}},
+	}
+	rows, err := NewExportService(db, &config.Config{}, nil).StreamExport(context.Background(), io.Discard, export, nil)
+	if err != nil {
+		t.Fatalf("StreamExport() error = %v", err)
+	}
+	if rows != 1 {
+		t.Errorf("StreamExport() exported %d events, want 1", rows)
+	}
+}
+
+func TestAnalyticsService_RecordLimitHit(t *testing.T) {
+	db := setupTestDB(t)
+	website := createTestWebsite(t, db)
+	service := NewAnalyticsService(db, &config.Config{})
+
+	hit := websocket.LimitHit{
+		Limit:       websocket.LimitMessageRate,
+		MessageType: "chat_message",
+		WebsiteID:   website.ID,
+		SessionID:   "session-1",
+		VisitorID:   "visitor-1",
+		IP:          "203.0.113.7",
+		UserAgent:   "test",
+		At:          time.Now(),
+	}
+	if err := service.RecordLimitHit(hit); err != nil {
+		t.Fatalf("RecordLimitHit() error = %v", err)
+	}
+
+	var event models.Analytics
+	if err := db.Where("website_id = ? AND event_type = ?", website.ID, models.EventTypeError).First(&event).Error; err != nil {
+		t.Fatalf("expected an error event: %v", err)
+	}
+	if event.EventData["limit"] != websocket.LimitMessageRate || event.EventData["message_type"] != "chat_message" {
+		t.Errorf("unexpected event data %v", event.EventData)
+	}
+	if event.SessionID != "session-1" || event.VisitorID != "visitor-1" || event.IP != "203.0.113.7" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
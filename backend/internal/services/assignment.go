@@ -0,0 +1,963 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Agent console notification types
+const (
+	AgentEventChatAssigned   = "chat_assigned"
+	AgentEventChatUnassigned = "chat_unassigned"
+	AgentEventChatQueued     = "chat_queued"
+	AgentEventChatEnded      = "chat_ended"
+	AgentEventPresence       = "presence_changed"
+)
+
+// maxQueueBatch bounds how many queued chats are routed in one pass
+const maxQueueBatch = 100
+
+// assignmentMu serializes routing decisions so two chats can't both take an
+// agent's last free slot. Routing is only consistent within one process.
+var assignmentMu sync.Mutex
+
+// AgentNotifier pushes assignment changes to connected agent consoles
+type AgentNotifier interface {
+	SendToAgent(userID uint, msgType string, data interface{})
+	IsAgentConnected(userID uint) bool
+}
+
+// AssignmentService handles chat routing, agent presence and the chat queue
+type AssignmentService struct {
+	db       *gorm.DB
+	cfg      *config.Config
+	notifier AgentNotifier
+	resolver txtResolver
+}
+
+// txtResolver looks up DNS TXT records
+type txtResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// NewAssignmentService creates a new AssignmentService. The notifier may be nil.
+func NewAssignmentService(db *gorm.DB, cfg *config.Config, notifier AgentNotifier) *AssignmentService {
+	return &AssignmentService{
+		db:       db,
+		cfg:      cfg,
+		notifier: notifier,
+		resolver: net.DefaultResolver,
+	}
+}
+
+// agentNotification is delivered after the routing transaction commits
+type agentNotification struct {
+	userID  uint
+	msgType string
+	data    map[string]interface{}
+}
+
+// agentLoad is a routing candidate with its current workload
+type agentLoad struct {
+	agent  models.WebsiteAgent
+	active int64
+}
+
+// RouteChat assigns a new chat using the website's routing strategy, or queues
+// it when no agent is available. Chats that are already assigned or queued are left alone.
+func (s *AssignmentService) RouteChat(chatID uint) (*models.Chat, error) {
+	var chat models.Chat
+	notifications, err := s.withRouting(func(tx *gorm.DB) ([]agentNotification, error) {
+		if err := loadActiveChat(tx, chatID, &chat); err != nil {
+			return nil, err
+		}
+		if chat.AssignedAgentID != nil || chat.QueuedAt != nil {
+			return nil, nil
+		}
+
+		_, notifications, err := routeChat(tx, &chat, 0)
+		return notifications, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notify(notifications)
+	return &chat, nil
+}
+
+// AssignChat assigns a chat to a specific agent, or routes it automatically when agentUserID is 0
+func (s *AssignmentService) AssignChat(chatID, agentUserID uint) (*models.Chat, error) {
+	if agentUserID == 0 {
+		var chat models.Chat
+		notifications, err := s.withRouting(func(tx *gorm.DB) ([]agentNotification, error) {
+			if err := loadActiveChat(tx, chatID, &chat); err != nil {
+				return nil, err
+			}
+			if chat.AssignedAgentID != nil {
+				return nil, errors.New("chat is already assigned")
+			}
+
+			assigned, notifications, err := routeChat(tx, &chat, 0)
+			if err != nil {
+				return nil, err
+			}
+			if !assigned {
+				return nil, errors.New("no agent is available")
+			}
+			return notifications, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		s.notify(notifications)
+		return &chat, nil
+	}
+
+	return s.assignTo(chatID, agentUserID, false)
+}
+
+// TransferChat moves an assigned chat to another agent
+func (s *AssignmentService) TransferChat(chatID, agentUserID uint) (*models.Chat, error) {
+	return s.assignTo(chatID, agentUserID, true)
+}
+
+// UnassignChat takes a chat away from its agent and routes it to someone else,
+// queueing it if nobody else is available
+func (s *AssignmentService) UnassignChat(chatID uint) (*models.Chat, error) {
+	var chat models.Chat
+	notifications, err := s.withRouting(func(tx *gorm.DB) ([]agentNotification, error) {
+		if err := loadActiveChat(tx, chatID, &chat); err != nil {
+			return nil, err
+		}
+		if chat.AssignedAgentID == nil {
+			return nil, errors.New("chat is not assigned")
+		}
+
+		previous := *chat.AssignedAgentID
+		if err := clearAssignment(tx, &chat); err != nil {
+			return nil, err
+		}
+
+		notifications := []agentNotification{{
+			userID:  previous,
+			msgType: AgentEventChatUnassigned,
+			data:    chatNotification(&chat, "unassigned"),
+		}}
+
+		_, routed, err := routeChat(tx, &chat, previous)
+		if err != nil {
+			return nil, err
+		}
+		return append(notifications, routed...), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notify(notifications)
+	return &chat, nil
+}
+
+// ReleaseChat frees the agent's slot after a chat ends and routes queued chats into it
+func (s *AssignmentService) ReleaseChat(chatID uint) error {
+	var chat models.Chat
+	if err := s.db.First(&chat, chatID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("chat not found")
+		}
+		return err
+	}
+
+	if chat.QueuedAt != nil {
+		if err := s.db.Model(&chat).Update("queued_at", nil).Error; err != nil {
+			return err
+		}
+	}
+
+	if chat.AssignedAgentID != nil {
+		s.notify([]agentNotification{{
+			userID:  *chat.AssignedAgentID,
+			msgType: AgentEventChatEnded,
+			data:    chatNotification(&chat, "ended"),
+		}})
+	}
+
+	return s.ProcessQueue(chat.WebsiteID)
+}
+
+// ProcessQueue assigns queued chats, oldest first, while agents are available
+func (s *AssignmentService) ProcessQueue(websiteID uint) error {
+	notifications, err := s.withRouting(func(tx *gorm.DB) ([]agentNotification, error) {
+		var queued []models.Chat
+		if err := tx.Where("website_id = ? AND is_active = ? AND assigned_agent_id IS NULL AND queued_at IS NOT NULL", websiteID, true).
+			Order("queued_at ASC").
+			Limit(maxQueueBatch).
+			Find(&queued).Error; err != nil {
+			return nil, err
+		}
+
+		var notifications []agentNotification
+		for i := range queued {
+			assigned, routed, err := routeChat(tx, &queued[i], 0)
+			if err != nil {
+				return nil, err
+			}
+			notifications = append(notifications, routed...)
+
+			// Availability doesn't depend on the chat, so one miss means the rest wait too
+			if !assigned {
+				break
+			}
+		}
+		return notifications, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notify(notifications)
+	return nil
+}
+
+// GetQueue returns the chats waiting for an agent, oldest first
+func (s *AssignmentService) GetQueue(websiteID uint) ([]models.Chat, error) {
+	var chats []models.Chat
+	if err := s.db.Where("website_id = ? AND is_active = ? AND assigned_agent_id IS NULL AND queued_at IS NOT NULL", websiteID, true).
+		Order("queued_at ASC").
+		Find(&chats).Error; err != nil {
+		return nil, err
+	}
+
+	return chats, nil
+}
+
+// GetAgentChats returns the active chats assigned to a user across websites
+func (s *AssignmentService) GetAgentChats(userID uint) ([]models.Chat, error) {
+	var chats []models.Chat
+	if err := s.db.Where("assigned_agent_id = ? AND is_active = ?", userID, true).
+		Order("assigned_at ASC").
+		Find(&chats).Error; err != nil {
+		return nil, err
+	}
+
+	return chats, nil
+}
+
+// GetPresence returns a user's presence, defaulting to offline
+func (s *AssignmentService) GetPresence(userID uint) (*models.AgentPresence, error) {
+	var presence models.AgentPresence
+	err := s.db.First(&presence, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.AgentPresence{UserID: userID, Status: models.AgentStatusOffline}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &presence, nil
+}
+
+// SetPresence updates a user's presence. Going online routes queued chats on
+// every website the user works on. Away and offline agents keep their chats
+// but receive no new ones.
+func (s *AssignmentService) SetPresence(userID uint, status string) (*models.AgentPresence, error) {
+	if !models.IsValidAgentStatus(status) {
+		return nil, errors.New("invalid agent status")
+	}
+
+	now := time.Now()
+	presence := &models.AgentPresence{
+		UserID:     userID,
+		Status:     status,
+		LastSeenAt: now,
+		UpdatedAt:  now,
+	}
+	if err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"status", "last_seen_at", "updated_at"}),
+	}).Create(presence).Error; err != nil {
+		return nil, fmt.Errorf("failed to update presence: %w", err)
+	}
+
+	s.notify([]agentNotification{{
+		userID:  userID,
+		msgType: AgentEventPresence,
+		data:    map[string]interface{}{"status": status},
+	}})
+
+	if status == models.AgentStatusOnline {
+		var websiteIDs []uint
+		if err := s.db.Model(&models.WebsiteAgent{}).Where("user_id = ?", userID).Pluck("website_id", &websiteIDs).Error; err != nil {
+			return nil, err
+		}
+		for _, websiteID := range websiteIDs {
+			if err := s.ProcessQueue(websiteID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return presence, nil
+}
+
+// HandleConsoleConnection tracks presence from agent console connections. The
+// first console brings an offline agent online; losing the last one takes the agent offline.
+func (s *AssignmentService) HandleConsoleConnection(userID uint, connected bool) error {
+	// Connection events are delivered asynchronously; trust the hub's current state
+	if s.notifier != nil && s.notifier.IsAgentConnected(userID) != connected {
+		return nil
+	}
+
+	presence, err := s.GetPresence(userID)
+	if err != nil {
+		return err
+	}
+
+	if connected && presence.Status == models.AgentStatusOffline {
+		_, err = s.SetPresence(userID, models.AgentStatusOnline)
+	} else if !connected && presence.Status != models.AgentStatusOffline {
+		_, err = s.SetPresence(userID, models.AgentStatusOffline)
+	}
+	return err
+}
+
+// GetAgents returns a website's agents with their presence and workload
+func (s *AssignmentService) GetAgents(websiteID uint) ([]models.AgentResponse, error) {
+	var agents []models.WebsiteAgent
+	if err := s.db.Preload("User").Where("website_id = ?", websiteID).Order("id ASC").Find(&agents).Error; err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]uint, len(agents))
+	for i, agent := range agents {
+		userIDs[i] = agent.UserID
+	}
+
+	counts, err := activeChatCounts(s.db, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var presences []models.AgentPresence
+	if len(userIDs) > 0 {
+		if err := s.db.Where("user_id IN ?", userIDs).Find(&presences).Error; err != nil {
+			return nil, err
+		}
+	}
+	statuses := make(map[uint]string, len(presences))
+	for _, presence := range presences {
+		statuses[presence.UserID] = presence.Status
+	}
+
+	response := make([]models.AgentResponse, len(agents))
+	for i, agent := range agents {
+		status := statuses[agent.UserID]
+		if status == "" {
+			status = models.AgentStatusOffline
+		}
+
+		response[i] = models.AgentResponse{
+			ID:                 agent.ID,
+			UserID:             agent.UserID,
+			Name:               agent.User.Name,
+			Email:              agent.User.Email,
+			Status:             status,
+			ActiveChats:        counts[agent.UserID],
+			MaxConcurrentChats: agent.MaxConcurrentChats,
+			LastAssignedAt:     agent.LastAssignedAt,
+		}
+	}
+
+	return response, nil
+}
+
+// GetAgentByID retrieves one of a website's agents
+func (s *AssignmentService) GetAgentByID(agentID, websiteID uint) (*models.WebsiteAgent, error) {
+	var agent models.WebsiteAgent
+	if err := s.db.Where("id = ? AND website_id = ?", agentID, websiteID).First(&agent).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("agent not found")
+		}
+		return nil, err
+	}
+
+	return &agent, nil
+}
+
+// AddAgent adds an existing user as an agent on a website
+func (s *AssignmentService) AddAgent(websiteID uint, req *models.AgentCreateRequest) (*models.WebsiteAgent, error) {
+	var user models.User
+	if err := s.db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&models.WebsiteAgent{}).Where("website_id = ? AND user_id = ?", websiteID, user.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("agent already exists")
+	}
+
+	agent := &models.WebsiteAgent{
+		WebsiteID:          websiteID,
+		UserID:             user.ID,
+		MaxConcurrentChats: req.MaxConcurrentChats,
+	}
+	if err := s.db.Create(agent).Error; err != nil {
+		return nil, fmt.Errorf("failed to add agent: %w", err)
+	}
+
+	// The new agent may already be online for another website
+	if err := s.ProcessQueue(websiteID); err != nil {
+		return nil, err
+	}
+
+	return agent, nil
+}
+
+// UpdateAgent changes an agent's chat cap
+func (s *AssignmentService) UpdateAgent(agentID, websiteID uint, req *models.AgentUpdateRequest) (*models.WebsiteAgent, error) {
+	agent, err := s.GetAgentByID(agentID, websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(agent).Update("max_concurrent_chats", req.MaxConcurrentChats).Error; err != nil {
+		return nil, fmt.Errorf("failed to update agent: %w", err)
+	}
+
+	if err := s.ProcessQueue(websiteID); err != nil {
+		return nil, err
+	}
+
+	return agent, nil
+}
+
+// RemoveAgent removes an agent from a website and reroutes their active chats there
+func (s *AssignmentService) RemoveAgent(agentID, websiteID uint) error {
+	agent, err := s.GetAgentByID(agentID, websiteID)
+	if err != nil {
+		return err
+	}
+
+	notifications, err := s.withRouting(func(tx *gorm.DB) ([]agentNotification, error) {
+		if err := tx.Delete(&models.WebsiteAgent{}, agent.ID).Error; err != nil {
+			return nil, err
+		}
+
+		var chats []models.Chat
+		if err := tx.Where("website_id = ? AND assigned_agent_id = ? AND is_active = ?", websiteID, agent.UserID, true).
+			Order("assigned_at ASC").
+			Find(&chats).Error; err != nil {
+			return nil, err
+		}
+
+		var notifications []agentNotification
+		for i := range chats {
+			if err := clearAssignment(tx, &chats[i]); err != nil {
+				return nil, err
+			}
+			notifications = append(notifications, agentNotification{
+				userID:  agent.UserID,
+				msgType: AgentEventChatUnassigned,
+				data:    chatNotification(&chats[i], "agent_removed"),
+			})
+
+			_, routed, err := routeChat(tx, &chats[i], agent.UserID)
+			if err != nil {
+				return nil, err
+			}
+			notifications = append(notifications, routed...)
+		}
+		return notifications, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notify(notifications)
+	return nil
+}
+
+// GetSSODomains lists the email domains whose users join a website as agents
+// when they first sign in with an identity provider
+func (s *AssignmentService) GetSSODomains(websiteID uint) ([]models.SSODomain, error) {
+	var domains []models.SSODomain
+	err := s.db.Where("website_id = ?", websiteID).Order("domain ASC").Find(&domains).Error
+	return domains, err
+}
+
+// AddSSODomain lets users at an email domain join a website as agents, once
+// the website verified the domain with VerifySSODomain
+func (s *AssignmentService) AddSSODomain(websiteID uint, domain string) (*models.SSODomain, error) {
+	var website models.Website
+	if err := s.db.First(&website, websiteID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("website not found")
+		}
+		return nil, err
+	}
+
+	domain = models.NormalizeDomain(domain)
+	if err := models.ValidateSSODomain(domain, website.Domain); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&models.SSODomain{}).Where("website_id = ? AND domain = ?", websiteID, domain).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("domain already added")
+	}
+	if err := s.checkSSODomainUnclaimed(s.db, websiteID, domain); err != nil {
+		return nil, err
+	}
+
+	ssoDomain := &models.SSODomain{WebsiteID: websiteID, Domain: domain}
+	if err := ssoDomain.GenerateVerificationToken(); err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(ssoDomain).Error; err != nil {
+		return nil, fmt.Errorf("failed to add domain: %w", err)
+	}
+	return ssoDomain, nil
+}
+
+// VerifySSODomain checks the domain's DNS TXT record proves the website
+// controls it, after which its users join the website
+func (s *AssignmentService) VerifySSODomain(ctx context.Context, domainID, websiteID uint) (*models.SSODomain, error) {
+	var ssoDomain models.SSODomain
+	if err := s.db.Where("id = ? AND website_id = ?", domainID, websiteID).First(&ssoDomain).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("domain not found")
+		}
+		return nil, err
+	}
+	if ssoDomain.VerifiedAt != nil {
+		return &ssoDomain, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	records, err := s.resolver.LookupTXT(ctx, ssoDomain.RecordName)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			log.Printf("Failed to look up %s: %v", ssoDomain.RecordName, err)
+			return nil, errors.New("failed to look up the verification record")
+		}
+	}
+	if !ssoDomain.HasVerificationRecord(records) {
+		return nil, errors.New("verification record not found")
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkSSODomainUnclaimed(tx, websiteID, ssoDomain.Domain); err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := tx.Model(&ssoDomain).Update("verified_at", now).Error; err != nil {
+			return err
+		}
+		ssoDomain.VerifiedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ssoDomain, nil
+}
+
+// checkSSODomainUnclaimed refuses domains another website verified
+func (s *AssignmentService) checkSSODomainUnclaimed(db *gorm.DB, websiteID uint, domain string) error {
+	var count int64
+	if err := db.Model(&models.SSODomain{}).
+		Where("domain = ? AND website_id <> ? AND verified_at IS NOT NULL", domain, websiteID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("domain verified by another website")
+	}
+	return nil
+}
+
+// RemoveSSODomain stops new users at an email domain joining a website. Agents
+// who already joined stay.
+func (s *AssignmentService) RemoveSSODomain(domainID, websiteID uint) error {
+	result := s.db.Where("id = ? AND website_id = ?", domainID, websiteID).Delete(&models.SSODomain{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("domain not found")
+	}
+	return nil
+}
+
+// UpdateRoutingStrategy changes how new chats are routed on a website
+func (s *AssignmentService) UpdateRoutingStrategy(websiteID uint, strategy string) error {
+	if !models.IsValidRoutingStrategy(strategy) {
+		return errors.New("invalid routing strategy")
+	}
+
+	return s.db.Model(&models.Website{}).Where("id = ?", websiteID).Update("routing_strategy", strategy).Error
+}
+
+// ValidateAgentAccess validates if a user owns a website or works on it as an agent
+func (s *AssignmentService) ValidateAgentAccess(websiteID, userID uint) error {
+	var count int64
+	if err := s.db.Model(&models.Website{}).
+		Where("id = ? AND (user_id = ? OR EXISTS (SELECT 1 FROM website_agents WHERE website_agents.website_id = websites.id AND website_agents.user_id = ?))", websiteID, userID, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return errors.New("website not found or access denied")
+	}
+
+	return nil
+}
+
+// assignTo assigns a chat to a specific agent. Transfers require the chat to
+// already have an agent.
+func (s *AssignmentService) assignTo(chatID, agentUserID uint, transfer bool) (*models.Chat, error) {
+	var chat models.Chat
+	notifications, err := s.withRouting(func(tx *gorm.DB) ([]agentNotification, error) {
+		if err := loadActiveChat(tx, chatID, &chat); err != nil {
+			return nil, err
+		}
+		if transfer && chat.AssignedAgentID == nil {
+			return nil, errors.New("chat is not assigned")
+		}
+		if chat.AssignedAgentID != nil && *chat.AssignedAgentID == agentUserID {
+			return nil, errors.New("chat is already assigned to this agent")
+		}
+
+		var agent models.WebsiteAgent
+		if err := tx.Where("website_id = ? AND user_id = ?", chat.WebsiteID, agentUserID).First(&agent).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errors.New("agent not found")
+			}
+			return nil, err
+		}
+
+		var presence models.AgentPresence
+		if err := tx.Where("user_id = ?", agentUserID).Limit(1).Find(&presence).Error; err != nil {
+			return nil, err
+		}
+		if presence.Status != models.AgentStatusOnline && presence.Status != models.AgentStatusAway {
+			return nil, errors.New("agent is offline")
+		}
+
+		counts, err := activeChatCounts(tx, []uint{agentUserID})
+		if err != nil {
+			return nil, err
+		}
+		if counts[agentUserID] >= int64(agent.MaxConcurrentChats) {
+			return nil, errors.New("agent is at capacity")
+		}
+
+		var notifications []agentNotification
+		if chat.AssignedAgentID != nil {
+			reason := "reassigned"
+			if transfer {
+				reason = "transferred"
+			}
+			notifications = append(notifications, agentNotification{
+				userID:  *chat.AssignedAgentID,
+				msgType: AgentEventChatUnassigned,
+				data:    chatNotification(&chat, reason),
+			})
+		}
+
+		if err := assignChat(tx, &chat, &agent); err != nil {
+			return nil, err
+		}
+		return append(notifications, agentNotification{
+			userID:  agentUserID,
+			msgType: AgentEventChatAssigned,
+			data:    chatNotification(&chat, "manual"),
+		}), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notify(notifications)
+	return &chat, nil
+}
+
+// withRouting runs fn in a transaction while holding the routing lock
+func (s *AssignmentService) withRouting(fn func(tx *gorm.DB) ([]agentNotification, error)) ([]agentNotification, error) {
+	assignmentMu.Lock()
+	defer assignmentMu.Unlock()
+
+	var notifications []agentNotification
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		notifications, err = fn(tx)
+		return err
+	})
+	return notifications, err
+}
+
+// notify delivers notifications to agent consoles
+func (s *AssignmentService) notify(notifications []agentNotification) {
+	if s.notifier == nil {
+		return
+	}
+	for _, n := range notifications {
+		s.notifier.SendToAgent(n.userID, n.msgType, n.data)
+	}
+}
+
+// routeChat picks an agent for an unassigned chat, excluding one user, and
+// queues the chat when nobody is available
+func routeChat(tx *gorm.DB, chat *models.Chat, exclude uint) (bool, []agentNotification, error) {
+	var website models.Website
+	if err := tx.Select("id", "routing_strategy").First(&website, chat.WebsiteID).Error; err != nil {
+		return false, nil, err
+	}
+
+	candidates, err := availableAgents(tx, chat.WebsiteID, exclude)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if len(candidates) == 0 {
+		if chat.QueuedAt != nil {
+			return false, nil, nil
+		}
+
+		now := time.Now()
+		if err := tx.Model(chat).Updates(map[string]interface{}{"queued_at": &now}).Error; err != nil {
+			return false, nil, err
+		}
+		chat.QueuedAt = &now
+
+		// Every agent on the website hears about new queue entries
+		var userIDs []uint
+		if err := tx.Model(&models.WebsiteAgent{}).Where("website_id = ?", chat.WebsiteID).Pluck("user_id", &userIDs).Error; err != nil {
+			return false, nil, err
+		}
+		notifications := make([]agentNotification, len(userIDs))
+		for i, userID := range userIDs {
+			notifications[i] = agentNotification{
+				userID:  userID,
+				msgType: AgentEventChatQueued,
+				data:    chatNotification(chat, "no_agent_available"),
+			}
+		}
+		return false, notifications, nil
+	}
+
+	chosen, err := pickAgent(tx, chat, website.RoutingStrategy, candidates)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if err := assignChat(tx, chat, &chosen.agent); err != nil {
+		return false, nil, err
+	}
+
+	return true, []agentNotification{{
+		userID:  chosen.agent.UserID,
+		msgType: AgentEventChatAssigned,
+		data:    chatNotification(chat, website.RoutingStrategy),
+	}}, nil
+}
+
+// pickAgent applies a routing strategy to a non-empty candidate list
+func pickAgent(tx *gorm.DB, chat *models.Chat, strategy string, candidates []agentLoad) (*agentLoad, error) {
+	if strategy == models.RoutingSticky && chat.VisitorID != "" {
+		var previous models.Chat
+		err := tx.Where("website_id = ? AND visitor_id = ? AND id <> ? AND assigned_agent_id IS NOT NULL", chat.WebsiteID, chat.VisitorID, chat.ID).
+			Order("assigned_at DESC").
+			Limit(1).
+			Find(&previous).Error
+		if err != nil {
+			return nil, err
+		}
+
+		if previous.AssignedAgentID != nil {
+			for i := range candidates {
+				if candidates[i].agent.UserID == *previous.AssignedAgentID {
+					return &candidates[i], nil
+				}
+			}
+		}
+	}
+
+	// Agents never assigned before go first, then the longest idle
+	assignedBefore := func(a, b *agentLoad) bool {
+		switch {
+		case a.agent.LastAssignedAt == nil && b.agent.LastAssignedAt == nil:
+			return a.agent.ID < b.agent.ID
+		case a.agent.LastAssignedAt == nil:
+			return true
+		case b.agent.LastAssignedAt == nil:
+			return false
+		case !a.agent.LastAssignedAt.Equal(*b.agent.LastAssignedAt):
+			return a.agent.LastAssignedAt.Before(*b.agent.LastAssignedAt)
+		default:
+			return a.agent.ID < b.agent.ID
+		}
+	}
+
+	if strategy == models.RoutingRoundRobin {
+		sort.Slice(candidates, func(i, j int) bool {
+			return assignedBefore(&candidates[i], &candidates[j])
+		})
+	} else {
+		// Least busy, which is also the fallback for sticky routing
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].active != candidates[j].active {
+				return candidates[i].active < candidates[j].active
+			}
+			return assignedBefore(&candidates[i], &candidates[j])
+		})
+	}
+
+	return &candidates[0], nil
+}
+
+// availableAgents returns a website's online agents who are below their chat cap
+func availableAgents(tx *gorm.DB, websiteID, exclude uint) ([]agentLoad, error) {
+	var agents []models.WebsiteAgent
+	if err := tx.Select("website_agents.*").
+		Joins("JOIN agent_presences ON agent_presences.user_id = website_agents.user_id").
+		Where("website_agents.website_id = ? AND agent_presences.status = ? AND website_agents.user_id <> ?", websiteID, models.AgentStatusOnline, exclude).
+		Find(&agents).Error; err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]uint, len(agents))
+	for i, agent := range agents {
+		userIDs[i] = agent.UserID
+	}
+
+	counts, err := activeChatCounts(tx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []agentLoad
+	for _, agent := range agents {
+		if counts[agent.UserID] < int64(agent.MaxConcurrentChats) {
+			candidates = append(candidates, agentLoad{agent: agent, active: counts[agent.UserID]})
+		}
+	}
+	return candidates, nil
+}
+
+// activeChatCounts counts active chats per agent across all websites, since an
+// agent's cap applies to their whole workload
+func activeChatCounts(tx *gorm.DB, userIDs []uint) (map[uint]int64, error) {
+	counts := make(map[uint]int64, len(userIDs))
+	if len(userIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		AssignedAgentID uint
+		Count           int64
+	}
+	if err := tx.Model(&models.Chat{}).
+		Select("assigned_agent_id, COUNT(*) AS count").
+		Where("assigned_agent_id IN ? AND is_active = ?", userIDs, true).
+		Group("assigned_agent_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		counts[row.AssignedAgentID] = row.Count
+	}
+	return counts, nil
+}
+
+// loadActiveChat loads a chat that can still be routed
+func loadActiveChat(tx *gorm.DB, chatID uint, chat *models.Chat) error {
+	if err := tx.First(chat, chatID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("chat not found")
+		}
+		return err
+	}
+
+	if !chat.IsActive {
+		return errors.New("chat is not active")
+	}
+	return nil
+}
+
+// assignChat records an assignment on the chat and the agent
+func assignChat(tx *gorm.DB, chat *models.Chat, agent *models.WebsiteAgent) error {
+	now := time.Now()
+	if err := tx.Model(chat).Updates(map[string]interface{}{
+		"assigned_agent_id": agent.UserID,
+		"assigned_at":       &now,
+		"queued_at":         nil,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to assign chat: %w", err)
+	}
+
+	if err := tx.Model(agent).Update("last_assigned_at", &now).Error; err != nil {
+		return err
+	}
+
+	userID := agent.UserID
+	chat.AssignedAgentID = &userID
+	chat.AssignedAt = &now
+	chat.QueuedAt = nil
+	agent.LastAssignedAt = &now
+	return nil
+}
+
+// clearAssignment removes a chat's agent
+func clearAssignment(tx *gorm.DB, chat *models.Chat) error {
+	if err := tx.Model(chat).Updates(map[string]interface{}{
+		"assigned_agent_id": nil,
+		"assigned_at":       nil,
+	}).Error; err != nil {
+		return err
+	}
+
+	chat.AssignedAgentID = nil
+	chat.AssignedAt = nil
+	return nil
+}
+
+// chatNotification is the chat summary sent to agent consoles
+func chatNotification(chat *models.Chat, reason string) map[string]interface{} {
+	return map[string]interface{}{
+		"chat_id":           chat.ID,
+		"website_id":        chat.WebsiteID,
+		"session_id":        chat.SessionID,
+		"visitor_id":        chat.VisitorID,
+		"assigned_agent_id": chat.AssignedAgentID,
+		"queued_at":         chat.QueuedAt,
+		"reason":            reason,
+	}
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type recordedNotification struct {
+	userID  uint
+	msgType string
+}
+
+type fakeNotifier struct {
+	sent []recordedNotification
+}
+
+func (n *fakeNotifier) SendToAgent(userID uint, msgType string, data interface{}) {
+	n.sent = append(n.sent, recordedNotification{userID: userID, msgType: msgType})
+}
+
+func (n *fakeNotifier) IsAgentConnected(userID uint) bool {
+	return false
+}
+
+func (n *fakeNotifier) count(userID uint, msgType string) int {
+	total := 0
+	for _, sent := range n.sent {
+		if sent.userID == userID && sent.msgType == msgType {
+			total++
+		}
+	}
+	return total
+}
+
+func setupAssignmentTest(t *testing.T) (*gorm.DB, *models.Website, *AssignmentService, *fakeNotifier) {
+	t.Helper()
+
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.WebsiteAgent{}, &models.AgentPresence{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	notifier := &fakeNotifier{}
+	return db, website, NewAssignmentService(db, &config.Config{}, notifier), notifier
+}
+
+func createTestAgent(t *testing.T, db *gorm.DB, service *AssignmentService, websiteID uint, name string, maxChats int) uint {
+	t.Helper()
+
+	user := &models.User{
+		Email:    name + "@example.com",
+		Password: "$2a$10$abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ01",
+		Name:     name,
+	}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	if _, err := service.AddAgent(websiteID, &models.AgentCreateRequest{Email: user.Email, MaxConcurrentChats: maxChats}); err != nil {
+		t.Fatalf("AddAgent() error = %v", err)
+	}
+	return user.ID
+}
+
+func createTestChat(t *testing.T, db *gorm.DB, websiteID uint, visitorID string) *models.Chat {
+	t.Helper()
+
+	chat := &models.Chat{
+		WebsiteID: websiteID,
+		SessionID: fmt.Sprintf("session-%d", time.Now().UnixNano()),
+		VisitorID: visitorID,
+		IsActive:  true,
+		StartedAt: time.Now(),
+	}
+	if err := db.Create(chat).Error; err != nil {
+		t.Fatalf("failed to create chat: %v", err)
+	}
+	return chat
+}
+
+func assignedTo(t *testing.T, chat *models.Chat) uint {
+	t.Helper()
+	if chat.AssignedAgentID == nil {
+		return 0
+	}
+	return *chat.AssignedAgentID
+}
+
+func TestAssignmentService_QueueAndCapacity(t *testing.T) {
+	db, website, service, notifier := setupAssignmentTest(t)
+	alice := createTestAgent(t, db, service, website.ID, "alice", 1)
+	bob := createTestAgent(t, db, service, website.ID, "bob", 2)
+
+	// Nobody is online, so the chat waits in the queue
+	chat1 := createTestChat(t, db, website.ID, "")
+	routed, err := service.RouteChat(chat1.ID)
+	if err != nil {
+		t.Fatalf("RouteChat() error = %v", err)
+	}
+	if routed.AssignedAgentID != nil || routed.QueuedAt == nil {
+		t.Fatalf("expected chat to be queued, got agent %v", routed.AssignedAgentID)
+	}
+	if notifier.count(alice, AgentEventChatQueued) != 1 || notifier.count(bob, AgentEventChatQueued) != 1 {
+		t.Errorf("expected both agents to hear about the queued chat, got %+v", notifier.sent)
+	}
+
+	// Going online drains the queue
+	if _, err := service.SetPresence(alice, models.AgentStatusOnline); err != nil {
+		t.Fatalf("SetPresence() error = %v", err)
+	}
+	queue, err := service.GetQueue(website.ID)
+	if err != nil {
+		t.Fatalf("GetQueue() error = %v", err)
+	}
+	if len(queue) != 0 {
+		t.Fatalf("expected empty queue, got %d chats", len(queue))
+	}
+	if notifier.count(alice, AgentEventChatAssigned) != 1 {
+		t.Errorf("expected alice to be notified of the assignment")
+	}
+
+	// Alice is at her cap of one, so the next chat queues again
+	chat2 := createTestChat(t, db, website.ID, "")
+	if routed, err = service.RouteChat(chat2.ID); err != nil {
+		t.Fatalf("RouteChat() error = %v", err)
+	}
+	if routed.QueuedAt == nil {
+		t.Fatalf("expected chat to be queued while alice is at capacity")
+	}
+	if _, err := service.AssignChat(chat2.ID, alice); err == nil || err.Error() != "agent is at capacity" {
+		t.Errorf("AssignChat() error = %v, want agent is at capacity", err)
+	}
+
+	// Away agents keep their chats but get no new ones
+	if _, err := service.SetPresence(bob, models.AgentStatusAway); err != nil {
+		t.Fatalf("SetPresence() error = %v", err)
+	}
+	if queue, _ = service.GetQueue(website.ID); len(queue) != 1 {
+		t.Fatalf("expected chat to stay queued while bob is away, got %d", len(queue))
+	}
+
+	// Ending alice's chat frees her slot for the queued one
+	if err := NewChatService(db, &config.Config{}).EndChat(chat1.ID); err != nil {
+		t.Fatalf("EndChat() error = %v", err)
+	}
+	if err := service.ReleaseChat(chat1.ID); err != nil {
+		t.Fatalf("ReleaseChat() error = %v", err)
+	}
+	chats, err := service.GetAgentChats(alice)
+	if err != nil {
+		t.Fatalf("GetAgentChats() error = %v", err)
+	}
+	if len(chats) != 1 || chats[0].ID != chat2.ID {
+		t.Fatalf("expected alice to pick up chat %d, got %+v", chat2.ID, chats)
+	}
+
+	agents, err := service.GetAgents(website.ID)
+	if err != nil {
+		t.Fatalf("GetAgents() error = %v", err)
+	}
+	if len(agents) != 2 || agents[0].ActiveChats != 1 || agents[0].Status != models.AgentStatusOnline || agents[1].Status != models.AgentStatusAway {
+		t.Errorf("unexpected agents %+v", agents)
+	}
+}
+
+func TestAssignmentService_RoutingStrategies(t *testing.T) {
+	db, website, service, _ := setupAssignmentTest(t)
+	alice := createTestAgent(t, db, service, website.ID, "alice", 5)
+	bob := createTestAgent(t, db, service, website.ID, "bob", 5)
+	for _, userID := range []uint{alice, bob} {
+		if _, err := service.SetPresence(userID, models.AgentStatusOnline); err != nil {
+			t.Fatalf("SetPresence() error = %v", err)
+		}
+	}
+
+	// Round robin alternates between agents
+	var order []uint
+	for i := 0; i < 4; i++ {
+		chat, err := service.RouteChat(createTestChat(t, db, website.ID, "").ID)
+		if err != nil {
+			t.Fatalf("RouteChat() error = %v", err)
+		}
+		order = append(order, assignedTo(t, chat))
+	}
+	if order[0] == order[1] || order[0] != order[2] || order[1] != order[3] {
+		t.Errorf("expected round robin order, got %v", order)
+	}
+
+	// Least busy picks bob once alice has more chats
+	if err := service.UpdateRoutingStrategy(website.ID, models.RoutingLeastBusy); err != nil {
+		t.Fatalf("UpdateRoutingStrategy() error = %v", err)
+	}
+	if _, err := service.AssignChat(createTestChat(t, db, website.ID, "").ID, alice); err != nil {
+		t.Fatalf("AssignChat() error = %v", err)
+	}
+	chat, err := service.RouteChat(createTestChat(t, db, website.ID, "").ID)
+	if err != nil {
+		t.Fatalf("RouteChat() error = %v", err)
+	}
+	if assignedTo(t, chat) != bob {
+		t.Errorf("least busy routed to %d, want bob (%d)", assignedTo(t, chat), bob)
+	}
+
+	// Sticky routing returns a visitor to the agent they spoke to last,
+	// even though alice is busier
+	if err := service.UpdateRoutingStrategy(website.ID, models.RoutingSticky); err != nil {
+		t.Fatalf("UpdateRoutingStrategy() error = %v", err)
+	}
+	if _, err := service.AssignChat(createTestChat(t, db, website.ID, "returning").ID, alice); err != nil {
+		t.Fatalf("AssignChat() error = %v", err)
+	}
+	chat, err = service.RouteChat(createTestChat(t, db, website.ID, "returning").ID)
+	if err != nil {
+		t.Fatalf("RouteChat() error = %v", err)
+	}
+	if assignedTo(t, chat) != alice {
+		t.Errorf("sticky routed to %d, want alice (%d)", assignedTo(t, chat), alice)
+	}
+
+	// New visitors fall back to least busy
+	chat, err = service.RouteChat(createTestChat(t, db, website.ID, "new").ID)
+	if err != nil {
+		t.Fatalf("RouteChat() error = %v", err)
+	}
+	if assignedTo(t, chat) != bob {
+		t.Errorf("sticky fallback routed to %d, want bob (%d)", assignedTo(t, chat), bob)
+	}
+}
+
+func TestAssignmentService_TransferAndUnassign(t *testing.T) {
+	db, website, service, notifier := setupAssignmentTest(t)
+	alice := createTestAgent(t, db, service, website.ID, "alice", 5)
+	bob := createTestAgent(t, db, service, website.ID, "bob", 5)
+	if _, err := service.SetPresence(alice, models.AgentStatusOnline); err != nil {
+		t.Fatalf("SetPresence() error = %v", err)
+	}
+
+	chat, err := service.RouteChat(createTestChat(t, db, website.ID, "").ID)
+	if err != nil {
+		t.Fatalf("RouteChat() error = %v", err)
+	}
+	if assignedTo(t, chat) != alice {
+		t.Fatalf("expected alice to get the chat")
+	}
+
+	if _, err := service.TransferChat(chat.ID, bob); err == nil || err.Error() != "agent is offline" {
+		t.Errorf("TransferChat() error = %v, want agent is offline", err)
+	}
+	if _, err := service.TransferChat(chat.ID, 9999); err == nil || err.Error() != "agent not found" {
+		t.Errorf("TransferChat() error = %v, want agent not found", err)
+	}
+
+	if _, err := service.SetPresence(bob, models.AgentStatusOnline); err != nil {
+		t.Fatalf("SetPresence() error = %v", err)
+	}
+	if chat, err = service.TransferChat(chat.ID, bob); err != nil {
+		t.Fatalf("TransferChat() error = %v", err)
+	}
+	if assignedTo(t, chat) != bob {
+		t.Errorf("expected chat to move to bob")
+	}
+	if notifier.count(alice, AgentEventChatUnassigned) != 1 || notifier.count(bob, AgentEventChatAssigned) != 1 {
+		t.Errorf("unexpected notifications %+v", notifier.sent)
+	}
+
+	// Unassigning hands the chat to someone else
+	if chat, err = service.UnassignChat(chat.ID); err != nil {
+		t.Fatalf("UnassignChat() error = %v", err)
+	}
+	if assignedTo(t, chat) != alice {
+		t.Errorf("expected unassigned chat to go back to alice, got %d", assignedTo(t, chat))
+	}
+
+	// Removing alice queues her chats when nobody else can take them
+	if _, err := service.SetPresence(bob, models.AgentStatusOffline); err != nil {
+		t.Fatalf("SetPresence() error = %v", err)
+	}
+	agents, err := service.GetAgents(website.ID)
+	if err != nil {
+		t.Fatalf("GetAgents() error = %v", err)
+	}
+	if err := service.RemoveAgent(agents[0].ID, website.ID); err != nil {
+		t.Fatalf("RemoveAgent() error = %v", err)
+	}
+	queue, err := service.GetQueue(website.ID)
+	if err != nil {
+		t.Fatalf("GetQueue() error = %v", err)
+	}
+	if len(queue) != 1 || queue[0].ID != chat.ID {
+		t.Errorf("expected removed agent's chat in the queue, got %+v", queue)
+	}
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registers the GIF decoder for thumbnails
+	"image/jpeg"
+	_ "image/png" // registers the PNG decoder for thumbnails
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/scanner"
+	"chatelly-backend/pkg/storage"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// thumbnailSize bounds the longer side of image thumbnails
+	thumbnailSize = 256
+
+	// maxThumbnailPixels skips thumbnails for images that would take too much
+	// memory to decode
+	maxThumbnailPixels = 40_000_000
+
+	// attachmentURLExpiry is how long signed download links stay valid
+	attachmentURLExpiry = 15 * time.Minute
+)
+
+// thumbnailTypes are the image types thumbnails can be made for
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// VisitorNotifier pushes messages to a widget visitor's open connections
+type VisitorNotifier interface {
+	SendToSession(sessionID string, msgType string, data interface{})
+}
+
+// AttachmentUpload is a file sent to a chat by a visitor or an agent
+type AttachmentUpload struct {
+	Filename     string
+	Body         io.Reader
+	Caption      string // optional message sent with the file
+	UploaderType string // 'visitor' or 'agent'
+	UploadedBy   *uint  // the agent, for agent uploads
+}
+
+// AttachmentService handles chat file uploads and downloads
+type AttachmentService struct {
+	db       *gorm.DB
+	cfg      *config.Config
+	storage  storage.Storage
+	scanner  scanner.Scanner
+	notifier VisitorNotifier
+}
+
+// NewAttachmentService creates a new AttachmentService. The notifier may be nil.
+func NewAttachmentService(db *gorm.DB, cfg *config.Config, store storage.Storage, notifier VisitorNotifier) *AttachmentService {
+	return &AttachmentService{
+		db:       db,
+		cfg:      cfg,
+		storage:  store,
+		scanner:  scanner.New(cfg),
+		notifier: notifier,
+	}
+}
+
+// SetScanner replaces the virus scanner; nil turns scanning off
+func (s *AttachmentService) SetScanner(scan scanner.Scanner) {
+	s.scanner = scan
+}
+
+// Upload checks a file against the website owner's plan, scans it, stores it
+// with a thumbnail for images and sends it to the chat as a message
+func (s *AttachmentService) Upload(ctx context.Context, chatID uint, upload *AttachmentUpload) (*models.Message, error) {
+	var chat models.Chat
+	if err := s.db.Preload("Website.User").First(&chat, chatID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("chat not found")
+		}
+		return nil, err
+	}
+	if !chat.IsActive {
+		return nil, errors.New("chat is not active")
+	}
+
+	if len(upload.Caption) > models.MaxAttachmentCaptionLength {
+		return nil, errors.New("message is too long")
+	}
+
+	// Read one byte past the limit to tell a full file from a truncated one
+	limits := chat.Website.User.GetPlanLimits()
+	data, err := io.ReadAll(io.LimitReader(upload.Body, limits.MaxAttachmentSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("file is empty")
+	}
+	if int64(len(data)) > limits.MaxAttachmentSize {
+		return nil, fmt.Errorf("file is too large (max %d MB)", limits.MaxAttachmentSize>>20)
+	}
+
+	// The client's content type is ignored; the file is identified by its content
+	filename := models.SanitizeAttachmentFilename(upload.Filename)
+	contentType := models.AttachmentContentType(http.DetectContentType(data), filename)
+	if !limits.AllowsAttachmentType(contentType) {
+		return nil, fmt.Errorf("file type %s is not allowed", contentType)
+	}
+
+	scanStatus := models.ScanStatusUnscanned
+	if s.scanner != nil {
+		result, err := s.scanner.Scan(ctx, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file: %w", err)
+		}
+		if result.Infected {
+			log.Printf("Rejected infected upload to chat %d: %s", chat.ID, result.Signature)
+			return nil, errors.New("file is infected")
+		}
+		scanStatus = models.ScanStatusClean
+	}
+
+	key, err := randomKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate storage key: %w", err)
+	}
+
+	digest := sha256.Sum256(data)
+	attachment := models.Attachment{
+		WebsiteID:    chat.WebsiteID,
+		ChatID:       chat.ID,
+		UploaderType: upload.UploaderType,
+		UploadedBy:   upload.UploadedBy,
+		Filename:     filename,
+		ContentType:  contentType,
+		Size:         int64(len(data)),
+		SHA256:       hex.EncodeToString(digest[:]),
+		StorageKey:   fmt.Sprintf("attachments/%d/%d/%s", chat.WebsiteID, chat.ID, key),
+		ScanStatus:   scanStatus,
+	}
+
+	if err := s.storage.Put(ctx, attachment.StorageKey, bytes.NewReader(data), attachment.Size, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store file: %w", err)
+	}
+
+	// A missing thumbnail only costs the preview, so failures are logged
+	if thumbnailTypes[contentType] {
+		thumbnail, width, height, err := makeThumbnail(data)
+		attachment.Width, attachment.Height = width, height
+		if err != nil {
+			log.Printf("Failed to make thumbnail for chat %d upload: %v", chat.ID, err)
+		} else if thumbnail != nil {
+			key := attachment.StorageKey + "_thumb.jpg"
+			if err := s.storage.Put(ctx, key, bytes.NewReader(thumbnail), int64(len(thumbnail)), "image/jpeg"); err != nil {
+				log.Printf("Failed to store thumbnail for chat %d upload: %v", chat.ID, err)
+			} else {
+				attachment.ThumbnailKey = key
+			}
+		}
+	}
+	attachment.HasThumbnail = attachment.ThumbnailKey != ""
+
+	content := strings.TrimSpace(upload.Caption)
+	if content == "" {
+		content = filename
+	}
+	sender := models.SenderUser
+	if upload.UploaderType == models.UploaderAgent {
+		sender = models.SenderAgent
+	}
+	message := &models.Message{
+		ChatID:          chat.ID,
+		Content:         content,
+		OriginalContent: content,
+		Sender:          sender,
+		SenderID:        upload.UploadedBy,
+		Language:        chat.Language,
+		Timestamp:       time.Now(),
+		Attachments:     []models.Attachment{attachment},
+	}
+	if err := NewChatService(s.db, s.cfg).saveMessage(message); err != nil {
+		s.deleteObjects(ctx, &attachment)
+		return nil, err
+	}
+
+	if upload.UploaderType == models.UploaderVisitor {
+		eventData := models.AnalyticsData{
+			"chat_id":      chat.ID,
+			"content_type": contentType,
+			"size":         attachment.Size,
+		}
+		if err := NewAnalyticsService(s.db, s.cfg).TrackEvent(chat.WebsiteID, models.EventTypeFileUpload, eventData, chat.VisitorID, chat.SessionID, chat.UserAgent, "", ""); err != nil {
+			log.Printf("Failed to track upload for chat %d: %v", chat.ID, err)
+		}
+	} else if s.notifier != nil {
+		s.notifier.SendToSession(chat.SessionID, "message_received", map[string]interface{}{
+			"id":          message.ID,
+			"content":     message.Content,
+			"sender":      message.Sender,
+			"timestamp":   message.Timestamp.Unix(),
+			"attachments": message.Attachments,
+		})
+	}
+
+	return message, nil
+}
+
+// GetAttachment retrieves an attachment of a chat
+func (s *AttachmentService) GetAttachment(chatID, attachmentID uint) (*models.Attachment, error) {
+	var attachment models.Attachment
+	if err := s.db.Where("id = ? AND chat_id = ?", attachmentID, chatID).First(&attachment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("attachment not found")
+		}
+		return nil, err
+	}
+
+	return &attachment, nil
+}
+
+// GetVisitorAttachment retrieves an attachment from a visitor's own chat
+func (s *AttachmentService) GetVisitorAttachment(websiteID uint, sessionID string, attachmentID uint) (*models.Attachment, error) {
+	var attachment models.Attachment
+	if err := s.db.Joins("JOIN chats ON chats.id = attachments.chat_id").
+		Where("attachments.id = ? AND chats.website_id = ? AND chats.session_id = ?", attachmentID, websiteID, sessionID).
+		First(&attachment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("attachment not found")
+		}
+		return nil, err
+	}
+
+	return &attachment, nil
+}
+
+// OpenAttachment returns a redirect URL for an attachment or its thumbnail,
+// or a reader when the storage backend cannot sign URLs
+func (s *AttachmentService) OpenAttachment(ctx context.Context, attachment *models.Attachment, thumbnail bool) (string, io.ReadCloser, error) {
+	key := attachment.StorageKey
+	if thumbnail {
+		if attachment.ThumbnailKey == "" {
+			return "", nil, errors.New("thumbnail not found")
+		}
+		key = attachment.ThumbnailKey
+	}
+
+	url, err := s.storage.URL(ctx, key, attachmentURLExpiry)
+	if err != nil {
+		return "", nil, err
+	}
+	if url != "" {
+		return url, nil, nil
+	}
+
+	body, err := s.storage.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", nil, errors.New("attachment not found")
+		}
+		return "", nil, err
+	}
+
+	return "", body, nil
+}
+
+// deleteObjects removes an attachment's stored files
+func (s *AttachmentService) deleteObjects(ctx context.Context, attachment *models.Attachment) {
+	for _, key := range []string{attachment.StorageKey, attachment.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := s.storage.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete attachment object %s: %v", key, err)
+		}
+	}
+}
+
+// randomKey returns an unguessable storage key component
+func randomKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// makeThumbnail scales an image to fit thumbnailSize and encodes it as JPEG.
+// It returns the original dimensions; the thumbnail is nil for images too
+// large to decode safely.
+func makeThumbnail(data []byte) ([]byte, int, int, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, cfg.Width, cfg.Height, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, cfg.Width, cfg.Height, err
+	}
+
+	width, height := cfg.Width, cfg.Height
+	if width > thumbnailSize || height > thumbnailSize {
+		if width >= height {
+			height = max(1, height*thumbnailSize/width)
+			width = thumbnailSize
+		} else {
+			width = max(1, width*thumbnailSize/height)
+			height = thumbnailSize
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleImage(src, width, height), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, cfg.Width, cfg.Height, err
+	}
+
+	return buf.Bytes(), cfg.Width, cfg.Height, nil
+}
+
+// scaleImage resizes an image by averaging a grid of samples per pixel.
+// Transparent areas are flattened onto white since JPEG has no alpha.
+func scaleImage(src image.Image, width, height int) *image.RGBA {
+	const samples = 4
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var r, g, b uint64
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					px := bounds.Min.X + (x*samples+sx)*bounds.Dx()/(width*samples)
+					py := bounds.Min.Y + (y*samples+sy)*bounds.Dy()/(height*samples)
+					cr, cg, cb, ca := src.At(px, py).RGBA()
+					r += uint64(cr + 0xffff - ca)
+					g += uint64(cg + 0xffff - ca)
+					b += uint64(cb + 0xffff - ca)
+				}
+			}
+			n := uint64(samples * samples * 0x101)
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: 0xff})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/scanner"
+	"chatelly-backend/pkg/storage"
+)
+
+type fakeScanner struct {
+	infected bool
+}
+
+func (f *fakeScanner) Scan(ctx context.Context, r io.Reader) (scanner.Result, error) {
+	if f.infected {
+		return scanner.Result{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return scanner.Result{}, nil
+}
+
+type fakeVisitorNotifier struct {
+	sessions []string
+}
+
+func (f *fakeVisitorNotifier) SendToSession(sessionID string, msgType string, data interface{}) {
+	f.sessions = append(f.sessions, sessionID)
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestAttachmentService_Upload(t *testing.T) {
+	db := setupTestDB(t)
+	website := createTestWebsite(t, db)
+	chat := &models.Chat{WebsiteID: website.ID, SessionID: "session-1", IsActive: true}
+	if err := db.Create(chat).Error; err != nil {
+		t.Fatalf("failed to create chat: %v", err)
+	}
+
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	notifier := &fakeVisitorNotifier{}
+	service := NewAttachmentService(db, &config.Config{}, store, notifier)
+	ctx := context.Background()
+
+	message, err := service.Upload(ctx, chat.ID, &AttachmentUpload{
+		Filename:     "../screenshot.png",
+		Body:         bytes.NewReader(testPNG(t, 600, 300)),
+		UploaderType: models.UploaderVisitor,
+	})
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if message.Sender != models.SenderUser || message.Content != "screenshot.png" || len(message.Attachments) != 1 {
+		t.Fatalf("unexpected message %+v", message)
+	}
+	attachment := message.Attachments[0]
+	if attachment.ContentType != "image/png" || attachment.Width != 600 || attachment.Height != 300 || !attachment.HasThumbnail {
+		t.Errorf("unexpected attachment %+v", attachment)
+	}
+	if attachment.ScanStatus != models.ScanStatusUnscanned {
+		t.Errorf("ScanStatus = %s, want %s", attachment.ScanStatus, models.ScanStatusUnscanned)
+	}
+
+	// Thumbnails fit in 256px and keep the aspect ratio
+	_, body, err := service.OpenAttachment(ctx, &attachment, true)
+	if err != nil {
+		t.Fatalf("OpenAttachment() error = %v", err)
+	}
+	thumbnail, format, err := image.DecodeConfig(body)
+	body.Close()
+	if err != nil || format != "jpeg" || thumbnail.Width != 256 || thumbnail.Height != 128 {
+		t.Errorf("unexpected thumbnail %s %dx%d (%v)", format, thumbnail.Width, thumbnail.Height, err)
+	}
+
+	// History includes the attachment
+	messages, _, err := NewChatService(db, &config.Config{}).GetMessagesByChatID(chat.ID, 1, 10)
+	if err != nil {
+		t.Fatalf("GetMessagesByChatID() error = %v", err)
+	}
+	if len(messages) != 1 || len(messages[0].Attachments) != 1 || !messages[0].Attachments[0].HasThumbnail {
+		t.Errorf("expected the message with its attachment in the history, got %+v", messages)
+	}
+
+	// Visitors only see attachments from their own session
+	if _, err := service.GetVisitorAttachment(website.ID, "session-1", attachment.ID); err != nil {
+		t.Errorf("GetVisitorAttachment() error = %v", err)
+	}
+	if _, err := service.GetVisitorAttachment(website.ID, "session-2", attachment.ID); err == nil || err.Error() != "attachment not found" {
+		t.Errorf("GetVisitorAttachment() error = %v, want attachment not found", err)
+	}
+
+	// The content decides the type, not the name
+	_, err = service.Upload(ctx, chat.ID, &AttachmentUpload{
+		Filename:     "invoice.png",
+		Body:         strings.NewReader("<html><script>alert(1)</script></html>"),
+		UploaderType: models.UploaderVisitor,
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "file type text/html") {
+		t.Errorf("Upload(html) error = %v, want file type not allowed", err)
+	}
+
+	// Free plans only take images up to 1MB
+	pdf := []byte("%PDF-1.4\n1 0 obj\n<< >>\nendobj\n")
+	if _, err := service.Upload(ctx, chat.ID, &AttachmentUpload{Filename: "terms.pdf", Body: bytes.NewReader(pdf), UploaderType: models.UploaderVisitor}); err == nil || err.Error() != "file type application/pdf is not allowed" {
+		t.Errorf("Upload(pdf) error = %v, want file type not allowed", err)
+	}
+	large := append(testPNG(t, 1, 1), make([]byte, 1<<20)...)
+	if _, err := service.Upload(ctx, chat.ID, &AttachmentUpload{Filename: "large.png", Body: bytes.NewReader(large), UploaderType: models.UploaderVisitor}); err == nil || err.Error() != "file is too large (max 1 MB)" {
+		t.Errorf("Upload(large) error = %v, want file is too large", err)
+	}
+
+	if err := db.Model(&models.User{}).Where("id = ?", website.UserID).Update("plan", "starter").Error; err != nil {
+		t.Fatalf("failed to change plan: %v", err)
+	}
+	agentID := website.UserID
+	message, err = service.Upload(ctx, chat.ID, &AttachmentUpload{
+		Filename:     "terms.pdf",
+		Body:         bytes.NewReader(pdf),
+		Caption:      "Here are our terms",
+		UploaderType: models.UploaderAgent,
+		UploadedBy:   &agentID,
+	})
+	if err != nil {
+		t.Fatalf("Upload(pdf) on starter error = %v", err)
+	}
+	if message.Sender != models.SenderAgent || message.Content != "Here are our terms" || message.Attachments[0].HasThumbnail {
+		t.Errorf("unexpected agent message %+v", message)
+	}
+	if len(notifier.sessions) != 1 || notifier.sessions[0] != "session-1" {
+		t.Errorf("expected the visitor to be notified of the agent's file, got %v", notifier.sessions)
+	}
+
+	// Infected files are rejected before they are stored
+	service.SetScanner(&fakeScanner{infected: true})
+	if _, err := service.Upload(ctx, chat.ID, &AttachmentUpload{Filename: "photo.png", Body: bytes.NewReader(testPNG(t, 10, 10)), UploaderType: models.UploaderVisitor}); err == nil || err.Error() != "file is infected" {
+		t.Errorf("Upload(infected) error = %v, want file is infected", err)
+	}
+	var count int64
+	db.Model(&models.Attachment{}).Count(&count)
+	if count != 2 {
+		t.Errorf("expected 2 stored attachments, got %d", count)
+	}
+
+	service.SetScanner(&fakeScanner{})
+	message, err = service.Upload(ctx, chat.ID, &AttachmentUpload{Filename: "photo.png", Body: bytes.NewReader(testPNG(t, 10, 10)), UploaderType: models.UploaderVisitor})
+	if err != nil || message.Attachments[0].ScanStatus != models.ScanStatusClean {
+		t.Errorf("Upload(clean) error = %v, message %+v", err, message)
+	}
+
+	// Ended chats take no more files
+	if err := NewChatService(db, &config.Config{}).EndChat(chat.ID); err != nil {
+		t.Fatalf("EndChat() error = %v", err)
+	}
+	if _, err := service.Upload(ctx, chat.ID, &AttachmentUpload{Filename: "photo.png", Body: bytes.NewReader(testPNG(t, 10, 10)), UploaderType: models.UploaderVisitor}); err == nil || err.Error() != "chat is not active" {
+		t.Errorf("Upload() error = %v, want chat is not active", err)
+	}
+}
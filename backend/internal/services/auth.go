@@ -11,14 +11,17 @@ import (
 )
 
 type AuthService struct {
-	db  *gorm.DB
-	cfg *config.Config
+	db   *gorm.DB
+	cfg  *config.Config
+	keys *utils.KeySet
 }
 
-func NewAuthService(db *gorm.DB, cfg *config.Config) *AuthService {
+// NewAuthService creates a new AuthService signing tokens with keys
+func NewAuthService(db *gorm.DB, cfg *config.Config, keys *utils.KeySet) *AuthService {
 	return &AuthService{
-		db:  db,
-		cfg: cfg,
+		db:   db,
+		cfg:  cfg,
+		keys: keys,
 	}
 }
 
@@ -47,7 +50,7 @@ func (s *AuthService) RegisterUser(req *models.UserCreateRequest) (*models.User,
 		return nil, nil, err
 	}
 
-	tokens, err := utils.GenerateTokenPair(user, s.cfg)
+	tokens, err := utils.GenerateTokenPair(user, s.cfg, s.keys)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -55,31 +58,139 @@ func (s *AuthService) RegisterUser(req *models.UserCreateRequest) (*models.User,
 	return user, tokens, nil
 }
 
-func (s *AuthService) LoginUser(email, password string) (*models.User, *utils.TokenPair, error) {
+// LoginResult is the outcome of checking a user's password. When the user
+// has a second step to take, Tokens is nil and MFAToken carries them to it.
+type LoginResult struct {
+	User                  *models.User
+	Tokens                *utils.TokenPair
+	MFAToken              string
+	MFARequired           bool // verify a code with the MFA token next
+	MFAEnrollmentRequired bool // set up two-factor authentication with the MFA token next
+}
+
+func (s *AuthService) LoginUser(email, password string) (*LoginResult, error) {
 	
 	var user models.User
 	if err := s.db.Where("email = ? AND is_active = ?", email, true).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, nil, errors.New("invalid email or password")
+			return nil, errors.New("invalid email or password")
 		}
-		return nil, nil, err
+		return nil, err
 	}
 
 	if !user.CheckPassword(password) {
-		return nil, nil, errors.New("invalid email or password")
+		return nil, errors.New("invalid email or password")
+	}
+
+	return s.StartSession(&user)
+}
+
+// StartSession issues a user's tokens once they have proven who they are,
+// or the MFA token for their second step
+func (s *AuthService) StartSession(user *models.User) (*LoginResult, error) {
+	result := &LoginResult{User: user}
+	if user.MFAEnabled {
+		token, err := utils.GenerateStepToken(user.ID, utils.StepTokenMFAChallenge, MFATokenTTL, s.keys)
+		if err != nil {
+			return nil, err
+		}
+		result.MFAToken = token
+		result.MFARequired = true
+		return result, nil
 	}
 
-	tokens, err := utils.GenerateTokenPair(&user, s.cfg)
+	// Agents of websites that require two-factor authentication set it up
+	// before they get a session
+	required, err := NewMFAService(s.db, s.cfg).Required(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if required {
+		token, err := utils.GenerateStepToken(user.ID, utils.StepTokenMFAEnrollment, MFATokenTTL, s.keys)
+		if err != nil {
+			return nil, err
+		}
+		result.MFAToken = token
+		result.MFAEnrollmentRequired = true
+		return result, nil
+	}
+
+	tokens, err := utils.GenerateTokenPair(user, s.cfg, s.keys)
+	if err != nil {
+		return nil, err
+	}
+	result.Tokens = tokens
+
+	return result, nil
+}
+
+// MFATokenUser returns the user a sign-in's MFA token was issued to
+func (s *AuthService) MFATokenUser(mfaToken, tokenType string) (*models.User, error) {
+	claims, err := utils.ValidateStepToken(mfaToken, tokenType, s.keys)
+	if err != nil {
+		return nil, errors.New("invalid or expired MFA token")
+	}
+
+	return s.GetUserByID(claims.UserID)
+}
+
+// VerifyMFA completes a sign-in with a code from the user's authenticator or
+// a recovery code
+func (s *AuthService) VerifyMFA(user *models.User, code, recoveryCode string) (*utils.TokenPair, error) {
+	if code == "" && recoveryCode == "" {
+		return nil, errors.New("verification code required")
+	}
+
+	if err := NewMFAService(s.db, s.cfg).Verify(user, code, recoveryCode); err != nil {
+		return nil, err
+	}
+
+	return utils.GenerateTokenPair(user, s.cfg, s.keys)
+}
+
+// CompleteMFAEnrollment turns on two-factor authentication for a user who was
+// required to set it up while signing in, and starts their session
+func (s *AuthService) CompleteMFAEnrollment(user *models.User, code string) (*utils.TokenPair, []string, error) {
+	recoveryCodes, err := NewMFAService(s.db, s.cfg).Enable(user.ID, code)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return &user, tokens, nil
+	tokens, err := utils.GenerateTokenPair(user, s.cfg, s.keys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tokens, recoveryCodes, nil
+}
+
+// Reauthenticate checks a signed-in user's credentials again before a
+// sensitive action, and returns a token that allows such actions for a while
+func (s *AuthService) Reauthenticate(userID uint, req *models.ReauthRequest) (string, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return "", err
+	}
+
+	if !user.CheckPassword(req.Password) {
+		return "", errors.New("invalid password")
+	}
+
+	if user.MFAEnabled {
+		if req.Code == "" && req.RecoveryCode == "" {
+			return "", errors.New("verification code required")
+		}
+		if err := NewMFAService(s.db, s.cfg).Verify(user, req.Code, req.RecoveryCode); err != nil {
+			return "", err
+		}
+	}
+
+	return utils.GenerateStepToken(user.ID, utils.StepTokenReauth, ReauthTokenTTL, s.keys)
 }
 
 func (s *AuthService) RefreshTokens(refreshToken string) (*utils.TokenPair, error) {
 
-	claims, err := utils.ValidateRefreshToken(refreshToken, s.cfg)
+	claims, err := utils.ValidateRefreshToken(refreshToken, s.keys)
 	if err != nil {
 		return nil, errors.New("invalid refresh token")
 	}
@@ -92,7 +203,19 @@ func (s *AuthService) RefreshTokens(refreshToken string) (*utils.TokenPair, erro
 		return nil, err
 	}
 
-	tokens, err := utils.GenerateTokenPair(&user, s.cfg)
+	// Sessions from before a website required two-factor authentication end
+	// when their access token does, so the agent signs in and sets it up
+	if !user.MFAEnabled {
+		required, err := NewMFAService(s.db, s.cfg).Required(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			return nil, errors.New("two-factor authentication is required")
+		}
+	}
+
+	tokens, err := utils.GenerateTokenPair(&user, s.cfg, s.keys)
 	if err != nil {
 		return nil, err
 	}
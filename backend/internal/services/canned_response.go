@@ -0,0 +1,405 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// maxCannedLookupResults caps the suggestions returned to agent consoles
+const maxCannedLookupResults = 10
+
+// CannedResponseService manages the saved replies library
+type CannedResponseService struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+// NewCannedResponseService creates a new CannedResponseService
+func NewCannedResponseService(db *gorm.DB, cfg *config.Config) *CannedResponseService {
+	return &CannedResponseService{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+// visibleResponses scopes a query to the responses available on a website:
+// its own and those its owner shares across websites
+func (s *CannedResponseService) visibleResponses(websiteID uint) *gorm.DB {
+	return s.db.Model(&models.CannedResponse{}).
+		Where("canned_responses.website_id = ? OR (canned_responses.website_id IS NULL AND canned_responses.owner_id = (SELECT user_id FROM websites WHERE websites.id = ?))", websiteID, websiteID)
+}
+
+// GetResponses lists a website's canned responses, optionally filtered by
+// category or a search over shortcut, title and content. The categories in
+// use are returned as well.
+func (s *CannedResponseService) GetResponses(websiteID uint, category, query string) ([]models.CannedResponse, []string, error) {
+	dbQuery := s.visibleResponses(websiteID)
+	if category != "" {
+		dbQuery = dbQuery.Where("category = ?", category)
+	}
+	if query = strings.ToLower(strings.TrimSpace(query)); query != "" {
+		pattern := "%" + escapeLike(query) + "%"
+		dbQuery = dbQuery.Where("(LOWER(shortcut) LIKE ? ESCAPE '\\' OR LOWER(title) LIKE ? ESCAPE '\\' OR LOWER(content) LIKE ? ESCAPE '\\')", pattern, pattern, pattern)
+	}
+
+	var responses []models.CannedResponse
+	if err := dbQuery.Order("category ASC, shortcut ASC").Find(&responses).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var categories []string
+	if err := s.visibleResponses(websiteID).
+		Where("category <> ''").
+		Distinct("category").
+		Order("category ASC").
+		Pluck("category", &categories).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return responses, categories, nil
+}
+
+// GetResponse retrieves a canned response available on a website
+func (s *CannedResponseService) GetResponse(websiteID, responseID uint) (*models.CannedResponse, error) {
+	var response models.CannedResponse
+	if err := s.visibleResponses(websiteID).Where("canned_responses.id = ?", responseID).First(&response).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("canned response not found")
+		}
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// CreateResponse adds a canned response to a website, or to all of the
+// owner's websites when it is shared
+func (s *CannedResponseService) CreateResponse(websiteID, userID uint, req *models.CannedResponseRequest) (*models.CannedResponse, error) {
+	if err := models.ValidateCannedResponse(req); err != nil {
+		return nil, err
+	}
+
+	var website models.Website
+	if err := s.db.Select("id", "user_id").First(&website, websiteID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("website not found")
+		}
+		return nil, err
+	}
+
+	response := &models.CannedResponse{
+		OwnerID:   website.UserID,
+		CreatedBy: userID,
+	}
+	applyCannedRequest(response, websiteID, req)
+
+	if err := s.checkShortcut(response); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(response).Error; err != nil {
+		return nil, fmt.Errorf("failed to create canned response: %w", err)
+	}
+
+	return response, nil
+}
+
+// UpdateResponse replaces a canned response
+func (s *CannedResponseService) UpdateResponse(websiteID, responseID uint, req *models.CannedResponseRequest) (*models.CannedResponse, error) {
+	if err := models.ValidateCannedResponse(req); err != nil {
+		return nil, err
+	}
+
+	response, err := s.GetResponse(websiteID, responseID)
+	if err != nil {
+		return nil, err
+	}
+	applyCannedRequest(response, websiteID, req)
+
+	if err := s.checkShortcut(response); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(response).Select("website_id", "shortcut", "title", "category", "content", "translations", "keywords", "bot_enabled").
+		Updates(response).Error; err != nil {
+		return nil, fmt.Errorf("failed to update canned response: %w", err)
+	}
+
+	return response, nil
+}
+
+// DeleteResponse deletes a canned response. Its usage history is kept.
+func (s *CannedResponseService) DeleteResponse(websiteID, responseID uint) error {
+	response, err := s.GetResponse(websiteID, responseID)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Delete(response).Error
+}
+
+func applyCannedRequest(response *models.CannedResponse, websiteID uint, req *models.CannedResponseRequest) {
+	response.WebsiteID = &websiteID
+	if req.Shared {
+		response.WebsiteID = nil
+	}
+	response.Shortcut = req.Shortcut
+	response.Title = req.Title
+	response.Category = req.Category
+	response.Content = req.Content
+	response.Translations = req.Translations
+	response.Keywords = req.Keywords
+	response.BotEnabled = req.BotEnabled
+}
+
+// checkShortcut makes sure a shortcut picks a single response on every
+// website the response is available on
+func (s *CannedResponseService) checkShortcut(response *models.CannedResponse) error {
+	query := s.db.Model(&models.CannedResponse{}).
+		Where("owner_id = ? AND shortcut = ? AND id <> ?", response.OwnerID, response.Shortcut, response.ID)
+	if response.WebsiteID != nil {
+		query = query.Where("(website_id = ? OR website_id IS NULL)", *response.WebsiteID)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("shortcut already exists")
+	}
+
+	return nil
+}
+
+// LookupResponses suggests canned responses for an agent typing in a chat.
+// Shortcut prefix matches come first, then title matches; each is rendered
+// for the chat.
+func (s *CannedResponseService) LookupResponses(chatID, agentID uint, query string) ([]models.RenderedCannedResponse, error) {
+	chat, values, err := s.chatValues(chatID, &agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(query), "/"))
+	dbQuery := s.visibleResponses(chat.WebsiteID)
+	if query != "" {
+		pattern := escapeLike(query)
+		dbQuery = dbQuery.Where("(LOWER(shortcut) LIKE ? ESCAPE '\\' OR LOWER(title) LIKE ? ESCAPE '\\')", pattern+"%", "%"+pattern+"%")
+	}
+
+	var responses []models.CannedResponse
+	if err := dbQuery.Order("usage_count DESC, shortcut ASC").Limit(50).Find(&responses).Error; err != nil {
+		return nil, err
+	}
+
+	// Within each group the most used replies stay first
+	sort.SliceStable(responses, func(i, j int) bool {
+		return strings.HasPrefix(responses[i].Shortcut, query) && !strings.HasPrefix(responses[j].Shortcut, query)
+	})
+	if len(responses) > maxCannedLookupResults {
+		responses = responses[:maxCannedLookupResults]
+	}
+
+	rendered := make([]models.RenderedCannedResponse, 0, len(responses))
+	for i := range responses {
+		rendered = append(rendered, renderCannedResponse(&responses[i], chat.Language, values))
+	}
+
+	return rendered, nil
+}
+
+// UseResponse renders a canned response for a chat and records the use
+func (s *CannedResponseService) UseResponse(chatID, agentID, responseID uint) (*models.RenderedCannedResponse, error) {
+	chat, values, err := s.chatValues(chatID, &agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.GetResponse(chat.WebsiteID, responseID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.recordUse(response, chat, &agentID); err != nil {
+		return nil, err
+	}
+
+	rendered := renderCannedResponse(response, chat.Language, values)
+	return &rendered, nil
+}
+
+// BotReply answers a visitor message with the bot-enabled canned response
+// whose keywords match it best, when the website has bot replies turned on.
+// The reply is saved as a bot message; nil means the bot stays quiet.
+func (s *CannedResponseService) BotReply(chatID uint, content string) (*models.Message, error) {
+	chat, values, err := s.chatValues(chatID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !chat.Website.Settings.CannedBotReplies || !chat.IsActive {
+		return nil, nil
+	}
+
+	var candidates []models.CannedResponse
+	if err := s.visibleResponses(chat.WebsiteID).Where("bot_enabled = ?", true).Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	// The response matching the most keywords wins; longer keywords break ties
+	words := models.MatchWords(content)
+	var best *models.CannedResponse
+	bestMatches, bestLength := 0, 0
+	for i := range candidates {
+		matches, length := 0, 0
+		for _, keyword := range candidates[i].Keywords {
+			if models.MatchesKeyword(words, keyword) {
+				matches++
+				length += len(keyword)
+			}
+		}
+		if matches > bestMatches || (matches > 0 && matches == bestMatches && length > bestLength) {
+			best, bestMatches, bestLength = &candidates[i], matches, length
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	rendered := renderCannedResponse(best, chat.Language, values)
+	language := rendered.Language
+	if language == "" {
+		language = chat.Website.Settings.Language
+	}
+	message, err := NewChatService(s.db, s.cfg).SaveMessage(chat.ID, rendered.Content, models.SenderBot, language, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.recordUse(best, chat, nil); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// GetUsageStats summarizes how often each of a website's canned responses
+// was used in the last days, most used first
+func (s *CannedResponseService) GetUsageStats(websiteID uint, days int) ([]models.CannedResponseStats, error) {
+	since := time.Now().AddDate(0, 0, -days)
+
+	var stats []models.CannedResponseStats
+	if err := s.db.Model(&models.CannedResponseUsage{}).
+		Select(`canned_response_usages.response_id,
+			canned_responses.shortcut,
+			canned_responses.title,
+			COUNT(*) AS uses,
+			COUNT(canned_response_usages.user_id) AS agent_uses,
+			COUNT(*) - COUNT(canned_response_usages.user_id) AS bot_uses,
+			COUNT(DISTINCT canned_response_usages.user_id) AS agents`).
+		Joins("JOIN canned_responses ON canned_responses.id = canned_response_usages.response_id").
+		Where("canned_response_usages.website_id = ? AND canned_response_usages.used_at >= ?", websiteID, since).
+		Group("canned_response_usages.response_id, canned_responses.shortcut, canned_responses.title").
+		Order("uses DESC, canned_response_usages.response_id ASC").
+		Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+
+	// Scanning MAX() into a time differs between drivers, so last uses are
+	// looked up separately
+	for i := range stats {
+		var usage models.CannedResponseUsage
+		if err := s.db.Where("response_id = ? AND website_id = ?", stats[i].ResponseID, websiteID).
+			Order("used_at DESC").
+			First(&usage).Error; err == nil {
+			stats[i].LastUsedAt = &usage.UsedAt
+		}
+	}
+
+	if stats == nil {
+		stats = []models.CannedResponseStats{}
+	}
+	return stats, nil
+}
+
+// recordUse logs a use of a canned response in a chat and bumps its counters
+func (s *CannedResponseService) recordUse(response *models.CannedResponse, chat *models.Chat, userID *uint) error {
+	now := time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.CannedResponseUsage{
+			ResponseID: response.ID,
+			WebsiteID:  chat.WebsiteID,
+			ChatID:     chat.ID,
+			UserID:     userID,
+			UsedAt:     now,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to record canned response use: %w", err)
+		}
+
+		return tx.Model(&models.CannedResponse{}).Where("id = ?", response.ID).
+			UpdateColumns(map[string]interface{}{
+				"usage_count":  gorm.Expr("usage_count + 1"),
+				"last_used_at": now,
+			}).Error
+	})
+}
+
+// chatValues loads a chat and the values of the canned response variables
+// for it. The agent is nil for bot replies.
+func (s *CannedResponseService) chatValues(chatID uint, agentID *uint) (*models.Chat, map[string]string, error) {
+	var chat models.Chat
+	if err := s.db.Preload("Website").First(&chat, chatID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errors.New("chat not found")
+		}
+		return nil, nil, err
+	}
+
+	values := map[string]string{
+		models.CannedVarWebsiteName:  chat.Website.Name,
+		models.CannedVarVisitorName:  chat.PreChatData["name"],
+		models.CannedVarVisitorEmail: chat.PreChatData["email"],
+	}
+
+	// Identified contacts know the visitor better than the pre-chat form
+	if chat.ContactID != nil {
+		var contact models.Contact
+		if err := s.db.Select("id", "name", "email").First(&contact, *chat.ContactID).Error; err == nil {
+			if contact.Name != "" {
+				values[models.CannedVarVisitorName] = contact.Name
+			}
+			if contact.Email != "" {
+				values[models.CannedVarVisitorEmail] = contact.Email
+			}
+		}
+	}
+
+	if agentID != nil {
+		var agent models.User
+		if err := s.db.Select("id", "name").First(&agent, *agentID).Error; err == nil {
+			values[models.CannedVarAgentName] = agent.Name
+		}
+	}
+
+	return &chat, values, nil
+}
+
+func renderCannedResponse(response *models.CannedResponse, language string, values map[string]string) models.RenderedCannedResponse {
+	text, used := response.Localize(language)
+	return models.RenderedCannedResponse{
+		ID:       response.ID,
+		Shortcut: response.Shortcut,
+		Title:    response.Title,
+		Category: response.Category,
+		Language: used,
+		Content:  models.RenderCannedText(text, values),
+	}
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"testing"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+)
+
+func TestCannedResponseService_Library(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.CannedResponse{}, &models.CannedResponseUsage{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	other := &models.Website{UserID: website.UserID, Name: "Other", Domain: "other.example.com"}
+	if err := db.Create(other).Error; err != nil {
+		t.Fatalf("failed to create website: %v", err)
+	}
+	service := NewCannedResponseService(db, &config.Config{})
+
+	own, err := service.CreateResponse(website.ID, website.UserID, &models.CannedResponseRequest{
+		Shortcut: "/hello",
+		Title:    "Greeting",
+		Category: "General",
+		Content:  "Hi {{visitor.name|there}}!",
+	})
+	if err != nil {
+		t.Fatalf("CreateResponse() error = %v", err)
+	}
+	if own.Shortcut != "hello" || own.WebsiteID == nil || *own.WebsiteID != website.ID {
+		t.Fatalf("unexpected response %+v", own)
+	}
+
+	shared, err := service.CreateResponse(other.ID, website.UserID, &models.CannedResponseRequest{
+		Shortcut: "refund",
+		Title:    "Refund policy",
+		Category: "Billing",
+		Content:  "Refunds take 5 days.",
+		Shared:   true,
+	})
+	if err != nil {
+		t.Fatalf("CreateResponse() error = %v", err)
+	}
+	if shared.WebsiteID != nil {
+		t.Fatalf("expected a shared response, got website %d", *shared.WebsiteID)
+	}
+
+	// Shared responses show up on every website of the owner
+	responses, categories, err := service.GetResponses(website.ID, "", "")
+	if err != nil {
+		t.Fatalf("GetResponses() error = %v", err)
+	}
+	if len(responses) != 2 || len(categories) != 2 || categories[0] != "Billing" {
+		t.Errorf("expected both responses and categories, got %d responses and %v", len(responses), categories)
+	}
+	responses, _, err = service.GetResponses(other.ID, "", "")
+	if err != nil {
+		t.Fatalf("GetResponses() error = %v", err)
+	}
+	if len(responses) != 1 || responses[0].ID != shared.ID {
+		t.Errorf("expected only the shared response on the other website, got %+v", responses)
+	}
+	responses, _, err = service.GetResponses(website.ID, "", "POLICY")
+	if err != nil {
+		t.Fatalf("GetResponses() error = %v", err)
+	}
+	if len(responses) != 1 || responses[0].ID != shared.ID {
+		t.Errorf("expected the search to find the refund response, got %+v", responses)
+	}
+
+	// A website response cannot hide a shared shortcut, and vice versa
+	if _, err := service.CreateResponse(website.ID, website.UserID, &models.CannedResponseRequest{
+		Shortcut: "refund", Title: "Refunds", Content: "text",
+	}); err == nil || err.Error() != "shortcut already exists" {
+		t.Errorf("CreateResponse() error = %v, want shortcut already exists", err)
+	}
+	if _, err := service.CreateResponse(other.ID, website.UserID, &models.CannedResponseRequest{
+		Shortcut: "hello", Title: "Hello", Content: "text",
+	}); err != nil {
+		t.Errorf("expected the shortcut to be free on another website, got %v", err)
+	}
+
+	updated, err := service.UpdateResponse(website.ID, own.ID, &models.CannedResponseRequest{
+		Shortcut: "hello",
+		Title:    "Greeting",
+		Content:  "Hello {{visitor.name|there}}, I'm {{agent.name}}.",
+	})
+	if err != nil {
+		t.Fatalf("UpdateResponse() error = %v", err)
+	}
+	if updated.Category != "" {
+		t.Errorf("expected the category to be cleared, got %q", updated.Category)
+	}
+	if _, err := service.UpdateResponse(other.ID, own.ID, &models.CannedResponseRequest{
+		Shortcut: "hello", Title: "Greeting", Content: "text",
+	}); err == nil || err.Error() != "canned response not found" {
+		t.Errorf("UpdateResponse() error = %v, want canned response not found", err)
+	}
+
+	if err := service.DeleteResponse(other.ID, own.ID); err == nil || err.Error() != "canned response not found" {
+		t.Errorf("DeleteResponse() error = %v, want canned response not found", err)
+	}
+	if err := service.DeleteResponse(website.ID, own.ID); err != nil {
+		t.Fatalf("DeleteResponse() error = %v", err)
+	}
+	if _, err := service.GetResponse(website.ID, own.ID); err == nil {
+		t.Errorf("expected the deleted response to be gone")
+	}
+}
+
+func TestCannedResponseService_LookupAndUse(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.CannedResponse{}, &models.CannedResponseUsage{}, &models.Contact{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	service := NewCannedResponseService(db, &config.Config{})
+
+	chat := &models.Chat{
+		WebsiteID:   website.ID,
+		SessionID:   "s1",
+		Language:    "de-AT",
+		IsActive:    true,
+		PreChatData: models.PreChatValues{"name": "Jane"},
+	}
+	if err := db.Create(chat).Error; err != nil {
+		t.Fatalf("failed to create chat: %v", err)
+	}
+
+	for _, req := range []models.CannedResponseRequest{
+		{Shortcut: "hello", Title: "Greeting", Content: "Hi {{visitor.name|there}}, I'm {{agent.name}} from {{website.name}}.", Translations: map[string]string{"de": "Hallo {{visitor.name}}!"}},
+		{Shortcut: "bye", Title: "Say hello later", Content: "Bye!"},
+		{Shortcut: "help", Title: "Help", Content: "How can I help?"},
+	} {
+		req := req
+		if _, err := service.CreateResponse(website.ID, website.UserID, &req); err != nil {
+			t.Fatalf("CreateResponse() error = %v", err)
+		}
+	}
+
+	suggestions, err := service.LookupResponses(chat.ID, website.UserID, "/hel")
+	if err != nil {
+		t.Fatalf("LookupResponses() error = %v", err)
+	}
+	if len(suggestions) != 3 {
+		t.Fatalf("expected shortcut and title matches, got %+v", suggestions)
+	}
+	if suggestions[2].Shortcut != "bye" {
+		t.Errorf("expected the title match after the shortcut matches, got %+v", suggestions)
+	}
+
+	var hello models.RenderedCannedResponse
+	for _, suggestion := range suggestions {
+		if suggestion.Shortcut == "hello" {
+			hello = suggestion
+		}
+	}
+	if hello.Language != "de" || hello.Content != "Hallo Jane!" {
+		t.Errorf("expected the German translation rendered for the visitor, got %+v", hello)
+	}
+
+	// An identified contact's name takes precedence over the pre-chat form
+	contact := &models.Contact{WebsiteID: website.ID, Name: "Jane Doe", Email: "jane@example.com"}
+	if err := db.Create(contact).Error; err != nil {
+		t.Fatalf("failed to create contact: %v", err)
+	}
+	if err := db.Model(chat).Updates(map[string]interface{}{"contact_id": contact.ID, "language": "en"}).Error; err != nil {
+		t.Fatalf("failed to update chat: %v", err)
+	}
+
+	used, err := service.UseResponse(chat.ID, website.UserID, hello.ID)
+	if err != nil {
+		t.Fatalf("UseResponse() error = %v", err)
+	}
+	if used.Content != "Hi Jane Doe, I'm Owner from Example." {
+		t.Errorf("unexpected rendered response %q", used.Content)
+	}
+	if _, err := service.UseResponse(chat.ID+1, website.UserID, hello.ID); err == nil || err.Error() != "chat not found" {
+		t.Errorf("UseResponse() error = %v, want chat not found", err)
+	}
+
+	suggestions, err = service.LookupResponses(chat.ID, website.UserID, "")
+	if err != nil {
+		t.Fatalf("LookupResponses() error = %v", err)
+	}
+	if len(suggestions) != 3 || suggestions[0].ID != hello.ID {
+		t.Errorf("expected the most used response first, got %+v", suggestions)
+	}
+
+	stats, err := service.GetUsageStats(website.ID, 30)
+	if err != nil {
+		t.Fatalf("GetUsageStats() error = %v", err)
+	}
+	if len(stats) != 1 || stats[0].Uses != 1 || stats[0].AgentUses != 1 || stats[0].BotUses != 0 || stats[0].Agents != 1 || stats[0].LastUsedAt == nil {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCannedResponseService_BotReply(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.CannedResponse{}, &models.CannedResponseUsage{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	service := NewCannedResponseService(db, &config.Config{})
+
+	chat := &models.Chat{WebsiteID: website.ID, SessionID: "s1", IsActive: true}
+	if err := db.Create(chat).Error; err != nil {
+		t.Fatalf("failed to create chat: %v", err)
+	}
+
+	for _, req := range []models.CannedResponseRequest{
+		{Shortcut: "shipping", Title: "Shipping", Content: "We ship worldwide.", Keywords: []string{"shipping", "ship"}, BotEnabled: true},
+		{Shortcut: "intl", Title: "International shipping", Content: "International orders take 10 days.", Keywords: []string{"shipping", "international shipping"}, BotEnabled: true},
+		{Shortcut: "manual", Title: "Manual", Content: "Agents only.", Keywords: []string{"shipping"}},
+	} {
+		req := req
+		if _, err := service.CreateResponse(website.ID, website.UserID, &req); err != nil {
+			t.Fatalf("CreateResponse() error = %v", err)
+		}
+	}
+
+	// Bot replies are off until the website turns them on
+	message, err := service.BotReply(chat.ID, "Do you offer international shipping?")
+	if err != nil || message != nil {
+		t.Fatalf("BotReply() = %v, %v, want no reply", message, err)
+	}
+
+	website.Settings.CannedBotReplies = true
+	if err := db.Model(website).Update("settings", website.Settings).Error; err != nil {
+		t.Fatalf("failed to update settings: %v", err)
+	}
+
+	message, err = service.BotReply(chat.ID, "Do you offer international shipping?")
+	if err != nil {
+		t.Fatalf("BotReply() error = %v", err)
+	}
+	if message == nil || message.Sender != models.SenderBot || message.Content != "International orders take 10 days." {
+		t.Fatalf("expected the best matching reply, got %+v", message)
+	}
+
+	if message, err := service.BotReply(chat.ID, "Where is my order?"); err != nil || message != nil {
+		t.Errorf("BotReply() = %v, %v, want no reply", message, err)
+	}
+
+	stats, err := service.GetUsageStats(website.ID, 30)
+	if err != nil {
+		t.Fatalf("GetUsageStats() error = %v", err)
+	}
+	if len(stats) != 1 || stats[0].BotUses != 1 || stats[0].AgentUses != 0 || stats[0].Agents != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
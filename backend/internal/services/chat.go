@@ -3,10 +3,12 @@ package services
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"chatelly-backend/internal/config"
 	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/live"
 
 	"gorm.io/gorm"
 )
@@ -26,7 +28,7 @@ func NewChatService(db *gorm.DB, cfg *config.Config) *ChatService {
 }
 
 // CreateOrGetChat creates a new chat session or returns existing one
-func (s *ChatService) CreateOrGetChat(websiteID uint, sessionID, visitorIP, userAgent, language string) (*models.Chat, error) {
+func (s *ChatService) CreateOrGetChat(websiteID uint, sessionID, visitorID, visitorIP, userAgent, language string) (*models.Chat, error) {
 	// Try to find existing active chat
 	var chat models.Chat
 	err := s.db.Where("website_id = ? AND session_id = ? AND is_active = ?", 
@@ -46,6 +48,7 @@ func (s *ChatService) CreateOrGetChat(websiteID uint, sessionID, visitorIP, user
 	chat = models.Chat{
 		WebsiteID: websiteID,
 		SessionID: sessionID,
+		VisitorID: visitorID,
 		VisitorIP: visitorIP,
 		UserAgent: userAgent,
 		Language:  language,
@@ -53,17 +56,58 @@ func (s *ChatService) CreateOrGetChat(websiteID uint, sessionID, visitorIP, user
 		StartedAt: time.Now(),
 	}
 	
+	// Link the chat to the visitor's contact so it shows up in their history
+	if visitorID != "" {
+		contact, err := NewContactService(s.db, s.cfg).ResolveVisitor(websiteID, visitorID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve contact: %w", err)
+		}
+		chat.ContactID = &contact.ID
+	}
+	
 	if err := s.db.Create(&chat).Error; err != nil {
 		return nil, fmt.Errorf("failed to create chat: %w", err)
 	}
 	
+	// Notify live dashboards
+	live.PublishChat(websiteID, map[string]interface{}{
+		"id":         chat.ID,
+		"session_id": chat.SessionID,
+		"language":   chat.Language,
+		"started_at": chat.StartedAt,
+	})
+	
+	return &chat, nil
+}
+
+// HasActiveChat reports whether a session already has an active chat
+func (s *ChatService) HasActiveChat(websiteID uint, sessionID string) (bool, error) {
+	var count int64
+	if err := s.db.Model(&models.Chat{}).
+		Where("website_id = ? AND session_id = ? AND is_active = ?", websiteID, sessionID, true).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetChatBySession retrieves a website's chat by the visitor's session ID
+func (s *ChatService) GetChatBySession(websiteID uint, sessionID string) (*models.Chat, error) {
+	var chat models.Chat
+	if err := s.db.Where("website_id = ? AND session_id = ?", websiteID, sessionID).First(&chat).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("chat not found")
+		}
+		return nil, err
+	}
+	
 	return &chat, nil
 }
 
 // GetChatByID retrieves a chat by ID
 func (s *ChatService) GetChatByID(chatID uint) (*models.Chat, error) {
 	var chat models.Chat
-	if err := s.db.Preload("Website").Preload("Messages").First(&chat, chatID).Error; err != nil {
+	if err := s.db.Preload("Website").Preload("Messages.Attachments").First(&chat, chatID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("chat not found")
 		}
@@ -102,11 +146,180 @@ func (s *ChatService) GetChatsByWebsiteID(websiteID uint, page, limit int) ([]mo
 func (s *ChatService) EndChat(chatID uint) error {
 	now := time.Now()
 	return s.db.Model(&models.Chat{}).Where("id = ?", chatID).Updates(map[string]interface{}{
-		"is_active": false,
-		"ended_at":  &now,
+		"is_active":     false,
+		"ended_at":      &now,
+		"status":        models.ChatStatusClosed,
+		"snoozed_until": nil,
 	}).Error
 }
 
+// UpdateChat applies triage changes: status, snooze time, priority and tags
+func (s *ChatService) UpdateChat(chatID uint, req *models.ChatUpdateRequest) (*models.Chat, error) {
+	var chat models.Chat
+	if err := s.db.First(&chat, chatID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("chat not found")
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	updates := make(map[string]interface{})
+
+	if req.Status != nil {
+		if err := models.ValidateStatusChange(chat.Status, *req.Status, req.SnoozedUntil, now); err != nil {
+			return nil, err
+		}
+		updates["status"] = *req.Status
+
+		if *req.Status == models.ChatStatusSnoozed {
+			updates["snoozed_until"] = req.SnoozedUntil
+		} else {
+			updates["snoozed_until"] = nil
+		}
+
+		switch *req.Status {
+		case models.ChatStatusResolved:
+			if chat.ResolvedAt == nil {
+				updates["resolved_at"] = &now
+			}
+		case models.ChatStatusClosed:
+			// Closing ends the conversation; a prior resolution is kept for stats
+			updates["is_active"] = false
+			if chat.EndedAt == nil {
+				updates["ended_at"] = &now
+			}
+		default:
+			updates["resolved_at"] = nil
+		}
+	} else if req.SnoozedUntil != nil {
+		// Extending an existing snooze
+		if err := models.ValidateStatusChange(chat.Status, models.ChatStatusSnoozed, req.SnoozedUntil, now); err != nil {
+			return nil, err
+		}
+		if chat.Status != models.ChatStatusSnoozed {
+			return nil, errors.New("snoozed_until requires status snoozed")
+		}
+		updates["snoozed_until"] = req.SnoozedUntil
+	}
+
+	if req.Priority != nil {
+		if !models.IsValidChatPriority(*req.Priority) {
+			return nil, errors.New("invalid chat priority")
+		}
+		updates["priority"] = *req.Priority
+	}
+
+	if req.Tags != nil {
+		tags, err := models.NormalizeChatTags(*req.Tags)
+		if err != nil {
+			return nil, err
+		}
+		updates["tags"] = tags
+	}
+
+	if len(updates) > 0 {
+		if err := s.db.Model(&chat).Updates(updates).Error; err != nil {
+			return nil, fmt.Errorf("failed to update chat: %w", err)
+		}
+	}
+
+	if err := s.db.First(&chat, chatID).Error; err != nil {
+		return nil, err
+	}
+
+	return &chat, nil
+}
+
+// WakeSnoozedChats reopens snoozed chats whose snooze time has passed
+func (s *ChatService) WakeSnoozedChats() (int64, error) {
+	result := s.db.Model(&models.Chat{}).
+		Where("status = ? AND snoozed_until <= ?", models.ChatStatusSnoozed, time.Now()).
+		Updates(map[string]interface{}{
+			"status":        models.ChatStatusOpen,
+			"snoozed_until": nil,
+		})
+	return result.RowsAffected, result.Error
+}
+
+// GetNotes returns a chat's internal notes, oldest first
+func (s *ChatService) GetNotes(chatID uint) ([]models.ChatNote, error) {
+	var notes []models.ChatNote
+	if err := s.db.Preload("User").
+		Where("chat_id = ?", chatID).
+		Order("created_at ASC").
+		Find(&notes).Error; err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+// CreateNote adds an internal note to a chat
+func (s *ChatService) CreateNote(chatID, userID uint, content string) (*models.ChatNote, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, errors.New("note content is required")
+	}
+
+	note := &models.ChatNote{
+		ChatID:  chatID,
+		UserID:  userID,
+		Content: content,
+	}
+	if err := s.db.Create(note).Error; err != nil {
+		return nil, fmt.Errorf("failed to create note: %w", err)
+	}
+
+	return note, nil
+}
+
+// UpdateNote edits a note. Only the author can edit their notes.
+func (s *ChatService) UpdateNote(noteID, chatID, userID uint, content string) (*models.ChatNote, error) {
+	note, err := s.getOwnNote(noteID, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, errors.New("note content is required")
+	}
+
+	if err := s.db.Model(note).Update("content", content).Error; err != nil {
+		return nil, fmt.Errorf("failed to update note: %w", err)
+	}
+
+	return note, nil
+}
+
+// DeleteNote deletes a note. Only the author can delete their notes.
+func (s *ChatService) DeleteNote(noteID, chatID, userID uint) error {
+	note, err := s.getOwnNote(noteID, chatID, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Delete(note).Error
+}
+
+// getOwnNote loads a chat note written by the given user
+func (s *ChatService) getOwnNote(noteID, chatID, userID uint) (*models.ChatNote, error) {
+	var note models.ChatNote
+	if err := s.db.Where("id = ? AND chat_id = ?", noteID, chatID).First(&note).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("note not found")
+		}
+		return nil, err
+	}
+
+	if note.UserID != userID {
+		return nil, errors.New("only the author can change a note")
+	}
+
+	return &note, nil
+}
+
 // SaveMessage saves a message to the database
 func (s *ChatService) SaveMessage(chatID uint, content, sender, language string, isFromBot bool) (*models.Message, error) {
 	message := &models.Message{
@@ -121,13 +334,206 @@ func (s *ChatService) SaveMessage(chatID uint, content, sender, language string,
 		Timestamp:       time.Now(),
 	}
 	
+	if err := s.saveMessage(message); err != nil {
+		return nil, err
+	}
+	
+	return message, nil
+}
+
+// saveMessage stores a message along with its attachments, reopens the chat
+// on visitor replies and notifies live dashboards
+func (s *ChatService) saveMessage(message *models.Message) error {
+	chatID := message.ChatID
+	sender := message.Sender
+	
 	if err := s.db.Create(message).Error; err != nil {
-		return nil, fmt.Errorf("failed to save message: %w", err)
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+	
+	// A visitor reply brings a waiting, snoozed or resolved chat back to the team
+	if sender == models.SenderUser {
+		if err := s.db.Model(&models.Chat{}).
+			Where("id = ? AND status IN ?", chatID, []string{models.ChatStatusPending, models.ChatStatusSnoozed, models.ChatStatusResolved}).
+			Updates(map[string]interface{}{
+				"status":        models.ChatStatusOpen,
+				"snoozed_until": nil,
+				"resolved_at":   nil,
+			}).Error; err != nil {
+			return fmt.Errorf("failed to reopen chat: %w", err)
+		}
+	}
+	
+	// Notify live dashboards
+	var websiteID uint
+	if err := s.db.Model(&models.Chat{}).Where("id = ?", chatID).Pluck("website_id", &websiteID).Error; err == nil {
+		live.PublishMessage(websiteID, map[string]interface{}{
+			"id":        message.ID,
+			"chat_id":   message.ChatID,
+			"sender":    message.Sender,
+			"content":   message.Content,
+			"timestamp": message.Timestamp,
+		})
 	}
 	
+	return nil
+}
+
+// SaveClientMessage stores a message a client sent over the hub. When the
+// client resends a message whose client message ID is already stored in the
+// chat, the stored message is returned with true instead of saving it again.
+func (s *ChatService) SaveClientMessage(chatID uint, clientMessageID, content, sender string, senderID *uint, language string) (*models.Message, bool, error) {
+	if err := models.ValidateClientMessageID(clientMessageID); err != nil {
+		return nil, false, err
+	}
+
+	if clientMessageID != "" {
+		existing, err := s.findClientMessage(chatID, clientMessageID)
+		if err == nil {
+			return existing, true, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, err
+		}
+	}
+
+	content = strings.TrimSpace(content)
+	message := &models.Message{
+		ChatID:          chatID,
+		Content:         content,
+		OriginalContent: content,
+		Sender:          sender,
+		SenderID:        senderID,
+		Language:        language,
+		Timestamp:       time.Now(),
+	}
+	if clientMessageID != "" {
+		message.ClientMessageID = &clientMessageID
+	}
+	if err := s.ProcessMessage(message); err != nil {
+		return nil, false, err
+	}
+
+	if err := s.saveMessage(message); err != nil {
+		// A resend racing the original loses on the unique index
+		if clientMessageID != "" {
+			if existing, findErr := s.findClientMessage(chatID, clientMessageID); findErr == nil {
+				return existing, true, nil
+			}
+		}
+		return nil, false, err
+	}
+
+	return message, false, nil
+}
+
+// findClientMessage looks up a message by the ID its sender generated.
+// Deleted messages count, so a late resend does not bring one back.
+func (s *ChatService) findClientMessage(chatID uint, clientMessageID string) (*models.Message, error) {
+	var message models.Message
+	if err := s.db.Unscoped().Where("chat_id = ? AND client_message_id = ?", chatID, clientMessageID).First(&message).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// EditMessage replaces the text of a message an agent sent
+func (s *ChatService) EditMessage(chatID, messageID, userID uint, content string) (*models.Message, error) {
+	message, err := s.getOwnMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	message.Content = strings.TrimSpace(content)
+	if err := s.ProcessMessage(message); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	message.OriginalContent = message.Content
+	message.Translated = false
+	message.EditedAt = &now
+	if err := s.db.Model(message).Select("content", "original_content", "translated", "edited_at").Updates(message).Error; err != nil {
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+
 	return message, nil
 }
 
+// DeleteMessage soft deletes a message an agent sent
+func (s *ChatService) DeleteMessage(chatID, messageID, userID uint) (*models.Message, error) {
+	message, err := s.getOwnMessage(chatID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Delete(message).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	return message, nil
+}
+
+// getOwnMessage loads a chat message sent by the given agent
+func (s *ChatService) getOwnMessage(chatID, messageID, userID uint) (*models.Message, error) {
+	var message models.Message
+	if err := s.db.Where("id = ? AND chat_id = ?", messageID, chatID).First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+
+	if message.Sender != models.SenderAgent || message.SenderID == nil || *message.SenderID != userID {
+		return nil, errors.New("only the author can change a message")
+	}
+
+	return &message, nil
+}
+
+// MarkMessages records that the messages the other side of a chat sent, up to
+// and including upToID, were delivered to or read by the visitor (byVisitor)
+// or the team. Reading implies delivery. It returns the messages that changed.
+func (s *ChatService) MarkMessages(chatID uint, byVisitor bool, status string, upToID uint) ([]uint, time.Time, error) {
+	now := time.Now()
+
+	query := s.db.Model(&models.Message{}).Where("chat_id = ? AND id <= ?", chatID, upToID)
+	if byVisitor {
+		query = query.Where("sender <> ?", models.SenderUser)
+	} else {
+		query = query.Where("sender = ?", models.SenderUser)
+	}
+
+	var updates map[string]interface{}
+	switch status {
+	case models.ReceiptDelivered:
+		query = query.Where("delivered_at IS NULL")
+		updates = map[string]interface{}{"delivered_at": now}
+	case models.ReceiptRead:
+		query = query.Where("read_at IS NULL")
+		updates = map[string]interface{}{
+			"read_at":      now,
+			"delivered_at": gorm.Expr("COALESCE(delivered_at, ?)", now),
+		}
+	default:
+		return nil, now, errors.New("invalid receipt status")
+	}
+
+	var ids []uint
+	if err := query.Order("id ASC").Pluck("id", &ids).Error; err != nil {
+		return nil, now, err
+	}
+	if len(ids) == 0 {
+		return ids, now, nil
+	}
+
+	if err := s.db.Model(&models.Message{}).Where("id IN ?", ids).Updates(updates).Error; err != nil {
+		return nil, now, fmt.Errorf("failed to mark messages %s: %w", status, err)
+	}
+
+	return ids, now, nil
+}
+
 // GetMessagesByChatID retrieves messages for a chat
 func (s *ChatService) GetMessagesByChatID(chatID uint, page, limit int) ([]models.Message, int64, error) {
 	var messages []models.Message
@@ -142,7 +548,7 @@ func (s *ChatService) GetMessagesByChatID(chatID uint, page, limit int) ([]model
 	offset := (page - 1) * limit
 	
 	// Get messages with pagination, ordered by timestamp
-	if err := s.db.Where("chat_id = ?", chatID).
+	if err := s.db.Preload("Attachments").Where("chat_id = ?", chatID).
 		Order("timestamp ASC").
 		Limit(limit).
 		Offset(offset).
@@ -157,7 +563,7 @@ func (s *ChatService) GetMessagesByChatID(chatID uint, page, limit int) ([]model
 func (s *ChatService) GetRecentMessages(chatID uint, limit int) ([]models.Message, error) {
 	var messages []models.Message
 	
-	if err := s.db.Where("chat_id = ?", chatID).
+	if err := s.db.Preload("Attachments").Where("chat_id = ?", chatID).
 		Order("timestamp DESC").
 		Limit(limit).
 		Find(&messages).Error; err != nil {
@@ -237,36 +643,87 @@ func (s *ChatService) GetChatStats(websiteID uint, days int) (map[string]interfa
 	}
 	
 	// Average chat duration (for ended chats)
-	var avgDuration float64
-	if err := s.db.Model(&models.Chat{}).
-		Select("AVG(EXTRACT(EPOCH FROM (ended_at - started_at))) as avg_duration").
-		Where("website_id = ? AND ended_at IS NOT NULL AND created_at >= ?", websiteID, startDate).
-		Scan(&avgDuration).Error; err != nil {
+	avgDuration, _, err := averageChatDuration(s.db, websiteID, startDate, time.Now())
+	if err != nil {
 		return nil, err
 	}
 	stats["avg_chat_duration_seconds"] = avgDuration
 	
+	// Status breakdown for chats started in the period
+	var statusRows []struct {
+		Status string
+		Count  int64
+	}
+	if err := s.db.Model(&models.Chat{}).
+		Select("status, COUNT(*) AS count").
+		Where("website_id = ? AND created_at >= ?", websiteID, startDate).
+		Group("status").
+		Scan(&statusRows).Error; err != nil {
+		return nil, err
+	}
+	statusCounts := map[string]int64{
+		models.ChatStatusOpen:     0,
+		models.ChatStatusPending:  0,
+		models.ChatStatusSnoozed:  0,
+		models.ChatStatusResolved: 0,
+		models.ChatStatusClosed:   0,
+	}
+	for _, row := range statusRows {
+		statusCounts[row.Status] = row.Count
+	}
+	stats["status_counts"] = statusCounts
+	
+	// Resolved chats were marked resolved at some point, even if closed since;
+	// abandoned chats ended without ever being resolved
+	var resolvedChats, abandonedChats int64
+	if err := s.db.Model(&models.Chat{}).
+		Where("website_id = ? AND created_at >= ? AND resolved_at IS NOT NULL", websiteID, startDate).
+		Count(&resolvedChats).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&models.Chat{}).
+		Where("website_id = ? AND created_at >= ? AND is_active = ? AND resolved_at IS NULL", websiteID, startDate, false).
+		Count(&abandonedChats).Error; err != nil {
+		return nil, err
+	}
+	stats["resolved_chats"] = resolvedChats
+	stats["abandoned_chats"] = abandonedChats
+	if resolvedChats+abandonedChats > 0 {
+		stats["resolution_rate"] = float64(resolvedChats) / float64(resolvedChats+abandonedChats) * 100
+	} else {
+		stats["resolution_rate"] = 0
+	}
+	
 	return stats, nil
 }
 
 // SearchChats searches chats by various criteria
-func (s *ChatService) SearchChats(websiteID uint, query string, status string, page, limit int) ([]models.Chat, int64, error) {
+func (s *ChatService) SearchChats(websiteID uint, filters models.ChatSearchFilters, page, limit int) ([]models.Chat, int64, error) {
 	var chats []models.Chat
 	var total int64
 	
 	// Build search query
 	searchQuery := s.db.Model(&models.Chat{}).Where("website_id = ?", websiteID)
 	
-	if query != "" {
-		searchQuery = searchQuery.Where("session_id ILIKE ? OR visitor_ip ILIKE ?", "%"+query+"%", "%"+query+"%")
+	if filters.Query != "" {
+		searchQuery = searchQuery.Where("session_id ILIKE ? OR visitor_ip ILIKE ?", "%"+filters.Query+"%", "%"+filters.Query+"%")
 	}
 	
-	if status != "" {
-		if status == "active" {
-			searchQuery = searchQuery.Where("is_active = ?", true)
-		} else if status == "ended" {
-			searchQuery = searchQuery.Where("is_active = ?", false)
-		}
+	switch {
+	case filters.Status == "active":
+		searchQuery = searchQuery.Where("is_active = ?", true)
+	case filters.Status == "ended":
+		searchQuery = searchQuery.Where("is_active = ?", false)
+	case filters.Status != "":
+		searchQuery = searchQuery.Where("status = ?", filters.Status)
+	}
+	
+	if filters.Priority != "" {
+		searchQuery = searchQuery.Where("priority = ?", filters.Priority)
+	}
+	
+	for _, tag := range filters.Tags {
+		searchQuery = filterChatTag(searchQuery, strings.ToLower(strings.TrimSpace(tag)))
 	}
 	
 	// Count total results
@@ -288,6 +745,14 @@ func (s *ChatService) SearchChats(websiteID uint, query string, status string, p
 	return chats, total, nil
 }
 
+// filterChatTag restricts a chat query to chats carrying a tag
+func filterChatTag(query *gorm.DB, tag string) *gorm.DB {
+	if query.Dialector.Name() == "postgres" {
+		return query.Where("chats.tags @> jsonb_build_array(?::text)", tag)
+	}
+	return query.Where("EXISTS (SELECT 1 FROM json_each(CAST(chats.tags AS TEXT)) WHERE json_each.value = ?)", tag)
+}
+
 // GetChatHistory returns formatted chat history for WebSocket
 func (s *ChatService) GetChatHistory(chatID uint, limit int) ([]map[string]interface{}, error) {
 	messages, err := s.GetRecentMessages(chatID, limit)
@@ -304,17 +769,29 @@ func (s *ChatService) GetChatHistory(chatID uint, limit int) ([]map[string]inter
 			"timestamp": msg.Timestamp.Unix(),
 			"language":  msg.Language,
 		}
+		if len(msg.Attachments) > 0 {
+			history[i]["attachments"] = msg.Attachments
+		}
+		if msg.ReadAt != nil {
+			history[i]["status"] = models.ReceiptRead
+		} else if msg.DeliveredAt != nil {
+			history[i]["status"] = models.ReceiptDelivered
+		}
+		if msg.EditedAt != nil {
+			history[i]["edited_at"] = msg.EditedAt.Unix()
+		}
 	}
 	
 	return history, nil
 }
 
-// ValidateChatAccess validates if a user has access to a chat
+// ValidateChatAccess validates if a user has access to a chat, either as the
+// website owner or as one of its agents
 func (s *ChatService) ValidateChatAccess(chatID, userID uint) error {
 	var count int64
 	if err := s.db.Model(&models.Chat{}).
 		Joins("JOIN websites ON chats.website_id = websites.id").
-		Where("chats.id = ? AND websites.user_id = ?", chatID, userID).
+		Where("chats.id = ? AND (websites.user_id = ? OR EXISTS (SELECT 1 FROM website_agents WHERE website_agents.website_id = chats.website_id AND website_agents.user_id = ?))", chatID, userID, userID).
 		Count(&count).Error; err != nil {
 		return err
 	}
@@ -352,7 +829,7 @@ func (s *ChatService) ProcessMessage(message *models.Message) error {
 		return errors.New("message content cannot be empty")
 	}
 	
-	if len(message.Content) > 1000 {
+	if len(message.Content) > models.MaxMessageLength {
 		return errors.New("message content too long")
 	}
 	
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+)
+
+func TestChatService_Triage(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.ChatNote{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	service := NewChatService(db, &config.Config{})
+
+	resolved := createTestChat(t, db, website.ID, "")
+	abandoned := createTestChat(t, db, website.ID, "")
+	snoozed := createTestChat(t, db, website.ID, "")
+
+	if resolved.Status != models.ChatStatusOpen || resolved.Priority != models.ChatPriorityNormal {
+		t.Fatalf("expected new chats to be open with normal priority, got %q/%q", resolved.Status, resolved.Priority)
+	}
+
+	status := models.ChatStatusResolved
+	priority := models.ChatPriorityHigh
+	tags := []string{"VIP", "billing"}
+	chat, err := service.UpdateChat(resolved.ID, &models.ChatUpdateRequest{Status: &status, Priority: &priority, Tags: &tags})
+	if err != nil {
+		t.Fatalf("UpdateChat() error = %v", err)
+	}
+	if chat.ResolvedAt == nil || chat.Priority != models.ChatPriorityHigh || len(chat.Tags) != 2 || chat.Tags[0] != "vip" {
+		t.Fatalf("unexpected chat after update: %+v", chat)
+	}
+
+	// Resolved chats stay resolved once closed; the other chat is abandoned
+	for _, id := range []uint{resolved.ID, abandoned.ID} {
+		if err := service.EndChat(id); err != nil {
+			t.Fatalf("EndChat() error = %v", err)
+		}
+	}
+	reopen := models.ChatStatusOpen
+	if _, err := service.UpdateChat(abandoned.ID, &models.ChatUpdateRequest{Status: &reopen}); err == nil || err.Error() != "chat is closed" {
+		t.Errorf("UpdateChat() error = %v, want chat is closed", err)
+	}
+
+	// A visitor reply wakes a snoozed chat
+	status = models.ChatStatusSnoozed
+	until := time.Now().Add(time.Hour)
+	if _, err := service.UpdateChat(snoozed.ID, &models.ChatUpdateRequest{Status: &status, SnoozedUntil: &until}); err != nil {
+		t.Fatalf("UpdateChat() error = %v", err)
+	}
+	if _, err := service.SaveMessage(snoozed.ID, "hello again", models.SenderUser, "en", false); err != nil {
+		t.Fatalf("SaveMessage() error = %v", err)
+	}
+	chat = &models.Chat{}
+	if err := db.First(chat, snoozed.ID).Error; err != nil {
+		t.Fatalf("failed to reload chat: %v", err)
+	}
+	if chat.Status != models.ChatStatusOpen || chat.SnoozedUntil != nil {
+		t.Errorf("expected visitor reply to reopen chat, got %q until %v", chat.Status, chat.SnoozedUntil)
+	}
+
+	// Expired snoozes reopen on their own
+	past := time.Now().Add(-time.Minute)
+	if err := db.Model(&models.Chat{}).Where("id = ?", snoozed.ID).Updates(map[string]interface{}{"status": models.ChatStatusSnoozed, "snoozed_until": &past}).Error; err != nil {
+		t.Fatalf("failed to snooze chat: %v", err)
+	}
+	if woken, err := service.WakeSnoozedChats(); err != nil || woken != 1 {
+		t.Errorf("WakeSnoozedChats() = %d, %v, want 1", woken, err)
+	}
+
+	// Search filters
+	search := func(filters models.ChatSearchFilters) int64 {
+		t.Helper()
+		_, total, err := service.SearchChats(website.ID, filters, 1, 10)
+		if err != nil {
+			t.Fatalf("SearchChats() error = %v", err)
+		}
+		return total
+	}
+	if got := search(models.ChatSearchFilters{Tags: []string{"vip"}}); got != 1 {
+		t.Errorf("tag search returned %d chats, want 1", got)
+	}
+	if got := search(models.ChatSearchFilters{Tags: []string{"vip", "refund"}}); got != 0 {
+		t.Errorf("search for missing tag returned %d chats, want 0", got)
+	}
+	if got := search(models.ChatSearchFilters{Status: models.ChatStatusClosed, Priority: models.ChatPriorityHigh}); got != 1 {
+		t.Errorf("status and priority search returned %d chats, want 1", got)
+	}
+	if got := search(models.ChatSearchFilters{Status: "active"}); got != 1 {
+		t.Errorf("active search returned %d chats, want 1", got)
+	}
+
+	stats, err := service.GetChatStats(website.ID, 7)
+	if err != nil {
+		t.Fatalf("GetChatStats() error = %v", err)
+	}
+	if stats["resolved_chats"] != int64(1) || stats["abandoned_chats"] != int64(1) || stats["resolution_rate"] != 50.0 {
+		t.Errorf("unexpected resolution stats %+v", stats)
+	}
+	if counts := stats["status_counts"].(map[string]int64); counts[models.ChatStatusClosed] != 2 || counts[models.ChatStatusOpen] != 1 {
+		t.Errorf("unexpected status counts %+v", counts)
+	}
+
+	// Notes belong to their author
+	note, err := service.CreateNote(snoozed.ID, website.UserID, "Asked for a refund last week")
+	if err != nil {
+		t.Fatalf("CreateNote() error = %v", err)
+	}
+	if _, err := service.UpdateNote(note.ID, snoozed.ID, website.UserID+1, "edited"); err == nil || err.Error() != "only the author can change a note" {
+		t.Errorf("UpdateNote() error = %v, want author check", err)
+	}
+	if err := service.DeleteNote(note.ID, resolved.ID, website.UserID); err == nil || err.Error() != "note not found" {
+		t.Errorf("DeleteNote() error = %v, want note not found for another chat", err)
+	}
+	notes, err := service.GetNotes(snoozed.ID)
+	if err != nil || len(notes) != 1 || notes[0].User.Email != "owner@example.com" {
+		t.Errorf("GetNotes() = %+v, %v", notes, err)
+	}
+}
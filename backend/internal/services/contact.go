@@ -0,0 +1,481 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// contactTouchInterval limits how often last_seen_at is written for busy visitors
+const contactTouchInterval = time.Minute
+
+// maxMergeDepth bounds how many merges GetContact follows for an old contact ID
+const maxMergeDepth = 10
+
+// ContactService handles visitor identity and contact records
+type ContactService struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+// NewContactService creates a new ContactService
+func NewContactService(db *gorm.DB, cfg *config.Config) *ContactService {
+	return &ContactService{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+// ResolveVisitor returns the contact a visitor ID belongs to, creating an
+// anonymous contact the first time the visitor is seen
+func (s *ContactService) ResolveVisitor(websiteID uint, visitorID string) (*models.Contact, error) {
+	if visitorID == "" || len(visitorID) > models.MaxVisitorIDLength {
+		return nil, errors.New("invalid visitor ID")
+	}
+	return s.resolveVisitor(websiteID, visitorID, time.Now())
+}
+
+// resolveVisitor looks up or creates the visitor's contact. Two requests may
+// see a new visitor at once; the unique visitor link decides which contact wins.
+func (s *ContactService) resolveVisitor(websiteID uint, visitorID string, now time.Time) (*models.Contact, error) {
+	var link models.ContactVisitor
+	err := s.db.Where("website_id = ? AND visitor_id = ?", websiteID, visitorID).First(&link).Error
+	if err == nil {
+		return s.touchContact(link.ContactID, now)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	contact := models.Contact{
+		WebsiteID:   websiteID,
+		FirstSeenAt: now,
+		LastSeenAt:  now,
+	}
+	if err := s.db.Create(&contact).Error; err != nil {
+		return nil, fmt.Errorf("failed to create contact: %w", err)
+	}
+
+	link = models.ContactVisitor{ContactID: contact.ID, WebsiteID: websiteID, VisitorID: visitorID}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&link)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to link visitor: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return &contact, nil
+	}
+
+	// Another request linked the visitor first
+	if err := s.db.Unscoped().Delete(&contact).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Where("website_id = ? AND visitor_id = ?", websiteID, visitorID).First(&link).Error; err != nil {
+		return nil, err
+	}
+	return s.touchContact(link.ContactID, now)
+}
+
+// touchContact loads a contact and records that it was just seen
+func (s *ContactService) touchContact(contactID uint, now time.Time) (*models.Contact, error) {
+	var contact models.Contact
+	if err := s.db.First(&contact, contactID).Error; err != nil {
+		return nil, err
+	}
+
+	if now.Sub(contact.LastSeenAt) >= contactTouchInterval {
+		if err := s.db.Model(&contact).UpdateColumn("last_seen_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &contact, nil
+}
+
+// Identify attaches identity details to the visitor's contact. When another
+// contact already has the same external ID (or email), the visitor's contact
+// is merged into it so their history is kept in one place. A visitor that
+// identifies as someone else, e.g. on a shared computer, moves to that
+// person's contact and leaves the previous history behind.
+//
+// Calls carrying an identity hash are verified against the website's
+// identity secret. Unverified calls are rejected when the website requires
+// verification and can never change or join a verified contact.
+func (s *ContactService) Identify(websiteID uint, req *models.ContactIdentifyRequest) (*models.Contact, error) {
+	externalID := strings.TrimSpace(req.ExternalID)
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if externalID == "" && email == "" {
+		return nil, errors.New("external_id or email is required")
+	}
+	if err := models.ValidateContactAttributes(req.Attributes); err != nil {
+		return nil, err
+	}
+	if req.VisitorID == "" || len(req.VisitorID) > models.MaxVisitorIDLength {
+		return nil, errors.New("invalid visitor ID")
+	}
+
+	var website models.Website
+	if err := s.db.First(&website, websiteID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("website not found")
+		}
+		return nil, err
+	}
+
+	verified := false
+	if req.IdentityHash != "" {
+		key := externalID
+		if key == "" {
+			key = email
+		}
+		if !models.VerifyIdentityHash(website.IdentitySecret, key, req.IdentityHash) {
+			return nil, errors.New("invalid identity hash")
+		}
+		verified = true
+	} else if website.Settings.IdentityVerification {
+		return nil, errors.New("identity hash is required")
+	}
+
+	now := time.Now()
+	current, err := s.resolveVisitor(websiteID, req.VisitorID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var owner *models.Contact
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Reload inside the transaction in case a concurrent identify changed it
+		if err := tx.First(current, current.ID).Error; err != nil {
+			return err
+		}
+
+		conflict := identityConflicts(current, externalID, email)
+		if current.Verified && !verified && !conflict {
+			// Nothing an unverified call may change here
+			owner = current
+			return nil
+		}
+
+		target, err := findIdentifiedContact(tx, websiteID, externalID, email, current.ID)
+		if err != nil {
+			return err
+		}
+		if target != nil && target.Verified && !verified {
+			target = nil
+		}
+
+		switch {
+		case conflict || (current.Verified && !verified):
+			// Someone else is using this browser now
+			if target == nil {
+				target = &models.Contact{WebsiteID: websiteID, FirstSeenAt: now}
+				if err := tx.Create(target).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&models.ContactVisitor{}).
+				Where("website_id = ? AND visitor_id = ?", websiteID, req.VisitorID).
+				Update("contact_id", target.ID).Error; err != nil {
+				return err
+			}
+			owner = target
+		case target != nil:
+			if err := mergeContacts(tx, current, target); err != nil {
+				return err
+			}
+			owner = target
+		default:
+			owner = current
+		}
+
+		if externalID != "" {
+			owner.ExternalID = externalID
+		}
+		if email != "" {
+			owner.Email = email
+		}
+		if name := strings.TrimSpace(req.Name); name != "" {
+			owner.Name = name
+		}
+		owner.Attributes = mergeAttributes(owner.Attributes, req.Attributes)
+		owner.Verified = owner.Verified || verified
+		owner.LastSeenAt = now
+
+		return tx.Save(owner).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return owner, nil
+}
+
+// identityConflicts reports whether an identify call names a different person
+// than the one the contact is already identified as
+func identityConflicts(contact *models.Contact, externalID, email string) bool {
+	if contact.ExternalID != "" && externalID != "" {
+		return contact.ExternalID != externalID
+	}
+	return contact.Email != "" && email != "" && contact.Email != email
+}
+
+// findIdentifiedContact finds another contact with the same external ID, or
+// the same email when no external ID is given
+func findIdentifiedContact(tx *gorm.DB, websiteID uint, externalID, email string, excludeID uint) (*models.Contact, error) {
+	query := tx.Where("website_id = ? AND id <> ?", websiteID, excludeID)
+	if externalID != "" {
+		query = query.Where("external_id = ?", externalID)
+	} else {
+		query = query.Where("email = ?", email)
+	}
+
+	var contact models.Contact
+	if err := query.Order("verified DESC, id ASC").First(&contact).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// mergeContacts moves the source contact's visitors and chats to the target
+// and retires the source. The target's own details win over the source's.
+func mergeContacts(tx *gorm.DB, source, target *models.Contact) error {
+	if err := tx.Model(&models.ContactVisitor{}).Where("contact_id = ?", source.ID).Update("contact_id", target.ID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Chat{}).Where("contact_id = ?", source.ID).Update("contact_id", target.ID).Error; err != nil {
+		return err
+	}
+	// Old IDs merged into the source now resolve to the target directly
+	if err := tx.Unscoped().Model(&models.Contact{}).Where("merged_into_id = ?", source.ID).Update("merged_into_id", target.ID).Error; err != nil {
+		return err
+	}
+
+	if target.ExternalID == "" {
+		target.ExternalID = source.ExternalID
+	}
+	if target.Email == "" {
+		target.Email = source.Email
+	}
+	if target.Name == "" {
+		target.Name = source.Name
+	}
+	if target.Attributes == nil {
+		target.Attributes = models.ContactAttributes{}
+	}
+	for key, value := range source.Attributes {
+		if _, exists := target.Attributes[key]; !exists {
+			target.Attributes[key] = value
+		}
+	}
+	if source.FirstSeenAt.Before(target.FirstSeenAt) {
+		target.FirstSeenAt = source.FirstSeenAt
+	}
+	if source.LastSeenAt.After(target.LastSeenAt) {
+		target.LastSeenAt = source.LastSeenAt
+	}
+	target.Verified = target.Verified || source.Verified
+	if err := tx.Save(target).Error; err != nil {
+		return err
+	}
+
+	source.MergedIntoID = &target.ID
+	if err := tx.Model(source).UpdateColumn("merged_into_id", target.ID).Error; err != nil {
+		return err
+	}
+	return tx.Delete(source).Error
+}
+
+// mergeAttributes applies attribute changes; a nil value removes an attribute
+func mergeAttributes(attributes models.ContactAttributes, changes map[string]interface{}) models.ContactAttributes {
+	if attributes == nil {
+		attributes = models.ContactAttributes{}
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(attributes, key)
+			continue
+		}
+		attributes[key] = value
+	}
+	return attributes
+}
+
+// GetContacts retrieves a website's contacts, most recently seen first. The
+// query matches name, email and external ID.
+func (s *ContactService) GetContacts(websiteID uint, query string, identifiedOnly bool, page, limit int) ([]models.Contact, int64, error) {
+	var contacts []models.Contact
+	var total int64
+
+	dbQuery := s.db.Model(&models.Contact{}).Where("website_id = ?", websiteID)
+	if query = strings.TrimSpace(query); query != "" {
+		pattern := "%" + escapeLike(strings.ToLower(query)) + "%"
+		dbQuery = dbQuery.Where("(LOWER(name) LIKE ? ESCAPE '\\' OR email LIKE ? ESCAPE '\\' OR LOWER(external_id) LIKE ? ESCAPE '\\')", pattern, pattern, pattern)
+	}
+	if identifiedOnly {
+		dbQuery = dbQuery.Where("(external_id <> '' OR email <> '')")
+	}
+
+	if err := dbQuery.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	if err := dbQuery.Order("last_seen_at DESC, id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&contacts).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return contacts, total, nil
+}
+
+// GetContact retrieves a contact with its visitor IDs. IDs of contacts that
+// were merged away resolve to the contact they were merged into.
+func (s *ContactService) GetContact(websiteID, contactID uint) (*models.Contact, error) {
+	var contact models.Contact
+	for depth := 0; ; depth++ {
+		if err := s.db.Unscoped().Where("id = ? AND website_id = ?", contactID, websiteID).First(&contact).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errors.New("contact not found")
+			}
+			return nil, err
+		}
+		if !contact.DeletedAt.Valid {
+			break
+		}
+		if contact.MergedIntoID == nil || depth >= maxMergeDepth {
+			return nil, errors.New("contact not found")
+		}
+		contactID = *contact.MergedIntoID
+		contact = models.Contact{}
+	}
+
+	if err := s.db.Where("contact_id = ?", contact.ID).Order("created_at ASC").Find(&contact.Visitors).Error; err != nil {
+		return nil, err
+	}
+
+	return &contact, nil
+}
+
+// UpdateContact updates a contact's details from the dashboard
+func (s *ContactService) UpdateContact(websiteID, contactID uint, req *models.ContactUpdateRequest) (*models.Contact, error) {
+	if err := models.ValidateContactAttributes(req.Attributes); err != nil {
+		return nil, err
+	}
+
+	contact, err := s.GetContact(websiteID, contactID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Email != nil {
+		contact.Email = strings.ToLower(strings.TrimSpace(*req.Email))
+	}
+	if req.Name != nil {
+		contact.Name = strings.TrimSpace(*req.Name)
+	}
+	contact.Attributes = mergeAttributes(contact.Attributes, req.Attributes)
+
+	if err := s.db.Omit("Visitors").Save(contact).Error; err != nil {
+		return nil, fmt.Errorf("failed to update contact: %w", err)
+	}
+
+	return contact, nil
+}
+
+// MergeContacts merges the source contact into the target, for people the
+// widget could not recognise on its own
+func (s *ContactService) MergeContacts(websiteID, targetID, sourceID uint) (*models.Contact, error) {
+	target, err := s.GetContact(websiteID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	source, err := s.GetContact(websiteID, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if source.ID == target.ID {
+		return nil, errors.New("cannot merge a contact into itself")
+	}
+	if identityConflicts(target, source.ExternalID, source.Email) {
+		return nil, errors.New("contacts have different identities")
+	}
+
+	source.Visitors, target.Visitors = nil, nil
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		return mergeContacts(tx, source, target)
+	}); err != nil {
+		return nil, err
+	}
+
+	return s.GetContact(websiteID, target.ID)
+}
+
+// DeleteContact deletes a contact and forgets its visitor IDs. Chats are kept
+// but no longer linked to anyone.
+func (s *ContactService) DeleteContact(websiteID, contactID uint) error {
+	contact, err := s.GetContact(websiteID, contactID)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("contact_id = ?", contact.ID).Delete(&models.ContactVisitor{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Chat{}).Where("contact_id = ?", contact.ID).Update("contact_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Contact{}, contact.ID).Error
+	})
+}
+
+// GetContactProfile returns a contact with all of their chats and their
+// event journey across every visitor ID they used
+func (s *ContactService) GetContactProfile(websiteID, contactID uint, startDate, endDate time.Time) (*models.ContactProfile, error) {
+	contact, err := s.GetContact(websiteID, contactID)
+	if err != nil {
+		return nil, err
+	}
+
+	visitorIDs := make([]string, len(contact.Visitors))
+	for i, visitor := range contact.Visitors {
+		visitorIDs[i] = visitor.VisitorID
+	}
+
+	// Chats started before the visitor had a contact are matched by visitor ID
+	chats := []models.Chat{}
+	query := s.db.Where("website_id = ?", websiteID)
+	if len(visitorIDs) > 0 {
+		query = query.Where("(contact_id = ? OR (contact_id IS NULL AND visitor_id IN ?))", contact.ID, visitorIDs)
+	} else {
+		query = query.Where("contact_id = ?", contact.ID)
+	}
+	if err := query.Preload("Messages", func(db *gorm.DB) *gorm.DB {
+		return db.Order("timestamp ASC")
+	}).Order("started_at DESC").Find(&chats).Error; err != nil {
+		return nil, err
+	}
+
+	journey, err := NewAnalyticsService(s.db, s.cfg).GetVisitorsJourney(websiteID, visitorIDs, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ContactProfile{
+		Contact:    *contact,
+		VisitorIDs: visitorIDs,
+		Chats:      chats,
+		Journey:    journey,
+	}, nil
+}
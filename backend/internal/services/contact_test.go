@@ -0,0 +1,146 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+)
+
+func TestContactService_IdentifyAndMerge(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.Contact{}, &models.ContactVisitor{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	cfg := &config.Config{}
+	service := NewContactService(db, cfg)
+	chatService := NewChatService(db, cfg)
+
+	secret, err := NewWebsiteService(db, cfg).GetIdentitySecret(website.ID, website.UserID)
+	if err != nil {
+		t.Fatalf("GetIdentitySecret() error = %v", err)
+	}
+
+	// The same visitor always resolves to the same anonymous contact
+	laptop, err := service.ResolveVisitor(website.ID, "visitor-laptop")
+	if err != nil {
+		t.Fatalf("ResolveVisitor() error = %v", err)
+	}
+	again, err := service.ResolveVisitor(website.ID, "visitor-laptop")
+	if err != nil {
+		t.Fatalf("ResolveVisitor() error = %v", err)
+	}
+	if again.ID != laptop.ID || laptop.IsIdentified() {
+		t.Fatalf("expected the same anonymous contact, got %d and %d", laptop.ID, again.ID)
+	}
+
+	// Chats and events from two devices
+	laptopChat, err := chatService.CreateOrGetChat(website.ID, "session-laptop", "visitor-laptop", "127.0.0.1", "test", "en")
+	if err != nil {
+		t.Fatalf("CreateOrGetChat() error = %v", err)
+	}
+	if laptopChat.ContactID == nil || *laptopChat.ContactID != laptop.ID {
+		t.Fatalf("expected chat to be linked to contact %d, got %v", laptop.ID, laptopChat.ContactID)
+	}
+	phoneChat, err := chatService.CreateOrGetChat(website.ID, "session-phone", "visitor-phone", "127.0.0.1", "test", "en")
+	if err != nil {
+		t.Fatalf("CreateOrGetChat() error = %v", err)
+	}
+	events := []models.Analytics{
+		{WebsiteID: website.ID, EventType: models.EventTypePageView, VisitorID: "visitor-laptop", CreatedAt: time.Now().Add(-2 * time.Hour)},
+		{WebsiteID: website.ID, EventType: models.EventTypePageView, VisitorID: "visitor-phone", CreatedAt: time.Now().Add(-time.Hour)},
+		{WebsiteID: website.ID, EventType: models.EventTypePageView, VisitorID: "someone-else", CreatedAt: time.Now()},
+	}
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatalf("failed to create events: %v", err)
+	}
+
+	identify := func(visitorID, externalID, hash string) (*models.Contact, error) {
+		return service.Identify(website.ID, &models.ContactIdentifyRequest{
+			VisitorID:    visitorID,
+			ExternalID:   externalID,
+			Email:        "Jane@Example.com",
+			Name:         "Jane",
+			Attributes:   map[string]interface{}{"plan": "pro"},
+			IdentityHash: hash,
+		})
+	}
+
+	if _, err := identify("visitor-laptop", "user-1", models.ComputeIdentityHash("wrong", "user-1")); err == nil || err.Error() != "invalid identity hash" {
+		t.Errorf("Identify() error = %v, want invalid identity hash", err)
+	}
+
+	jane, err := identify("visitor-laptop", "user-1", models.ComputeIdentityHash(secret, "user-1"))
+	if err != nil {
+		t.Fatalf("Identify() error = %v", err)
+	}
+	if jane.ID != laptop.ID || !jane.Verified || jane.Email != "jane@example.com" || jane.Attributes["plan"] != "pro" {
+		t.Fatalf("expected the laptop contact to be identified, got %+v", jane)
+	}
+
+	// Identifying on the phone merges its anonymous contact into Jane's
+	merged, err := identify("visitor-phone", "user-1", models.ComputeIdentityHash(secret, "user-1"))
+	if err != nil {
+		t.Fatalf("Identify() error = %v", err)
+	}
+	if merged.ID != jane.ID {
+		t.Fatalf("expected phone visitor to join contact %d, got %d", jane.ID, merged.ID)
+	}
+	if resolved, err := service.GetContact(website.ID, *phoneChat.ContactID); err != nil || resolved.ID != jane.ID {
+		t.Errorf("expected the merged contact ID to resolve to %d, got %+v (%v)", jane.ID, resolved, err)
+	}
+
+	profile, err := service.GetContactProfile(website.ID, jane.ID, time.Now().Add(-24*time.Hour), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetContactProfile() error = %v", err)
+	}
+	if len(profile.VisitorIDs) != 2 || len(profile.Chats) != 2 || len(profile.Journey) != 2 {
+		t.Fatalf("expected 2 visitors, chats and events, got %d, %d and %d", len(profile.VisitorIDs), len(profile.Chats), len(profile.Journey))
+	}
+	if profile.Journey[0].VisitorID != "visitor-laptop" {
+		t.Errorf("expected the journey oldest first, got %s", profile.Journey[0].VisitorID)
+	}
+
+	// Unverified claims never join a verified contact
+	impostor, err := identify("visitor-impostor", "user-1", "")
+	if err != nil {
+		t.Fatalf("Identify() error = %v", err)
+	}
+	if impostor.ID == jane.ID || impostor.Verified {
+		t.Errorf("expected an unverified contact separate from %d, got %+v", jane.ID, impostor)
+	}
+
+	// Another user signing in on the laptop takes only the laptop with them
+	bob, err := identify("visitor-laptop", "user-2", models.ComputeIdentityHash(secret, "user-2"))
+	if err != nil {
+		t.Fatalf("Identify() error = %v", err)
+	}
+	if bob.ID == jane.ID {
+		t.Fatalf("expected a new contact for user-2")
+	}
+	if profile, err = service.GetContactProfile(website.ID, jane.ID, time.Time{}, time.Now()); err != nil {
+		t.Fatalf("GetContactProfile() error = %v", err)
+	}
+	if len(profile.VisitorIDs) != 1 || len(profile.Chats) != 2 {
+		t.Errorf("expected jane to keep her chats but lose the laptop, got %+v", profile.VisitorIDs)
+	}
+
+	contacts, total, err := service.GetContacts(website.ID, "USER-", true, 1, 10)
+	if err != nil {
+		t.Fatalf("GetContacts() error = %v", err)
+	}
+	if total != 3 || len(contacts) != 3 {
+		t.Errorf("expected 3 identified contacts, got %d", total)
+	}
+
+	// Websites can insist on verified identities
+	website.Settings.IdentityVerification = true
+	if err := db.Model(website).Update("settings", website.Settings).Error; err != nil {
+		t.Fatalf("failed to update settings: %v", err)
+	}
+	if _, err := identify("visitor-new", "user-3", ""); err == nil || err.Error() != "identity hash is required" {
+		t.Errorf("Identify() error = %v, want identity hash is required", err)
+	}
+}
@@ -0,0 +1,728 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/storage"
+
+	"github.com/xitongsys/parquet-go/writer"
+	"gorm.io/gorm"
+)
+
+// ExportService handles raw data exports
+type ExportService struct {
+	db      *gorm.DB
+	cfg     *config.Config
+	storage storage.Storage
+}
+
+// NewExportService creates a new ExportService
+func NewExportService(db *gorm.DB, cfg *config.Config, store storage.Storage) *ExportService {
+	return &ExportService{
+		db:      db,
+		cfg:     cfg,
+		storage: store,
+	}
+}
+
+// ErrExportCheckFailed wraps database failures while validating an export, as
+// opposed to the export itself being invalid
+var ErrExportCheckFailed = errors.New("failed to validate export")
+
+// ValidateExport checks an export's dataset, format and filters before any output is written
+func (s *ExportService) ValidateExport(job *models.ExportJob) error {
+	if _, ok := exportDatasets[job.Dataset]; !ok {
+		return fmt.Errorf("invalid dataset '%s'", job.Dataset)
+	}
+
+	switch job.Format {
+	case models.ExportFormatCSV, models.ExportFormatNDJSON, models.ExportFormatParquet:
+	default:
+		return fmt.Errorf("invalid format '%s'", job.Format)
+	}
+
+	if job.EndDate.Before(job.StartDate) {
+		return errors.New("end date must not be before start date")
+	}
+
+	if job.Dataset != models.ExportDatasetEvents && (job.Filters.EventType != "" || len(job.Filters.Properties) > 0) {
+		return errors.New("event filters only apply to the events dataset")
+	}
+
+	if job.Filters.EventType != "" {
+		valid, err := models.IsValidEventTypeForWebsite(s.db, job.WebsiteID, job.Filters.EventType)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrExportCheckFailed, err)
+		}
+		if !valid {
+			return errors.New("invalid event type")
+		}
+	}
+
+	for key := range job.Filters.Properties {
+		if !eventPropertyKeyRegex.MatchString(key) {
+			return fmt.Errorf("invalid property filter '%s'", key)
+		}
+	}
+
+	return nil
+}
+
+// IsSyncExport reports whether a date range is small enough to stream directly
+func (s *ExportService) IsSyncExport(startDate, endDate time.Time) bool {
+	maxDays := s.cfg.Export.MaxSyncDays
+	if maxDays <= 0 {
+		maxDays = 31
+	}
+
+	return endDate.Sub(startDate) <= time.Duration(maxDays)*24*time.Hour
+}
+
+// StreamExport writes an export to w, iterating rows with an ID cursor so memory
+// stays bounded. flush, when set, is called after every batch.
+func (s *ExportService) StreamExport(ctx context.Context, w io.Writer, job *models.ExportJob, flush func()) (int64, error) {
+	dataset, ok := exportDatasets[job.Dataset]
+	if !ok {
+		return 0, fmt.Errorf("invalid dataset '%s'", job.Dataset)
+	}
+
+	encoder, err := newExportEncoder(job.Format, w, dataset.columns)
+	if err != nil {
+		return 0, err
+	}
+
+	batchSize := s.cfg.Export.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	var (
+		rows   int64
+		cursor uint
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return rows, err
+		}
+
+		records, last, err := dataset.fetch(s.db.WithContext(ctx), job, cursor, batchSize)
+		if err != nil {
+			return rows, err
+		}
+
+		for _, record := range records {
+			if err := encoder.Write(record); err != nil {
+				return rows, err
+			}
+		}
+		rows += int64(len(records))
+
+		if len(records) < batchSize {
+			break
+		}
+		cursor = last
+
+		if err := encoder.Flush(); err != nil {
+			return rows, err
+		}
+		if flush != nil {
+			flush()
+		}
+	}
+
+	if err := encoder.Close(); err != nil {
+		return rows, err
+	}
+	if flush != nil {
+		flush()
+	}
+
+	return rows, nil
+}
+
+// StartExportJob saves an export job and runs it in the background
+func (s *ExportService) StartExportJob(job *models.ExportJob) error {
+	job.Status = models.ExportStatusPending
+	if err := s.db.Create(job).Error; err != nil {
+		return fmt.Errorf("failed to create export job: %w", err)
+	}
+
+	go func(jobID uint) {
+		if err := s.RunExportJob(context.Background(), jobID); err != nil {
+			log.Printf("Export job %d failed: %v", jobID, err)
+		}
+	}(job.ID)
+
+	return nil
+}
+
+// RunExportJob writes an export job to a temporary file and uploads it to storage
+func (s *ExportService) RunExportJob(ctx context.Context, jobID uint) error {
+	var job models.ExportJob
+	if err := s.db.First(&job, jobID).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := s.db.Model(&job).Updates(map[string]interface{}{
+		"status":     models.ExportStatusRunning,
+		"started_at": now,
+	}).Error; err != nil {
+		return err
+	}
+
+	rows, size, key, err := s.writeExportFile(ctx, &job)
+	if err != nil {
+		s.db.Model(&job).Updates(map[string]interface{}{
+			"status":       models.ExportStatusFailed,
+			"error":        err.Error(),
+			"completed_at": time.Now(),
+		})
+		return err
+	}
+
+	expiration := s.cfg.Export.LinkExpiration
+	if expiration <= 0 {
+		expiration = 24
+	}
+	completedAt := time.Now()
+	expiresAt := completedAt.Add(time.Duration(expiration) * time.Hour)
+
+	return s.db.Model(&job).Updates(map[string]interface{}{
+		"status":       models.ExportStatusCompleted,
+		"storage_key":  key,
+		"row_count":    rows,
+		"file_size":    size,
+		"completed_at": completedAt,
+		"expires_at":   expiresAt,
+	}).Error
+}
+
+// writeExportFile streams an export into a temporary file, then uploads it
+func (s *ExportService) writeExportFile(ctx context.Context, job *models.ExportJob) (int64, int64, string, error) {
+	tmp, err := os.CreateTemp("", "chatelly-export-*")
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	rows, err := s.StreamExport(ctx, tmp, job, nil)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, "", err
+	}
+
+	key := fmt.Sprintf("exports/%d/%d-%s.%s", job.WebsiteID, job.ID, job.Dataset, job.Format)
+	if err := s.storage.Put(ctx, key, tmp, size, models.ExportContentType(job.Format)); err != nil {
+		return 0, 0, "", fmt.Errorf("failed to store export: %w", err)
+	}
+
+	return rows, size, key, nil
+}
+
+// GetExportJobs retrieves the export jobs of a website
+func (s *ExportService) GetExportJobs(websiteID uint) ([]models.ExportJob, error) {
+	var jobs []models.ExportJob
+	if err := s.db.Where("website_id = ?", websiteID).
+		Order("created_at DESC").
+		Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// GetExportJob retrieves an export job scoped to a website
+func (s *ExportService) GetExportJob(jobID, websiteID uint) (*models.ExportJob, error) {
+	var job models.ExportJob
+	if err := s.db.Where("id = ? AND website_id = ?", jobID, websiteID).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("export job not found")
+		}
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// OpenExport returns a redirect URL for a finished export, or a reader when the
+// storage backend cannot sign URLs
+func (s *ExportService) OpenExport(ctx context.Context, job *models.ExportJob) (string, io.ReadCloser, error) {
+	if job.Status != models.ExportStatusCompleted {
+		return "", nil, errors.New("export is not ready")
+	}
+
+	expiry := time.Hour
+	if job.ExpiresAt != nil {
+		if time.Now().After(*job.ExpiresAt) {
+			return "", nil, errors.New("export has expired")
+		}
+		expiry = time.Until(*job.ExpiresAt)
+	}
+
+	url, err := s.storage.URL(ctx, job.StorageKey, expiry)
+	if err != nil {
+		return "", nil, err
+	}
+	if url != "" {
+		return url, nil, nil
+	}
+
+	body, err := s.storage.Get(ctx, job.StorageKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "", body, nil
+}
+
+// FailInterruptedJobs marks jobs left running by a previous process as failed
+func (s *ExportService) FailInterruptedJobs() error {
+	return s.db.Model(&models.ExportJob{}).
+		Where("status IN ?", []string{models.ExportStatusPending, models.ExportStatusRunning}).
+		Updates(map[string]interface{}{
+			"status":       models.ExportStatusFailed,
+			"error":        "interrupted by server restart",
+			"completed_at": time.Now(),
+		}).Error
+}
+
+// CleanupExpiredExports deletes stored files of expired export jobs
+func (s *ExportService) CleanupExpiredExports(ctx context.Context) error {
+	var jobs []models.ExportJob
+	if err := s.db.Where("status = ? AND expires_at < ?", models.ExportStatusCompleted, time.Now()).
+		Find(&jobs).Error; err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		if err := s.storage.Delete(ctx, jobs[i].StorageKey); err != nil {
+			return err
+		}
+		if err := s.db.Delete(&jobs[i]).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Column kinds used to encode values per format
+const (
+	exportInt = iota
+	exportOptionalInt
+	exportString
+	exportBool
+	exportTime
+	exportOptionalTime
+	exportJSON
+)
+
+type exportColumn struct {
+	name string
+	kind int
+}
+
+type exportDataset struct {
+	columns []exportColumn
+	// fetch loads the next batch of rows with IDs after the cursor
+	fetch func(db *gorm.DB, job *models.ExportJob, cursor uint, limit int) ([][]interface{}, uint, error)
+}
+
+var exportDatasets = map[string]exportDataset{
+	models.ExportDatasetEvents: {
+		columns: []exportColumn{
+			{"id", exportInt},
+			{"website_id", exportInt},
+			{"event_type", exportString},
+			{"event_data", exportJSON},
+			{"visitor_id", exportString},
+			{"session_id", exportString},
+			{"user_agent", exportString},
+			{"ip", exportString},
+			{"country", exportString},
+			{"city", exportString},
+			{"referrer", exportString},
+			{"created_at", exportTime},
+		},
+		fetch: fetchExportEvents,
+	},
+	models.ExportDatasetChats: {
+		columns: []exportColumn{
+			{"id", exportInt},
+			{"website_id", exportInt},
+			{"session_id", exportString},
+			{"visitor_ip", exportString},
+			{"user_agent", exportString},
+			{"language", exportString},
+			{"is_active", exportBool},
+			{"started_at", exportTime},
+			{"ended_at", exportOptionalTime},
+		},
+		fetch: fetchExportChats,
+	},
+	models.ExportDatasetMessages: {
+		columns: []exportColumn{
+			{"id", exportInt},
+			{"chat_id", exportInt},
+			{"sender", exportString},
+			{"sender_id", exportOptionalInt},
+			{"content", exportString},
+			{"original_content", exportString},
+			{"language", exportString},
+			{"translated", exportBool},
+			{"moderated", exportBool},
+			{"flagged", exportBool},
+			{"timestamp", exportTime},
+		},
+		fetch: fetchExportMessages,
+	},
+	models.ExportDatasetLeads: {
+		columns: []exportColumn{
+			{"id", exportInt},
+			{"website_id", exportInt},
+			{"email", exportString},
+			{"name", exportString},
+			{"external_id", exportString},
+			{"attributes", exportJSON},
+			{"verified", exportBool},
+			{"lead_captured_at", exportOptionalTime},
+			{"consent_given_at", exportOptionalTime},
+			{"consent_text", exportString},
+			{"first_seen_at", exportTime},
+			{"last_seen_at", exportTime},
+		},
+		fetch: fetchExportLeads,
+	},
+}
+
+func fetchExportEvents(db *gorm.DB, job *models.ExportJob, cursor uint, limit int) ([][]interface{}, uint, error) {
+	query := db.Model(&models.Analytics{}).
+		Where("website_id = ? AND created_at BETWEEN ? AND ? AND id > ?", job.WebsiteID, job.StartDate, job.EndDate, cursor)
+
+	if job.Filters.EventType != "" {
+		query = query.Where("event_type = ?", job.Filters.EventType)
+	}
+
+	query, err := filterEventData(query, job.Filters.Properties)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var events []models.Analytics
+	if err := query.Order("id ASC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+
+	records := make([][]interface{}, len(events))
+	var last uint
+	for i, e := range events {
+		records[i] = []interface{}{
+			e.ID, e.WebsiteID, e.EventType, e.EventData, e.VisitorID, e.SessionID,
+			e.UserAgent, e.IP, e.Country, e.City, e.Referrer, e.CreatedAt,
+		}
+		last = e.ID
+	}
+
+	return records, last, nil
+}
+
+func fetchExportChats(db *gorm.DB, job *models.ExportJob, cursor uint, limit int) ([][]interface{}, uint, error) {
+	var chats []models.Chat
+	if err := db.Where("website_id = ? AND started_at BETWEEN ? AND ? AND id > ?", job.WebsiteID, job.StartDate, job.EndDate, cursor).
+		Order("id ASC").
+		Limit(limit).
+		Find(&chats).Error; err != nil {
+		return nil, 0, err
+	}
+
+	records := make([][]interface{}, len(chats))
+	var last uint
+	for i, c := range chats {
+		records[i] = []interface{}{
+			c.ID, c.WebsiteID, c.SessionID, c.VisitorIP, c.UserAgent, c.Language,
+			c.IsActive, c.StartedAt, c.EndedAt,
+		}
+		last = c.ID
+	}
+
+	return records, last, nil
+}
+
+func fetchExportMessages(db *gorm.DB, job *models.ExportJob, cursor uint, limit int) ([][]interface{}, uint, error) {
+	var messages []models.Message
+	if err := db.Select("messages.*").
+		Joins("JOIN chats ON messages.chat_id = chats.id").
+		Where("chats.website_id = ? AND messages.timestamp BETWEEN ? AND ? AND messages.id > ?", job.WebsiteID, job.StartDate, job.EndDate, cursor).
+		Order("messages.id ASC").
+		Limit(limit).
+		Find(&messages).Error; err != nil {
+		return nil, 0, err
+	}
+
+	records := make([][]interface{}, len(messages))
+	var last uint
+	for i, m := range messages {
+		records[i] = []interface{}{
+			m.ID, m.ChatID, m.Sender, m.SenderID, m.Content, m.OriginalContent,
+			m.Language, m.Translated, m.Moderated, m.Flagged, m.Timestamp,
+		}
+		last = m.ID
+	}
+
+	return records, last, nil
+}
+
+func fetchExportLeads(db *gorm.DB, job *models.ExportJob, cursor uint, limit int) ([][]interface{}, uint, error) {
+	var leads []models.Contact
+	if err := db.Where("website_id = ? AND lead_captured_at BETWEEN ? AND ? AND id > ?", job.WebsiteID, job.StartDate, job.EndDate, cursor).
+		Order("id ASC").
+		Limit(limit).
+		Find(&leads).Error; err != nil {
+		return nil, 0, err
+	}
+
+	records := make([][]interface{}, len(leads))
+	var last uint
+	for i, l := range leads {
+		records[i] = []interface{}{
+			l.ID, l.WebsiteID, l.Email, l.Name, l.ExternalID, models.AnalyticsData(l.Attributes),
+			l.Verified, l.LeadCapturedAt, l.ConsentGivenAt, l.ConsentText, l.FirstSeenAt, l.LastSeenAt,
+		}
+		last = l.ID
+	}
+
+	return records, last, nil
+}
+
+// exportEncoder writes export records in a specific file format
+type exportEncoder interface {
+	Write(record []interface{}) error
+	// Flush pushes buffered rows to the underlying writer
+	Flush() error
+	// Close writes any trailing data, such as a Parquet footer
+	Close() error
+}
+
+func newExportEncoder(format string, w io.Writer, columns []exportColumn) (exportEncoder, error) {
+	switch format {
+	case models.ExportFormatCSV:
+		return newCSVExportEncoder(w, columns)
+	case models.ExportFormatNDJSON:
+		return &ndjsonExportEncoder{w: w, columns: columns}, nil
+	case models.ExportFormatParquet:
+		return newParquetExportEncoder(w, columns)
+	default:
+		return nil, fmt.Errorf("invalid format '%s'", format)
+	}
+}
+
+type csvExportEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVExportEncoder(w io.Writer, columns []exportColumn) (*csvExportEncoder, error) {
+	encoder := &csvExportEncoder{w: csv.NewWriter(w)}
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+
+	return encoder, encoder.w.Write(header)
+}
+
+func (e *csvExportEncoder) Write(record []interface{}) error {
+	fields := make([]string, len(record))
+	for i, value := range record {
+		switch v := value.(type) {
+		case string:
+			fields[i] = csvCell(v)
+		case uint:
+			fields[i] = strconv.FormatUint(uint64(v), 10)
+		case *uint:
+			if v != nil {
+				fields[i] = strconv.FormatUint(uint64(*v), 10)
+			}
+		case bool:
+			fields[i] = strconv.FormatBool(v)
+		case time.Time:
+			fields[i] = v.UTC().Format(time.RFC3339Nano)
+		case *time.Time:
+			if v != nil {
+				fields[i] = v.UTC().Format(time.RFC3339Nano)
+			}
+		case models.AnalyticsData:
+			if len(v) > 0 {
+				encoded, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				fields[i] = string(encoded)
+			}
+		default:
+			fields[i] = csvCell(fmt.Sprint(v))
+		}
+	}
+
+	return e.w.Write(fields)
+}
+
+// csvCell quotes a value a spreadsheet would otherwise run as a formula, since
+// exports carry text visitors typed
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (e *csvExportEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExportEncoder) Close() error {
+	return e.Flush()
+}
+
+type ndjsonExportEncoder struct {
+	w       io.Writer
+	columns []exportColumn
+	buf     bytes.Buffer
+}
+
+// Write encodes a record as a JSON object, keeping column order
+func (e *ndjsonExportEncoder) Write(record []interface{}) error {
+	e.buf.WriteByte('{')
+	for i, value := range record {
+		if i > 0 {
+			e.buf.WriteByte(',')
+		}
+
+		name, _ := json.Marshal(e.columns[i].name)
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		e.buf.Write(name)
+		e.buf.WriteByte(':')
+		e.buf.Write(encoded)
+	}
+	e.buf.WriteString("}\n")
+
+	// Keep the buffer small between flushes
+	if e.buf.Len() >= 64*1024 {
+		return e.Flush()
+	}
+	return nil
+}
+
+func (e *ndjsonExportEncoder) Flush() error {
+	_, err := e.buf.WriteTo(e.w)
+	return err
+}
+
+func (e *ndjsonExportEncoder) Close() error {
+	return e.Flush()
+}
+
+type parquetExportEncoder struct {
+	w       *writer.CSVWriter
+	columns []exportColumn
+}
+
+func newParquetExportEncoder(w io.Writer, columns []exportColumn) (*parquetExportEncoder, error) {
+	metadata := make([]string, len(columns))
+	for i, column := range columns {
+		switch column.kind {
+		case exportInt:
+			metadata[i] = fmt.Sprintf("name=%s, type=INT64", column.name)
+		case exportOptionalInt:
+			metadata[i] = fmt.Sprintf("name=%s, type=INT64, repetitiontype=OPTIONAL", column.name)
+		case exportBool:
+			metadata[i] = fmt.Sprintf("name=%s, type=BOOLEAN", column.name)
+		case exportTime:
+			metadata[i] = fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MILLIS", column.name)
+		case exportOptionalTime:
+			metadata[i] = fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL", column.name)
+		default:
+			metadata[i] = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8", column.name)
+		}
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(metadata, w, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bound memory use; each row group is written out once it reaches this size
+	pw.RowGroupSize = 8 * 1024 * 1024
+
+	return &parquetExportEncoder{w: pw, columns: columns}, nil
+}
+
+func (e *parquetExportEncoder) Write(record []interface{}) error {
+	values := make([]interface{}, len(record))
+	for i, value := range record {
+		switch v := value.(type) {
+		case uint:
+			values[i] = int64(v)
+		case *uint:
+			if v != nil {
+				values[i] = int64(*v)
+			}
+		case time.Time:
+			values[i] = v.UnixMilli()
+		case *time.Time:
+			if v != nil {
+				values[i] = v.UnixMilli()
+			}
+		case models.AnalyticsData:
+			encoded := ""
+			if len(v) > 0 {
+				b, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				encoded = string(b)
+			}
+			values[i] = encoded
+		default:
+			values[i] = v
+		}
+	}
+
+	return e.w.Write(values)
+}
+
+// Flush is a no-op; Parquet rows are written out per row group
+func (e *parquetExportEncoder) Flush() error {
+	return nil
+}
+
+func (e *parquetExportEncoder) Close() error {
+	return e.w.WriteStop()
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/storage"
+)
+
+func newTestExportService(t *testing.T) (*ExportService, *models.Website) {
+	t.Helper()
+
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.ExportJob{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	// A small batch size exercises cursor iteration across batches
+	cfg := &config.Config{Export: config.ExportConfig{BatchSize: 2, MaxSyncDays: 7}}
+	service := NewExportService(db, cfg, store)
+
+	base := time.Now().Add(-time.Hour)
+	events := []models.Analytics{
+		{EventType: models.EventTypePageView, VisitorID: "v1", EventData: models.AnalyticsData{"page": "/pricing"}},
+		{EventType: models.EventTypePageView, VisitorID: "v2", EventData: models.AnalyticsData{"page": "/"}},
+		{EventType: models.EventTypeChatStart, VisitorID: "v1"},
+		{EventType: models.EventTypePageView, VisitorID: "v3", EventData: models.AnalyticsData{"page": "/pricing"}},
+		{EventType: models.EventTypeConversion, VisitorID: "v1", EventData: models.AnalyticsData{"value": 10.0}},
+	}
+	for i := range events {
+		events[i].WebsiteID = website.ID
+		events[i].CreatedAt = base.Add(time.Duration(i) * time.Second)
+	}
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatalf("failed to create events: %v", err)
+	}
+
+	chat := &models.Chat{WebsiteID: website.ID, SessionID: "s1", StartedAt: base}
+	if err := db.Create(chat).Error; err != nil {
+		t.Fatalf("failed to create chat: %v", err)
+	}
+	messages := []models.Message{
+		{ChatID: chat.ID, Content: "Hi, \"quoted\", text", Sender: models.SenderUser, Timestamp: base},
+		{ChatID: chat.ID, Content: "Hello!", Sender: models.SenderBot, Timestamp: base.Add(time.Second)},
+	}
+	if err := db.Create(&messages).Error; err != nil {
+		t.Fatalf("failed to create messages: %v", err)
+	}
+
+	return service, website
+}
+
+func newTestExportJob(website *models.Website, dataset, format string) *models.ExportJob {
+	return &models.ExportJob{
+		WebsiteID: website.ID,
+		UserID:    website.UserID,
+		Dataset:   dataset,
+		Format:    format,
+		StartDate: time.Now().Add(-2 * time.Hour),
+		EndDate:   time.Now().Add(time.Hour),
+	}
+}
+
+func TestExportService_StreamExport(t *testing.T) {
+	service, website := newTestExportService(t)
+	ctx := context.Background()
+
+	// CSV events filtered by type and property
+	job := newTestExportJob(website, models.ExportDatasetEvents, models.ExportFormatCSV)
+	job.Filters = models.ExportFilters{EventType: models.EventTypePageView, Properties: map[string]string{"page": "/pricing"}}
+	if err := service.ValidateExport(job); err != nil {
+		t.Fatalf("ValidateExport() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	flushes := 0
+	rows, err := service.StreamExport(ctx, &buf, job, func() { flushes++ })
+	if err != nil {
+		t.Fatalf("StreamExport() error = %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse CSV: %v", err)
+	}
+	if rows != 2 || len(records) != 3 || records[0][2] != "event_type" || records[1][3] != `{"page":"/pricing"}` {
+		t.Errorf("unexpected CSV export (%d rows): %v", rows, records)
+	}
+	if flushes < 2 {
+		t.Errorf("StreamExport() flushed %d times, want one per batch", flushes)
+	}
+
+	// NDJSON messages keep column order and escape content
+	buf.Reset()
+	job = newTestExportJob(website, models.ExportDatasetMessages, models.ExportFormatNDJSON)
+	if rows, err = service.StreamExport(ctx, &buf, job, nil); err != nil || rows != 2 {
+		t.Fatalf("StreamExport() = %d, %v", rows, err)
+	}
+	scanner := bufio.NewScanner(&buf)
+	var lines []map[string]interface{}
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[0]["content"] != "Hi, \"quoted\", text" || lines[1]["sender"] != models.SenderBot {
+		t.Errorf("unexpected NDJSON export: %v", lines)
+	}
+
+	// Parquet chats produce a complete file
+	buf.Reset()
+	job = newTestExportJob(website, models.ExportDatasetChats, models.ExportFormatParquet)
+	if rows, err = service.StreamExport(ctx, &buf, job, nil); err != nil || rows != 1 {
+		t.Fatalf("StreamExport() = %d, %v", rows, err)
+	}
+	data := buf.Bytes()
+	if len(data) < 8 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Errorf("parquet export is missing magic bytes")
+	}
+}
+
+func TestExportService_StreamExportQuotesFormulas(t *testing.T) {
+	service, website := newTestExportService(t)
+
+	chat := &models.Chat{WebsiteID: website.ID, SessionID: "s2", StartedAt: time.Now().Add(-time.Minute)}
+	if err := service.db.Create(chat).Error; err != nil {
+		t.Fatalf("failed to create chat: %v", err)
+	}
+	contents := []string{"=HYPERLINK(\"http://evil.test\")", "+1", "-2+3", "@SUM(A1)", "\tx", "\rx", "a=b"}
+	for i, content := range contents {
+		message := &models.Message{ChatID: chat.ID, Content: content, Sender: models.SenderUser, Timestamp: chat.StartedAt.Add(time.Duration(i) * time.Second)}
+		if err := service.db.Create(message).Error; err != nil {
+			t.Fatalf("failed to create message: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	job := newTestExportJob(website, models.ExportDatasetMessages, models.ExportFormatCSV)
+	if _, err := service.StreamExport(context.Background(), &buf, job, nil); err != nil {
+		t.Fatalf("StreamExport() error = %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse CSV: %v", err)
+	}
+
+	column := -1
+	for i, name := range records[0] {
+		if name == "content" {
+			column = i
+		}
+	}
+	exported := make(map[string]bool)
+	for _, record := range records[1:] {
+		exported[record[column]] = true
+	}
+	for _, content := range contents[:6] {
+		if !exported["'"+content] {
+			t.Errorf("expected %q to be exported as text, got %v", content, exported)
+		}
+	}
+	if !exported["a=b"] {
+		t.Errorf("expected %q to be exported as is", "a=b")
+	}
+}
+
+func TestExportService_ValidateExport(t *testing.T) {
+	service, website := newTestExportService(t)
+
+	job := newTestExportJob(website, models.ExportDatasetChats, models.ExportFormatCSV)
+	job.Filters = models.ExportFilters{EventType: models.EventTypePageView}
+	if err := service.ValidateExport(job); err == nil {
+		t.Error("ValidateExport() with event filters on chats should fail")
+	}
+
+	job = newTestExportJob(website, models.ExportDatasetEvents, models.ExportFormatCSV)
+	job.Filters = models.ExportFilters{Properties: map[string]string{"a b": "x"}}
+	if err := service.ValidateExport(job); err == nil {
+		t.Error("ValidateExport() with an invalid property key should fail")
+	}
+
+	if !service.IsSyncExport(job.StartDate, job.StartDate.AddDate(0, 0, 7)) || service.IsSyncExport(job.StartDate, job.StartDate.AddDate(0, 0, 8)) {
+		t.Error("IsSyncExport() should allow up to MaxSyncDays")
+	}
+}
+
+func TestExportService_RunExportJob(t *testing.T) {
+	service, website := newTestExportService(t)
+	ctx := context.Background()
+
+	job := newTestExportJob(website, models.ExportDatasetEvents, models.ExportFormatNDJSON)
+	job.Status = models.ExportStatusPending
+	if err := service.db.Create(job).Error; err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	if err := service.RunExportJob(ctx, job.ID); err != nil {
+		t.Fatalf("RunExportJob() error = %v", err)
+	}
+
+	job, err := service.GetExportJob(job.ID, website.ID)
+	if err != nil {
+		t.Fatalf("GetExportJob() error = %v", err)
+	}
+	if job.Status != models.ExportStatusCompleted || job.RowCount != 5 || job.FileSize == 0 || job.ExpiresAt == nil {
+		t.Fatalf("unexpected job after run: %+v", job)
+	}
+
+	url, body, err := service.OpenExport(ctx, job)
+	if err != nil || url != "" {
+		t.Fatalf("OpenExport() = %q, %v", url, err)
+	}
+	defer body.Close()
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+	if int64(len(content)) != job.FileSize || bytes.Count(content, []byte("\n")) != 5 {
+		t.Errorf("stored export has %d bytes and %d lines", len(content), bytes.Count(content, []byte("\n")))
+	}
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// FunnelService handles funnel and conversion attribution business logic
+type FunnelService struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+// NewFunnelService creates a new FunnelService
+func NewFunnelService(db *gorm.DB, cfg *config.Config) *FunnelService {
+	return &FunnelService{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+// CreateFunnel creates a new funnel for a website
+func (s *FunnelService) CreateFunnel(websiteID uint, req *models.FunnelCreateRequest) (*models.Funnel, error) {
+	funnel := &models.Funnel{
+		WebsiteID: websiteID,
+		Name:      req.Name,
+		Steps:     req.Steps,
+	}
+
+	// Save to database (BeforeSave hook validates steps)
+	if err := s.db.Create(funnel).Error; err != nil {
+		return nil, fmt.Errorf("failed to create funnel: %w", err)
+	}
+
+	return funnel, nil
+}
+
+// GetFunnelsByWebsiteID retrieves all funnels for a website
+func (s *FunnelService) GetFunnelsByWebsiteID(websiteID uint) ([]models.Funnel, error) {
+	var funnels []models.Funnel
+	if err := s.db.Where("website_id = ?", websiteID).
+		Order("created_at DESC").
+		Find(&funnels).Error; err != nil {
+		return nil, err
+	}
+
+	return funnels, nil
+}
+
+// GetFunnelByID retrieves a funnel by ID scoped to a website
+func (s *FunnelService) GetFunnelByID(funnelID, websiteID uint) (*models.Funnel, error) {
+	var funnel models.Funnel
+	if err := s.db.Where("id = ? AND website_id = ?", funnelID, websiteID).First(&funnel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("funnel not found")
+		}
+		return nil, err
+	}
+
+	return &funnel, nil
+}
+
+// UpdateFunnel updates a funnel's name or steps
+func (s *FunnelService) UpdateFunnel(funnelID, websiteID uint, req *models.FunnelUpdateRequest) (*models.Funnel, error) {
+	funnel, err := s.GetFunnelByID(funnelID, websiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != "" {
+		funnel.Name = req.Name
+	}
+
+	if len(req.Steps) > 0 {
+		funnel.Steps = req.Steps
+	}
+
+	if err := s.db.Save(funnel).Error; err != nil {
+		return nil, fmt.Errorf("failed to update funnel: %w", err)
+	}
+
+	return funnel, nil
+}
+
+// DeleteFunnel deletes a funnel
+func (s *FunnelService) DeleteFunnel(funnelID, websiteID uint) error {
+	funnel, err := s.GetFunnelByID(funnelID, websiteID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.Delete(funnel).Error; err != nil {
+		return fmt.Errorf("failed to delete funnel: %w", err)
+	}
+
+	return nil
+}
+
+// GetFunnelReport computes step-by-step drop-off for a funnel over a date range.
+// A visitor reaches a step only after reaching every previous step in order.
+func (s *FunnelService) GetFunnelReport(funnel *models.Funnel, startDate, endDate time.Time) (*models.FunnelReport, error) {
+	report := &models.FunnelReport{
+		FunnelID:  funnel.ID,
+		Name:      funnel.Name,
+		StartDate: startDate,
+		EndDate:   endDate,
+	}
+
+	eventTypes := make([]string, 0, len(funnel.Steps))
+	for _, step := range funnel.Steps {
+		eventTypes = append(eventTypes, step.EventType)
+	}
+
+	rows, err := s.db.Model(&models.Analytics{}).
+		Select("visitor_id, session_id, event_type, event_data").
+		Where("website_id = ? AND event_type IN ? AND created_at BETWEEN ? AND ?", funnel.WebsiteID, eventTypes, startDate, endDate).
+		Order("created_at ASC, id ASC").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Walk each visitor's events in order, advancing through the funnel
+	progress := make(map[string]int)
+	reached := make([]int64, len(funnel.Steps))
+	for rows.Next() {
+		var event models.Analytics
+		if err := s.db.ScanRows(rows, &event); err != nil {
+			return nil, err
+		}
+
+		visitor := visitorKey(event.VisitorID, event.SessionID)
+		if visitor == "" {
+			continue
+		}
+
+		next := progress[visitor]
+		if next >= len(funnel.Steps) {
+			continue
+		}
+
+		if funnel.Steps[next].Matches(event.EventType, event.EventData) {
+			reached[next]++
+			progress[visitor] = next + 1
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report.Steps = make([]models.FunnelStepResult, len(funnel.Steps))
+	for i, step := range funnel.Steps {
+		result := models.FunnelStepResult{
+			Name:      step.Name,
+			EventType: step.EventType,
+			Visitors:  reached[i],
+		}
+
+		if reached[0] > 0 {
+			result.ConversionRate = float64(reached[i]) / float64(reached[0]) * 100
+		}
+
+		if i > 0 {
+			result.DropOff = reached[i-1] - reached[i]
+			if reached[i-1] > 0 {
+				result.DropOffRate = float64(result.DropOff) / float64(reached[i-1]) * 100
+			}
+		}
+
+		report.Steps[i] = result
+	}
+
+	attribution, err := conversionAttribution(s.db, funnel.WebsiteID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	report.Attribution = *attribution
+
+	return report, nil
+}
+
+// conversionAttribution reports whether converting visitors chatted before their first
+// conversion. Chats are linked to visitors by visitor ID, or by session ID when the
+// chat event carried no visitor ID.
+func conversionAttribution(db *gorm.DB, websiteID uint, startDate, endDate time.Time) (*models.ConversionAttribution, error) {
+	attribution := &models.ConversionAttribution{}
+
+	if err := db.Model(&models.Analytics{}).
+		Select("COUNT(DISTINCT CASE WHEN visitor_id <> '' THEN 'v:' || visitor_id ELSE 's:' || session_id END)").
+		Where("website_id = ? AND event_type = ? AND created_at BETWEEN ? AND ?", websiteID, models.EventTypeChatStart, startDate, endDate).
+		Where("visitor_id <> '' OR session_id <> ''").
+		Scan(&attribution.ChattingVisitors).Error; err != nil {
+		return nil, err
+	}
+
+	// Each conversion, and whether its visitor had chatted by then
+	chattedBefore := db.Table("analytics AS chats").
+		Select("1").
+		Where("chats.website_id = conversions.website_id AND chats.event_type = ? AND chats.created_at >= ? AND chats.created_at <= conversions.created_at", models.EventTypeChatStart, startDate).
+		Where("(conversions.visitor_id <> '' AND chats.visitor_id = conversions.visitor_id) OR (conversions.session_id <> '' AND chats.session_id = conversions.session_id)").
+		Where("chats.deleted_at IS NULL")
+	rows, err := db.Table("analytics AS conversions").
+		Select("conversions.visitor_id, conversions.session_id, conversions.event_data, EXISTS (?) AS chatted", chattedBefore).
+		Where("conversions.website_id = ? AND conversions.event_type = ? AND conversions.created_at BETWEEN ? AND ?", websiteID, models.EventTypeConversion, startDate, endDate).
+		Where("conversions.deleted_at IS NULL").
+		Order("conversions.created_at ASC, conversions.id ASC").
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Attribution is decided by each visitor's first conversion
+	chattedFirst := make(map[string]bool)
+	for rows.Next() {
+		var conversion struct {
+			VisitorID string
+			SessionID string
+			EventData models.AnalyticsData
+			Chatted   bool
+		}
+		if err := db.ScanRows(rows, &conversion); err != nil {
+			return nil, err
+		}
+
+		value := conversion.EventData.ConversionValue()
+		attribution.Revenue += value
+
+		visitor := visitorKey(conversion.VisitorID, conversion.SessionID)
+		if visitor == "" {
+			continue
+		}
+
+		chatted, ok := chattedFirst[visitor]
+		if !ok {
+			chatted = conversion.Chatted
+			chattedFirst[visitor] = chatted
+			attribution.ConvertingVisitors++
+			if chatted {
+				attribution.ChattedFirst++
+			}
+		}
+
+		if chatted {
+			attribution.ChatRevenue += value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	attribution.NotChatted = attribution.ConvertingVisitors - attribution.ChattedFirst
+
+	if attribution.ChattingVisitors > 0 {
+		attribution.ChatToConversion = float64(attribution.ChattedFirst) / float64(attribution.ChattingVisitors) * 100
+	}
+
+	return attribution, nil
+}
+
+// visitorKey identifies a visitor by visitor ID, falling back to the session ID
+func visitorKey(visitorID, sessionID string) string {
+	if visitorID != "" {
+		return "v:" + visitorID
+	}
+	if sessionID != "" {
+		return "s:" + sessionID
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+)
+
+func TestFunnelService_GetFunnelReport(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.Funnel{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	service := NewFunnelService(db, &config.Config{})
+
+	funnel, err := service.CreateFunnel(website.ID, &models.FunnelCreateRequest{
+		Name: "Checkout",
+		Steps: []models.FunnelStep{
+			{EventType: models.EventTypeWidgetOpen},
+			{EventType: models.EventTypeChatStart},
+			{Name: "Pricing email", EventType: models.EventTypeEmailCapture, Filters: map[string]interface{}{"form": "pricing"}},
+			{EventType: models.EventTypeConversion},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateFunnel() error = %v", err)
+	}
+
+	base := time.Now().Add(-time.Hour)
+	events := []models.Analytics{
+		// v1 completes the funnel after chatting
+		{EventType: models.EventTypeWidgetOpen, VisitorID: "v1"},
+		{EventType: models.EventTypeChatStart, VisitorID: "v1"},
+		{EventType: models.EventTypeEmailCapture, VisitorID: "v1", EventData: models.AnalyticsData{"form": "pricing"}},
+		{EventType: models.EventTypeConversion, VisitorID: "v1", EventData: models.AnalyticsData{"value": 50.0}},
+		// v2 chats but captures the wrong form
+		{EventType: models.EventTypeWidgetOpen, VisitorID: "v2"},
+		{EventType: models.EventTypeChatStart, VisitorID: "v2"},
+		{EventType: models.EventTypeEmailCapture, VisitorID: "v2", EventData: models.AnalyticsData{"form": "newsletter"}},
+		// v3 only opens the widget
+		{EventType: models.EventTypeWidgetOpen, VisitorID: "v3"},
+		// v4 converts without chatting; the chat is linked by session only
+		{EventType: models.EventTypeConversion, VisitorID: "v4", SessionID: "s4", EventData: models.AnalyticsData{"value": "20"}},
+		{EventType: models.EventTypeChatStart, SessionID: "s4"},
+	}
+	for i := range events {
+		events[i].WebsiteID = website.ID
+		events[i].CreatedAt = base.Add(time.Duration(i) * time.Second)
+	}
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatalf("failed to create events: %v", err)
+	}
+
+	report, err := service.GetFunnelReport(funnel, base.Add(-time.Minute), time.Now())
+	if err != nil {
+		t.Fatalf("GetFunnelReport() error = %v", err)
+	}
+
+	want := []int64{3, 2, 1, 1}
+	for i, step := range report.Steps {
+		if step.Visitors != want[i] {
+			t.Errorf("step %d (%s) visitors = %d, want %d", i, step.Name, step.Visitors, want[i])
+		}
+	}
+	if report.Steps[2].Name != "Pricing email" || report.Steps[1].Name != models.EventTypeChatStart {
+		t.Errorf("unexpected step names: %+v", report.Steps)
+	}
+	if report.Steps[1].DropOff != 1 || report.Steps[2].DropOffRate != 50 {
+		t.Errorf("unexpected drop-off: %+v", report.Steps)
+	}
+
+	attribution := report.Attribution
+	if attribution.ConvertingVisitors != 2 || attribution.ChattedFirst != 1 || attribution.NotChatted != 1 {
+		t.Errorf("unexpected attribution: %+v", attribution)
+	}
+	if attribution.Revenue != 70 || attribution.ChatRevenue != 50 {
+		t.Errorf("revenue = %v (chat %v), want 70 (chat 50)", attribution.Revenue, attribution.ChatRevenue)
+	}
+}
+
+func TestFunnelService_CreateFunnelValidation(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.Funnel{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	service := NewFunnelService(db, &config.Config{})
+
+	_, err := service.CreateFunnel(website.ID, &models.FunnelCreateRequest{
+		Name:  "Too short",
+		Steps: []models.FunnelStep{{EventType: models.EventTypeWidgetOpen}},
+	})
+	if err == nil {
+		t.Error("CreateFunnel() with one step should fail")
+	}
+
+	_, err = service.CreateFunnel(website.ID, &models.FunnelCreateRequest{
+		Name:  "Unknown event",
+		Steps: []models.FunnelStep{{EventType: models.EventTypeWidgetOpen}, {EventType: "nope"}},
+	})
+	if err == nil {
+		t.Error("CreateFunnel() with an unknown event type should fail")
+	}
+}
+
+func TestFunnelService_CreateFunnelWithCustomEvent(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.Funnel{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	service := NewFunnelService(db, &config.Config{})
+
+	steps := []models.FunnelStep{{EventType: models.EventTypeChatStart}, {EventType: "trial_started"}}
+	if _, err := service.CreateFunnel(website.ID, &models.FunnelCreateRequest{Name: "Trial", Steps: steps}); err == nil {
+		t.Fatal("CreateFunnel() with an undefined custom event should fail")
+	}
+
+	analytics := NewAnalyticsService(db, &config.Config{})
+	if _, err := analytics.CreateEventDefinition(website.ID, &models.EventDefinitionCreateRequest{Name: "trial_started"}); err != nil {
+		t.Fatalf("CreateEventDefinition() error = %v", err)
+	}
+
+	if _, err := service.CreateFunnel(website.ID, &models.FunnelCreateRequest{Name: "Trial", Steps: steps}); err != nil {
+		t.Errorf("CreateFunnel() with a defined custom event error = %v", err)
+	}
+}
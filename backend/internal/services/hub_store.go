@@ -0,0 +1,166 @@
+package services
+
+import (
+	"errors"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/websocket"
+
+	"gorm.io/gorm"
+)
+
+// HubMessageStore persists the messages and receipts visitors and agents send
+// over the websocket hub
+type HubMessageStore struct {
+	db          *gorm.DB
+	chatService *ChatService
+}
+
+// NewHubMessageStore creates a new HubMessageStore
+func NewHubMessageStore(db *gorm.DB, cfg *config.Config) *HubMessageStore {
+	return &HubMessageStore{
+		db:          db,
+		chatService: NewChatService(db, cfg),
+	}
+}
+
+// SaveVisitorMessage stores a message from the visitor of a widget session
+func (s *HubMessageStore) SaveVisitorMessage(websiteID uint, sessionID, clientMessageID, content string) (*websocket.StoredMessage, error) {
+	chat, err := s.chatService.GetChatBySession(websiteID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, duplicate, err := s.chatService.SaveClientMessage(chat.ID, clientMessageID, content, models.SenderUser, nil, chat.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	return storedMessage(chat, message, duplicate), nil
+}
+
+// SaveAgentMessage stores an agent's reply in a chat they have access to
+func (s *HubMessageStore) SaveAgentMessage(userID, chatID uint, clientMessageID, content string) (*websocket.StoredMessage, error) {
+	chat, err := s.agentChat(userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if !chat.IsActive {
+		return nil, errors.New("chat is not active")
+	}
+
+	message, duplicate, err := s.chatService.SaveClientMessage(chat.ID, clientMessageID, content, models.SenderAgent, &userID, chat.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	return storedMessage(chat, message, duplicate), nil
+}
+
+// EditAgentMessage changes the text of a message the agent sent
+func (s *HubMessageStore) EditAgentMessage(userID, chatID, messageID uint, content string) (*websocket.StoredMessage, error) {
+	chat, err := s.agentChat(userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := s.chatService.EditMessage(chat.ID, messageID, userID, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return storedMessage(chat, message, false), nil
+}
+
+// DeleteAgentMessage deletes a message the agent sent
+func (s *HubMessageStore) DeleteAgentMessage(userID, chatID, messageID uint) (*websocket.StoredMessage, error) {
+	chat, err := s.agentChat(userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := s.chatService.DeleteMessage(chat.ID, messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return storedMessage(chat, message, false), nil
+}
+
+// MarkVisitorReceipt marks the team's messages in a visitor's chat delivered or read
+func (s *HubMessageStore) MarkVisitorReceipt(websiteID uint, sessionID, status string, upToID uint) (*websocket.Receipt, error) {
+	chat, err := s.chatService.GetChatBySession(websiteID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.markReceipt(chat, true, status, upToID)
+}
+
+// MarkAgentReceipt marks a visitor's messages delivered to or read by an agent
+func (s *HubMessageStore) MarkAgentReceipt(userID, chatID uint, status string, upToID uint) (*websocket.Receipt, error) {
+	chat, err := s.agentChat(userID, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.markReceipt(chat, false, status, upToID)
+}
+
+// VisitorHistory returns the latest messages of a visitor's chat
+func (s *HubMessageStore) VisitorHistory(websiteID uint, sessionID string, limit int) ([]map[string]interface{}, error) {
+	chat, err := s.chatService.GetChatBySession(websiteID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.chatService.GetChatHistory(chat.ID, limit)
+}
+
+func (s *HubMessageStore) markReceipt(chat *models.Chat, byVisitor bool, status string, upToID uint) (*websocket.Receipt, error) {
+	ids, at, err := s.chatService.MarkMessages(chat.ID, byVisitor, status, upToID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &websocket.Receipt{
+		ChatID:     chat.ID,
+		SessionID:  chat.SessionID,
+		AgentID:    chat.AssignedAgentID,
+		Status:     status,
+		MessageIDs: ids,
+		At:         at,
+	}, nil
+}
+
+// agentChat loads a chat the agent has access to
+func (s *HubMessageStore) agentChat(userID, chatID uint) (*models.Chat, error) {
+	if err := s.chatService.ValidateChatAccess(chatID, userID); err != nil {
+		return nil, err
+	}
+
+	var chat models.Chat
+	if err := s.db.First(&chat, chatID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("chat not found")
+		}
+		return nil, err
+	}
+
+	return &chat, nil
+}
+
+func storedMessage(chat *models.Chat, message *models.Message, duplicate bool) *websocket.StoredMessage {
+	return &websocket.StoredMessage{
+		ID:        message.ID,
+		ChatID:    chat.ID,
+		SessionID: chat.SessionID,
+		AgentID:   chat.AssignedAgentID,
+		Sender:    message.Sender,
+		Content:   message.Content,
+		Timestamp: message.Timestamp,
+		EditedAt:  message.EditedAt,
+		Duplicate: duplicate,
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"testing"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+)
+
+func TestHubMessageStore_MessagesAndReceipts(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.WebsiteAgent{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	chat := createTestChat(t, db, website.ID, "")
+	store := NewHubMessageStore(db, &config.Config{})
+	agentID := website.UserID
+
+	visitor, err := store.SaveVisitorMessage(website.ID, chat.SessionID, "c-1", "  Hello?  ")
+	if err != nil {
+		t.Fatalf("SaveVisitorMessage() error = %v", err)
+	}
+	if visitor.ID == 0 || visitor.Duplicate || visitor.Content != "Hello?" || visitor.ChatID != chat.ID {
+		t.Fatalf("unexpected stored message %+v", visitor)
+	}
+
+	// Resending the same client message ID returns the stored message
+	resent, err := store.SaveVisitorMessage(website.ID, chat.SessionID, "c-1", "Hello?")
+	if err != nil {
+		t.Fatalf("SaveVisitorMessage() error = %v", err)
+	}
+	if !resent.Duplicate || resent.ID != visitor.ID {
+		t.Errorf("expected the resend to return message %d, got %+v", visitor.ID, resent)
+	}
+	var count int64
+	db.Model(&models.Message{}).Where("chat_id = ?", chat.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("expected one stored message, got %d", count)
+	}
+
+	if _, err := store.SaveVisitorMessage(website.ID, chat.SessionID, "bad id!", "Hi"); err == nil {
+		t.Errorf("expected an invalid client message ID to be rejected")
+	}
+	if _, err := store.SaveVisitorMessage(website.ID, "unknown", "", "Hi"); err == nil || err.Error() != "chat not found" {
+		t.Errorf("SaveVisitorMessage() error = %v, want chat not found", err)
+	}
+	if _, err := store.SaveVisitorMessage(website.ID, chat.SessionID, "", "   "); err == nil {
+		t.Errorf("expected an empty message to be rejected")
+	}
+
+	reply, err := store.SaveAgentMessage(agentID, chat.ID, "a-1", "Hi, how can I help?")
+	if err != nil {
+		t.Fatalf("SaveAgentMessage() error = %v", err)
+	}
+	if reply.Sender != models.SenderAgent || reply.SessionID != chat.SessionID {
+		t.Fatalf("unexpected agent message %+v", reply)
+	}
+	if _, err := store.SaveAgentMessage(agentID+1, chat.ID, "", "Hi"); err == nil {
+		t.Errorf("expected agents without access to be rejected")
+	}
+
+	// The visitor reads the reply, which also marks it delivered
+	receipt, err := store.MarkVisitorReceipt(website.ID, chat.SessionID, models.ReceiptRead, reply.ID)
+	if err != nil {
+		t.Fatalf("MarkVisitorReceipt() error = %v", err)
+	}
+	if len(receipt.MessageIDs) != 1 || receipt.MessageIDs[0] != reply.ID {
+		t.Errorf("expected only the agent reply to be marked, got %v", receipt.MessageIDs)
+	}
+	var stored models.Message
+	db.First(&stored, reply.ID)
+	if stored.ReadAt == nil || stored.DeliveredAt == nil {
+		t.Errorf("expected the reply to be read and delivered, got %+v", stored)
+	}
+	receipt, err = store.MarkVisitorReceipt(website.ID, chat.SessionID, models.ReceiptDelivered, reply.ID)
+	if err != nil || len(receipt.MessageIDs) != 0 {
+		t.Errorf("expected nothing left to mark delivered, got %v, %v", receipt, err)
+	}
+
+	receipt, err = store.MarkAgentReceipt(agentID, chat.ID, models.ReceiptDelivered, reply.ID)
+	if err != nil {
+		t.Fatalf("MarkAgentReceipt() error = %v", err)
+	}
+	if len(receipt.MessageIDs) != 1 || receipt.MessageIDs[0] != visitor.ID || receipt.SessionID != chat.SessionID {
+		t.Errorf("expected the visitor message to be marked, got %+v", receipt)
+	}
+	if _, err := store.MarkAgentReceipt(agentID, chat.ID, "seen", reply.ID); err == nil {
+		t.Errorf("expected an invalid receipt status to be rejected")
+	}
+
+	edited, err := store.EditAgentMessage(agentID, chat.ID, reply.ID, "Hi! How can I help?")
+	if err != nil {
+		t.Fatalf("EditAgentMessage() error = %v", err)
+	}
+	if edited.Content != "Hi! How can I help?" || edited.EditedAt == nil {
+		t.Errorf("unexpected edited message %+v", edited)
+	}
+	if _, err := store.EditAgentMessage(agentID, chat.ID, visitor.ID, "changed"); err == nil || err.Error() != "only the author can change a message" {
+		t.Errorf("EditAgentMessage() error = %v, want only the author can change a message", err)
+	}
+
+	if _, err := store.DeleteAgentMessage(agentID, chat.ID, reply.ID); err != nil {
+		t.Fatalf("DeleteAgentMessage() error = %v", err)
+	}
+	if err := db.First(&models.Message{}, reply.ID).Error; err == nil {
+		t.Errorf("expected the deleted message to be hidden")
+	}
+	if err := db.Unscoped().First(&stored, reply.ID).Error; err != nil || !stored.DeletedAt.Valid {
+		t.Errorf("expected the message to be soft deleted, got %v", err)
+	}
+	if _, err := store.DeleteAgentMessage(agentID, chat.ID, reply.ID); err == nil || err.Error() != "message not found" {
+		t.Errorf("DeleteAgentMessage() error = %v, want message not found", err)
+	}
+
+	// A late resend of a deleted message does not bring it back
+	resent, err = store.SaveAgentMessage(agentID, chat.ID, "a-1", "Hi, how can I help?")
+	if err != nil || !resent.Duplicate {
+		t.Errorf("expected the resend to be a duplicate, got %+v, %v", resent, err)
+	}
+}
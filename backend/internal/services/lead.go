@@ -0,0 +1,179 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// LeadService handles pre-chat forms and the leads they capture
+type LeadService struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+// NewLeadService creates a new LeadService
+func NewLeadService(db *gorm.DB, cfg *config.Config) *LeadService {
+	return &LeadService{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+// SubmitPreChatForm validates a pre-chat form submission and starts the chat
+// it belongs to. Values are stored on the chat; email, name and short answers
+// also fill in the visitor's contact. Leaving an email records an
+// email_capture event.
+func (s *LeadService) SubmitPreChatForm(website *models.Website, req *models.PreChatSubmission, visitorIP, userAgent, language, referrer string) (*models.Chat, error) {
+	form := website.Settings.PreChatForm
+	if !form.Enabled {
+		return nil, errors.New("pre-chat form is not enabled")
+	}
+
+	values, err := models.ValidatePreChatSubmission(form, req.Values, req.Consent)
+	if err != nil {
+		return nil, err
+	}
+
+	chat, err := NewChatService(s.db, s.cfg).CreateOrGetChat(website.ID, req.SessionID, req.VisitorID, visitorIP, userAgent, language)
+	if err != nil {
+		return nil, err
+	}
+	if chat.PreChatSubmittedAt != nil {
+		return nil, errors.New("pre-chat form already submitted")
+	}
+
+	now := time.Now()
+	chat.PreChatData = values
+	chat.PreChatSubmittedAt = &now
+
+	var contact *models.Contact
+	if chat.ContactID != nil {
+		if contact, err = s.updateContact(website.ID, req.VisitorID, *chat.ContactID, form, values, req.Consent, now); err != nil {
+			return nil, err
+		}
+		chat.ContactID = &contact.ID
+	}
+
+	if err := s.db.Model(chat).Updates(map[string]interface{}{
+		"pre_chat_data":         chat.PreChatData,
+		"pre_chat_submitted_at": chat.PreChatSubmittedAt,
+		"contact_id":            chat.ContactID,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to save pre-chat form: %w", err)
+	}
+
+	// The email itself stays on the contact rather than in analytics
+	if values["email"] != "" {
+		eventData := models.AnalyticsData{
+			"source":  "pre_chat_form",
+			"chat_id": chat.ID,
+			"consent": req.Consent,
+		}
+		if contact != nil {
+			eventData["contact_id"] = contact.ID
+		}
+		if err := NewAnalyticsService(s.db, s.cfg).TrackEvent(website.ID, models.EventTypeEmailCapture, eventData,
+			req.VisitorID, req.SessionID, userAgent, visitorIP, referrer); err != nil {
+			return nil, fmt.Errorf("failed to track email capture: %w", err)
+		}
+	}
+
+	return chat, nil
+}
+
+// updateContact stores the submission on the visitor's contact. The email
+// identifies the visitor the same way an unverified identify call does;
+// verified contacts keep their identity and only record consent.
+func (s *LeadService) updateContact(websiteID uint, visitorID string, contactID uint, form models.PreChatForm, values models.PreChatValues, consent bool, now time.Time) (*models.Contact, error) {
+	var contact models.Contact
+	if err := s.db.First(&contact, contactID).Error; err != nil {
+		return nil, err
+	}
+
+	// Short answers become attributes; free text stays on the chat
+	attributes := make(map[string]interface{})
+	for _, field := range form.Fields {
+		value, ok := values[field.Name]
+		if !ok || field.Name == "email" || field.Name == "name" || field.Type == models.PreChatFieldTextarea {
+			continue
+		}
+		if len(value) <= models.MaxAttributeValueLength {
+			attributes[field.Name] = value
+		}
+	}
+
+	email := values["email"]
+	if email != "" && !contact.Verified && visitorID != "" {
+		identified, err := NewContactService(s.db, s.cfg).Identify(websiteID, &models.ContactIdentifyRequest{
+			VisitorID:  visitorID,
+			Email:      email,
+			Name:       values["name"],
+			Attributes: attributes,
+		})
+		switch {
+		case err == nil:
+			contact = *identified
+			attributes = nil
+		case err.Error() != "identity hash is required":
+			return nil, err
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if len(attributes) > 0 && !contact.Verified {
+		updates["attributes"] = mergeAttributes(contact.Attributes, attributes)
+	}
+	if !contact.Verified && contact.Name == "" && values["name"] != "" {
+		updates["name"] = strings.TrimSpace(values["name"])
+	}
+	if email != "" && contact.LeadCapturedAt == nil {
+		updates["lead_captured_at"] = now
+	}
+	if consent && form.ConsentText != "" {
+		updates["consent_given_at"] = now
+		updates["consent_text"] = form.ConsentText
+	}
+	if len(updates) > 0 {
+		if err := s.db.Model(&contact).Updates(updates).Error; err != nil {
+			return nil, fmt.Errorf("failed to update contact: %w", err)
+		}
+	}
+
+	return &contact, nil
+}
+
+// GetLeads retrieves contacts that left an email in a form, newest first.
+// Either date may be nil for an open range.
+func (s *LeadService) GetLeads(websiteID uint, startDate, endDate *time.Time, page, limit int) ([]models.Contact, int64, error) {
+	var leads []models.Contact
+	var total int64
+
+	query := s.db.Model(&models.Contact{}).Where("website_id = ? AND lead_captured_at IS NOT NULL", websiteID)
+	if startDate != nil {
+		query = query.Where("lead_captured_at >= ?", *startDate)
+	}
+	if endDate != nil {
+		query = query.Where("lead_captured_at <= ?", *endDate)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	if err := query.Order("lead_captured_at DESC, id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&leads).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return leads, total, nil
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+)
+
+func TestLeadService_SubmitPreChatForm(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.Contact{}, &models.ContactVisitor{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	cfg := &config.Config{}
+	service := NewLeadService(db, cfg)
+
+	submission := &models.PreChatSubmission{
+		VisitorID: "visitor-1",
+		SessionID: "session-1",
+		Values:    map[string]string{"name": "Jane", "email": "jane@example.com", "topic": "Sales", "question": "Do you ship abroad?"},
+		Consent:   true,
+	}
+	if _, err := service.SubmitPreChatForm(website, submission, "127.0.0.1", "test", "en", ""); err == nil || err.Error() != "pre-chat form is not enabled" {
+		t.Errorf("SubmitPreChatForm() error = %v, want pre-chat form is not enabled", err)
+	}
+
+	website.Settings.PreChatForm = models.PreChatForm{
+		Enabled: true,
+		Fields: []models.PreChatField{
+			{Name: "name", Label: "Name", Type: models.PreChatFieldText},
+			{Name: "email", Label: "Email", Type: models.PreChatFieldEmail, Required: true},
+			{Name: "topic", Label: "Topic", Type: models.PreChatFieldSelect, Options: []string{"Sales", "Support"}},
+			{Name: "question", Label: "Question", Type: models.PreChatFieldTextarea},
+		},
+		ConsentText: "Keep me posted about new products",
+	}
+
+	chat, err := service.SubmitPreChatForm(website, submission, "127.0.0.1", "test", "en", "")
+	if err != nil {
+		t.Fatalf("SubmitPreChatForm() error = %v", err)
+	}
+	if chat.PreChatSubmittedAt == nil || chat.PreChatData["question"] != "Do you ship abroad?" || chat.ContactID == nil {
+		t.Fatalf("expected the submission on the chat, got %+v", chat)
+	}
+
+	var contact models.Contact
+	if err := db.First(&contact, *chat.ContactID).Error; err != nil {
+		t.Fatalf("failed to load contact: %v", err)
+	}
+	if contact.Email != "jane@example.com" || contact.Name != "Jane" || contact.Attributes["topic"] != "Sales" {
+		t.Errorf("expected the contact to be filled in, got %+v", contact)
+	}
+	if _, ok := contact.Attributes["question"]; ok {
+		t.Errorf("free text should stay on the chat, got %v", contact.Attributes)
+	}
+	if contact.LeadCapturedAt == nil || contact.ConsentGivenAt == nil || contact.ConsentText != website.Settings.PreChatForm.ConsentText {
+		t.Errorf("expected lead and consent to be recorded, got %+v", contact)
+	}
+
+	var captures []models.Analytics
+	if err := db.Where("event_type = ?", models.EventTypeEmailCapture).Find(&captures).Error; err != nil {
+		t.Fatalf("failed to load events: %v", err)
+	}
+	if len(captures) != 1 || captures[0].VisitorID != "visitor-1" || captures[0].EventData["source"] != "pre_chat_form" {
+		t.Errorf("expected one email_capture event, got %+v", captures)
+	}
+
+	if _, err := service.SubmitPreChatForm(website, submission, "127.0.0.1", "test", "en", ""); err == nil || err.Error() != "pre-chat form already submitted" {
+		t.Errorf("SubmitPreChatForm() error = %v, want pre-chat form already submitted", err)
+	}
+
+	leads, total, err := service.GetLeads(website.ID, nil, nil, 1, 10)
+	if err != nil {
+		t.Fatalf("GetLeads() error = %v", err)
+	}
+	if total != 1 || leads[0].ID != contact.ID {
+		t.Errorf("expected jane as the only lead, got %+v", leads)
+	}
+
+	// Leads export through the regular export pipeline
+	job := &models.ExportJob{
+		WebsiteID: website.ID,
+		Dataset:   models.ExportDatasetLeads,
+		Format:    models.ExportFormatCSV,
+		StartDate: time.Now().Add(-time.Hour),
+		EndDate:   time.Now().Add(time.Hour),
+	}
+	exportService := NewExportService(db, cfg, nil)
+	if err := exportService.ValidateExport(job); err != nil {
+		t.Fatalf("ValidateExport() error = %v", err)
+	}
+	var buf bytes.Buffer
+	rows, err := exportService.StreamExport(context.Background(), &buf, job, nil)
+	if err != nil {
+		t.Fatalf("StreamExport() error = %v", err)
+	}
+	if rows != 1 || !strings.Contains(buf.String(), "jane@example.com") || !strings.Contains(buf.String(), `""topic"":""Sales""`) {
+		t.Errorf("unexpected leads export (%d rows):\n%s", rows, buf.String())
+	}
+}
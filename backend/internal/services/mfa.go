@@ -0,0 +1,294 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/totp"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// How long the second step of a sign-in may take
+	MFATokenTTL = 5 * time.Minute
+
+	// How long a re-authentication covers sensitive actions for
+	ReauthTokenTTL = 5 * time.Minute
+
+	// Names the account in authenticator apps
+	mfaIssuer = "Chatelly"
+)
+
+// MFAStatus describes a user's two-factor authentication
+type MFAStatus struct {
+	Enabled                bool  `json:"enabled"`
+	Required               bool  `json:"required"` // a website the user works on requires it
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+	RequireAgentMFA        bool  `json:"require_agent_mfa"`
+}
+
+// MFAService handles TOTP two-factor authentication and its recovery codes
+type MFAService struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+// NewMFAService creates a new MFAService
+func NewMFAService(db *gorm.DB, cfg *config.Config) *MFAService {
+	return &MFAService{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+func (s *MFAService) user(userID uint) (*models.User, error) {
+	var user models.User
+	if err := s.db.Where("id = ? AND is_active = ?", userID, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetStatus returns a user's two-factor authentication status
+func (s *MFAService) GetStatus(userID uint) (*MFAStatus, error) {
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	required, err := s.Required(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MFAStatus{
+		Enabled:         user.MFAEnabled,
+		Required:        required,
+		RequireAgentMFA: user.RequireAgentMFA,
+	}
+	if err := s.db.Model(&models.MFARecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&status.RecoveryCodesRemaining).Error; err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// BeginSetup generates a new secret for the user's authenticator. It is not
+// used for sign-ins until Enable verifies a code from it.
+func (s *MFAService) BeginSetup(userID uint) (*models.MFASetup, error) {
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Updates(map[string]interface{}{
+		"mfa_secret":       secret,
+		"mfa_last_counter": 0,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.MFASetup{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(mfaIssuer, user.Email, secret),
+	}, nil
+}
+
+// Enable turns on two-factor authentication once the user proves their
+// authenticator works, and returns their recovery codes. They are only ever
+// shown now.
+func (s *MFAService) Enable(userID uint, code string) ([]string, error) {
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+	if user.MFASecret == "" {
+		return nil, errors.New("two-factor authentication is not set up")
+	}
+
+	counter, ok := totp.Validate(user.MFASecret, code, time.Now(), 0)
+	if !ok {
+		return nil, errors.New("invalid verification code")
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"mfa_enabled":      true,
+			"mfa_last_counter": counter,
+		}).Error; err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+	return codes, nil
+}
+
+// Disable turns off two-factor authentication, unless a website the user
+// works on requires it
+func (s *MFAService) Disable(userID uint) error {
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return errors.New("two-factor authentication is not enabled")
+	}
+
+	required, err := s.Required(userID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return errors.New("two-factor authentication is required by a website you work on")
+	}
+	if user.RequireAgentMFA {
+		return errors.New("stop requiring two-factor authentication of your agents first")
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"mfa_enabled":      false,
+			"mfa_secret":       "",
+			"mfa_last_counter": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error
+	})
+}
+
+// Verify checks a code from the user's authenticator, or one of their
+// recovery codes. Each is accepted only once.
+func (s *MFAService) Verify(user *models.User, code, recoveryCode string) error {
+	if !user.MFAEnabled {
+		return errors.New("two-factor authentication is not enabled")
+	}
+
+	if recoveryCode != "" {
+		result := s.db.Model(&models.MFARecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, models.HashRecoveryCode(recoveryCode)).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("invalid recovery code")
+		}
+		return nil
+	}
+
+	if code == "" {
+		return errors.New("verification code required")
+	}
+	counter, ok := totp.Validate(user.MFASecret, code, time.Now(), user.MFALastCounter)
+	if !ok {
+		return errors.New("invalid verification code")
+	}
+
+	// Only one of two requests racing with the same code gets to use it
+	result := s.db.Model(&models.User{}).
+		Where("id = ? AND mfa_last_counter < ?", user.ID, counter).
+		Update("mfa_last_counter", counter)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("invalid verification code")
+	}
+	user.MFALastCounter = counter
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes
+func (s *MFAService) RegenerateRecoveryCodes(userID uint) ([]string, error) {
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled {
+		return nil, errors.New("two-factor authentication is not enabled")
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to regenerate recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// replaceRecoveryCodes stores new recovery codes for a user in place of their
+// old ones
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, models.RecoveryCodeCount)
+	records := make([]models.MFARecoveryCode, models.RecoveryCodeCount)
+	for i := range codes {
+		code, err := models.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		records[i] = models.MFARecoveryCode{UserID: userID, CodeHash: models.HashRecoveryCode(code)}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Required reports whether the owner of a website the user works on as an
+// agent requires two-factor authentication
+func (s *MFAService) Required(userID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.WebsiteAgent{}).
+		Joins("JOIN websites ON websites.id = website_agents.website_id AND websites.deleted_at IS NULL").
+		Joins("JOIN users ON users.id = websites.user_id").
+		Where("website_agents.user_id = ? AND websites.user_id <> ? AND users.require_agent_mfa = ?", userID, userID, true).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// SetEnforcement sets whether the agents on the user's websites must use
+// two-factor authentication. Agents without it are sent to set it up the next
+// time they sign in, and cannot refresh their sessions until they do.
+func (s *MFAService) SetEnforcement(userID uint, required bool) error {
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+	if required && !user.MFAEnabled {
+		return errors.New("enable two-factor authentication on your own account first")
+	}
+
+	return s.db.Model(user).Update("require_agent_mfa", required).Error
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/totp"
+	"chatelly-backend/pkg/utils"
+
+	"gorm.io/gorm"
+)
+
+func setupMFATest(t *testing.T) (*gorm.DB, *config.Config, *models.User, *models.Website) {
+	t.Helper()
+
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.MFARecoveryCode{}, &models.WebsiteAgent{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+
+	var owner models.User
+	if err := db.First(&owner, website.UserID).Error; err != nil {
+		t.Fatalf("failed to load user: %v", err)
+	}
+	if err := owner.HashPassword("Password123!"); err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if err := db.Save(&owner).Error; err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+
+	cfg := &config.Config{JWT: config.JWTConfig{Secret: "test-secret", Expiration: 1}}
+	return db, cfg, &owner, website
+}
+
+// newTestAuthService creates an AuthService signing with the config's secret
+func newTestAuthService(t *testing.T, db *gorm.DB, cfg *config.Config) *AuthService {
+	t.Helper()
+	keys, err := utils.LoadKeys(cfg.JWT)
+	if err != nil {
+		t.Fatalf("LoadKeys() error = %v", err)
+	}
+	return NewAuthService(db, cfg, keys)
+}
+
+// enableMFA sets up two-factor authentication for a user, returning their
+// secret and recovery codes
+func enableMFA(t *testing.T, service *MFAService, userID uint) (string, []string) {
+	t.Helper()
+
+	setup, err := service.BeginSetup(userID)
+	if err != nil {
+		t.Fatalf("BeginSetup() error = %v", err)
+	}
+
+	// Use the previous step's code, leaving the current one for the test
+	code, _ := totp.Code(setup.Secret, totp.Counter(time.Now())-1)
+	recoveryCodes, err := service.Enable(userID, code)
+	if err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	return setup.Secret, recoveryCodes
+}
+
+func TestMFAService_EnableAndVerify(t *testing.T) {
+	db, cfg, owner, _ := setupMFATest(t)
+	service := NewMFAService(db, cfg)
+
+	setup, err := service.BeginSetup(owner.ID)
+	if err != nil {
+		t.Fatalf("BeginSetup() error = %v", err)
+	}
+	if _, err := service.Enable(owner.ID, "000000"); err == nil || err.Error() != "invalid verification code" {
+		t.Fatalf("expected a wrong code to be refused, got %v", err)
+	}
+
+	code, _ := totp.Code(setup.Secret, totp.Counter(time.Now())-1)
+	recoveryCodes, err := service.Enable(owner.ID, code)
+	if err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if len(recoveryCodes) != models.RecoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", models.RecoveryCodeCount, len(recoveryCodes))
+	}
+
+	// Only hashes are stored
+	var stored models.MFARecoveryCode
+	if err := db.Where("user_id = ?", owner.ID).First(&stored).Error; err != nil {
+		t.Fatalf("failed to load recovery code: %v", err)
+	}
+	for _, recoveryCode := range recoveryCodes {
+		if stored.CodeHash == recoveryCode {
+			t.Fatalf("expected recovery codes to be hashed")
+		}
+	}
+
+	user, _ := newTestAuthService(t, db, cfg).GetUserByID(owner.ID)
+
+	// The code used to enable 2FA, and any before it, cannot be used again
+	if err := service.Verify(user, code, ""); err == nil {
+		t.Errorf("expected the enabling code to be refused")
+	}
+
+	code, _ = totp.Code(setup.Secret, totp.Counter(time.Now()))
+	if err := service.Verify(user, code, ""); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if err := service.Verify(user, code, ""); err == nil {
+		t.Errorf("expected a code to be accepted only once")
+	}
+
+	// A stale copy of the user cannot reuse it either
+	stale, _ := newTestAuthService(t, db, cfg).GetUserByID(owner.ID)
+	stale.MFALastCounter = 0
+	if err := service.Verify(stale, code, ""); err == nil {
+		t.Errorf("expected a racing request with the same code to be refused")
+	}
+}
+
+func TestMFAService_RecoveryCodes(t *testing.T) {
+	db, cfg, owner, _ := setupMFATest(t)
+	service := NewMFAService(db, cfg)
+	_, recoveryCodes := enableMFA(t, service, owner.ID)
+	user, _ := newTestAuthService(t, db, cfg).GetUserByID(owner.ID)
+
+	if err := service.Verify(user, "", "AAAA-BBBB-CCCC"); err == nil || err.Error() != "invalid recovery code" {
+		t.Fatalf("expected an unknown recovery code to be refused, got %v", err)
+	}
+	if err := service.Verify(user, "", recoveryCodes[0]); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if err := service.Verify(user, "", recoveryCodes[0]); err == nil {
+		t.Errorf("expected a recovery code to be accepted only once")
+	}
+
+	status, err := service.GetStatus(owner.ID)
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if !status.Enabled || status.RecoveryCodesRemaining != models.RecoveryCodeCount-1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// Regenerating replaces every old code
+	if _, err := service.RegenerateRecoveryCodes(owner.ID); err != nil {
+		t.Fatalf("RegenerateRecoveryCodes() error = %v", err)
+	}
+	if err := service.Verify(user, "", recoveryCodes[1]); err == nil {
+		t.Errorf("expected old recovery codes to be replaced")
+	}
+}
+
+func TestMFAService_Enforcement(t *testing.T) {
+	db, cfg, owner, website := setupMFATest(t)
+	service := NewMFAService(db, cfg)
+	auth := newTestAuthService(t, db, cfg)
+
+	agent := &models.User{Email: "agent@example.com", Name: "Agent"}
+	if err := agent.HashPassword("Password123!"); err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if err := db.Create(agent).Error; err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+	if err := db.Create(&models.WebsiteAgent{WebsiteID: website.ID, UserID: agent.ID}).Error; err != nil {
+		t.Fatalf("failed to add agent: %v", err)
+	}
+	refreshToken, err := utils.GenerateRefreshToken(agent, auth.keys)
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken() error = %v", err)
+	}
+
+	// Owners cannot require what they do not use themselves
+	if err := service.SetEnforcement(owner.ID, true); err == nil {
+		t.Fatalf("expected enforcement without 2FA on the owner to be refused")
+	}
+	enableMFA(t, service, owner.ID)
+	if err := service.SetEnforcement(owner.ID, true); err != nil {
+		t.Fatalf("SetEnforcement() error = %v", err)
+	}
+
+	// The agent must set up 2FA before they get a session
+	result, err := auth.LoginUser(agent.Email, "Password123!")
+	if err != nil {
+		t.Fatalf("LoginUser() error = %v", err)
+	}
+	if !result.MFAEnrollmentRequired || result.Tokens != nil || result.MFAToken == "" {
+		t.Fatalf("expected enrollment to be required, got %+v", result)
+	}
+	if _, err := auth.RefreshTokens(refreshToken); err == nil || err.Error() != "two-factor authentication is required" {
+		t.Errorf("expected refreshing without 2FA to be refused, got %v", err)
+	}
+
+	enrolling, err := auth.MFATokenUser(result.MFAToken, utils.StepTokenMFAEnrollment)
+	if err != nil {
+		t.Fatalf("MFATokenUser() error = %v", err)
+	}
+	if _, err := auth.MFATokenUser(result.MFAToken, utils.StepTokenMFAChallenge); err == nil {
+		t.Errorf("expected an enrollment token not to pass as a challenge token")
+	}
+	setup, err := service.BeginSetup(enrolling.ID)
+	if err != nil {
+		t.Fatalf("BeginSetup() error = %v", err)
+	}
+	code, _ := totp.Code(setup.Secret, totp.Counter(time.Now()))
+	tokens, recoveryCodes, err := auth.CompleteMFAEnrollment(enrolling, code)
+	if err != nil || tokens == nil || len(recoveryCodes) == 0 {
+		t.Fatalf("CompleteMFAEnrollment() = %v, %v, %v", tokens, recoveryCodes, err)
+	}
+
+	// From then on the agent verifies a code and cannot turn 2FA off
+	result, err = auth.LoginUser(agent.Email, "Password123!")
+	if err != nil {
+		t.Fatalf("LoginUser() error = %v", err)
+	}
+	if !result.MFARequired || result.Tokens != nil {
+		t.Fatalf("expected a code to be required, got %+v", result)
+	}
+	if err := service.Disable(agent.ID); err == nil || err.Error() != "two-factor authentication is required by a website you work on" {
+		t.Errorf("expected disabling to be refused, got %v", err)
+	}
+	if _, err := auth.RefreshTokens(refreshToken); err != nil {
+		t.Errorf("expected refreshing to work once 2FA is enabled, got %v", err)
+	}
+
+	// Lifting enforcement lets the agent turn it off
+	if err := service.SetEnforcement(owner.ID, false); err != nil {
+		t.Fatalf("SetEnforcement() error = %v", err)
+	}
+	if err := service.Disable(agent.ID); err != nil {
+		t.Errorf("Disable() error = %v", err)
+	}
+}
+
+func TestAuthService_Reauthenticate(t *testing.T) {
+	db, cfg, owner, _ := setupMFATest(t)
+	auth := newTestAuthService(t, db, cfg)
+
+	if _, err := auth.Reauthenticate(owner.ID, &models.ReauthRequest{Password: "wrong"}); err == nil || err.Error() != "invalid password" {
+		t.Fatalf("expected a wrong password to be refused, got %v", err)
+	}
+
+	token, err := auth.Reauthenticate(owner.ID, &models.ReauthRequest{Password: "Password123!"})
+	if err != nil {
+		t.Fatalf("Reauthenticate() error = %v", err)
+	}
+	claims, err := utils.ValidateStepToken(token, utils.StepTokenReauth, auth.keys)
+	if err != nil || claims.UserID != owner.ID {
+		t.Fatalf("ValidateStepToken() = %v, %v", claims, err)
+	}
+
+	// With 2FA on, a code is needed too
+	secret, _ := enableMFA(t, NewMFAService(db, cfg), owner.ID)
+	if _, err := auth.Reauthenticate(owner.ID, &models.ReauthRequest{Password: "Password123!"}); err == nil || err.Error() != "verification code required" {
+		t.Fatalf("expected a code to be required, got %v", err)
+	}
+	code, _ := totp.Code(secret, totp.Counter(time.Now()))
+	if _, err := auth.Reauthenticate(owner.ID, &models.ReauthRequest{Password: "Password123!", Code: code}); err != nil {
+		t.Errorf("Reauthenticate() error = %v", err)
+	}
+}
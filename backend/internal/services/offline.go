@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/mailer"
+
+	"gorm.io/gorm"
+)
+
+// OfflineService handles messages left while a website is offline
+type OfflineService struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	mailer mailer.Mailer
+}
+
+// NewOfflineService creates a new OfflineService
+func NewOfflineService(db *gorm.DB, cfg *config.Config, mail mailer.Mailer) *OfflineService {
+	return &OfflineService{
+		db:     db,
+		cfg:    cfg,
+		mailer: mail,
+	}
+}
+
+// CreateTicket stores an offline form submission as a ticket. Submissions are
+// accepted while online too, so a form opened just before opening time is
+// not lost. The owner is notified separately through NotifyOwner.
+func (s *OfflineService) CreateTicket(website *models.Website, req *models.OfflineTicketRequest, visitorIP, userAgent string) (*models.OfflineTicket, error) {
+	message := strings.TrimSpace(req.Message)
+	if message == "" {
+		return nil, errors.New("message is required")
+	}
+	if len([]rune(message)) > models.MaxTicketMessageLength {
+		return nil, errors.New("message is too long")
+	}
+
+	ticket := &models.OfflineTicket{
+		WebsiteID: website.ID,
+		VisitorID: req.VisitorID,
+		Name:      strings.TrimSpace(req.Name),
+		Email:     strings.ToLower(strings.TrimSpace(req.Email)),
+		Message:   message,
+		Status:    models.TicketStatusOpen,
+		PageURL:   req.PageURL,
+		VisitorIP: visitorIP,
+		UserAgent: userAgent,
+	}
+
+	if req.VisitorID != "" {
+		contact, err := NewContactService(s.db, s.cfg).ResolveVisitor(website.ID, req.VisitorID)
+		if err != nil {
+			return nil, err
+		}
+		ticket.ContactID = &contact.ID
+	}
+
+	if err := s.db.Create(ticket).Error; err != nil {
+		return nil, fmt.Errorf("failed to create ticket: %w", err)
+	}
+
+	return ticket, nil
+}
+
+// NotifyOwner emails a new ticket to the website owner. Replies go straight
+// to the visitor.
+func (s *OfflineService) NotifyOwner(ctx context.Context, ticket *models.OfflineTicket) error {
+	var website models.Website
+	if err := s.db.Preload("User").First(&website, ticket.WebsiteID).Error; err != nil {
+		return fmt.Errorf("failed to load website: %w", err)
+	}
+	if website.User.Email == "" {
+		return errors.New("website owner has no email address")
+	}
+
+	from := ticket.Email
+	if ticket.Name != "" {
+		from = fmt.Sprintf("%s <%s>", ticket.Name, ticket.Email)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s left a message on %s while you were offline.\n\n", from, website.Name)
+	body.WriteString(ticket.Message)
+	body.WriteString("\n\n")
+	if ticket.PageURL != "" {
+		fmt.Fprintf(&body, "Page: %s\n", ticket.PageURL)
+	}
+	fmt.Fprintf(&body, "Ticket: #%d\nReceived: %s\n", ticket.ID, ticket.CreatedAt.UTC().Format(time.RFC1123))
+
+	if err := s.mailer.Send(ctx, mailer.Message{
+		To:      []string{website.User.Email},
+		ReplyTo: ticket.Email,
+		Subject: fmt.Sprintf("[%s] New offline message from %s", website.Name, from),
+		Body:    body.String(),
+	}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ticket.EmailedAt = &now
+	return s.db.Model(ticket).UpdateColumn("emailed_at", now).Error
+}
+
+// NotifyOwnerAsync emails the owner in the background, logging failures
+func (s *OfflineService) NotifyOwnerAsync(ticket *models.OfflineTicket) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := s.NotifyOwner(ctx, ticket); err != nil {
+			log.Printf("Failed to email offline ticket %d: %v", ticket.ID, err)
+		}
+	}()
+}
+
+// GetTickets retrieves a website's tickets, newest first. An empty status
+// returns tickets of every status.
+func (s *OfflineService) GetTickets(websiteID uint, status string, page, limit int) ([]models.OfflineTicket, int64, error) {
+	var tickets []models.OfflineTicket
+	var total int64
+
+	query := s.db.Model(&models.OfflineTicket{}).Where("website_id = ?", websiteID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	if err := query.Order("created_at DESC, id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&tickets).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return tickets, total, nil
+}
+
+// UpdateTicket opens or closes a ticket
+func (s *OfflineService) UpdateTicket(websiteID, ticketID uint, status string) (*models.OfflineTicket, error) {
+	var ticket models.OfflineTicket
+	if err := s.db.Where("id = ? AND website_id = ?", ticketID, websiteID).First(&ticket).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("ticket not found")
+		}
+		return nil, err
+	}
+
+	var closedAt *time.Time
+	if status == models.TicketStatusClosed {
+		now := time.Now()
+		closedAt = &now
+		if ticket.ClosedAt != nil {
+			closedAt = ticket.ClosedAt
+		}
+	}
+
+	if err := s.db.Model(&ticket).Updates(map[string]interface{}{
+		"status":    status,
+		"closed_at": closedAt,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update ticket: %w", err)
+	}
+
+	return &ticket, nil
+}
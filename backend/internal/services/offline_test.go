@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/mailer"
+)
+
+// fakeMailer records sent emails
+type fakeMailer struct {
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestOfflineService_Tickets(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.Contact{}, &models.ContactVisitor{}, &models.OfflineTicket{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	mail := &fakeMailer{}
+	service := NewOfflineService(db, &config.Config{}, mail)
+
+	req := &models.OfflineTicketRequest{
+		VisitorID: "visitor-1",
+		Name:      "Jane",
+		Email:     " Jane@Example.com ",
+		Message:   "  Do you ship abroad?  ",
+		PageURL:   "https://example.com/pricing",
+	}
+	ticket, err := service.CreateTicket(website, req, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("CreateTicket() error = %v", err)
+	}
+	if ticket.Status != models.TicketStatusOpen || ticket.Email != "jane@example.com" || ticket.Message != "Do you ship abroad?" || ticket.ContactID == nil {
+		t.Fatalf("unexpected ticket %+v", ticket)
+	}
+
+	if err := service.NotifyOwner(context.Background(), ticket); err != nil {
+		t.Fatalf("NotifyOwner() error = %v", err)
+	}
+	if len(mail.sent) != 1 {
+		t.Fatalf("expected one email, got %d", len(mail.sent))
+	}
+	sent := mail.sent[0]
+	if sent.To[0] != "owner@example.com" || sent.ReplyTo != "jane@example.com" || !strings.Contains(sent.Body, "Do you ship abroad?") {
+		t.Errorf("unexpected email %+v", sent)
+	}
+
+	var stored models.OfflineTicket
+	if err := db.First(&stored, ticket.ID).Error; err != nil {
+		t.Fatalf("failed to load ticket: %v", err)
+	}
+	if stored.EmailedAt == nil {
+		t.Errorf("expected the ticket to be marked as emailed")
+	}
+
+	if _, err := service.CreateTicket(website, &models.OfflineTicketRequest{Email: "a@example.com", Message: "  "}, "", ""); err == nil || err.Error() != "message is required" {
+		t.Errorf("CreateTicket() error = %v, want message is required", err)
+	}
+
+	closed, err := service.UpdateTicket(website.ID, ticket.ID, models.TicketStatusClosed)
+	if err != nil {
+		t.Fatalf("UpdateTicket() error = %v", err)
+	}
+	if closed.Status != models.TicketStatusClosed || closed.ClosedAt == nil {
+		t.Errorf("expected a closed ticket, got %+v", closed)
+	}
+	if _, err := service.UpdateTicket(website.ID+1, ticket.ID, models.TicketStatusOpen); err == nil || err.Error() != "ticket not found" {
+		t.Errorf("UpdateTicket() error = %v, want ticket not found", err)
+	}
+
+	open, total, err := service.GetTickets(website.ID, models.TicketStatusOpen, 1, 10)
+	if err != nil {
+		t.Fatalf("GetTickets() error = %v", err)
+	}
+	if total != 0 || len(open) != 0 {
+		t.Errorf("expected no open tickets, got %d", total)
+	}
+	all, total, err := service.GetTickets(website.ID, "", 1, 10)
+	if err != nil {
+		t.Fatalf("GetTickets() error = %v", err)
+	}
+	if total != 1 || len(all) != 1 {
+		t.Errorf("expected 1 ticket, got %d", total)
+	}
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// SearchService handles full-text search over chat messages
+type SearchService struct {
+	db    *gorm.DB
+	cfg   *config.Config
+	index MessageIndex
+}
+
+// NewSearchService creates a new SearchService using the best index for the database
+func NewSearchService(db *gorm.DB, cfg *config.Config) *SearchService {
+	return &SearchService{
+		db:    db,
+		cfg:   cfg,
+		index: NewMessageIndex(db),
+	}
+}
+
+// IndexName returns the active index implementation
+func (s *SearchService) IndexName() string {
+	return s.index.Name()
+}
+
+// EnsureIndex creates the message search index if needed
+func (s *SearchService) EnsureIndex() error {
+	return s.index.EnsureIndex(s.db)
+}
+
+// messageHit is a single matching message
+type messageHit struct {
+	MessageID uint
+	ChatID    uint
+	Sender    string
+	Timestamp time.Time
+	Content   string
+	Snippet   string
+	Score     float64
+}
+
+// SearchMessages finds chats by what was said, ranked by their best matching
+// message. Each chat carries up to MaxMatchesPerChat highlighted snippets.
+func (s *SearchService) SearchMessages(websiteID uint, filters models.MessageSearchFilters, page, limit int) ([]models.ChatSearchResult, int64, error) {
+	terms := strings.TrimSpace(filters.Query)
+	if terms == "" {
+		return nil, 0, errors.New("search query is required")
+	}
+	if len(terms) > models.MaxSearchQueryLength {
+		return nil, 0, errors.New("search query is too long")
+	}
+	filters.Query = terms
+
+	hits, scoreExpr, scoreArgs, err := s.matchingMessages(websiteID, filters)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Count matching chats. Hits are materialized because SQLite can't
+	// evaluate FTS5 ranking functions once a subquery is merged into an aggregate.
+	var total int64
+	if err := s.db.Raw("WITH hits AS MATERIALIZED (?) SELECT COUNT(DISTINCT chat_id) FROM hits",
+		hits.Select("messages.chat_id")).
+		Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return []models.ChatSearchResult{}, 0, nil
+	}
+
+	// Rank chats by their best message, most recent activity first on ties
+	hits, _, _, err = s.matchingMessages(websiteID, filters)
+	if err != nil {
+		return nil, 0, err
+	}
+	var groups []struct {
+		ChatID     uint
+		Score      float64
+		MatchCount int64
+	}
+	offset := (page - 1) * limit
+	if err := s.db.Raw(`WITH hits AS MATERIALIZED (?)
+		SELECT chat_id, MAX(score) AS score, COUNT(*) AS match_count, MAX(timestamp) AS last_match_at
+		FROM hits GROUP BY chat_id
+		ORDER BY score DESC, last_match_at DESC, chat_id DESC
+		LIMIT ? OFFSET ?`,
+		hits.Select("messages.chat_id, messages.timestamp, "+scoreExpr+" AS score", scoreArgs...), limit, offset).
+		Scan(&groups).Error; err != nil {
+		return nil, 0, err
+	}
+	if len(groups) == 0 {
+		return []models.ChatSearchResult{}, total, nil
+	}
+
+	chatIDs := make([]uint, len(groups))
+	for i, group := range groups {
+		chatIDs[i] = group.ChatID
+	}
+
+	matches, err := s.topMatches(websiteID, filters, chatIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var chats []models.Chat
+	if err := s.db.Where("id IN ?", chatIDs).Find(&chats).Error; err != nil {
+		return nil, 0, err
+	}
+	chatsByID := make(map[uint]models.Chat, len(chats))
+	for _, chat := range chats {
+		chatsByID[chat.ID] = chat
+	}
+
+	results := make([]models.ChatSearchResult, 0, len(groups))
+	for _, group := range groups {
+		chat, ok := chatsByID[group.ChatID]
+		if !ok {
+			continue
+		}
+		results = append(results, models.ChatSearchResult{
+			Chat:       chat,
+			Score:      group.Score,
+			MatchCount: group.MatchCount,
+			Matches:    matches[group.ChatID],
+		})
+	}
+
+	return results, total, nil
+}
+
+// matchingMessages builds the filtered query over matching messages
+func (s *SearchService) matchingMessages(websiteID uint, filters models.MessageSearchFilters) (*gorm.DB, string, []interface{}, error) {
+	query := s.db.Table("messages").
+		Joins("JOIN chats ON chats.id = messages.chat_id").
+		Where("chats.website_id = ? AND chats.deleted_at IS NULL AND messages.deleted_at IS NULL", websiteID)
+
+	query, scoreExpr, scoreArgs, err := s.index.Match(query, filters.Query)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if filters.StartDate != nil {
+		query = query.Where("messages.timestamp >= ?", *filters.StartDate)
+	}
+	if filters.EndDate != nil {
+		query = query.Where("messages.timestamp <= ?", *filters.EndDate)
+	}
+	if filters.Sender != "" {
+		query = query.Where("messages.sender = ?", filters.Sender)
+	}
+	if filters.Language != "" {
+		query = query.Where("messages.language = ?", filters.Language)
+	}
+	if filters.Flagged != nil {
+		query = query.Where("messages.flagged = ?", *filters.Flagged)
+	}
+	if filters.Tag != "" {
+		query = filterChatTag(query, strings.ToLower(strings.TrimSpace(filters.Tag)))
+	}
+
+	return query, scoreExpr, scoreArgs, nil
+}
+
+// topMatches returns the best matching messages for each chat, with snippets
+func (s *SearchService) topMatches(websiteID uint, filters models.MessageSearchFilters, chatIDs []uint) (map[uint][]models.MessageSearchMatch, error) {
+	query, scoreExpr, scoreArgs, err := s.matchingMessages(websiteID, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := "messages.id AS message_id, messages.chat_id, messages.sender, messages.timestamp, messages.content, " + scoreExpr + " AS score"
+	args := append([]interface{}{}, scoreArgs...)
+	snippetExpr, snippetArgs := s.index.Snippet(filters.Query)
+	if snippetExpr != "" {
+		columns += ", " + snippetExpr + " AS snippet"
+		args = append(args, snippetArgs...)
+	}
+
+	var hits []messageHit
+	if err := query.Select(columns, args...).
+		Where("messages.chat_id IN ?", chatIDs).
+		Order("score DESC, messages.timestamp DESC").
+		Scan(&hits).Error; err != nil {
+		return nil, err
+	}
+
+	words := searchWords(filters.Query)
+	matches := make(map[uint][]models.MessageSearchMatch, len(chatIDs))
+	for _, hit := range hits {
+		if len(matches[hit.ChatID]) >= models.MaxMatchesPerChat {
+			continue
+		}
+
+		snippet := hit.Snippet
+		if snippetExpr == "" {
+			snippet = highlightWords(hit.Content, words)
+		}
+
+		matches[hit.ChatID] = append(matches[hit.ChatID], models.MessageSearchMatch{
+			MessageID: hit.MessageID,
+			Sender:    hit.Sender,
+			Timestamp: hit.Timestamp,
+			Snippet:   renderSnippet(snippet),
+			Score:     hit.Score,
+		})
+	}
+
+	return matches, nil
+}
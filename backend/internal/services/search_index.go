@@ -0,0 +1,284 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// Snippet highlight markers. They are swapped for <mark> tags after the
+// snippet is HTML-escaped, so message content can't inject markup.
+const (
+	highlightStart = "[[mark]]"
+	highlightEnd   = "[[/mark]]"
+)
+
+// snippetRadius is how many characters of context fallback snippets keep around a match
+const snippetRadius = 60
+
+// MessageIndex is a full-text index over message content and original content
+type MessageIndex interface {
+	// Name identifies the index implementation
+	Name() string
+
+	// EnsureIndex creates the index and keeps it in sync with the messages table
+	EnsureIndex(db *gorm.DB) error
+
+	// Match restricts a query over the messages table to messages matching the
+	// search terms and returns a SQL expression ranking them (higher is better)
+	Match(query *gorm.DB, terms string) (*gorm.DB, string, []interface{}, error)
+
+	// Snippet returns a SQL expression for a highlighted snippet of a matched
+	// message, or an empty string when snippets are built in Go
+	Snippet(terms string) (string, []interface{})
+}
+
+// NewMessageIndex picks the best index for the database: tsvector on
+// Postgres, FTS5 on SQLite builds that include it (-tags sqlite_fts5), and
+// substring matching otherwise.
+func NewMessageIndex(db *gorm.DB) MessageIndex {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return &postgresMessageIndex{}
+	case "sqlite":
+		var enabled int
+		if err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled).Error; err == nil && enabled == 1 {
+			return &sqliteMessageIndex{}
+		}
+	}
+	return &likeMessageIndex{}
+}
+
+// postgresMessageIndex uses a generated tsvector column with a GIN index
+type postgresMessageIndex struct{}
+
+func (i *postgresMessageIndex) Name() string { return "postgres" }
+
+func (i *postgresMessageIndex) EnsureIndex(db *gorm.DB) error {
+	// The 'simple' configuration doesn't stem, since chats mix languages.
+	// Original content only adds weight when moderation or translation changed it.
+	statements := []string{
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+			setweight(to_tsvector('simple', coalesce(content, '')), 'A') ||
+			setweight(to_tsvector('simple', coalesce(nullif(original_content, content), '')), 'B')
+		) STORED`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_search_vector ON messages USING GIN (search_vector)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to create message search index: %w", err)
+		}
+	}
+	return nil
+}
+
+func (i *postgresMessageIndex) Match(query *gorm.DB, terms string) (*gorm.DB, string, []interface{}, error) {
+	query = query.Where("messages.search_vector @@ websearch_to_tsquery('simple', ?)", terms)
+	return query, "ts_rank_cd(messages.search_vector, websearch_to_tsquery('simple', ?))", []interface{}{terms}, nil
+}
+
+func (i *postgresMessageIndex) Snippet(terms string) (string, []interface{}) {
+	options := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=20, MinWords=5, MaxFragments=2", highlightStart, highlightEnd)
+	return "ts_headline('simple', messages.content, websearch_to_tsquery('simple', ?), ?)", []interface{}{terms, options}
+}
+
+// sqliteMessageIndex uses an external-content FTS5 table kept in sync by triggers
+type sqliteMessageIndex struct{}
+
+func (i *sqliteMessageIndex) Name() string { return "sqlite_fts5" }
+
+func (i *sqliteMessageIndex) EnsureIndex(db *gorm.DB) error {
+	var existing int64
+	if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'messages_fts'").Scan(&existing).Error; err != nil {
+		return err
+	}
+
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+			content, original_content,
+			content='messages', content_rowid='id',
+			tokenize='unicode61 remove_diacritics 2'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts(rowid, content, original_content) VALUES (new.id, new.content, new.original_content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts(messages_fts, rowid, content, original_content) VALUES ('delete', old.id, old.content, old.original_content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content, original_content ON messages BEGIN
+			INSERT INTO messages_fts(messages_fts, rowid, content, original_content) VALUES ('delete', old.id, old.content, old.original_content);
+			INSERT INTO messages_fts(rowid, content, original_content) VALUES (new.id, new.content, new.original_content);
+		END`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to create message search index: %w", err)
+		}
+	}
+
+	// Index messages written before the table existed
+	if existing == 0 {
+		if err := db.Exec("INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')").Error; err != nil {
+			return fmt.Errorf("failed to build message search index: %w", err)
+		}
+	}
+	return nil
+}
+
+func (i *sqliteMessageIndex) Match(query *gorm.DB, terms string) (*gorm.DB, string, []interface{}, error) {
+	match, err := ftsMatchExpression(terms)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	query = query.Joins("JOIN messages_fts ON messages_fts.rowid = messages.id").
+		Where("messages_fts MATCH ?", match)
+
+	// bm25 scores are lower for better matches; content outweighs original content
+	return query, "-bm25(messages_fts, 1.0, 0.5)", nil, nil
+}
+
+func (i *sqliteMessageIndex) Snippet(terms string) (string, []interface{}) {
+	return "snippet(messages_fts, 0, ?, ?, '…', 16)", []interface{}{highlightStart, highlightEnd}
+}
+
+// ftsMatchExpression quotes each search term so user input can't use FTS5
+// query syntax. A trailing * is kept for prefix searches; quotes inside a
+// term are doubled, as FTS5 strings escape them.
+func ftsMatchExpression(terms string) (string, error) {
+	var quoted []string
+	for _, term := range strings.Fields(terms) {
+		prefix := strings.HasSuffix(term, "*")
+		term = strings.Trim(strings.ReplaceAll(term, "*", ""), `"`)
+		if term == "" {
+			continue
+		}
+
+		phrase := `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			phrase += "*"
+		}
+		quoted = append(quoted, phrase)
+	}
+
+	if len(quoted) == 0 {
+		return "", errors.New("search query is required")
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+// likeMessageIndex matches substrings without an index. It keeps search
+// working on databases without full-text support but does not rank results.
+type likeMessageIndex struct{}
+
+func (i *likeMessageIndex) Name() string { return "like" }
+
+func (i *likeMessageIndex) EnsureIndex(db *gorm.DB) error { return nil }
+
+func (i *likeMessageIndex) Match(query *gorm.DB, terms string) (*gorm.DB, string, []interface{}, error) {
+	words := searchWords(terms)
+	if len(words) == 0 {
+		return nil, "", nil, errors.New("search query is required")
+	}
+
+	for _, word := range words {
+		pattern := "%" + escapeLike(word) + "%"
+		query = query.Where("(LOWER(messages.content) LIKE ? ESCAPE '\\' OR LOWER(messages.original_content) LIKE ? ESCAPE '\\')", pattern, pattern)
+	}
+	return query, "1.0", nil, nil
+}
+
+func (i *likeMessageIndex) Snippet(terms string) (string, []interface{}) {
+	return "", nil
+}
+
+// searchWords splits search input into lowercase words, dropping FTS operators
+func searchWords(terms string) []string {
+	var words []string
+	for _, word := range strings.Fields(strings.ToLower(terms)) {
+		word = strings.Trim(word, `*"`)
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// escapeLike escapes LIKE wildcards
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// highlightWords builds a snippet around the first matched word with all
+// matches wrapped in highlight markers
+func highlightWords(content string, words []string) string {
+	lower := strings.ToLower(content)
+
+	// Only ASCII case folding keeps byte offsets aligned with the original
+	if len(lower) != len(content) {
+		lower = content
+	}
+
+	first := -1
+	for _, word := range words {
+		if idx := strings.Index(lower, word); idx >= 0 && (first < 0 || idx < first) {
+			first = idx
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	start := first - snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	end := first + snippetRadius
+	if end > len(content) {
+		end = len(content)
+	}
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	var builder strings.Builder
+	if start > 0 {
+		builder.WriteString("…")
+	}
+
+	window, windowLower := content[start:end], lower[start:end]
+	for pos := 0; pos < len(window); {
+		matched := 0
+		for _, word := range words {
+			if strings.HasPrefix(windowLower[pos:], word) && len(word) > matched {
+				matched = len(word)
+			}
+		}
+		if matched > 0 {
+			builder.WriteString(highlightStart + window[pos:pos+matched] + highlightEnd)
+			pos += matched
+			continue
+		}
+		builder.WriteByte(window[pos])
+		pos++
+	}
+
+	if end < len(content) {
+		builder.WriteString("…")
+	}
+	return builder.String()
+}
+
+// renderSnippet escapes a snippet and turns highlight markers into <mark> tags
+func renderSnippet(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	return strings.ReplaceAll(escaped, highlightEnd, "</mark>")
+}
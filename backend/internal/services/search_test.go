@@ -0,0 +1,150 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+)
+
+// Runs against FTS5 with -tags sqlite_fts5 and the substring index otherwise
+func TestSearchService_SearchMessages(t *testing.T) {
+	db := setupTestDB(t)
+	website := createTestWebsite(t, db)
+	service := NewSearchService(db, &config.Config{})
+
+	now := time.Now()
+	refunds := createTestChat(t, db, website.ID, "")
+	shipping := createTestChat(t, db, website.ID, "")
+	other := createTestChat(t, db, website.ID, "")
+	if err := db.Model(refunds).Update("tags", models.ChatTags{"billing"}).Error; err != nil {
+		t.Fatalf("failed to tag chat: %v", err)
+	}
+
+	// Written before the index exists, so it must be picked up by the initial build
+	early := models.Message{ChatID: refunds.ID, Content: "I want a refund for my order", Sender: models.SenderUser, Language: "en", Timestamp: now.Add(-2 * time.Hour)}
+	if err := db.Create(&early).Error; err != nil {
+		t.Fatalf("failed to create message: %v", err)
+	}
+
+	if err := service.EnsureIndex(); err != nil {
+		t.Fatalf("EnsureIndex() error = %v", err)
+	}
+	// Setting up twice must be harmless
+	if err := service.EnsureIndex(); err != nil {
+		t.Fatalf("EnsureIndex() second call error = %v", err)
+	}
+
+	messages := []models.Message{
+		{ChatID: refunds.ID, Content: "Refund refund REFUND, please!", Sender: models.SenderUser, Language: "en", Timestamp: now.Add(-time.Hour)},
+		{ChatID: refunds.ID, Content: "Your refund is on its way", Sender: models.SenderAgent, Language: "en", Flagged: true, Timestamp: now.Add(-30 * time.Minute)},
+		{ChatID: shipping.ID, Content: "Where is my <b>order</b>? No refund needed", Sender: models.SenderUser, Language: "de", Timestamp: now.Add(-48 * time.Hour)},
+		{ChatID: other.ID, Content: "Hello there", Sender: models.SenderUser, Language: "en", Timestamp: now},
+	}
+	if err := db.Create(&messages).Error; err != nil {
+		t.Fatalf("failed to create messages: %v", err)
+	}
+
+	search := func(filters models.MessageSearchFilters) ([]models.ChatSearchResult, int64) {
+		t.Helper()
+		results, total, err := service.SearchMessages(website.ID, filters, 1, 10)
+		if err != nil {
+			t.Fatalf("SearchMessages(%+v) error = %v", filters, err)
+		}
+		return results, total
+	}
+
+	results, total := search(models.MessageSearchFilters{Query: "refund"})
+	if total != 2 || len(results) != 2 {
+		t.Fatalf("expected 2 chats, got total %d and %d results", total, len(results))
+	}
+	if results[0].Chat.ID != refunds.ID || results[0].MatchCount != 3 || len(results[0].Matches) != 3 {
+		t.Errorf("expected refund chat first with 3 matches, got %+v", results[0])
+	}
+	if service.IndexName() != "like" && !strings.Contains(results[0].Matches[0].Snippet, "REFUND") {
+		t.Errorf("expected the most relevant message first, got %q", results[0].Matches[0].Snippet)
+	}
+	for _, match := range results[0].Matches {
+		if !strings.Contains(strings.ToLower(match.Snippet), "<mark>refund</mark>") {
+			t.Errorf("snippet %q is not highlighted", match.Snippet)
+		}
+	}
+
+	// Message content is escaped before highlighting
+	results, _ = search(models.MessageSearchFilters{Query: "order", Language: "de"})
+	if len(results) != 1 || !strings.Contains(results[0].Matches[0].Snippet, "&lt;b&gt;") {
+		t.Errorf("expected escaped snippet, got %+v", results)
+	}
+
+	// All terms must match
+	if _, total = search(models.MessageSearchFilters{Query: "refund order"}); total != 2 {
+		t.Errorf("multi-term search returned %d chats, want 2", total)
+	}
+	if _, total = search(models.MessageSearchFilters{Query: "refund hello"}); total != 0 {
+		t.Errorf("unmatched multi-term search returned %d chats, want 0", total)
+	}
+
+	flagged := true
+	start := now.Add(-24 * time.Hour)
+	filterTests := []struct {
+		name    string
+		filters models.MessageSearchFilters
+		want    int64
+	}{
+		{"sender", models.MessageSearchFilters{Query: "refund", Sender: models.SenderAgent}, 1},
+		{"flagged", models.MessageSearchFilters{Query: "refund", Flagged: &flagged}, 1},
+		{"tag", models.MessageSearchFilters{Query: "refund", Tag: "Billing"}, 1},
+		{"date", models.MessageSearchFilters{Query: "refund", StartDate: &start}, 1},
+		{"language", models.MessageSearchFilters{Query: "refund", Language: "fr"}, 0},
+	}
+	for _, tt := range filterTests {
+		if _, total := search(tt.filters); total != tt.want {
+			t.Errorf("%s filter returned %d chats, want %d", tt.name, total, tt.want)
+		}
+	}
+
+	// Edits and deletes are reflected
+	if err := db.Model(&messages[2]).Update("content", "Where is my parcel?").Error; err != nil {
+		t.Fatalf("failed to update message: %v", err)
+	}
+	if err := db.Delete(&early).Error; err != nil {
+		t.Fatalf("failed to delete message: %v", err)
+	}
+	results, total = search(models.MessageSearchFilters{Query: "refund"})
+	if total != 1 || results[0].MatchCount != 2 {
+		t.Errorf("expected edits and deletes to drop matches, got total %d: %+v", total, results)
+	}
+
+	// Stray quotes are searched as text rather than breaking the query
+	search(models.MessageSearchFilters{Query: `order"s "refund`})
+
+	if _, _, err := service.SearchMessages(website.ID, models.MessageSearchFilters{Query: `  "" * `}, 1, 10); err == nil {
+		t.Error("expected error for a query without terms")
+	}
+}
+
+func TestFTSMatchExpression(t *testing.T) {
+	tests := []struct {
+		terms string
+		want  string
+		err   bool
+	}{
+		{"refund order", `"refund" "order"`, false},
+		{"ref*", `"ref"*`, false},
+		{`foo"bar`, `"foo""bar"`, false},
+		{`"refund"`, `"refund"`, false},
+		{`say "hi there"`, `"say" "hi" "there"`, false},
+		{`re*fund`, `"refund"`, false},
+		{`"`, "", true},
+		{"* ** *", "", true},
+		{`"*" ""`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := ftsMatchExpression(tt.terms)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ftsMatchExpression(%q) = %q, %v, want %q", tt.terms, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/mailer"
+
+	"gorm.io/gorm"
+)
+
+// Sign-in throttling. Failures are counted by account and IP together, by
+// account from any IP and by IP on any account, so neither rotating IPs nor
+// spraying accounts gets around them, and one attacker behind an office's
+// shared IP does not lock out everyone else there.
+const (
+	// Failures older than this, or from before the account's last successful
+	// sign-in or lock, are not counted
+	loginWindow = 15 * time.Minute
+
+	// Failures on an account from one IP before each retry from there is
+	// delayed, doubling from a second up to maxLoginDelay
+	loginDelayAfter = 3
+	maxLoginDelay   = 5 * time.Minute
+
+	// Failures on an account from any IPs that lock it. Devices that signed in
+	// from the same IP before can still sign in, so an attacker cannot lock
+	// the owner out.
+	accountLockAfter    = 10
+	accountLockDuration = 15 * time.Minute
+
+	// Failures from an IP on any accounts before it is refused
+	ipBlockAfter = 50
+
+	// How long a device that signed in stays known
+	knownDeviceAge = 90 * 24 * time.Hour
+)
+
+// LoginAttempt describes a sign-in attempt and where it came from
+type LoginAttempt struct {
+	Email     string
+	IP        string
+	UserAgent string
+	Country   string
+	City      string
+}
+
+// event creates a security event for the attempt
+func (a LoginAttempt) event(userID uint, eventType, reason string) *models.SecurityEvent {
+	return &models.SecurityEvent{
+		UserID:    userID,
+		Email:     normalizeLoginEmail(a.Email),
+		Type:      eventType,
+		Reason:    reason,
+		IP:        a.IP,
+		UserAgent: a.UserAgent,
+		Device:    models.DeviceID(a.UserAgent),
+		Country:   a.Country,
+		City:      a.City,
+		CreatedAt: time.Now(),
+	}
+}
+
+func normalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// SecurityService throttles sign-ins and keeps users' security logs
+type SecurityService struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	mailer mailer.Mailer
+}
+
+// NewSecurityService creates a new SecurityService
+func NewSecurityService(db *gorm.DB, cfg *config.Config, mail mailer.Mailer) *SecurityService {
+	return &SecurityService{
+		db:     db,
+		cfg:    cfg,
+		mailer: mail,
+	}
+}
+
+// CheckLogin checks a sign-in attempt may go ahead before its password is
+// checked. When it may not, the attempt is logged as blocked, the error says
+// why and the duration is how long to wait.
+func (s *SecurityService) CheckLogin(attempt LoginAttempt) (time.Duration, error) {
+	now := time.Now()
+	email := normalizeLoginEmail(attempt.Email)
+
+	// Failures from the IP on any account
+	if wait, err := s.ipBlock(attempt.IP, now); err != nil {
+		return 0, err
+	} else if wait > 0 {
+		return s.block(attempt, "too many failed sign-ins from this network", wait)
+	}
+
+	// Failures on the account from any IP
+	var lock models.SecurityEvent
+	err := s.db.Where("email = ? AND type = ? AND created_at > ?", email, models.SecurityEventAccountLocked, now.Add(-accountLockDuration)).
+		Order("created_at DESC").First(&lock).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+	if err == nil {
+		known, err := s.knownDevice(email, attempt, now)
+		if err != nil {
+			return 0, err
+		}
+		if !known {
+			return s.block(attempt, "account is temporarily locked", lock.CreatedAt.Add(accountLockDuration).Sub(now))
+		}
+	}
+
+	// Failures on the account from this IP
+	since, err := s.failuresSince(email, now)
+	if err != nil {
+		return 0, err
+	}
+	var failures []models.SecurityEvent
+	if err := s.db.Where("email = ? AND ip = ? AND type = ? AND created_at > ?", email, attempt.IP, models.SecurityEventLoginFailed, since).
+		Order("created_at DESC").Find(&failures).Error; err != nil {
+		return 0, err
+	}
+	if len(failures) >= loginDelayAfter {
+		if wait := failures[0].CreatedAt.Add(loginDelay(len(failures))).Sub(now); wait > 0 {
+			return s.block(attempt, "too many failed attempts", wait)
+		}
+	}
+
+	return 0, nil
+}
+
+// loginDelay is the wait after a number of failures on an account from one IP
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayAfter {
+		return 0
+	}
+	shift := failures - loginDelayAfter
+	if shift > 16 {
+		return maxLoginDelay
+	}
+	delay := time.Second << shift
+	if delay > maxLoginDelay {
+		delay = maxLoginDelay
+	}
+	return delay
+}
+
+// ipBlock returns how long an IP must wait before it may try again
+func (s *SecurityService) ipBlock(ip string, now time.Time) (time.Duration, error) {
+	query := s.db.Model(&models.SecurityEvent{}).
+		Where("ip = ? AND type = ? AND created_at > ?", ip, models.SecurityEventLoginFailed, now.Add(-loginWindow))
+
+	var failures int64
+	if err := query.Count(&failures).Error; err != nil {
+		return 0, err
+	}
+	if failures < ipBlockAfter {
+		return 0, nil
+	}
+
+	// The IP may try again once enough of its failures fall out of the window
+	var oldest models.SecurityEvent
+	if err := query.Order("created_at ASC").Offset(int(failures - ipBlockAfter)).Limit(1).Find(&oldest).Error; err != nil {
+		return 0, err
+	}
+	return oldest.CreatedAt.Add(loginWindow).Sub(now), nil
+}
+
+// failuresSince returns when an account's failures start counting
+func (s *SecurityService) failuresSince(email string, now time.Time) (time.Time, error) {
+	since := now.Add(-loginWindow)
+
+	var last models.SecurityEvent
+	err := s.db.Where("email = ? AND type IN ? AND created_at > ?", email,
+		[]string{models.SecurityEventLoginSucceeded, models.SecurityEventAccountLocked}, since).
+		Order("created_at DESC").First(&last).Error
+	if err == nil {
+		return last.CreatedAt, nil
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return since, nil
+	}
+	return since, err
+}
+
+// knownDevice reports whether the attempt comes from a device that signed in
+// to the account from the same IP before
+func (s *SecurityService) knownDevice(email string, attempt LoginAttempt, now time.Time) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.SecurityEvent{}).
+		Where("email = ? AND type = ? AND device = ? AND ip = ? AND created_at > ?", email, models.SecurityEventLoginSucceeded,
+			models.DeviceID(attempt.UserAgent), attempt.IP, now.Add(-knownDeviceAge)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// block logs a refused attempt
+func (s *SecurityService) block(attempt LoginAttempt, reason string, wait time.Duration) (time.Duration, error) {
+	userID, err := s.userID(attempt.Email)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.db.Create(attempt.event(userID, models.SecurityEventLoginBlocked, reason)).Error; err != nil {
+		return 0, fmt.Errorf("failed to log sign-in attempt: %w", err)
+	}
+	return wait, errors.New(reason)
+}
+
+// userID returns the ID of the account with an email, or zero
+func (s *SecurityService) userID(email string) (uint, error) {
+	var user models.User
+	err := s.db.Select("id").Where("LOWER(email) = ?", normalizeLoginEmail(email)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return user.ID, err
+}
+
+// RecordFailure logs a sign-in attempt that failed, such as with a wrong
+// password or verification code. When it locks the account, the lock event is
+// returned for NotifyAsync.
+func (s *SecurityService) RecordFailure(attempt LoginAttempt, reason string) (*models.SecurityEvent, error) {
+	now := time.Now()
+	email := normalizeLoginEmail(attempt.Email)
+
+	userID, err := s.userID(email)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Create(attempt.event(userID, models.SecurityEventLoginFailed, reason)).Error; err != nil {
+		return nil, fmt.Errorf("failed to log sign-in attempt: %w", err)
+	}
+
+	since, err := s.failuresSince(email, now)
+	if err != nil {
+		return nil, err
+	}
+	var failures int64
+	if err := s.db.Model(&models.SecurityEvent{}).
+		Where("email = ? AND type = ? AND created_at > ?", email, models.SecurityEventLoginFailed, since).
+		Count(&failures).Error; err != nil {
+		return nil, err
+	}
+	if failures < accountLockAfter {
+		return nil, nil
+	}
+
+	// Emails without an account lock the same way, so locks do not tell
+	// which emails have one
+	lock := attempt.event(userID, models.SecurityEventAccountLocked, fmt.Sprintf("%d failed sign-ins", failures))
+	if err := s.db.Create(lock).Error; err != nil {
+		return nil, fmt.Errorf("failed to lock account: %w", err)
+	}
+	return lock, nil
+}
+
+// RecordSuccess logs a successful sign-in. When it is the first from a device
+// on an account that has signed in before, the new device event is returned
+// for NotifyAsync.
+func (s *SecurityService) RecordSuccess(user *models.User, attempt LoginAttempt) (*models.SecurityEvent, error) {
+	success := attempt.event(user.ID, models.SecurityEventLoginSucceeded, "")
+
+	var previous, fromDevice int64
+	query := s.db.Model(&models.SecurityEvent{}).Where("user_id = ? AND type = ?", user.ID, models.SecurityEventLoginSucceeded)
+	if err := query.Count(&previous).Error; err != nil {
+		return nil, err
+	}
+	if err := query.Where("device = ?", success.Device).Count(&fromDevice).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(success).Error; err != nil {
+		return nil, fmt.Errorf("failed to log sign-in: %w", err)
+	}
+	if previous == 0 || fromDevice > 0 {
+		return nil, nil
+	}
+
+	newDevice := attempt.event(user.ID, models.SecurityEventNewDevice, "")
+	if err := s.db.Create(newDevice).Error; err != nil {
+		return nil, fmt.Errorf("failed to log new device: %w", err)
+	}
+	return newDevice, nil
+}
+
+// RecordChange logs a change to the security of a user's account, such as
+// their password
+func (s *SecurityService) RecordChange(userID uint, eventType string, attempt LoginAttempt) error {
+	return s.db.Create(attempt.event(userID, eventType, "")).Error
+}
+
+// GetSecurityLog retrieves a user's security events, newest first
+func (s *SecurityService) GetSecurityLog(userID uint, page, limit int) ([]models.SecurityEvent, int64, error) {
+	var events []models.SecurityEvent
+	var total int64
+
+	query := s.db.Model(&models.SecurityEvent{}).Where("user_id = ?", userID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	if err := query.Order("created_at DESC, id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// Notify emails a user about a lock on their account or a sign-in from a new
+// device
+func (s *SecurityService) Notify(ctx context.Context, event *models.SecurityEvent) error {
+	if event.UserID == 0 {
+		return nil
+	}
+
+	var user models.User
+	if err := s.db.First(&user, event.UserID).Error; err != nil {
+		return err
+	}
+
+	location := event.IP
+	if event.City != "" && event.Country != "" {
+		location = fmt.Sprintf("%s (%s, %s)", event.IP, event.City, event.Country)
+	} else if event.Country != "" {
+		location = fmt.Sprintf("%s (%s)", event.IP, event.Country)
+	}
+
+	var subject string
+	var body strings.Builder
+	switch event.Type {
+	case models.SecurityEventAccountLocked:
+		subject = "Sign-ins to your Chatelly account are locked"
+		fmt.Fprintf(&body, "After %s, we have locked sign-ins to your account for %d minutes.\n\n", event.Reason, int(accountLockDuration.Minutes()))
+		fmt.Fprintf(&body, "The last attempt came from %s using %s at %s.\n\n", location, event.UserAgent, event.CreatedAt.UTC().Format(time.RFC1123))
+		body.WriteString("Devices you have signed in from before can still sign in. If these attempts were not you, change your password.\n")
+	case models.SecurityEventNewDevice:
+		subject = "New sign-in to your Chatelly account"
+		fmt.Fprintf(&body, "Your account was signed in to from a new device at %s.\n\n", event.CreatedAt.UTC().Format(time.RFC1123))
+		fmt.Fprintf(&body, "Location: %s\nBrowser: %s\n\n", location, event.UserAgent)
+		body.WriteString("If this was you, there is nothing to do. If not, change your password straight away.\n")
+	default:
+		return nil
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      []string{user.Email},
+		Subject: subject,
+		Body:    body.String(),
+	})
+}
+
+// NotifyAsync emails the user in the background, logging failures
+func (s *SecurityService) NotifyAsync(event *models.SecurityEvent) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := s.Notify(ctx, event); err != nil {
+			log.Printf("Failed to email %s security event to user %d: %v", event.Type, event.UserID, err)
+		}
+	}()
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+)
+
+func setupSecurityTest(t *testing.T) (*SecurityService, *models.User, *fakeMailer) {
+	t.Helper()
+
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.SecurityEvent{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+	var user models.User
+	if err := db.First(&user, website.UserID).Error; err != nil {
+		t.Fatalf("failed to load user: %v", err)
+	}
+
+	mail := &fakeMailer{}
+	return NewSecurityService(db, &config.Config{}, mail), &user, mail
+}
+
+func TestSecurityService_DelaysRetriesFromAnIP(t *testing.T) {
+	service, user, _ := setupSecurityTest(t)
+	attacker := LoginAttempt{Email: "Owner@Example.com", IP: "203.0.113.1", UserAgent: "curl"}
+
+	for i := 0; i < loginDelayAfter; i++ {
+		if _, err := service.CheckLogin(attacker); err != nil {
+			t.Fatalf("expected attempt %d to go ahead, got %v", i+1, err)
+		}
+		if lock, err := service.RecordFailure(attacker, "invalid email or password"); err != nil || lock != nil {
+			t.Fatalf("RecordFailure() = %v, %v", lock, err)
+		}
+	}
+
+	wait, err := service.CheckLogin(attacker)
+	if err == nil || err.Error() != "too many failed attempts" {
+		t.Fatalf("expected the retry to be delayed, got %v", err)
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("expected a wait of up to a second, got %v", wait)
+	}
+
+	// The owner signing in from elsewhere is unaffected
+	owner := LoginAttempt{Email: user.Email, IP: "198.51.100.7", UserAgent: "Firefox"}
+	if _, err := service.CheckLogin(owner); err != nil {
+		t.Errorf("expected another IP to go ahead, got %v", err)
+	}
+
+	// The refused attempt is in the owner's log
+	events, total, err := service.GetSecurityLog(user.ID, 1, 10)
+	if err != nil {
+		t.Fatalf("GetSecurityLog() error = %v", err)
+	}
+	if total != 4 || events[0].Type != models.SecurityEventLoginBlocked || events[0].IP != attacker.IP {
+		t.Errorf("unexpected security log %+v", events)
+	}
+
+	if loginDelay(loginDelayAfter+2) != 4*time.Second || loginDelay(100) != maxLoginDelay {
+		t.Errorf("expected delays to double up to the maximum")
+	}
+}
+
+func TestSecurityService_LocksAccountsAcrossIPs(t *testing.T) {
+	service, user, mail := setupSecurityTest(t)
+
+	owner := LoginAttempt{Email: user.Email, IP: "198.51.100.7", UserAgent: "Firefox"}
+	if _, err := service.RecordSuccess(user, owner); err != nil {
+		t.Fatalf("RecordSuccess() error = %v", err)
+	}
+
+	// An attacker rotating IPs is never delayed, but locks the account
+	var lock *models.SecurityEvent
+	for i := 0; i < accountLockAfter; i++ {
+		attempt := LoginAttempt{Email: user.Email, IP: fmt.Sprintf("203.0.113.%d", i), UserAgent: "curl", Country: "NL"}
+		if _, err := service.CheckLogin(attempt); err != nil {
+			t.Fatalf("expected attempt %d to go ahead, got %v", i+1, err)
+		}
+		var err error
+		if lock, err = service.RecordFailure(attempt, "invalid email or password"); err != nil {
+			t.Fatalf("RecordFailure() error = %v", err)
+		}
+		if lock != nil && i != accountLockAfter-1 {
+			t.Fatalf("expected the account to lock after %d failures, locked after %d", accountLockAfter, i+1)
+		}
+	}
+	if lock == nil || lock.UserID != user.ID {
+		t.Fatalf("expected the account to be locked, got %+v", lock)
+	}
+
+	wait, err := service.CheckLogin(LoginAttempt{Email: user.Email, IP: "203.0.113.99", UserAgent: "curl"})
+	if err == nil || err.Error() != "account is temporarily locked" {
+		t.Fatalf("expected the account to be locked, got %v", err)
+	}
+	if wait <= accountLockDuration-time.Minute || wait > accountLockDuration {
+		t.Errorf("expected to wait out the lock, got %v", wait)
+	}
+
+	// The owner's known device can still sign in
+	if _, err := service.CheckLogin(owner); err != nil {
+		t.Errorf("expected the known device to go ahead, got %v", err)
+	}
+
+	if err := service.Notify(context.Background(), lock); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].To[0] != user.Email || !strings.Contains(mail.sent[0].Body, "10 failed sign-ins") {
+		t.Errorf("unexpected lock email %+v", mail.sent)
+	}
+}
+
+func TestSecurityService_BlocksIPsSprayingAccounts(t *testing.T) {
+	service, _, _ := setupSecurityTest(t)
+
+	for i := 0; i < ipBlockAfter; i++ {
+		attempt := LoginAttempt{Email: fmt.Sprintf("user%d@example.com", i), IP: "203.0.113.1", UserAgent: "curl"}
+		if _, err := service.RecordFailure(attempt, "invalid email or password"); err != nil {
+			t.Fatalf("RecordFailure() error = %v", err)
+		}
+	}
+
+	wait, err := service.CheckLogin(LoginAttempt{Email: "someone@example.com", IP: "203.0.113.1", UserAgent: "curl"})
+	if err == nil || err.Error() != "too many failed sign-ins from this network" {
+		t.Fatalf("expected the IP to be blocked, got %v", err)
+	}
+	if wait <= loginWindow-time.Minute || wait > loginWindow {
+		t.Errorf("expected to wait for the failures to expire, got %v", wait)
+	}
+
+	if _, err := service.CheckLogin(LoginAttempt{Email: "someone@example.com", IP: "203.0.113.2", UserAgent: "curl"}); err != nil {
+		t.Errorf("expected other IPs to go ahead, got %v", err)
+	}
+}
+
+func TestSecurityService_NewDevices(t *testing.T) {
+	service, user, mail := setupSecurityTest(t)
+	laptop := LoginAttempt{Email: user.Email, IP: "198.51.100.7", UserAgent: "Firefox", Country: "DE", City: "Berlin"}
+	phone := LoginAttempt{Email: user.Email, IP: "198.51.100.8", UserAgent: "Safari", Country: "DE"}
+
+	// The first sign-in has nothing to compare against
+	for _, attempt := range []LoginAttempt{laptop, laptop} {
+		event, err := service.RecordSuccess(user, attempt)
+		if err != nil || event != nil {
+			t.Fatalf("RecordSuccess() = %v, %v", event, err)
+		}
+	}
+
+	event, err := service.RecordSuccess(user, phone)
+	if err != nil {
+		t.Fatalf("RecordSuccess() error = %v", err)
+	}
+	if event == nil || event.Type != models.SecurityEventNewDevice || event.Device != models.DeviceID("Safari") {
+		t.Fatalf("expected a new device event, got %+v", event)
+	}
+
+	if err := service.Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].Subject != "New sign-in to your Chatelly account" || !strings.Contains(mail.sent[0].Body, "198.51.100.8 (DE)") {
+		t.Errorf("unexpected new device email %+v", mail.sent)
+	}
+
+	events, total, err := service.GetSecurityLog(user.ID, 1, 2)
+	if err != nil {
+		t.Fatalf("GetSecurityLog() error = %v", err)
+	}
+	if total != 4 || len(events) != 2 || events[0].Type != models.SecurityEventNewDevice || events[1].Type != models.SecurityEventLoginSucceeded {
+		t.Errorf("unexpected security log %+v", events)
+	}
+}
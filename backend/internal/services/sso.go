@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/oidc"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// How long a user has to sign in at the provider
+	SSOLoginTTL = 10 * time.Minute
+
+	// How long the dashboard has to exchange its code for a session
+	ssoCodeTTL = time.Minute
+)
+
+// ssoProvider is an identity provider users can sign in with
+type ssoProvider struct {
+	name        string
+	provider    *oidc.Provider
+	trustEmails bool
+}
+
+// SSOService signs users in through identity providers. Accounts are matched
+// by the provider's ID for the user, or else linked by verified email; users
+// without an account get one, and join the website that verified their
+// email's domain.
+type SSOService struct {
+	db        *gorm.DB
+	cfg       *config.Config
+	providers map[string]*ssoProvider
+}
+
+// NewSSOService creates a new SSOService with the providers that are set up
+func NewSSOService(db *gorm.DB, cfg *config.Config) *SSOService {
+	s := &SSOService{
+		db:        db,
+		cfg:       cfg,
+		providers: make(map[string]*ssoProvider),
+	}
+
+	sso := cfg.SSO
+	if sso.Google.ClientID != "" {
+		s.providers[models.SSOProviderGoogle] = &ssoProvider{
+			name:     sso.Google.Name,
+			provider: oidc.Google(sso.Google.ClientID, sso.Google.ClientSecret, s.CallbackURL(models.SSOProviderGoogle)),
+		}
+	}
+	if sso.GitHub.ClientID != "" {
+		s.providers[models.SSOProviderGitHub] = &ssoProvider{
+			name:     sso.GitHub.Name,
+			provider: oidc.GitHub(sso.GitHub.ClientID, sso.GitHub.ClientSecret, s.CallbackURL(models.SSOProviderGitHub)),
+		}
+	}
+	if sso.OIDC.ClientID != "" && sso.OIDC.Issuer != "" {
+		s.providers[models.SSOProviderOIDC] = &ssoProvider{
+			name:        sso.OIDC.Name,
+			provider:    oidc.New(sso.OIDC.Issuer, sso.OIDC.ClientID, sso.OIDC.ClientSecret, s.CallbackURL(models.SSOProviderOIDC)),
+			trustEmails: sso.OIDC.TrustEmails,
+		}
+	}
+
+	return s
+}
+
+// CallbackURL returns where a provider sends users back to
+func (s *SSOService) CallbackURL(provider string) string {
+	return strings.TrimSuffix(s.cfg.SSO.APIURL, "/") + "/api/v1/auth/sso/" + provider + "/callback"
+}
+
+// Providers lists the providers users can sign in with
+func (s *SSOService) Providers() []models.SSOProviderInfo {
+	providers := []models.SSOProviderInfo{}
+	for _, id := range []string{models.SSOProviderGoogle, models.SSOProviderGitHub, models.SSOProviderOIDC} {
+		if provider, ok := s.providers[id]; ok {
+			providers = append(providers, models.SSOProviderInfo{ID: id, Name: provider.name})
+		}
+	}
+	return providers
+}
+
+func hashSSOToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Begin starts a sign-in, returning the provider URL to send the user to and
+// the state to bind to their browser
+func (s *SSOService) Begin(ctx context.Context, providerID string) (string, string, error) {
+	provider, ok := s.providers[providerID]
+	if !ok {
+		return "", "", errors.New("unknown sign-in provider")
+	}
+
+	state, err := oidc.RandomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.RandomToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.RandomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to reach the sign-in provider: %w", err)
+	}
+
+	// Sign-ins that were never finished
+	if err := s.db.Where("expires_at < ?", time.Now()).Delete(&models.SSOLogin{}).Error; err != nil {
+		log.Printf("Failed to delete expired SSO sign-ins: %v", err)
+	}
+
+	login := &models.SSOLogin{
+		StateHash:    hashSSOToken(state),
+		Provider:     providerID,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(SSOLoginTTL),
+	}
+	if err := s.db.Create(login).Error; err != nil {
+		return "", "", fmt.Errorf("failed to start sign-in: %w", err)
+	}
+
+	return authURL, state, nil
+}
+
+// Complete finishes a sign-in when the provider redirects back with a code,
+// and returns a one-time code the dashboard exchanges for a session
+func (s *SSOService) Complete(ctx context.Context, providerID, state, code string) (string, error) {
+	provider, ok := s.providers[providerID]
+	if !ok {
+		return "", errors.New("unknown sign-in provider")
+	}
+
+	var login models.SSOLogin
+	if err := s.db.Where("state_hash = ? AND provider = ? AND code_hash = '' AND expires_at > ?", hashSSOToken(state), providerID, time.Now()).
+		First(&login).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", errors.New("invalid or expired sign-in")
+		}
+		return "", err
+	}
+
+	// Each state is used once: claim it by giving it the code the dashboard
+	// will exchange
+	exchangeCode, err := oidc.RandomToken()
+	if err != nil {
+		return "", err
+	}
+	result := s.db.Model(&login).Where("code_hash = ''").Updates(map[string]interface{}{
+		"code_hash":  hashSSOToken(exchangeCode),
+		"expires_at": time.Now().Add(ssoCodeTTL),
+	})
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", errors.New("invalid or expired sign-in")
+	}
+
+	user, err := s.signIn(ctx, provider, providerID, code, &login)
+	if err != nil {
+		s.db.Delete(&login)
+		return "", err
+	}
+	if err := s.db.Model(&login).Update("user_id", user.ID).Error; err != nil {
+		return "", fmt.Errorf("failed to finish sign-in: %w", err)
+	}
+
+	return exchangeCode, nil
+}
+
+// signIn redeems the provider's code and returns the account it signs in to
+func (s *SSOService) signIn(ctx context.Context, provider *ssoProvider, providerID, code string, login *models.SSOLogin) (*models.User, error) {
+	identity, err := provider.provider.Exchange(ctx, code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("Sign-in with %s failed: %v", providerID, err)
+		return nil, errors.New("sign-in with the provider failed")
+	}
+	if provider.trustEmails && identity.Email != "" {
+		identity.EmailVerified = true
+	}
+
+	return s.resolveUser(providerID, identity)
+}
+
+// Exchange redeems the one-time code from Complete, returning the user to
+// start a session for
+func (s *SSOService) Exchange(code string) (*models.User, error) {
+	var login models.SSOLogin
+	if err := s.db.Where("code_hash = ? AND user_id <> 0 AND expires_at > ?", hashSSOToken(code), time.Now()).
+		First(&login).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("invalid or expired sign-in code")
+		}
+		return nil, err
+	}
+
+	// Only one of two racing requests gets the session
+	result := s.db.Delete(&login)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("invalid or expired sign-in code")
+	}
+
+	var user models.User
+	if err := s.db.Where("id = ? AND is_active = ?", login.UserID, true).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("account is deactivated")
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// resolveUser finds or creates the account an identity signs in to
+func (s *SSOService) resolveUser(providerID string, identity *oidc.Identity) (*models.User, error) {
+	// Signed in with this provider before
+	var linked models.UserIdentity
+	err := s.db.Where("provider = ? AND subject = ?", providerID, identity.Subject).First(&linked).Error
+	if err == nil {
+		var user models.User
+		if err := s.db.First(&user, linked.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errors.New("account is deactivated")
+			}
+			return nil, err
+		}
+		if !user.IsActive {
+			return nil, errors.New("account is deactivated")
+		}
+		if identity.Email != "" && identity.Email != linked.Email {
+			s.db.Model(&linked).Update("email", identity.Email)
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Anyone can put any email on an account at some providers, so only
+	// verified ones are trusted to link or create accounts
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errors.New("email not verified by the provider")
+	}
+	email := strings.ToLower(strings.TrimSpace(identity.Email))
+
+	var user models.User
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("LOWER(email) = ?", email).First(&user).Error
+		switch {
+		case err == nil:
+			if !user.IsActive {
+				return errors.New("account is deactivated")
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := provisionUser(tx, &user, email, identity.Name); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: providerID,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// provisionUser creates an account for a user signing in for the first time,
+// adding them as an agent on the website that verified their email's domain
+func provisionUser(tx *gorm.DB, user *models.User, email, name string) error {
+	if name == "" {
+		name = email[:strings.Index(email, "@")]
+	}
+	*user = models.User{
+		Email: email,
+		Name:  name,
+		Plan:  "free",
+	}
+	if err := user.SetUnusablePassword(); err != nil {
+		return err
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return fmt.Errorf("failed to create account: %w", err)
+	}
+
+	var websiteIDs []uint
+	if err := tx.Model(&models.SSODomain{}).
+		Joins("JOIN websites ON websites.id = sso_domains.website_id AND websites.deleted_at IS NULL").
+		Where("sso_domains.domain = ? AND sso_domains.verified_at IS NOT NULL", models.EmailDomain(email)).
+		Pluck("sso_domains.website_id", &websiteIDs).Error; err != nil {
+		return err
+	}
+	for _, websiteID := range websiteIDs {
+		if err := tx.Create(&models.WebsiteAgent{WebsiteID: websiteID, UserID: user.ID}).Error; err != nil {
+			return fmt.Errorf("failed to add agent: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetIdentities lists the identity providers linked to a user
+func (s *SSOService) GetIdentities(userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := s.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error
+	return identities, err
+}
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/oidc"
+	"chatelly-backend/pkg/oidc/oidctest"
+
+	"gorm.io/gorm"
+)
+
+func setupSSOTest(t *testing.T) (*SSOService, *oidctest.Server, *gorm.DB, *models.Website) {
+	t.Helper()
+
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.UserIdentity{}, &models.SSOLogin{}, &models.SSODomain{}, &models.WebsiteAgent{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	website := createTestWebsite(t, db)
+
+	server := oidctest.NewServer("chatelly", "secret")
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{SSO: config.SSOConfig{
+		APIURL: "https://api.example.com",
+		OIDC: config.SSOProviderConfig{
+			Name:         "Acme SSO",
+			Issuer:       server.Issuer(),
+			ClientID:     "chatelly",
+			ClientSecret: "secret",
+		},
+	}}
+	return NewSSOService(db, cfg), server, db, website
+}
+
+// fakeResolver serves DNS TXT records by name
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+// ssoSignIn signs a user in at the provider and returns the dashboard's code
+func ssoSignIn(t *testing.T, service *SSOService, server *oidctest.Server, user oidc.Identity) (string, error) {
+	t.Helper()
+	ctx := context.Background()
+	server.SetUser(user)
+
+	authURL, state, err := service.Begin(ctx, models.SSOProviderOIDC)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	code, returnedState, err := server.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	if returnedState != state {
+		t.Fatalf("expected the state back")
+	}
+	return service.Complete(ctx, models.SSOProviderOIDC, state, code)
+}
+
+func TestSSOService_ProvisionsUsersIntoWebsitesByDomain(t *testing.T) {
+	service, server, db, website := setupSSOTest(t)
+
+	if providers := service.Providers(); len(providers) != 1 || providers[0].ID != models.SSOProviderOIDC || providers[0].Name != "Acme SSO" {
+		t.Fatalf("unexpected providers %+v", providers)
+	}
+
+	assignments := NewAssignmentService(db, &config.Config{}, nil)
+	domain, err := assignments.AddSSODomain(website.ID, "https://www.Example.com/")
+	if err != nil {
+		t.Fatalf("AddSSODomain() error = %v", err)
+	}
+	assignments.resolver = fakeResolver{domain.RecordName: {"v=spf1 -all", domain.RecordValue}}
+	if _, err := assignments.VerifySSODomain(context.Background(), domain.ID, website.ID); err != nil {
+		t.Fatalf("VerifySSODomain() error = %v", err)
+	}
+
+	jane := oidc.Identity{Subject: "jane-1", Email: "jane@example.com", EmailVerified: true, Name: "Jane"}
+	code, err := ssoSignIn(t, service, server, jane)
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	user, err := service.Exchange(code)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if user.Email != "jane@example.com" || user.Name != "Jane" {
+		t.Errorf("unexpected user %+v", user)
+	}
+
+	// The code is good for one session
+	if _, err := service.Exchange(code); err == nil {
+		t.Errorf("expected the code to be used only once")
+	}
+
+	// Jane joined the website claiming her domain
+	if err := assignments.ValidateAgentAccess(website.ID, user.ID); err != nil {
+		t.Errorf("expected the new user to be an agent: %v", err)
+	}
+
+	// and signs in to the same account next time, even with a new email
+	jane.Email = "jane.doe@example.com"
+	code, err = ssoSignIn(t, service, server, jane)
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	again, err := service.Exchange(code)
+	if err != nil || again.ID != user.ID {
+		t.Errorf("expected the linked account, got %v, %v", again, err)
+	}
+	identities, _ := service.GetIdentities(user.ID)
+	if len(identities) != 1 || identities[0].Email != "jane.doe@example.com" {
+		t.Errorf("unexpected identities %+v", identities)
+	}
+
+	// Users at other domains get an account but join nothing
+	code, err = ssoSignIn(t, service, server, oidc.Identity{Subject: "bob-1", Email: "bob@other.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	bob, _ := service.Exchange(code)
+	if bob == nil || bob.Name != "bob" {
+		t.Fatalf("expected bob to get an account, got %+v", bob)
+	}
+	if err := assignments.ValidateAgentAccess(website.ID, bob.ID); err == nil {
+		t.Errorf("expected bob not to join the website")
+	}
+}
+
+func TestSSOService_LinksAccountsByVerifiedEmail(t *testing.T) {
+	service, server, db, website := setupSSOTest(t)
+
+	// Unverified emails are not trusted with an account
+	_, err := ssoSignIn(t, service, server, oidc.Identity{Subject: "mallory", Email: "owner@example.com"})
+	if err == nil || err.Error() != "email not verified by the provider" {
+		t.Fatalf("expected an unverified email to be refused, got %v", err)
+	}
+
+	code, err := ssoSignIn(t, service, server, oidc.Identity{Subject: "owner-1", Email: "Owner@Example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	user, err := service.Exchange(code)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if user.ID != website.UserID {
+		t.Errorf("expected the existing account to be linked, got user %d", user.ID)
+	}
+
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected no new account, got %d users", count)
+	}
+}
+
+func TestSSOService_StatesAreSingleUse(t *testing.T) {
+	service, server, _, _ := setupSSOTest(t)
+	ctx := context.Background()
+	server.SetUser(oidc.Identity{Subject: "jane-1", Email: "jane@example.com", EmailVerified: true})
+
+	if _, _, err := service.Begin(ctx, models.SSOProviderGoogle); err == nil || err.Error() != "unknown sign-in provider" {
+		t.Errorf("expected an unconfigured provider to be refused, got %v", err)
+	}
+
+	authURL, state, err := service.Begin(ctx, models.SSOProviderOIDC)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	code, _, _ := server.Authorize(authURL)
+
+	if _, err := service.Complete(ctx, models.SSOProviderOIDC, "forged", code); err == nil || err.Error() != "invalid or expired sign-in" {
+		t.Errorf("expected an unknown state to be refused, got %v", err)
+	}
+	if _, err := service.Complete(ctx, models.SSOProviderOIDC, state, code); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if _, err := service.Complete(ctx, models.SSOProviderOIDC, state, code); err == nil || err.Error() != "invalid or expired sign-in" {
+		t.Errorf("expected a used state to be refused, got %v", err)
+	}
+}
+
+func TestAssignmentService_AddSSODomain(t *testing.T) {
+	_, _, db, website := setupSSOTest(t)
+	assignments := NewAssignmentService(db, &config.Config{}, nil)
+
+	tests := []struct {
+		domain string
+		err    string
+	}{
+		{"gmail.com", "domain belongs to a public email provider"},
+		{"other.com", "domain does not match the website"},
+		{"com", "invalid domain format"},
+		{"example.com", ""},
+		{"EXAMPLE.com", "domain already added"},
+	}
+	for _, tt := range tests {
+		_, err := assignments.AddSSODomain(website.ID, tt.domain)
+		if tt.err == "" && err != nil {
+			t.Errorf("AddSSODomain(%q) error = %v", tt.domain, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("AddSSODomain(%q) error = %v, want %s", tt.domain, err, tt.err)
+		}
+	}
+}
+
+func TestAssignmentService_VerifySSODomain(t *testing.T) {
+	service, server, db, website := setupSSOTest(t)
+	ctx := context.Background()
+	assignments := NewAssignmentService(db, &config.Config{}, nil)
+
+	// Anyone can create a website for someone else's domain
+	squatter := &models.Website{UserID: website.UserID, Name: "Squatter", Domain: "example.com"}
+	if err := db.Create(squatter).Error; err != nil {
+		t.Fatalf("failed to create website: %v", err)
+	}
+	claimed, err := assignments.AddSSODomain(squatter.ID, "example.com")
+	if err != nil {
+		t.Fatalf("AddSSODomain() error = %v", err)
+	}
+	if claimed.RecordName != "_chatelly-verification.example.com" || claimed.RecordValue == "" {
+		t.Errorf("unexpected verification record %s %s", claimed.RecordName, claimed.RecordValue)
+	}
+
+	// An unverified claim provisions nothing
+	code, err := ssoSignIn(t, service, server, oidc.Identity{Subject: "jane-1", Email: "jane@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	jane, err := service.Exchange(code)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if err := assignments.ValidateAgentAccess(squatter.ID, jane.ID); err == nil {
+		t.Errorf("expected an unverified domain not to add agents")
+	}
+
+	// Without the record, or with another claim's, the domain stays unverified
+	assignments.resolver = fakeResolver{}
+	if _, err := assignments.VerifySSODomain(ctx, claimed.ID, squatter.ID); err == nil || err.Error() != "verification record not found" {
+		t.Errorf("expected a missing record to be refused, got %v", err)
+	}
+	owned, err := assignments.AddSSODomain(website.ID, "example.com")
+	if err != nil {
+		t.Fatalf("AddSSODomain() error = %v", err)
+	}
+	assignments.resolver = fakeResolver{owned.RecordName: {owned.RecordValue}}
+	if _, err := assignments.VerifySSODomain(ctx, claimed.ID, squatter.ID); err == nil || err.Error() != "verification record not found" {
+		t.Errorf("expected another claim's record to be refused, got %v", err)
+	}
+
+	// The website controlling the domain verifies it, and keeps it
+	verified, err := assignments.VerifySSODomain(ctx, owned.ID, website.ID)
+	if err != nil || verified.VerifiedAt == nil {
+		t.Fatalf("VerifySSODomain() = %+v, %v", verified, err)
+	}
+	assignments.resolver = fakeResolver{claimed.RecordName: {claimed.RecordValue}}
+	if _, err := assignments.VerifySSODomain(ctx, claimed.ID, squatter.ID); err == nil || err.Error() != "domain verified by another website" {
+		t.Errorf("expected a verified domain to be refused to others, got %v", err)
+	}
+	other := &models.Website{UserID: website.UserID, Name: "Other", Domain: "example.com"}
+	if err := db.Create(other).Error; err != nil {
+		t.Fatalf("failed to create website: %v", err)
+	}
+	if _, err := assignments.AddSSODomain(other.ID, "example.com"); err == nil || err.Error() != "domain verified by another website" {
+		t.Errorf("expected a verified domain to be refused to others, got %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+	"chatelly-backend/pkg/mailer"
+	"chatelly-backend/pkg/pdf"
+
+	"gorm.io/gorm"
+)
+
+// transcriptPageSize is how many messages are loaded at a time
+const transcriptPageSize = 500
+
+// transcriptTimeLayout formats message times in the website's timezone
+const transcriptTimeLayout = "2006-01-02 15:04 MST"
+
+// TranscriptService builds chat transcripts and emails them
+type TranscriptService struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	mailer mailer.Mailer
+}
+
+// NewTranscriptService creates a new TranscriptService
+func NewTranscriptService(db *gorm.DB, cfg *config.Config, mail mailer.Mailer) *TranscriptService {
+	return &TranscriptService{
+		db:     db,
+		cfg:    cfg,
+		mailer: mail,
+	}
+}
+
+// GetTranscript collects a chat's messages in order. Times are converted to
+// the website's business hours timezone.
+func (s *TranscriptService) GetTranscript(chatID uint) (*models.Transcript, error) {
+	var chat models.Chat
+	if err := s.db.Preload("Website").First(&chat, chatID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("chat not found")
+		}
+		return nil, err
+	}
+
+	chatService := NewChatService(s.db, s.cfg)
+	var messages []models.Message
+	for page := 1; ; page++ {
+		batch, total, err := chatService.GetMessagesByChatID(chatID, page, transcriptPageSize)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, batch...)
+		if len(batch) < transcriptPageSize || int64(len(messages)) >= total {
+			break
+		}
+	}
+
+	// Agent replies show the agent's name
+	agentIDs := make([]uint, 0)
+	for _, message := range messages {
+		if message.SenderID != nil {
+			agentIDs = append(agentIDs, *message.SenderID)
+		}
+	}
+	agentNames := make(map[uint]string)
+	if len(agentIDs) > 0 {
+		var agents []models.User
+		if err := s.db.Select("id", "name").Where("id IN ?", agentIDs).Find(&agents).Error; err != nil {
+			return nil, err
+		}
+		for _, agent := range agents {
+			agentNames[agent.ID] = agent.Name
+		}
+	}
+
+	location := chat.Website.Settings.Location()
+	transcript := &models.Transcript{
+		ChatID:      chat.ID,
+		WebsiteName: chat.Website.Name,
+		StartedAt:   chat.StartedAt.In(location),
+		Entries:     make([]models.TranscriptEntry, 0, len(messages)),
+	}
+	if chat.EndedAt != nil {
+		endedAt := chat.EndedAt.In(location)
+		transcript.EndedAt = &endedAt
+	}
+
+	for _, message := range messages {
+		sender := "Visitor"
+		switch message.Sender {
+		case models.SenderBot:
+			sender = "Bot"
+		case models.SenderAgent:
+			sender = "Agent"
+			if message.SenderID != nil && agentNames[*message.SenderID] != "" {
+				sender = agentNames[*message.SenderID]
+			}
+		}
+		transcript.Entries = append(transcript.Entries, models.TranscriptEntry{
+			Sender:    sender,
+			Content:   message.Content,
+			Timestamp: message.Timestamp.In(location),
+		})
+	}
+
+	return transcript, nil
+}
+
+// RenderTranscript renders a transcript as plain text, HTML or PDF and
+// returns it with its content type
+func RenderTranscript(transcript *models.Transcript, format string) ([]byte, string, error) {
+	switch format {
+	case models.TranscriptFormatText:
+		return []byte(renderTranscriptText(transcript)), "text/plain; charset=utf-8", nil
+	case models.TranscriptFormatHTML:
+		var buf bytes.Buffer
+		if err := transcriptHTMLTemplate.Execute(&buf, transcript); err != nil {
+			return nil, "", fmt.Errorf("failed to render transcript: %w", err)
+		}
+		return buf.Bytes(), "text/html; charset=utf-8", nil
+	case models.TranscriptFormatPDF:
+		return renderTranscriptPDF(transcript), "application/pdf", nil
+	default:
+		return nil, "", models.ValidateTranscriptFormat(format)
+	}
+}
+
+func renderTranscriptText(transcript *models.Transcript) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Chat transcript - %s\n", transcript.WebsiteName)
+	fmt.Fprintf(&b, "Started: %s\n", transcript.StartedAt.Format(transcriptTimeLayout))
+	if transcript.EndedAt != nil {
+		fmt.Fprintf(&b, "Ended: %s\n", transcript.EndedAt.Format(transcriptTimeLayout))
+	}
+	b.WriteString("\n")
+	for _, entry := range transcript.Entries {
+		fmt.Fprintf(&b, "[%s] %s: %s\n", entry.Timestamp.Format(transcriptTimeLayout), entry.Sender, entry.Content)
+	}
+	return b.String()
+}
+
+func renderTranscriptPDF(transcript *models.Transcript) []byte {
+	doc := pdf.New()
+	doc.Bold("Chat transcript - " + transcript.WebsiteName)
+	doc.Text("Started: " + transcript.StartedAt.Format(transcriptTimeLayout))
+	if transcript.EndedAt != nil {
+		doc.Text("Ended: " + transcript.EndedAt.Format(transcriptTimeLayout))
+	}
+	for _, entry := range transcript.Entries {
+		doc.Blank()
+		doc.Bold(entry.Sender + " - " + entry.Timestamp.Format(transcriptTimeLayout))
+		doc.Text(entry.Content)
+	}
+	return doc.Bytes()
+}
+
+var transcriptHTMLTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"formatTime": func(t time.Time) string { return t.Format(transcriptTimeLayout) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Chat transcript - {{.WebsiteName}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; max-width: 720px; margin: 40px auto; color: #333; }
+.meta { color: #6c757d; font-size: 14px; }
+.entry { margin: 16px 0; }
+.sender { font-weight: 600; }
+.time { color: #6c757d; font-size: 12px; margin-left: 8px; }
+.content { white-space: pre-wrap; margin-top: 4px; }
+</style>
+</head>
+<body>
+<h1>Chat transcript - {{.WebsiteName}}</h1>
+<p class="meta">Started: {{formatTime .StartedAt}}{{if .EndedAt}}<br>Ended: {{formatTime .EndedAt}}{{end}}</p>
+{{range .Entries}}<div class="entry"><span class="sender">{{.Sender}}</span><span class="time">{{formatTime .Timestamp}}</span><div class="content">{{.Content}}</div></div>
+{{end}}</body>
+</html>
+`))
+
+// EmailTranscript sends a chat's transcript to the given team addresses: the
+// text version as the body and the PDF attached
+func (s *TranscriptService) EmailTranscript(ctx context.Context, chatID uint, recipients []string) error {
+	return s.emailTranscript(ctx, chatID, recipients, false)
+}
+
+func (s *TranscriptService) emailTranscript(ctx context.Context, chatID uint, recipients []string, visitor bool) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	transcript, err := s.GetTranscript(chatID)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("[%s] Chat transcript #%d", transcript.WebsiteName, transcript.ChatID)
+	if visitor {
+		subject = fmt.Sprintf("Your chat with %s", transcript.WebsiteName)
+	}
+	body := renderTranscriptText(transcript)
+	attachment := mailer.Attachment{
+		Filename:    fmt.Sprintf("chat-%d-transcript.pdf", transcript.ChatID),
+		ContentType: "application/pdf",
+		Data:        renderTranscriptPDF(transcript),
+	}
+
+	// Recipients don't see each other's addresses
+	for _, recipient := range recipients {
+		if err := s.mailer.Send(ctx, mailer.Message{
+			To:          []string{recipient},
+			Subject:     subject,
+			Body:        body,
+			Attachments: []mailer.Attachment{attachment},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SendChatEndedTranscripts emails an ended chat's transcript to the
+// website's transcript recipients and, when they asked for it, the visitor
+func (s *TranscriptService) SendChatEndedTranscripts(ctx context.Context, chatID uint) error {
+	var chat models.Chat
+	if err := s.db.Preload("Website").First(&chat, chatID).Error; err != nil {
+		return err
+	}
+
+	if err := s.EmailTranscript(ctx, chatID, chat.Website.Settings.Transcripts.OwnerEmails); err != nil {
+		return fmt.Errorf("failed to email owners: %w", err)
+	}
+
+	return s.sendVisitorTranscript(ctx, &chat)
+}
+
+// SendChatEndedTranscriptsAsync sends the transcripts in the background,
+// logging failures
+func (s *TranscriptService) SendChatEndedTranscriptsAsync(chatID uint) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		if err := s.SendChatEndedTranscripts(ctx, chatID); err != nil {
+			log.Printf("Failed to send transcripts for chat %d: %v", chatID, err)
+		}
+	}()
+}
+
+// RequestTranscript records a visitor's request for their transcript. Active
+// chats get the transcript when they end; for ended chats it is sent right
+// away. Each chat's transcript goes to the visitor only once, and only to an
+// address they already left in the pre-chat form or were identified by, so
+// the widget cannot be used to email anyone else.
+func (s *TranscriptService) RequestTranscript(ctx context.Context, website *models.Website, req *models.TranscriptRequest) error {
+	if !website.Settings.Transcripts.VisitorRequests {
+		return errors.New("transcript requests are not enabled")
+	}
+
+	var chat models.Chat
+	if err := s.db.Where("website_id = ? AND session_id = ?", website.ID, req.SessionID).First(&chat).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("chat not found")
+		}
+		return err
+	}
+	if chat.TranscriptSentAt != nil {
+		return errors.New("transcript already sent")
+	}
+
+	addresses, err := s.visitorEmails(website, &chat)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		return errors.New("no email address for this chat")
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" {
+		email = addresses[0]
+	} else if !slices.Contains(addresses, email) {
+		return errors.New("email does not match the chat")
+	}
+
+	chat.TranscriptEmail = email
+	if err := s.db.Model(&chat).UpdateColumn("transcript_email", chat.TranscriptEmail).Error; err != nil {
+		return fmt.Errorf("failed to save transcript request: %w", err)
+	}
+
+	if chat.IsActive {
+		return nil
+	}
+	return s.sendVisitorTranscript(ctx, &chat)
+}
+
+// visitorEmails returns the addresses the visitor of a chat left: in the
+// pre-chat form, then on their contact
+func (s *TranscriptService) visitorEmails(website *models.Website, chat *models.Chat) ([]string, error) {
+	var addresses []string
+	add := func(email string) {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" && !slices.Contains(addresses, email) {
+			addresses = append(addresses, email)
+		}
+	}
+
+	for _, field := range website.Settings.PreChatForm.Fields {
+		if field.Type == models.PreChatFieldEmail || field.Name == "email" {
+			add(chat.PreChatData[field.Name])
+		}
+	}
+	if chat.ContactID != nil {
+		var contact models.Contact
+		err := s.db.Select("email").Where("id = ? AND website_id = ?", *chat.ContactID, chat.WebsiteID).First(&contact).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		add(contact.Email)
+	}
+	return addresses, nil
+}
+
+// sendVisitorTranscript emails the transcript to the address the visitor
+// left, at most once
+func (s *TranscriptService) sendVisitorTranscript(ctx context.Context, chat *models.Chat) error {
+	if chat.TranscriptEmail == "" || chat.TranscriptSentAt != nil {
+		return nil
+	}
+
+	// Claim the send first so concurrent calls don't email twice
+	now := time.Now()
+	result := s.db.Model(&models.Chat{}).
+		Where("id = ? AND transcript_sent_at IS NULL", chat.ID).
+		UpdateColumn("transcript_sent_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	chat.TranscriptSentAt = &now
+
+	if err := s.emailTranscript(ctx, chat.ID, []string{chat.TranscriptEmail}, true); err != nil {
+		// Allow another attempt
+		s.db.Model(&models.Chat{}).Where("id = ?", chat.ID).UpdateColumn("transcript_sent_at", nil)
+		chat.TranscriptSentAt = nil
+		return fmt.Errorf("failed to email visitor: %w", err)
+	}
+	return nil
+}
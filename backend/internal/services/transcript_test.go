@@ -0,0 +1,176 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+)
+
+func TestTranscriptService_RenderAndEmail(t *testing.T) {
+	db := setupTestDB(t)
+	website := createTestWebsite(t, db)
+	website.Settings.Timezone = "Europe/Berlin"
+	website.Settings.Transcripts = models.TranscriptSettings{
+		VisitorRequests: true,
+		OwnerEmails:     []string{"support@example.com", "sales@example.com"},
+	}
+	if err := db.Model(website).Update("settings", website.Settings).Error; err != nil {
+		t.Fatalf("failed to update settings: %v", err)
+	}
+
+	agent := &models.User{Email: "alex@example.com", Password: "$2a$10$abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ01", Name: "Alex"}
+	if err := db.Create(agent).Error; err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+
+	if err := db.AutoMigrate(&models.Contact{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	contact := &models.Contact{WebsiteID: website.ID, Email: "jane@example.com"}
+	if err := db.Create(contact).Error; err != nil {
+		t.Fatalf("failed to create contact: %v", err)
+	}
+
+	start := time.Date(2026, 5, 4, 10, 0, 0, 0, time.UTC)
+	chat := &models.Chat{WebsiteID: website.ID, SessionID: "session-1", IsActive: true, StartedAt: start, ContactID: &contact.ID}
+	if err := db.Create(chat).Error; err != nil {
+		t.Fatalf("failed to create chat: %v", err)
+	}
+	messages := []models.Message{
+		{ChatID: chat.ID, Sender: models.SenderUser, Content: "Hi, is <b>shipping</b> free?", Timestamp: start.Add(time.Minute)},
+		{ChatID: chat.ID, Sender: models.SenderAgent, SenderID: &agent.ID, Content: "Yes, above 50 EUR (€).", Timestamp: start.Add(2 * time.Minute)},
+	}
+	if err := db.Create(&messages).Error; err != nil {
+		t.Fatalf("failed to create messages: %v", err)
+	}
+
+	mail := &fakeMailer{}
+	service := NewTranscriptService(db, &config.Config{}, mail)
+
+	transcript, err := service.GetTranscript(chat.ID)
+	if err != nil {
+		t.Fatalf("GetTranscript() error = %v", err)
+	}
+	if len(transcript.Entries) != 2 || transcript.Entries[1].Sender != "Alex" || transcript.Entries[0].Sender != "Visitor" {
+		t.Fatalf("unexpected transcript entries %+v", transcript.Entries)
+	}
+
+	text, contentType, err := RenderTranscript(transcript, models.TranscriptFormatText)
+	if err != nil || contentType != "text/plain; charset=utf-8" {
+		t.Fatalf("RenderTranscript(txt) error = %v, content type %s", err, contentType)
+	}
+	// 10:01 UTC is 12:01 in Berlin summer time
+	if !strings.Contains(string(text), "[2026-05-04 12:01 CEST] Visitor: Hi, is <b>shipping</b> free?") {
+		t.Errorf("unexpected text transcript:\n%s", text)
+	}
+
+	html, _, err := RenderTranscript(transcript, models.TranscriptFormatHTML)
+	if err != nil {
+		t.Fatalf("RenderTranscript(html) error = %v", err)
+	}
+	if !strings.Contains(string(html), "&lt;b&gt;shipping&lt;/b&gt;") || strings.Contains(string(html), "<b>shipping") {
+		t.Errorf("expected message content to be escaped in HTML")
+	}
+
+	pdf, contentType, err := RenderTranscript(transcript, models.TranscriptFormatPDF)
+	if err != nil || contentType != "application/pdf" {
+		t.Fatalf("RenderTranscript(pdf) error = %v, content type %s", err, contentType)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.Contains(pdf, []byte("(Yes, above 50 EUR \\(\\200\\).)")) {
+		t.Errorf("unexpected PDF output")
+	}
+
+	if _, _, err := RenderTranscript(transcript, "docx"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+
+	// The visitor asks while the chat is active; it goes out when the chat ends
+	if err := service.RequestTranscript(context.Background(), website, &models.TranscriptRequest{SessionID: "session-1", Email: "Jane@Example.com"}); err != nil {
+		t.Fatalf("RequestTranscript() error = %v", err)
+	}
+	if len(mail.sent) != 0 {
+		t.Fatalf("expected no email before the chat ends, got %d", len(mail.sent))
+	}
+
+	if err := NewChatService(db, &config.Config{}).EndChat(chat.ID); err != nil {
+		t.Fatalf("EndChat() error = %v", err)
+	}
+	if err := service.SendChatEndedTranscripts(context.Background(), chat.ID); err != nil {
+		t.Fatalf("SendChatEndedTranscripts() error = %v", err)
+	}
+	if len(mail.sent) != 3 {
+		t.Fatalf("expected emails to 2 owners and the visitor, got %d", len(mail.sent))
+	}
+	visitorEmail := mail.sent[2]
+	if visitorEmail.To[0] != "jane@example.com" || visitorEmail.Subject != "Your chat with Example" || len(visitorEmail.Attachments) != 1 {
+		t.Errorf("unexpected visitor email %+v", visitorEmail)
+	}
+
+	// Each visitor gets the transcript once
+	if err := service.SendChatEndedTranscripts(context.Background(), chat.ID); err != nil {
+		t.Fatalf("SendChatEndedTranscripts() error = %v", err)
+	}
+	if len(mail.sent) != 5 {
+		t.Errorf("expected only owner emails on a second run, got %d emails", len(mail.sent))
+	}
+	if err := service.RequestTranscript(context.Background(), website, &models.TranscriptRequest{SessionID: "session-1", Email: "jane@example.com"}); err == nil || err.Error() != "transcript already sent" {
+		t.Errorf("RequestTranscript() error = %v, want transcript already sent", err)
+	}
+
+	website.Settings.Transcripts.VisitorRequests = false
+	if err := service.RequestTranscript(context.Background(), website, &models.TranscriptRequest{SessionID: "session-1", Email: "jane@example.com"}); err == nil || err.Error() != "transcript requests are not enabled" {
+		t.Errorf("RequestTranscript() error = %v, want transcript requests are not enabled", err)
+	}
+}
+
+func TestTranscriptService_RequestTranscriptOnlyToVisitorAddresses(t *testing.T) {
+	db := setupTestDB(t)
+	website := createTestWebsite(t, db)
+	website.Settings.Transcripts = models.TranscriptSettings{VisitorRequests: true}
+	website.Settings.PreChatForm = models.PreChatForm{
+		Enabled: true,
+		Fields:  []models.PreChatField{{Name: "work_email", Label: "Email", Type: models.PreChatFieldEmail}},
+	}
+
+	anonymous := &models.Chat{WebsiteID: website.ID, SessionID: "anonymous", StartedAt: time.Now()}
+	prechat := &models.Chat{WebsiteID: website.ID, SessionID: "prechat", StartedAt: time.Now(),
+		PreChatData: models.PreChatValues{"work_email": "Jane@Example.com"}}
+	for _, chat := range []*models.Chat{anonymous, prechat} {
+		if err := db.Create(chat).Error; err != nil {
+			t.Fatalf("failed to create chat: %v", err)
+		}
+	}
+
+	if err := db.Model(prechat).Update("is_active", false).Error; err != nil {
+		t.Fatalf("failed to end chat: %v", err)
+	}
+
+	mail := &fakeMailer{}
+	service := NewTranscriptService(db, &config.Config{}, mail)
+	request := func(sessionID, email string) error {
+		return service.RequestTranscript(context.Background(), website, &models.TranscriptRequest{SessionID: sessionID, Email: email})
+	}
+
+	if err := request("anonymous", "victim@example.org"); err == nil || err.Error() != "no email address for this chat" {
+		t.Errorf("expected a chat without an address to be refused, got %v", err)
+	}
+	if err := request("prechat", "victim@example.org"); err == nil || err.Error() != "email does not match the chat" {
+		t.Errorf("expected another address to be refused, got %v", err)
+	}
+	if len(mail.sent) != 0 {
+		t.Fatalf("expected no emails, got %d", len(mail.sent))
+	}
+
+	// Without an address, the one from the pre-chat form is used
+	if err := request("prechat", ""); err != nil {
+		t.Fatalf("RequestTranscript() error = %v", err)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].To[0] != "jane@example.com" {
+		t.Errorf("expected the transcript at the pre-chat address, got %+v", mail.sent)
+	}
+}
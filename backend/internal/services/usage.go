@@ -0,0 +1,275 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// How often metered calls are written to the database
+	UsageFlushInterval = 30 * time.Second
+
+	// How long a user's monthly total is trusted before it is reloaded,
+	// picking up the calls other servers metered
+	usageTotalTTL = time.Minute
+)
+
+// usageDay is the day a user's calls are metered under
+type usageDay struct {
+	userID uint
+	date   string
+}
+
+// usageTotal is a user's calls in a month as this server knows it
+type usageTotal struct {
+	month    string
+	calls    int64
+	loadedAt time.Time
+}
+
+// QuotaResult is the outcome of metering a call against a monthly quota
+type QuotaResult struct {
+	Allowed  bool
+	Quota    int64 // -1 for unlimited
+	Used     int64 // calls this month, including this one when allowed
+	ResetsAt time.Time
+}
+
+// UsageService meters API calls against the monthly quotas of plans. Calls are
+// counted in memory and written in batches by Flush, so one service should be
+// shared by everything that meters or reports usage.
+//
+// Usage is metered per user. Chatelly has no API keys or organizations: every
+// API call is made with a user's session token, and plans (and so quotas)
+// belong to users, so the user is both who calls and who is billed.
+type UsageService struct {
+	db  *gorm.DB
+	cfg *config.Config
+
+	mu      sync.Mutex
+	pending map[usageDay]int64 // calls not yet written
+	totals  map[uint]*usageTotal
+}
+
+// NewUsageService creates a new UsageService
+func NewUsageService(db *gorm.DB, cfg *config.Config) *UsageService {
+	return &UsageService{
+		db:      db,
+		cfg:     cfg,
+		pending: make(map[usageDay]int64),
+		totals:  make(map[uint]*usageTotal),
+	}
+}
+
+// RecordCall meters a user's API call unless it would go over quota, which is
+// -1 for unlimited. Servers share totals through the database, so a user
+// spreading calls over several can overshoot by what a server meters before
+// it reloads.
+func (s *UsageService) RecordCall(userID uint, quota int64) (*QuotaResult, error) {
+	now := time.Now().UTC()
+	month := models.UsageMonth(now)
+	_, end, _ := models.ParseUsageMonth(month)
+
+	s.mu.Lock()
+	total, ok := s.cachedTotal(userID, month, now)
+	s.mu.Unlock()
+
+	// Totals are loaded without the lock, so calls from other users are
+	// never metered one at a time behind the database
+	var loaded *usageTotal
+	if !ok {
+		calls, err := s.monthCalls(userID, month)
+		if err != nil {
+			return nil, err
+		}
+		loaded = &usageTotal{month: month, calls: calls, loadedAt: now}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if loaded != nil {
+		// Another call may have loaded the total in the meantime and metered
+		// against it
+		if total, ok = s.cachedTotal(userID, month, now); !ok {
+			total = loaded
+			s.totals[userID] = total
+		}
+	}
+
+	result := &QuotaResult{Quota: quota, Used: total.calls, ResetsAt: end}
+	if quota >= 0 && total.calls >= quota {
+		return result, nil
+	}
+
+	s.pending[usageDay{userID: userID, date: now.Format(models.UsageDateLayout)}]++
+	total.calls++
+	result.Allowed = true
+	result.Used = total.calls
+	return result, nil
+}
+
+// cachedTotal returns a user's calls in the month when they are known and
+// fresh. Caller must hold s.mu.
+func (s *UsageService) cachedTotal(userID uint, month string, now time.Time) (*usageTotal, bool) {
+	total, ok := s.totals[userID]
+	if !ok || total.month != month || now.Sub(total.loadedAt) >= usageTotalTTL {
+		return nil, false
+	}
+	return total, true
+}
+
+// monthCalls returns a user's calls in a month, written or not
+func (s *UsageService) monthCalls(userID uint, month string) (int64, error) {
+	days, err := s.days(userID, month)
+	if err != nil {
+		return 0, err
+	}
+
+	var calls int64
+	for _, day := range days {
+		calls += day.Calls
+	}
+	return calls, nil
+}
+
+// days returns a user's calls in a month by day, written or not. It takes
+// s.mu to add the calls not yet written, so callers must not hold it.
+func (s *UsageService) days(userID uint, month string) ([]models.APIUsageDay, error) {
+	start, end, err := models.ParseUsageMonth(month)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []models.APIUsage
+	if err := s.db.Where("user_id = ? AND date >= ? AND date < ?", userID,
+		start.Format(models.UsageDateLayout), end.Format(models.UsageDateLayout)).
+		Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load API usage: %w", err)
+	}
+
+	calls := make(map[string]int64)
+	for _, row := range rows {
+		calls[row.Date] += row.Calls
+	}
+
+	// A flush between the query and here can leave calls out until the next
+	// reload, never count them twice
+	s.mu.Lock()
+	for day, pending := range s.pending {
+		if day.userID == userID && day.date[:len(month)] == month {
+			calls[day.date] += pending
+		}
+	}
+	s.mu.Unlock()
+
+	days := make([]models.APIUsageDay, 0, len(calls))
+	for date, count := range calls {
+		days = append(days, models.APIUsageDay{Date: date, Calls: count})
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days, nil
+}
+
+// Flush writes the metered calls to the database. Calls that fail to write
+// are kept for the next flush.
+func (s *UsageService) Flush() error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[usageDay]int64)
+	s.mu.Unlock()
+
+	var firstErr error
+	for day, calls := range pending {
+		err := s.db.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "date"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"calls":      gorm.Expr("api_usages.calls + excluded.calls"),
+				"updated_at": time.Now(),
+			}),
+		}).Create(&models.APIUsage{UserID: day.userID, Date: day.date, Calls: calls}).Error
+		if err == nil {
+			continue
+		}
+
+		if firstErr == nil {
+			firstErr = fmt.Errorf("failed to write API usage: %w", err)
+		}
+		s.mu.Lock()
+		s.pending[day] += calls
+		s.mu.Unlock()
+	}
+	return firstErr
+}
+
+// GetUsage reports a user's API usage over a YYYY-MM month against their
+// plan; the current month when month is empty
+func (s *UsageService) GetUsage(userID uint, plan, month string) (*models.APIUsageReport, error) {
+	if month == "" {
+		month = models.UsageMonth(time.Now())
+	}
+	_, end, err := models.ParseUsageMonth(month)
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := s.days(userID, month)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := models.GetPlanLimits(plan)
+	report := &models.APIUsageReport{
+		Month:             month,
+		Plan:              limits.Plan,
+		Quota:             limits.MonthlyAPICalls,
+		Remaining:         -1,
+		ResetsAt:          end,
+		Days:              days,
+		RequestsPerMinute: limits.RequestsPerMinute,
+		RequestBurst:      limits.RequestBurst,
+	}
+	for _, day := range days {
+		report.Calls += day.Calls
+	}
+	if report.Quota >= 0 {
+		report.Remaining = report.Quota - report.Calls
+		if report.Remaining < 0 {
+			report.Remaining = 0
+		}
+	}
+	return report, nil
+}
+
+// GetMonthlyTotals returns every user's calls over a YYYY-MM month, for
+// billing. Only written calls are counted, so flush first to include the
+// latest.
+func (s *UsageService) GetMonthlyTotals(month string) ([]models.APIUsageTotal, error) {
+	start, end, err := models.ParseUsageMonth(month)
+	if err != nil {
+		return nil, err
+	}
+
+	var totals []models.APIUsageTotal
+	if err := s.db.Model(&models.APIUsage{}).
+		Select("user_id, SUM(calls) AS calls").
+		Where("date >= ? AND date < ?", start.Format(models.UsageDateLayout), end.Format(models.UsageDateLayout)).
+		Group("user_id").
+		Order("user_id").
+		Scan(&totals).Error; err != nil {
+		return nil, fmt.Errorf("failed to load API usage: %w", err)
+	}
+
+	for i := range totals {
+		totals[i].Month = month
+	}
+	return totals, nil
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+)
+
+func TestUsageService_RecordCall(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.APIUsage{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	service := NewUsageService(db, &config.Config{})
+
+	// Calls already written this month count against the quota
+	today := time.Now().UTC().Format(models.UsageDateLayout)
+	if err := db.Create(&models.APIUsage{UserID: 1, Date: today, Calls: 3}).Error; err != nil {
+		t.Fatalf("failed to create usage: %v", err)
+	}
+
+	result, err := service.RecordCall(1, 5)
+	if err != nil {
+		t.Fatalf("RecordCall() error = %v", err)
+	}
+	if !result.Allowed || result.Used != 4 || result.Quota != 5 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result, _ := service.RecordCall(1, 5); !result.Allowed || result.Used != 5 {
+		t.Fatalf("expected the last call of the quota to be allowed, got %+v", result)
+	}
+
+	result, err = service.RecordCall(1, 5)
+	if err != nil {
+		t.Fatalf("RecordCall() error = %v", err)
+	}
+	if result.Allowed || result.Used != 5 {
+		t.Errorf("expected the call over quota to be refused, got %+v", result)
+	}
+	if !result.ResetsAt.After(time.Now()) || result.ResetsAt.Day() != 1 {
+		t.Errorf("expected the quota to reset at the start of next month, got %v", result.ResetsAt)
+	}
+
+	// Unlimited plans are metered but never refused
+	for i := 0; i < 3; i++ {
+		if result, _ := service.RecordCall(2, -1); !result.Allowed {
+			t.Fatalf("expected an unlimited call to be allowed")
+		}
+	}
+
+	if err := service.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	var usage models.APIUsage
+	if err := db.Where("user_id = ? AND date = ?", 1, today).First(&usage).Error; err != nil {
+		t.Fatalf("failed to load usage: %v", err)
+	}
+	if usage.Calls != 5 {
+		t.Errorf("expected the refused call not to be metered, got %d calls", usage.Calls)
+	}
+
+	// Flushing again adds to the day's row
+	service.RecordCall(2, -1)
+	if err := service.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	var unlimited models.APIUsage
+	if err := db.Where("user_id = ? AND date = ?", 2, today).First(&unlimited).Error; err != nil {
+		t.Fatalf("failed to load usage: %v", err)
+	}
+	if unlimited.Calls != 4 {
+		t.Errorf("expected 4 calls, got %d", unlimited.Calls)
+	}
+}
+
+func TestUsageService_RecordCallConcurrently(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.APIUsage{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	// Every connection to :memory: is a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	service := NewUsageService(db, &config.Config{})
+
+	// Calls racing to load the same total still share one quota
+	var (
+		wg      sync.WaitGroup
+		allowed atomic.Int64
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := service.RecordCall(1, 20)
+			if err != nil {
+				t.Errorf("RecordCall() error = %v", err)
+				return
+			}
+			if result.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != 20 {
+		t.Errorf("allowed %d calls, want the quota of 20", got)
+	}
+}
+
+func TestUsageService_GetUsage(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&models.APIUsage{}); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	service := NewUsageService(db, &config.Config{})
+
+	for _, usage := range []models.APIUsage{
+		{UserID: 1, Date: "2026-09-30", Calls: 100},
+		{UserID: 1, Date: "2026-10-01", Calls: 7},
+		{UserID: 1, Date: "2026-10-15", Calls: 5},
+		{UserID: 2, Date: "2026-10-02", Calls: 40},
+	} {
+		if err := db.Create(&usage).Error; err != nil {
+			t.Fatalf("failed to create usage: %v", err)
+		}
+	}
+
+	report, err := service.GetUsage(1, "free", "2026-10")
+	if err != nil {
+		t.Fatalf("GetUsage() error = %v", err)
+	}
+	if report.Calls != 12 || len(report.Days) != 2 || report.Days[0].Date != "2026-10-01" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	free := models.GetPlanLimits("free")
+	if report.Quota != free.MonthlyAPICalls || report.Remaining != free.MonthlyAPICalls-12 {
+		t.Errorf("expected the free quota, got %d with %d remaining", report.Quota, report.Remaining)
+	}
+	if !report.ResetsAt.Equal(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected reset %v", report.ResetsAt)
+	}
+
+	report, err = service.GetUsage(1, "pro_max", "2026-10")
+	if err != nil {
+		t.Fatalf("GetUsage() error = %v", err)
+	}
+	if report.Quota != -1 || report.Remaining != -1 {
+		t.Errorf("expected an unlimited quota, got %d with %d remaining", report.Quota, report.Remaining)
+	}
+
+	if _, err := service.GetUsage(1, "free", "October"); err == nil || err.Error() != "invalid month" {
+		t.Errorf("expected an invalid month error, got %v", err)
+	}
+
+	totals, err := service.GetMonthlyTotals("2026-10")
+	if err != nil {
+		t.Fatalf("GetMonthlyTotals() error = %v", err)
+	}
+	if len(totals) != 2 || totals[0].UserID != 1 || totals[0].Calls != 12 || totals[1].Calls != 40 || totals[1].Month != "2026-10" {
+		t.Errorf("unexpected totals %+v", totals)
+	}
+}
@@ -312,6 +312,44 @@ func (s *WebsiteService) RegenerateWidgetKey(websiteID, userID uint) (*models.We
 	return website, nil
 }
 
+// GetIdentitySecret returns the secret websites use to sign identify calls,
+// creating one the first time it is requested
+func (s *WebsiteService) GetIdentitySecret(websiteID, userID uint) (string, error) {
+	website, err := s.GetWebsiteByID(websiteID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if website.IdentitySecret != "" {
+		return website.IdentitySecret, nil
+	}
+	return s.saveIdentitySecret(website)
+}
+
+// RegenerateIdentitySecret replaces the identity secret. Identity hashes
+// signed with the old secret stop verifying.
+func (s *WebsiteService) RegenerateIdentitySecret(websiteID, userID uint) (string, error) {
+	website, err := s.GetWebsiteByID(websiteID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return s.saveIdentitySecret(website)
+}
+
+// saveIdentitySecret generates and stores a new identity secret
+func (s *WebsiteService) saveIdentitySecret(website *models.Website) (string, error) {
+	if err := website.GenerateIdentitySecret(); err != nil {
+		return "", fmt.Errorf("failed to generate identity secret: %w", err)
+	}
+
+	if err := s.db.Model(website).UpdateColumn("identity_secret", website.IdentitySecret).Error; err != nil {
+		return "", fmt.Errorf("failed to save identity secret: %w", err)
+	}
+
+	return website.IdentitySecret, nil
+}
+
 // SearchWebsites searches websites by name or domain for a user
 func (s *WebsiteService) SearchWebsites(userID uint, query string, page, limit int) ([]models.Website, int64, error) {
 	var websites []models.Website
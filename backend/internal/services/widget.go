@@ -1,6 +1,7 @@
 package services
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -174,6 +175,21 @@ func (s *WidgetService) ValidateWidgetSettings(settings models.WebsiteSettings)
 		}
 	}
 
+	// Validate pre-chat form
+	if err := models.ValidatePreChatForm(settings.PreChatForm); err != nil {
+		return err
+	}
+
+	// Validate business hours
+	if err := models.ValidateBusinessHours(settings.BusinessHours, settings.Timezone, settings.Holidays); err != nil {
+		return err
+	}
+
+	// Validate transcript recipients
+	if err := models.ValidateTranscriptSettings(settings.Transcripts); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -220,6 +236,7 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
         websiteId: %d,
         apiUrl: '%s',
         wsUrl: '%s',
+        widgetUrl: '%s',
         settings: %s
     };
     
@@ -228,12 +245,45 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
     let isConnected = false;
     let socket = null;
     let sessionId = null;
+    let preChatSubmitted = false;
+    let statusPending = false;
+    let pendingMessages = {};
+    let lastReceivedId = 0;
+    let lastSeq = 0;
+    let hasJoined = false;
+    let reconnectAttempts = 0;
+    let reconnectTimer = null;
+    let transport = window.WebSocket ? 'websocket' : (window.EventSource ? 'sse' : 'polling');
+    let transportFailures = 0;
+    
+    // Reconnect delays double from the base up to the max
+    const RECONNECT_BASE_DELAY = 1000;
+    const RECONNECT_MAX_DELAY = 30000;
+    
+    // Failed attempts to open a transport before falling back to the next
+    const TRANSPORT_FALLBACK_FAILURES = 2;
     
     // Generate session ID
     function generateSessionId() {
         return 'session_' + Math.random().toString(36).substr(2, 9) + '_' + Date.now();
     }
     
+    // Visitor ID persists across page loads so returning visitors can be recognised
+    function getVisitorId() {
+        const key = 'chatelly_visitor_id';
+        let visitorId = null;
+        try {
+            visitorId = localStorage.getItem(key);
+            if (!visitorId) {
+                visitorId = 'visitor_' + Math.random().toString(36).substr(2, 9) + '_' + Date.now();
+                localStorage.setItem(key, visitorId);
+            }
+        } catch (e) {
+            // Storage may be unavailable (private mode, blocked cookies)
+        }
+        return visitorId;
+    }
+    
     // Create widget HTML
     function createWidget() {
         const widgetContainer = document.createElement('div');
@@ -247,10 +297,13 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
             <div id="chatelly-widget-chat" class="chatelly-chat" style="display: none;">
                 <div class="chatelly-header">
                     <h3>Chat with us</h3>
+                    <button id="chatelly-transcript" class="chatelly-close" title="Email me a transcript" style="display: none;">✉</button>
                     <button id="chatelly-close" class="chatelly-close">×</button>
                 </div>
                 <div class="chatelly-messages" id="chatelly-messages"></div>
                 <div class="chatelly-input-container">
+                    <button id="chatelly-attach" title="Send a file">📎</button>
+                    <input type="file" id="chatelly-file" style="display: none;" />
                     <input type="text" id="chatelly-input" placeholder="Type your message..." />
                     <button id="chatelly-send">Send</button>
                 </div>
@@ -387,12 +440,48 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
                 align-self: flex-end;
             }
             
-            .chatelly-message.bot {
+            .chatelly-message.bot,
+            .chatelly-message.agent {
                 background: #f1f3f5;
                 color: #333;
                 align-self: flex-start;
             }
             
+            .chatelly-message.user[data-status]::after {
+                margin-left: 6px;
+                font-size: 11px;
+                opacity: 0.8;
+            }
+            
+            .chatelly-message.user[data-status="pending"]::after {
+                content: '…';
+            }
+            
+            .chatelly-message.user[data-status="failed"]::after {
+                content: '!';
+                font-weight: bold;
+            }
+            
+            .chatelly-message.user[data-status="sent"]::after {
+                content: '✓';
+            }
+            
+            .chatelly-message.user[data-status="delivered"]::after,
+            .chatelly-message.user[data-status="read"]::after {
+                content: '✓✓';
+            }
+            
+            .chatelly-message.user[data-status="read"]::after {
+                opacity: 1;
+                font-weight: bold;
+            }
+            
+            .chatelly-message.edited::after {
+                content: ' (edited)';
+                font-size: 11px;
+                opacity: 0.6;
+            }
+            
             .chatelly-input-container {
                 padding: 16px;
                 border-top: 1px solid #e9ecef;
@@ -424,6 +513,73 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
                 font-weight: 500;
             }
             
+            #chatelly-attach {
+                background: none;
+                border: none;
+                cursor: pointer;
+                font-size: 18px;
+                padding: 0 4px;
+            }
+            
+            .chatelly-attachment {
+                display: block;
+                margin-top: 4px;
+                color: inherit;
+                word-break: break-all;
+            }
+            
+            .chatelly-attachment img {
+                display: block;
+                max-width: 200px;
+                max-height: 200px;
+                border-radius: 8px;
+            }
+            
+            .chatelly-prechat {
+                display: flex;
+                flex-direction: column;
+                gap: 10px;
+                font-size: 14px;
+            }
+            
+            .chatelly-prechat label {
+                display: flex;
+                flex-direction: column;
+                gap: 4px;
+                color: #333;
+            }
+            
+            .chatelly-prechat input,
+            .chatelly-prechat select,
+            .chatelly-prechat textarea {
+                padding: 8px 12px;
+                border: 1px solid #dee2e6;
+                border-radius: 8px;
+                font-size: 14px;
+            }
+            
+            .chatelly-prechat label.chatelly-consent {
+                flex-direction: row;
+                align-items: flex-start;
+                font-size: 12px;
+            }
+            
+            .chatelly-prechat-error {
+                color: #dc3545;
+                font-size: 12px;
+            }
+            
+            .chatelly-prechat button {
+                background: ${settings.primary_color};
+                color: white;
+                border: none;
+                padding: 10px 16px;
+                border-radius: 20px;
+                cursor: pointer;
+                font-size: 14px;
+                font-weight: 500;
+            }
+            
             ${settings.custom_css || ''}
         ` + "`" + `;
     }
@@ -438,12 +594,37 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
         
         button.addEventListener('click', toggleChat);
         closeBtn.addEventListener('click', closeChat);
+        if (WIDGET_CONFIG.settings.transcripts_enabled) {
+            const transcriptBtn = document.getElementById('chatelly-transcript');
+            transcriptBtn.style.display = 'flex';
+            transcriptBtn.addEventListener('click', showTranscriptForm);
+        }
         sendBtn.addEventListener('click', sendMessage);
+        const attachBtn = document.getElementById('chatelly-attach');
+        const fileInput = document.getElementById('chatelly-file');
+        attachBtn.addEventListener('click', function() {
+            if (sessionId && isConnected) {
+                fileInput.click();
+            }
+        });
+        fileInput.addEventListener('change', function() {
+            if (fileInput.files.length > 0) {
+                uploadFile(fileInput.files[0]);
+            }
+            fileInput.value = '';
+        });
         input.addEventListener('keypress', function(e) {
             if (e.key === 'Enter') {
                 sendMessage();
             }
         });
+        
+        // Messages that arrived while the page was in the background are read on return
+        document.addEventListener('visibilitychange', function() {
+            if (isOpen && document.visibilityState === 'visible') {
+                sendReceipt('read');
+            }
+        });
     }
     
     // Toggle chat visibility
@@ -452,11 +633,44 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
         isOpen = !isOpen;
         chat.style.display = isOpen ? 'flex' : 'none';
         
-        if (isOpen && !isConnected) {
-            connectWebSocket();
+        if (isOpen) {
+            sendReceipt('read');
+        }
+        
+        if (isOpen && !hasJoined && !isConnected && !statusPending) {
+            statusPending = true;
+            fetchStatus().then(function(status) {
+                statusPending = false;
+                if (isConnected || hasJoined) {
+                    return;
+                }
+                if (!status.online) {
+                    showOfflineForm(status);
+                } else if (needsPreChatForm()) {
+                    showPreChatForm();
+                } else {
+                    connect();
+                }
+            });
         }
     }
     
+    // Fetch whether the website is within business hours. The script is
+    // cached, so the status comes from the config endpoint; when it cannot be
+    // fetched the chat starts as usual.
+    function fetchStatus() {
+        return fetch(WIDGET_CONFIG.widgetUrl + '/config/' + WIDGET_CONFIG.widgetKey)
+            .then(function(response) {
+                return response.json();
+            })
+            .then(function(body) {
+                return body.status || { online: true };
+            })
+            .catch(function() {
+                return { online: true };
+            });
+    }
+    
     // Close chat
     function closeChat() {
         const chat = document.getElementById('chatelly-widget-chat');
@@ -464,18 +678,437 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
         chat.style.display = 'none';
     }
     
-    // Connect to WebSocket
-    function connectWebSocket() {
+    // Whether the visitor has to fill in the pre-chat form before chatting
+    function needsPreChatForm() {
+        const form = WIDGET_CONFIG.settings.pre_chat_form;
+        return !!(form && form.enabled && !preChatSubmitted);
+    }
+    
+    // Show the pre-chat form in place of the message input. Labels and options
+    // come from the website's settings, so they are only ever set as text.
+    function showPreChatForm() {
+        if (document.getElementById('chatelly-prechat')) {
+            return;
+        }
+        
+        const form = WIDGET_CONFIG.settings.pre_chat_form;
+        const formEl = document.createElement('form');
+        formEl.id = 'chatelly-prechat';
+        formEl.className = 'chatelly-prechat';
+        
+        (form.fields || []).forEach(function(field) {
+            const label = document.createElement('label');
+            label.textContent = field.label + (field.required ? ' *' : '');
+            
+            let input;
+            if (field.type === 'textarea') {
+                input = document.createElement('textarea');
+            } else if (field.type === 'select') {
+                input = document.createElement('select');
+                input.appendChild(document.createElement('option'));
+                (field.options || []).forEach(function(option) {
+                    const optionEl = document.createElement('option');
+                    optionEl.value = option;
+                    optionEl.textContent = option;
+                    input.appendChild(optionEl);
+                });
+            } else {
+                input = document.createElement('input');
+                input.type = field.type === 'phone' ? 'tel' : field.type;
+            }
+            input.name = field.name;
+            input.required = !!field.required;
+            if (field.max_length) {
+                input.maxLength = field.max_length;
+            }
+            
+            label.appendChild(input);
+            formEl.appendChild(label);
+        });
+        
+        if (form.consent_text) {
+            const consent = document.createElement('label');
+            consent.className = 'chatelly-consent';
+            const checkbox = document.createElement('input');
+            checkbox.type = 'checkbox';
+            checkbox.name = 'consent';
+            checkbox.required = !!form.consent_required;
+            consent.appendChild(checkbox);
+            consent.appendChild(document.createTextNode(' ' + form.consent_text));
+            formEl.appendChild(consent);
+        }
+        
+        const error = document.createElement('div');
+        error.className = 'chatelly-prechat-error';
+        formEl.appendChild(error);
+        
+        const submit = document.createElement('button');
+        submit.type = 'submit';
+        submit.textContent = 'Start chat';
+        formEl.appendChild(submit);
+        
+        formEl.addEventListener('submit', function(e) {
+            e.preventDefault();
+            submitPreChatForm(formEl, error, submit);
+        });
+        
+        document.querySelector('#chatelly-widget .chatelly-input-container').style.display = 'none';
+        document.getElementById('chatelly-messages').appendChild(formEl);
+    }
+    
+    // Submit the pre-chat form; the server starts the chat once it is valid
+    function submitPreChatForm(formEl, error, submit) {
         if (!sessionId) {
             sessionId = generateSessionId();
         }
         
-        const wsUrl = WIDGET_CONFIG.wsUrl + '/' + WIDGET_CONFIG.widgetKey + '?session_id=' + sessionId;
-        socket = new WebSocket(wsUrl);
+        const values = {};
+        (WIDGET_CONFIG.settings.pre_chat_form.fields || []).forEach(function(field) {
+            values[field.name] = formEl.elements[field.name].value;
+        });
+        const consent = formEl.elements['consent'] ? formEl.elements['consent'].checked : false;
+        
+        submit.disabled = true;
+        error.textContent = '';
+        
+        fetch(WIDGET_CONFIG.widgetUrl + '/prechat/' + WIDGET_CONFIG.widgetKey, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                visitor_id: getVisitorId() || '',
+                session_id: sessionId,
+                values: values,
+                consent: consent
+            })
+        }).then(function(response) {
+            return response.json().then(function(body) {
+                if (!response.ok) {
+                    throw new Error(body.error || 'Could not start the chat');
+                }
+            });
+        }).then(function() {
+            preChatSubmitted = true;
+            formEl.remove();
+            document.querySelector('#chatelly-widget .chatelly-input-container').style.display = 'flex';
+            connect();
+        }).catch(function(err) {
+            submit.disabled = false;
+            error.textContent = err.message;
+        });
+    }
+    
+    // Show the offline form in place of the message input. Messages become
+    // tickets that are emailed to the website owner.
+    function showOfflineForm(status) {
+        if (document.getElementById('chatelly-offline')) {
+            return;
+        }
+        
+        const formEl = document.createElement('form');
+        formEl.id = 'chatelly-offline';
+        formEl.className = 'chatelly-prechat';
         
-        socket.onopen = function() {
-            isConnected = true;
-            console.log('Connected to chat');
+        const intro = document.createElement('p');
+        intro.textContent = WIDGET_CONFIG.settings.offline_message || 'We are currently offline.';
+        if (status.next_opening) {
+            intro.textContent += ' We are back ' + new Date(status.next_opening).toLocaleString() + '.';
+        }
+        formEl.appendChild(intro);
+        
+        [
+            { name: 'name', label: 'Name', type: 'text', required: false },
+            { name: 'email', label: 'Email *', type: 'email', required: true },
+            { name: 'message', label: 'Message *', type: 'textarea', required: true }
+        ].forEach(function(field) {
+            const label = document.createElement('label');
+            label.textContent = field.label;
+            const input = document.createElement(field.type === 'textarea' ? 'textarea' : 'input');
+            if (field.type !== 'textarea') {
+                input.type = field.type;
+            }
+            input.name = field.name;
+            input.required = field.required;
+            input.maxLength = field.type === 'textarea' ? 5000 : 255;
+            label.appendChild(input);
+            formEl.appendChild(label);
+        });
+        
+        const error = document.createElement('div');
+        error.className = 'chatelly-prechat-error';
+        formEl.appendChild(error);
+        
+        const submit = document.createElement('button');
+        submit.type = 'submit';
+        submit.textContent = 'Send message';
+        formEl.appendChild(submit);
+        
+        formEl.addEventListener('submit', function(e) {
+            e.preventDefault();
+            submitOfflineForm(formEl, error, submit);
+        });
+        
+        document.querySelector('#chatelly-widget .chatelly-input-container').style.display = 'none';
+        document.getElementById('chatelly-messages').appendChild(formEl);
+    }
+    
+    // Submit the offline form
+    function submitOfflineForm(formEl, error, submit) {
+        submit.disabled = true;
+        error.textContent = '';
+        
+        fetch(WIDGET_CONFIG.widgetUrl + '/offline/' + WIDGET_CONFIG.widgetKey, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                visitor_id: getVisitorId() || '',
+                name: formEl.elements['name'].value,
+                email: formEl.elements['email'].value,
+                message: formEl.elements['message'].value,
+                page_url: window.location.href.substring(0, 2048)
+            })
+        }).then(function(response) {
+            return response.json().then(function(body) {
+                if (!response.ok) {
+                    throw new Error(body.error || 'Could not send your message');
+                }
+            });
+        }).then(function() {
+            formEl.remove();
+            addMessage('Thanks! We will get back to you by email.', 'bot');
+        }).catch(function(err) {
+            submit.disabled = false;
+            error.textContent = err.message;
+        });
+    }
+    
+    // Ask for an email address to send the chat transcript to. The transcript
+    // is emailed when the chat ends.
+    function showTranscriptForm() {
+        if (!sessionId || document.getElementById('chatelly-transcript-form')) {
+            return;
+        }
+        
+        const formEl = document.createElement('form');
+        formEl.id = 'chatelly-transcript-form';
+        formEl.className = 'chatelly-prechat';
+        
+        const label = document.createElement('label');
+        label.textContent = 'Email me a transcript of this chat';
+        const input = document.createElement('input');
+        input.type = 'email';
+        input.name = 'email';
+        input.required = true;
+        input.maxLength = 255;
+        label.appendChild(input);
+        formEl.appendChild(label);
+        
+        const error = document.createElement('div');
+        error.className = 'chatelly-prechat-error';
+        formEl.appendChild(error);
+        
+        const submit = document.createElement('button');
+        submit.type = 'submit';
+        submit.textContent = 'Send transcript';
+        formEl.appendChild(submit);
+        
+        formEl.addEventListener('submit', function(e) {
+            e.preventDefault();
+            submit.disabled = true;
+            error.textContent = '';
+            
+            fetch(WIDGET_CONFIG.widgetUrl + '/transcript/' + WIDGET_CONFIG.widgetKey, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({
+                    session_id: sessionId,
+                    email: input.value
+                })
+            }).then(function(response) {
+                return response.json().then(function(body) {
+                    if (!response.ok) {
+                        throw new Error(body.error || 'Could not request the transcript');
+                    }
+                });
+            }).then(function() {
+                formEl.remove();
+                addMessage('We will email you a transcript when the chat ends.', 'bot');
+            }).catch(function(err) {
+                submit.disabled = false;
+                error.textContent = err.message;
+            });
+        });
+        
+        const messagesContainer = document.getElementById('chatelly-messages');
+        messagesContainer.appendChild(formEl);
+        messagesContainer.scrollTop = messagesContainer.scrollHeight;
+    }
+    
+    // Connect over the transport the network allows
+    function connect() {
+        if (!sessionId) {
+            sessionId = generateSessionId();
+        }
+        
+        let query = '?session_id=' + sessionId;
+        const visitorId = getVisitorId();
+        if (visitorId) {
+            query += '&visitor_id=' + encodeURIComponent(visitorId);
+        }
+        
+        if (transport === 'websocket') {
+            socket = openWebSocket(query);
+        } else if (transport === 'sse') {
+            socket = openEventStream(query);
+        } else {
+            socket = openPolling(query);
+        }
+    }
+    
+    function openWebSocket(query) {
+        const ws = new WebSocket(WIDGET_CONFIG.wsUrl + '/' + WIDGET_CONFIG.widgetKey + query);
+        let opened = false;
+        
+        ws.onopen = function() {
+            opened = true;
+            handleOpen();
+        };
+        
+        ws.onmessage = function(event) {
+            handleServerMessage(JSON.parse(event.data));
+        };
+        
+        ws.onclose = function(event) {
+            handleClose(opened, event.reason);
+        };
+        
+        ws.onerror = function(error) {
+            console.error('WebSocket error:', error);
+        };
+        
+        return ws;
+    }
+    
+    // Server-Sent Events down, POSTs up
+    function openEventStream(query) {
+        const source = new EventSource(WIDGET_CONFIG.widgetUrl + '/stream/' + WIDGET_CONFIG.widgetKey + query);
+        const stream = { id: null, closed: false };
+        stream.send = streamSender(stream, query);
+        stream.close = function() {
+            if (stream.closed) {
+                return;
+            }
+            stream.closed = true;
+            source.close();
+            handleClose(stream.id !== null, stream.reason);
+        };
+        
+        source.onmessage = function(event) {
+            const message = JSON.parse(event.data);
+            if (message.type === 'connection_established' && !stream.id) {
+                stream.id = message.data.stream_id;
+                handleOpen();
+            }
+            if (message.type === 'connection_closed') {
+                stream.reason = message.data.reason;
+            }
+            handleServerMessage(message);
+        };
+        
+        // EventSource would reconnect by itself, without resuming the session
+        source.onerror = function() {
+            stream.close();
+        };
+        
+        return stream;
+    }
+    
+    // Long polls down, POSTs up; the last resort
+    function openPolling(query) {
+        const stream = { id: null, closed: false };
+        stream.send = streamSender(stream, query);
+        stream.close = function() {
+            if (stream.closed) {
+                return;
+            }
+            stream.closed = true;
+            handleClose(stream.id !== null, stream.reason);
+        };
+        
+        function poll() {
+            let url = WIDGET_CONFIG.widgetUrl + '/poll/' + WIDGET_CONFIG.widgetKey + query;
+            if (stream.id) {
+                url += '&stream_id=' + stream.id;
+            }
+            fetch(url).then(function(response) {
+                if (!response.ok) {
+                    throw new Error('Poll failed');
+                }
+                return response.json();
+            }).then(function(result) {
+                if (stream.closed) {
+                    return;
+                }
+                if (!stream.id) {
+                    stream.id = result.stream_id;
+                    handleOpen();
+                }
+                result.messages.forEach(function(message) {
+                    handleServerMessage(message);
+                });
+                if (result.closed) {
+                    stream.reason = result.reason;
+                    stream.close();
+                    return;
+                }
+                poll();
+            }).catch(function() {
+                stream.close();
+            });
+        }
+        poll();
+        
+        return stream;
+    }
+    
+    // Sends each message on a stream in its own POST, one after another so
+    // they arrive in order. The session the stream was opened for comes along,
+    // so nobody else can send on it.
+    function streamSender(stream, query) {
+        let queue = Promise.resolve();
+        return function(text) {
+            queue = queue.then(function() {
+                return fetch(WIDGET_CONFIG.widgetUrl + '/send/' + WIDGET_CONFIG.widgetKey + query + '&stream_id=' + stream.id, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: text
+                });
+            }).then(function(response) {
+                if (!response.ok) {
+                    throw new Error('Send failed');
+                }
+            }).catch(function() {
+                // Messages not yet stored are resent once the stream reopens
+                stream.close();
+            });
+        };
+    }
+    
+    function handleOpen() {
+        isConnected = true;
+        reconnectAttempts = 0;
+        transportFailures = 0;
+        console.log('Connected to chat over ' + transport);
+        
+        if (hasJoined) {
+            // Catch up on what was pushed to the session while disconnected
+            socket.send(JSON.stringify({
+                type: 'resume',
+                data: {
+                    last_seq: lastSeq
+                }
+            }));
+        } else {
+            hasJoined = true;
             
             // Join chat
             socket.send(JSON.stringify({
@@ -484,35 +1117,104 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
                     session_id: sessionId
                 }
             }));
-        };
+        }
         
-        socket.onmessage = function(event) {
-            const message = JSON.parse(event.data);
-            handleWebSocketMessage(message);
-        };
+        // Send what was queued while offline; resends of messages the
+        // server already stored are only acknowledged again
+        Object.keys(pendingMessages).forEach(function(clientMessageId) {
+            socket.send(JSON.stringify({
+                type: 'chat_message',
+                data: pendingMessages[clientMessageId].data
+            }));
+        });
+    }
+    
+    function handleClose(opened, reason) {
+        isConnected = false;
+        console.log('Disconnected from chat' + (reason ? ': ' + reason : ''));
         
-        socket.onclose = function() {
-            isConnected = false;
-            console.log('Disconnected from chat');
-        };
+        // A transport that keeps failing to open while the device is online
+        // is likely blocked by a proxy; fall back to the next one. One the
+        // server refused with a reason, like a ban, got through.
+        if (!opened && !reason && navigator.onLine !== false && transport !== 'polling') {
+            transportFailures++;
+            if (transportFailures >= TRANSPORT_FALLBACK_FAILURES) {
+                transport = transport === 'websocket' && window.EventSource ? 'sse' : 'polling';
+                transportFailures = 0;
+                reconnectAttempts = 0;
+                console.log('Falling back to ' + transport);
+            }
+        }
         
-        socket.onerror = function(error) {
-            console.error('WebSocket error:', error);
-        };
+        scheduleReconnect();
     }
     
-    // Handle WebSocket messages
-    function handleWebSocketMessage(message) {
+    // Reconnect with exponential backoff. The jitter keeps the widgets that
+    // lost the same server from all reconnecting at once.
+    function scheduleReconnect() {
+        if (reconnectTimer) {
+            return;
+        }
+        const delay = Math.min(RECONNECT_MAX_DELAY, RECONNECT_BASE_DELAY * Math.pow(2, reconnectAttempts));
+        reconnectAttempts++;
+        reconnectTimer = setTimeout(function() {
+            reconnectTimer = null;
+            connect();
+        }, delay / 2 + Math.random() * delay / 2);
+    }
+    
+    // Reconnect right away when the device comes back online
+    window.addEventListener('online', function() {
+        if (hasJoined && !isConnected && reconnectTimer) {
+            clearTimeout(reconnectTimer);
+            reconnectTimer = null;
+            reconnectAttempts = 0;
+            connect();
+        }
+    });
+    
+    // Handle messages from the server, whatever the transport
+    function handleServerMessage(message) {
+        // Events pushed to the session are numbered; a replay may repeat some
+        if (message.seq) {
+            if (message.seq <= lastSeq) {
+                return;
+            }
+            lastSeq = message.seq;
+        }
+        
         switch (message.type) {
             case 'chat_history':
                 loadChatHistory(message.data.messages);
+                if (typeof message.data.seq === 'number') {
+                    lastSeq = message.data.seq;
+                }
+                break;
+            case 'resumed':
+                lastSeq = message.data.seq;
                 break;
             case 'message_received':
-                addMessage(message.data.content, message.data.sender);
+                receiveMessage(message.data);
                 break;
             case 'bot_message':
                 addMessage(message.data.content, 'bot');
                 break;
+            case 'ack':
+                acknowledgeMessage(message.data);
+                break;
+            case 'delivered':
+            case 'read':
+                updateMessageStatus(message.data.message_ids, message.type);
+                break;
+            case 'message_edited':
+                editMessage(message.data.id, message.data.content);
+                break;
+            case 'message_deleted':
+                deleteMessage(message.data.id);
+                break;
+            case 'error':
+                rejectMessage(message.data);
+                break;
             case 'connection_established':
                 console.log('Connection established');
                 break;
@@ -525,22 +1227,177 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
         messagesContainer.innerHTML = '';
         
         messages.forEach(function(msg) {
-            addMessage(msg.content, msg.sender, false);
+            const messageDiv = addMessage(msg.content, msg.sender, false, msg.attachments);
+            if (!msg.id) {
+                return;
+            }
+            messageDiv.dataset.messageId = msg.id;
+            if (msg.sender === 'user') {
+                messageDiv.dataset.status = msg.status || 'sent';
+            } else {
+                lastReceivedId = Math.max(lastReceivedId, msg.id);
+            }
+            if (msg.edited_at) {
+                messageDiv.classList.add('edited');
+            }
+        });
+        
+        // Messages still waiting to be stored stay at the end
+        Object.keys(pendingMessages).forEach(function(clientMessageId) {
+            messagesContainer.appendChild(pendingMessages[clientMessageId].element);
         });
+        messagesContainer.scrollTop = messagesContainer.scrollHeight;
+    }
+    
+    // Show a message from the team and let it know the message arrived
+    function receiveMessage(data) {
+        const messageDiv = addMessage(data.content, data.sender, true, data.attachments);
+        if (!data.id) {
+            return;
+        }
+        messageDiv.dataset.messageId = data.id;
+        lastReceivedId = Math.max(lastReceivedId, data.id);
+        sendReceipt('delivered');
+        if (isOpen && document.visibilityState !== 'hidden') {
+            sendReceipt('read');
+        }
+    }
+    
+    // Tell the team the messages up to the latest one were delivered or read
+    function sendReceipt(status) {
+        if (!lastReceivedId || !socket || !isConnected) {
+            return;
+        }
+        socket.send(JSON.stringify({
+            type: status,
+            data: { message_id: lastReceivedId }
+        }));
+    }
+    
+    // A sent message was stored; keep its ID for receipts and edits
+    function acknowledgeMessage(data) {
+        const pending = pendingMessages[data.client_message_id];
+        if (!pending) {
+            return;
+        }
+        delete pendingMessages[data.client_message_id];
+        pending.element.dataset.messageId = data.message_id;
+        pending.element.dataset.status = 'sent';
+    }
+    
+    // A sent message was refused, so it is not sent again
+    function rejectMessage(data) {
+        console.error('Chat error:', data.error);
+        const pending = pendingMessages[data.client_message_id];
+        if (!pending) {
+            return;
+        }
+        delete pendingMessages[data.client_message_id];
+        pending.element.dataset.status = 'failed';
+        pending.element.title = data.error;
+    }
+    
+    function findMessage(id) {
+        return document.querySelector('#chatelly-messages [data-message-id="' + Number(id) + '"]');
+    }
+    
+    function updateMessageStatus(ids, status) {
+        (ids || []).forEach(function(id) {
+            const messageDiv = findMessage(id);
+            if (messageDiv && messageDiv.dataset.status !== 'read') {
+                messageDiv.dataset.status = status;
+            }
+        });
+    }
+    
+    // Replace the text of a message an agent edited, keeping its attachments
+    function editMessage(id, content) {
+        const messageDiv = findMessage(id);
+        if (!messageDiv) {
+            return;
+        }
+        if (messageDiv.firstChild && messageDiv.firstChild.nodeType === Node.TEXT_NODE) {
+            messageDiv.firstChild.nodeValue = content;
+        } else {
+            messageDiv.insertBefore(document.createTextNode(content), messageDiv.firstChild);
+        }
+        messageDiv.classList.add('edited');
     }
     
-    // Add message to chat
-    function addMessage(content, sender, scroll = true) {
+    function deleteMessage(id) {
+        const messageDiv = findMessage(id);
+        if (messageDiv) {
+            messageDiv.remove();
+        }
+    }
+    
+    // Add message to chat. Messages sent with a file show it below the text;
+    // images are shown as thumbnails.
+    function addMessage(content, sender, scroll = true, attachments = []) {
         const messagesContainer = document.getElementById('chatelly-messages');
         const messageDiv = document.createElement('div');
         messageDiv.className = 'chatelly-message ' + sender;
-        messageDiv.textContent = content;
+        attachments = attachments || [];
+        if (attachments.length !== 1 || content !== attachments[0].filename) {
+            messageDiv.textContent = content;
+        }
+        attachments.forEach(function(attachment) {
+            const link = document.createElement('a');
+            link.className = 'chatelly-attachment';
+            link.href = attachmentUrl(attachment, false);
+            link.target = '_blank';
+            link.rel = 'noopener';
+            if (attachment.has_thumbnail) {
+                const img = document.createElement('img');
+                img.src = attachmentUrl(attachment, true);
+                img.alt = attachment.filename;
+                link.appendChild(img);
+            } else {
+                link.textContent = '📎 ' + attachment.filename;
+            }
+            messageDiv.appendChild(link);
+        });
         
         messagesContainer.appendChild(messageDiv);
         
         if (scroll) {
             messagesContainer.scrollTop = messagesContainer.scrollHeight;
         }
+        
+        return messageDiv;
+    }
+    
+    // Download URL of an attachment in the visitor's chat
+    function attachmentUrl(attachment, thumbnail) {
+        let url = WIDGET_CONFIG.widgetUrl + '/attachments/' + WIDGET_CONFIG.widgetKey + '/' + attachment.id +
+            '?session_id=' + encodeURIComponent(sessionId);
+        if (thumbnail) {
+            url += '&thumbnail=true';
+        }
+        return url;
+    }
+    
+    // Upload a file to the chat; it is sent as a message
+    function uploadFile(file) {
+        const body = new FormData();
+        body.append('session_id', sessionId);
+        body.append('file', file);
+        
+        fetch(WIDGET_CONFIG.widgetUrl + '/attachments/' + WIDGET_CONFIG.widgetKey, {
+            method: 'POST',
+            body: body
+        }).then(function(response) {
+            return response.json().then(function(result) {
+                if (!response.ok) {
+                    throw new Error(result.error || 'Could not send the file');
+                }
+                return result.message;
+            });
+        }).then(function(message) {
+            addMessage(message.content, 'user', true, message.attachments);
+        }).catch(function(err) {
+            addMessage(err.message, 'bot');
+        });
     }
     
     // Send message
@@ -548,23 +1405,60 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
         const input = document.getElementById('chatelly-input');
         const message = input.value.trim();
         
-        if (message && socket && isConnected) {
-            // Add message to UI immediately
-            addMessage(message, 'user');
+        if (message && socket) {
+            // Add message to UI immediately; it stays queued until the ack
+            // marks it stored, and is resent after a reconnect
+            const clientMessageId = 'msg_' + Math.random().toString(36).substr(2, 9) + '_' + Date.now();
+            const data = {
+                content: message,
+                client_message_id: clientMessageId
+            };
+            const messageDiv = addMessage(message, 'user');
+            messageDiv.dataset.status = 'pending';
+            pendingMessages[clientMessageId] = { element: messageDiv, data: data };
             
             // Send to server
-            socket.send(JSON.stringify({
-                type: 'chat_message',
-                data: {
-                    content: message,
-                    session_id: sessionId
-                }
-            }));
+            if (isConnected) {
+                socket.send(JSON.stringify({
+                    type: 'chat_message',
+                    data: data
+                }));
+            }
             
             input.value = '';
         }
     }
     
+    // Identify the visitor as one of the website's users. identity_hash is the
+    // HMAC-SHA256 of external_id (or email), signed on the website's server.
+    function identify(user) {
+        const visitorId = getVisitorId();
+        if (!visitorId || !user) {
+            return Promise.reject(new Error('Visitor cannot be identified'));
+        }
+        
+        return fetch(WIDGET_CONFIG.widgetUrl + '/identify/' + WIDGET_CONFIG.widgetKey, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                visitor_id: visitorId,
+                external_id: user.external_id ? String(user.external_id) : '',
+                email: user.email || '',
+                name: user.name || '',
+                attributes: user.attributes || {},
+                identity_hash: user.identity_hash || ''
+            })
+        }).then(function(response) {
+            if (!response.ok) {
+                throw new Error('Identify failed with status ' + response.status);
+            }
+            return response.json();
+        });
+    }
+    
+    window.Chatelly = window.Chatelly || {};
+    window.Chatelly.identify = identify;
+    
     // Initialize widget when DOM is ready
     if (document.readyState === 'loading') {
         document.addEventListener('DOMContentLoaded', createWidget);
@@ -584,6 +1478,7 @@ func (s *WidgetService) GenerateWidgetScript(widgetKey string) (string, error) {
 		website.ID,
 		s.getAPIURL(),
 		s.getWebSocketURL(),
+		s.getWidgetURL(),
 		s.settingsToJSON(website.Settings),
 	)
 
@@ -602,8 +1497,21 @@ func (s *WidgetService) getWebSocketURL() string {
 	return "ws://localhost:8080/widget/ws"
 }
 
+// getWidgetURL returns the base URL of the public widget endpoints
+func (s *WidgetService) getWidgetURL() string {
+	// TODO: Get from config
+	return "http://localhost:8080/widget"
+}
+
 // settingsToJSON converts settings to JSON string
 func (s *WidgetService) settingsToJSON(settings models.WebsiteSettings) string {
+	// The pre-chat form is nested, so it is marshalled properly. json.Marshal
+	// escapes <, > and &, which keeps it safe inside the script.
+	preChatForm := "null"
+	if encoded, err := json.Marshal(settings.PreChatForm); err == nil {
+		preChatForm = string(encoded)
+	}
+
 	// Simple JSON serialization for settings
 	return fmt.Sprintf(`{
 		"theme": "%s",
@@ -614,7 +1522,9 @@ func (s *WidgetService) settingsToJSON(settings models.WebsiteSettings) string {
 		"language": "%s",
 		"translation_enabled": %t,
 		"moderation_enabled": %t,
-		"custom_css": "%s"
+		"custom_css": "%s",
+		"pre_chat_form": %s,
+		"transcripts_enabled": %t
 	}`,
 		settings.Theme,
 		settings.PrimaryColor,
@@ -625,6 +1535,8 @@ func (s *WidgetService) settingsToJSON(settings models.WebsiteSettings) string {
 		settings.TranslationEnabled,
 		settings.ModerationEnabled,
 		escapeJSON(settings.CustomCSS),
+		preChatForm,
+		settings.Transcripts.VisitorRequests,
 	)
 }
 
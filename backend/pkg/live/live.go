@@ -0,0 +1,261 @@
+package live
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInterval is how often pending activity is pushed to subscribers
+	DefaultInterval = time.Second
+
+	// MaxUpdateItems caps the chats and messages carried by a single update;
+	// anything beyond is counted in Update.Dropped
+	MaxUpdateItems = 20
+)
+
+// VisitorCounter reports connected widget visitors per website
+type VisitorCounter interface {
+	GetWebsiteClientCount(websiteID uint) int
+}
+
+// Update is a coalesced batch of website activity pushed to dashboards
+type Update struct {
+	WebsiteID uint             `json:"website_id"`
+	Visitors  *int             `json:"visitors,omitempty"`
+	Chats     []interface{}    `json:"chats,omitempty"`
+	Messages  []interface{}    `json:"messages,omitempty"`
+	Events    map[string]int64 `json:"events,omitempty"` // tracked event counts by type
+	Dropped   int64            `json:"dropped,omitempty"`
+	Timestamp int64            `json:"timestamp"`
+}
+
+func (u *Update) empty() bool {
+	return u.Visitors == nil && len(u.Chats) == 0 && len(u.Messages) == 0 && len(u.Events) == 0 && u.Dropped == 0
+}
+
+// Subscription receives throttled updates for one website
+type Subscription struct {
+	WebsiteID uint
+
+	// C delivers at most one update per interval. Activity that arrives while
+	// the subscriber is busy is merged into the next update.
+	C chan *Update
+
+	pending      *Update
+	lastVisitors int
+}
+
+// Feed fans out website activity to dashboard subscribers
+type Feed struct {
+	mu          sync.Mutex
+	subscribers map[uint]map[*Subscription]struct{}
+	counter     VisitorCounter
+	interval    time.Duration
+	stop        chan struct{}
+}
+
+// Default is the feed used by the package-level publish functions
+var Default *Feed
+
+// NewFeed creates a feed that flushes every interval
+func NewFeed(counter VisitorCounter, interval time.Duration) *Feed {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	return &Feed{
+		subscribers: make(map[uint]map[*Subscription]struct{}),
+		counter:     counter,
+		interval:    interval,
+		stop:        make(chan struct{}),
+	}
+}
+
+// Run flushes pending activity until Stop is called
+func (f *Feed) Run() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.Flush()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the flush loop
+func (f *Feed) Stop() {
+	close(f.stop)
+}
+
+// Subscribe registers a subscriber for a website
+func (f *Feed) Subscribe(websiteID uint) *Subscription {
+	sub := &Subscription{
+		WebsiteID: websiteID,
+		C:         make(chan *Update, 1),
+		// Forces the current visitor count into the first update
+		lastVisitors: -1,
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.subscribers[websiteID] == nil {
+		f.subscribers[websiteID] = make(map[*Subscription]struct{})
+	}
+	f.subscribers[websiteID][sub] = struct{}{}
+
+	return sub
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (f *Feed) Unsubscribe(sub *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subs, ok := f.subscribers[sub.WebsiteID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(f.subscribers, sub.WebsiteID)
+	}
+	close(sub.C)
+}
+
+// SubscriberCount returns the number of subscribers for a website
+func (f *Feed) SubscriberCount(websiteID uint) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers[websiteID])
+}
+
+// PublishChat queues a new chat for a website's subscribers
+func (f *Feed) PublishChat(websiteID uint, chat interface{}) {
+	f.publish(websiteID, func(u *Update) {
+		if len(u.Chats) >= MaxUpdateItems {
+			u.Dropped++
+			return
+		}
+		u.Chats = append(u.Chats, chat)
+	})
+}
+
+// PublishMessage queues a new message for a website's subscribers
+func (f *Feed) PublishMessage(websiteID uint, message interface{}) {
+	f.publish(websiteID, func(u *Update) {
+		if len(u.Messages) >= MaxUpdateItems {
+			u.Dropped++
+			return
+		}
+		u.Messages = append(u.Messages, message)
+	})
+}
+
+// PublishEvent counts a tracked analytics event for a website's subscribers
+func (f *Feed) PublishEvent(websiteID uint, eventType string) {
+	f.publish(websiteID, func(u *Update) {
+		if u.Events == nil {
+			u.Events = make(map[string]int64)
+		}
+		u.Events[eventType]++
+	})
+}
+
+func (f *Feed) publish(websiteID uint, apply func(*Update)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for sub := range f.subscribers[websiteID] {
+		if sub.pending == nil {
+			sub.pending = &Update{WebsiteID: websiteID}
+		}
+		apply(sub.pending)
+	}
+}
+
+// Flush delivers pending activity and visitor count changes. Subscribers whose
+// previous update has not been read keep accumulating into the next one.
+func (f *Feed) Flush() {
+	// Read visitor counts before taking the feed lock; the hub may publish
+	// while holding its own lock
+	f.mu.Lock()
+	websiteIDs := make([]uint, 0, len(f.subscribers))
+	for websiteID := range f.subscribers {
+		websiteIDs = append(websiteIDs, websiteID)
+	}
+	f.mu.Unlock()
+
+	counts := make(map[uint]int, len(websiteIDs))
+	for _, websiteID := range websiteIDs {
+		if f.counter != nil {
+			counts[websiteID] = f.counter.GetWebsiteClientCount(websiteID)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now().Unix()
+	for websiteID, subs := range f.subscribers {
+		visitors, ok := counts[websiteID]
+		if !ok {
+			visitors = -1
+		}
+
+		for sub := range subs {
+			if visitors >= 0 && visitors != sub.lastVisitors {
+				if sub.pending == nil {
+					sub.pending = &Update{WebsiteID: websiteID}
+				}
+				count := visitors
+				sub.pending.Visitors = &count
+			}
+
+			if sub.pending == nil || sub.pending.empty() {
+				continue
+			}
+
+			sub.pending.Timestamp = now
+			select {
+			case sub.C <- sub.pending:
+				if sub.pending.Visitors != nil {
+					sub.lastVisitors = *sub.pending.Visitors
+				}
+				sub.pending = nil
+			default:
+				// Subscriber is still busy; coalesce into the next flush
+			}
+		}
+	}
+}
+
+// PublishChat queues a new chat on the default feed
+func PublishChat(websiteID uint, chat interface{}) {
+	if Default != nil {
+		Default.PublishChat(websiteID, chat)
+	}
+}
+
+// PublishMessage queues a new message on the default feed
+func PublishMessage(websiteID uint, message interface{}) {
+	if Default != nil {
+		Default.PublishMessage(websiteID, message)
+	}
+}
+
+// PublishEvent counts a tracked event on the default feed
+func PublishEvent(websiteID uint, eventType string) {
+	if Default != nil {
+		Default.PublishEvent(websiteID, eventType)
+	}
+}
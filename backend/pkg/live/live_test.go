@@ -0,0 +1,147 @@
+package live
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeCounter map[uint]int
+
+func (f fakeCounter) GetWebsiteClientCount(websiteID uint) int {
+	return f[websiteID]
+}
+
+func TestFeed_CoalescesUntilRead(t *testing.T) {
+	counter := fakeCounter{1: 3}
+	feed := NewFeed(counter, time.Hour)
+	sub := feed.Subscribe(1)
+	other := feed.Subscribe(2)
+
+	feed.PublishChat(1, "chat-1")
+	feed.PublishEvent(1, "page_view")
+	feed.PublishEvent(1, "page_view")
+	feed.Flush()
+
+	update := <-sub.C
+	if update.Visitors == nil || *update.Visitors != 3 || len(update.Chats) != 1 || update.Events["page_view"] != 2 {
+		t.Fatalf("unexpected first update: %+v", update)
+	}
+
+	// Unread updates are merged; unchanged visitor counts are not resent
+	for i := 0; i < MaxUpdateItems+5; i++ {
+		feed.PublishMessage(1, i)
+	}
+	feed.Flush()
+	feed.PublishEvent(1, "conversion")
+	feed.Flush()
+
+	update = <-sub.C
+	if update.Visitors != nil || len(update.Messages) != MaxUpdateItems || update.Dropped != 5 {
+		t.Fatalf("unexpected second update: visitors=%v messages=%d dropped=%d", update.Visitors, len(update.Messages), update.Dropped)
+	}
+
+	feed.Flush()
+	update = <-sub.C
+	if update.Events["conversion"] != 1 || len(update.Messages) != 0 {
+		t.Fatalf("unexpected third update: %+v", update)
+	}
+
+	// Another website's subscriber only sees its own visitor count
+	select {
+	case update := <-other.C:
+		if len(update.Chats) != 0 || update.Visitors == nil || *update.Visitors != 0 {
+			t.Errorf("unexpected update for website 2: %+v", update)
+		}
+	default:
+		t.Error("website 2 should receive its initial visitor count")
+	}
+
+	counter[1] = 4
+	feed.Flush()
+	update = <-sub.C
+	if update.Visitors == nil || *update.Visitors != 4 {
+		t.Errorf("visitor change not pushed: %+v", update)
+	}
+
+	feed.Unsubscribe(sub)
+	if _, ok := <-sub.C; ok {
+		t.Error("Unsubscribe() should close the channel")
+	}
+	if feed.SubscriberCount(1) != 0 {
+		t.Error("Unsubscribe() should remove the subscriber")
+	}
+}
+
+func TestFeed_ServeSSE(t *testing.T) {
+	feed := NewFeed(fakeCounter{7: 2}, 10*time.Millisecond)
+	go feed.Run()
+	defer feed.Stop()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		feed.ServeSSE(w, r, 7)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	// Publish once the subscription is registered
+	for feed.SubscriberCount(7) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	feed.PublishChat(7, map[string]interface{}{"id": 1})
+
+	var updates []Update
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && len(updates) < 2 {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var msg struct {
+			Type string `json:"type"`
+			Data Update `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg); err != nil {
+			t.Fatalf("invalid event data %q: %v", line, err)
+		}
+		if msg.Type != "live_update" {
+			t.Errorf("type = %q, want live_update", msg.Type)
+		}
+		updates = append(updates, msg.Data)
+
+		// The chat may be coalesced with the visitor count into one update
+		if len(msg.Data.Chats) > 0 {
+			break
+		}
+	}
+
+	var visitors, chats int
+	for _, update := range updates {
+		if update.Visitors != nil {
+			visitors = *update.Visitors
+		}
+		chats += len(update.Chats)
+	}
+	if visitors != 2 || chats != 1 {
+		t.Errorf("received visitors=%d chats=%d, want 2 and 1", visitors, chats)
+	}
+}
@@ -0,0 +1,161 @@
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write an update to the peer
+	writeWait = 10 * time.Second
+
+	// Time allowed to read the next pong message from the peer
+	pongWait = 60 * time.Second
+
+	// Keep-alive period for pings and SSE comments. Must be less than pongWait
+	keepAlivePeriod = (pongWait * 9) / 10
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		// Dashboard clients authenticate with a token, not cookies
+		return true
+	},
+}
+
+// envelope matches the websocket.Message format used by the chat hub
+type envelope struct {
+	Type      string      `json:"type"`
+	Data      interface{} `json:"data"`
+	WebsiteID uint        `json:"website_id,omitempty"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+const updateType = "live_update"
+
+// ServeSSE streams a subscription as server-sent events until the client disconnects
+func (f *Feed) ServeSSE(w http.ResponseWriter, r *http.Request, websiteID uint) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := f.Subscribe(websiteID)
+	defer f.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Tell EventSource how long to wait before reconnecting
+	fmt.Fprintf(w, "retry: %d\n\n", (5 * time.Second).Milliseconds())
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAlivePeriod)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case update, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			payload, err := json.Marshal(envelope{
+				Type:      updateType,
+				Data:      update,
+				WebsiteID: websiteID,
+				Timestamp: update.Timestamp,
+			})
+			if err != nil {
+				log.Printf("Live update encoding error: %v", err)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", updateType, payload); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// ServeWS streams a subscription over a WebSocket connection
+func (f *Feed) ServeWS(w http.ResponseWriter, r *http.Request, websiteID uint) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Live WebSocket upgrade error:", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := f.Subscribe(websiteID)
+	defer f.Unsubscribe(sub)
+
+	// The stream is push-only; reading detects disconnects and handles pongs
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+			return nil
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(keepAlivePeriod)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case update, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteJSON(envelope{
+				Type:      updateType,
+				Data:      update,
+				WebsiteID: websiteID,
+				Timestamp: update.Timestamp,
+			}); err != nil {
+				return
+			}
+
+		case <-keepAlive.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"chatelly-backend/internal/config"
+)
+
+// Message is a plain-text email, optionally with attachments
+type Message struct {
+	To          []string
+	ReplyTo     string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to a Message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Mailer sends email notifications
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New creates the mailer selected in the configuration. Without an SMTP host
+// emails are written to the log, which keeps development setups working.
+func New(cfg *config.Config) Mailer {
+	if cfg.SMTP.Host == "" {
+		return NewLog()
+	}
+	return NewSMTP(cfg.SMTP)
+}
+
+// Log writes emails to the standard logger instead of sending them
+type Log struct{}
+
+// NewLog creates a Log mailer
+func NewLog() *Log {
+	return &Log{}
+}
+
+// Send logs the email
+func (l *Log) Send(ctx context.Context, msg Message) error {
+	log.Printf("Email to %s: %s\n%s", strings.Join(msg.To, ", "), msg.Subject, msg.Body)
+	for _, attachment := range msg.Attachments {
+		log.Printf("Attachment: %s (%s, %d bytes)", attachment.Filename, attachment.ContentType, len(attachment.Data))
+	}
+	return nil
+}
+
+// headerValue strips line breaks so values cannot inject extra headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
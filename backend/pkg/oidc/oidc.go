@@ -0,0 +1,369 @@
+// Package oidc signs users in with OAuth 2.0 authorization servers and OpenID
+// Connect providers: the authorization code flow with PKCE (RFC 7636),
+// discovery, and ID token verification against the provider's published keys.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"chatelly-backend/pkg/jwk"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// Largest response read from a provider
+	maxResponseSize = 1 << 20
+
+	// How long after a refetch of the provider's keys still lacked a key ID
+	// before unknown IDs may trigger another. Refetching is how rotated keys
+	// are picked up.
+	keyRefetchInterval = time.Minute
+)
+
+// Signing algorithms accepted on ID tokens. Symmetric ones are not, as the
+// client secret would verify tokens anyone holding it could forge.
+var idTokenAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Identity is the user a provider signed in
+type Identity struct {
+	Subject       string // stable ID of the user at the provider
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// UserInfoFunc fetches the signed-in user with their access token, for
+// providers that do not issue ID tokens
+type UserInfoFunc func(ctx context.Context, client *http.Client, accessToken string) (*Identity, error)
+
+// Provider signs users in with an authorization server. With an Issuer it is
+// an OpenID Connect provider: endpoints left empty are discovered, and the ID
+// token identifies the user. Without one, UserInfo does.
+type Provider struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	Issuer     string
+	AltIssuers []string // other iss values the provider's ID tokens carry
+	AuthURL    string
+	TokenURL   string
+	JWKSURL    string
+
+	UserInfo UserInfoFunc
+
+	// Makes requests to the provider; http.DefaultClient when nil
+	HTTPClient *http.Client
+
+	mu         sync.Mutex
+	discovered bool
+	keys       *jwk.Set
+	keyMissed  time.Time // when a refetch last failed to find a key
+}
+
+// RandomToken returns a random URL-safe string, for states, nonces and PKCE
+// code verifiers
+func RandomToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge for a code verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *Provider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// AuthCodeURL returns the URL to send the user to for signing in. The state
+// and code verifier must be kept for Exchange, as must the nonce for OpenID
+// Connect providers.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("code_challenge", CodeChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+	if p.Issuer != "" {
+		params.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.AuthURL + separator + params.Encode(), nil
+}
+
+// Exchange redeems the code the provider redirected back with, and returns
+// the user it signed in
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.do(req, &token)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s %s", token.Error, token.ErrorDescription)
+	}
+	if status != http.StatusOK || token.AccessToken == "" {
+		return nil, fmt.Errorf("token request failed with status %d", status)
+	}
+
+	if p.Issuer == "" {
+		if p.UserInfo == nil {
+			return nil, errors.New("provider has no way to identify the user")
+		}
+		return p.UserInfo(ctx, p.client(), token.AccessToken)
+	}
+
+	if token.IDToken == "" {
+		return nil, errors.New("provider returned no ID token")
+	}
+	return p.VerifyIDToken(ctx, token.IDToken, nonce)
+}
+
+// idTokenClaims represents the claims of an ID token
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string      `json:"nonce"`
+	Email           string      `json:"email"`
+	EmailVerified   interface{} `json:"email_verified"` // some providers send "true"
+	Name            string      `json:"name"`
+	AuthorizedParty string      `json:"azp"`
+}
+
+// VerifyIDToken checks an ID token was signed by the provider for this client
+// and carries the nonce, and returns the user it identifies
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Identity, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods(idTokenAlgorithms))
+	var claims idTokenClaims
+	_, err := parser.ParseWithClaims(raw, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if !p.validIssuer(claims.Issuer) {
+		return nil, errors.New("invalid ID token: wrong issuer")
+	}
+	if !claims.VerifyAudience(p.ClientID, true) {
+		return nil, errors.New("invalid ID token: wrong audience")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return nil, errors.New("invalid ID token: wrong authorized party")
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("invalid ID token: no expiry")
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("invalid ID token: wrong nonce")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: no subject")
+	}
+
+	verified := false
+	switch value := claims.EmailVerified.(type) {
+	case bool:
+		verified = value
+	case string:
+		verified = value == "true"
+	}
+
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: verified,
+		Name:          claims.Name,
+	}, nil
+}
+
+func (p *Provider) validIssuer(issuer string) bool {
+	if issuer == p.Issuer {
+		return true
+	}
+	for _, alt := range p.AltIssuers {
+		if issuer == alt {
+			return true
+		}
+	}
+	return false
+}
+
+// discover fills in the endpoints of an OpenID Connect provider from its
+// discovery document, once
+func (p *Provider) discover(ctx context.Context) error {
+	if p.Issuer == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovered || (p.AuthURL != "" && p.TokenURL != "" && p.JWKSURL != "") {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	status, err := p.do(req, &doc)
+	if err != nil {
+		return fmt.Errorf("discovery failed: %w", err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("discovery failed with status %d", status)
+	}
+	if doc.Issuer != p.Issuer {
+		return fmt.Errorf("discovery returned issuer %q, expected %q", doc.Issuer, p.Issuer)
+	}
+
+	if p.AuthURL == "" {
+		p.AuthURL = doc.AuthorizationEndpoint
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = doc.TokenEndpoint
+	}
+	if p.JWKSURL == "" {
+		p.JWKSURL = doc.JWKSURI
+	}
+	if p.AuthURL == "" || p.TokenURL == "" || p.JWKSURL == "" {
+		return errors.New("discovery returned incomplete endpoints")
+	}
+	p.discovered = true
+	return nil
+}
+
+// key returns the provider's public key with an ID, refetching the keys when
+// it is unknown in case they were rotated
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.findKey(kid); ok {
+		return key.PublicKey()
+	}
+	if time.Since(p.keyMissed) < keyRefetchInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var keys jwk.Set
+	status, err := p.do(req, &keys)
+	if err != nil {
+		return nil, fmt.Errorf("fetching keys failed: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("fetching keys failed with status %d", status)
+	}
+	p.keys = &keys
+
+	if key, ok := p.findKey(kid); ok {
+		return key.PublicKey()
+	}
+	p.keyMissed = time.Now()
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// findKey looks a key up in the cached keys. Tokens without a key ID match a
+// provider's only key.
+func (p *Provider) findKey(kid string) (*jwk.Key, bool) {
+	if p.keys == nil {
+		return nil, false
+	}
+	if kid == "" {
+		if len(p.keys.Keys) == 1 {
+			return &p.keys.Keys[0], true
+		}
+		return nil, false
+	}
+	return p.keys.Find(kid)
+}
+
+// do sends a request and decodes its JSON response, returning the status
+func (p *Provider) do(req *http.Request, v interface{}) (int, error) {
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("invalid response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
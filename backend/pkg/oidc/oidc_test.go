@@ -0,0 +1,137 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"chatelly-backend/pkg/oidc"
+	"chatelly-backend/pkg/oidc/oidctest"
+)
+
+const redirectURL = "https://api.example.com/api/v1/auth/sso/oidc/callback"
+
+func setupProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+
+	server := oidctest.NewServer("client", "secret")
+	t.Cleanup(server.Close)
+	server.SetUser(oidc.Identity{Subject: "user-1", Email: "jane@acme.com", EmailVerified: true, Name: "Jane"})
+	return server, oidc.New(server.Issuer(), "client", "secret", redirectURL)
+}
+
+// signIn runs the code flow, returning the identity or the exchange error
+func signIn(t *testing.T, server *oidctest.Server, provider *oidc.Provider, verifier string) (*oidc.Identity, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	code, state, err := server.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	if state != "state-1" {
+		t.Fatalf("expected the state back, got %q", state)
+	}
+	return provider.Exchange(ctx, code, verifier, "nonce-1")
+}
+
+func TestProvider_CodeFlow(t *testing.T) {
+	server, provider := setupProvider(t)
+
+	identity, err := signIn(t, server, provider, "verifier-1")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if identity.Subject != "user-1" || identity.Email != "jane@acme.com" || !identity.EmailVerified || identity.Name != "Jane" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+}
+
+func TestProvider_AuthCodeURL(t *testing.T) {
+	_, provider := setupProvider(t)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	if query.Get("code_challenge") != oidc.CodeChallenge("verifier-1") || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("expected an S256 challenge, got %v", query)
+	}
+	if query.Get("nonce") != "nonce-1" || query.Get("redirect_uri") != redirectURL || query.Get("scope") != "openid email profile" {
+		t.Errorf("unexpected parameters %v", query)
+	}
+	if strings.Contains(authURL, "verifier-1") {
+		t.Errorf("expected the code verifier to stay secret")
+	}
+}
+
+func TestProvider_RefusesWrongVerifier(t *testing.T) {
+	server, provider := setupProvider(t)
+
+	if _, err := signIn(t, server, provider, "someone-elses-verifier"); err == nil || !strings.Contains(err.Error(), "PKCE") {
+		t.Errorf("expected the exchange to fail PKCE, got %v", err)
+	}
+}
+
+func TestProvider_RefusesWrongNonce(t *testing.T) {
+	server, provider := setupProvider(t)
+	server.SetNonce("replayed")
+
+	if _, err := signIn(t, server, provider, "verifier-1"); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("expected the ID token to be refused, got %v", err)
+	}
+}
+
+func TestProvider_PicksUpRotatedKeys(t *testing.T) {
+	server, provider := setupProvider(t)
+
+	if _, err := signIn(t, server, provider, "verifier-1"); err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	server.RotateKey()
+	if _, err := signIn(t, server, provider, "verifier-1"); err != nil {
+		t.Errorf("expected the rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestGitHubUserInfo(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/user":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 42, "login": "jane"})
+		case "/user/emails":
+			json.NewEncoder(w).Encode([]map[string]interface{}{
+				{"email": "old@example.com", "primary": false, "verified": true},
+				{"email": "jane@acme.com", "primary": true, "verified": true},
+			})
+		}
+	}))
+	defer api.Close()
+
+	identity, err := oidc.GitHubUserInfo(api.URL)(context.Background(), http.DefaultClient, "token")
+	if err != nil {
+		t.Fatalf("GitHubUserInfo() error = %v", err)
+	}
+	if identity.Subject != "42" || identity.Email != "jane@acme.com" || !identity.EmailVerified || identity.Name != "jane" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	if _, err := oidc.GitHubUserInfo(api.URL)(context.Background(), http.DefaultClient, "wrong"); err == nil {
+		t.Errorf("expected a bad token to fail")
+	}
+}
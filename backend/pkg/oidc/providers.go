@@ -0,0 +1,107 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Provider endpoints
+const (
+	GoogleIssuer = "https://accounts.google.com"
+
+	GitHubAuthURL  = "https://github.com/login/oauth/authorize"
+	GitHubTokenURL = "https://github.com/login/oauth/access_token"
+	GitHubAPIURL   = "https://api.github.com"
+)
+
+// New returns an OpenID Connect provider, discovered from its issuer
+func New(issuer, clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		Issuer:       issuer,
+	}
+}
+
+// Google returns a provider for Google accounts
+func Google(clientID, clientSecret, redirectURL string) *Provider {
+	provider := New(GoogleIssuer, clientID, clientSecret, redirectURL)
+	provider.AltIssuers = []string{"accounts.google.com"}
+	return provider
+}
+
+// GitHub returns a provider for GitHub accounts. GitHub is not an OpenID
+// Connect provider, so the user comes from its API.
+func GitHub(clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"read:user", "user:email"},
+		AuthURL:      GitHubAuthURL,
+		TokenURL:     GitHubTokenURL,
+		UserInfo:     GitHubUserInfo(GitHubAPIURL),
+	}
+}
+
+// GitHubUserInfo returns a UserInfoFunc reading the user from the GitHub API
+// at apiURL. Their email is their primary one, verified only if GitHub has.
+func GitHubUserInfo(apiURL string) UserInfoFunc {
+	return func(ctx context.Context, client *http.Client, accessToken string) (*Identity, error) {
+		provider := &Provider{HTTPClient: client}
+		get := func(path string, v interface{}) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL+path, nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+			req.Header.Set("Accept", "application/vnd.github+json")
+			status, err := provider.do(req, v)
+			if err != nil {
+				return fmt.Errorf("GitHub request failed: %w", err)
+			}
+			if status != http.StatusOK {
+				return fmt.Errorf("GitHub request failed with status %d", status)
+			}
+			return nil
+		}
+
+		var user struct {
+			ID    int64  `json:"id"`
+			Login string `json:"login"`
+			Name  string `json:"name"`
+		}
+		if err := get("/user", &user); err != nil {
+			return nil, err
+		}
+		if user.ID == 0 {
+			return nil, errors.New("GitHub returned no user")
+		}
+
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := get("/user/emails", &emails); err != nil {
+			return nil, err
+		}
+
+		identity := &Identity{Subject: strconv.FormatInt(user.ID, 10), Name: user.Name}
+		if identity.Name == "" {
+			identity.Name = user.Login
+		}
+		for _, email := range emails {
+			if email.Primary {
+				identity.Email = email.Email
+				identity.EmailVerified = email.Verified
+			}
+		}
+		return identity, nil
+	}
+}
@@ -0,0 +1,194 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Limit lets a client make Rate requests per Period on average, and up to
+// Burst of them at once
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+// PerMinute returns a limit of rate requests a minute
+func PerMinute(rate, burst int) Limit {
+	return Limit{Rate: rate, Period: time.Minute, Burst: burst}
+}
+
+// String describes the limit, such as "60 requests per minute"
+func (l Limit) String() string {
+	period := l.Period.String()
+	switch l.Period {
+	case time.Second:
+		period = "second"
+	case time.Minute:
+		period = "minute"
+	case time.Hour:
+		period = "hour"
+	}
+	return fmt.Sprintf("%d requests per %s", l.Rate, period)
+}
+
+// interval is the time one request takes to be paid back
+func (l Limit) interval() time.Duration {
+	if l.Rate <= 0 {
+		return l.Period
+	}
+	return l.Period / time.Duration(l.Rate)
+}
+
+// burst is how many requests can be made at once; at least one
+func (l Limit) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+// Result is the outcome of a request against a limit
+type Result struct {
+	Allowed bool
+
+	// The burst the limit allows, and how much of it is left
+	Limit     int
+	Remaining int
+
+	// Until the client could make a full burst again
+	ResetAfter time.Duration
+
+	// Until the client may retry; zero when the request was allowed
+	RetryAfter time.Duration
+}
+
+// Limiter rate limits requests by key. Limiters are safe for concurrent use.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// gcra applies the generic cell rate algorithm. tat is the theoretical arrival
+// time of the key's next request; a client that is on pace keeps it at now,
+// and every request pushes it one interval further. A request is allowed while
+// tat is less than a burst of intervals ahead of now.
+func gcra(tat, now time.Time, limit Limit) (time.Time, Result) {
+	interval := limit.interval()
+	burst := limit.burst()
+
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(interval)
+	allowAt := next.Add(-interval * time.Duration(burst))
+
+	if now.Before(allowAt) {
+		return tat, Result{
+			Limit:      burst,
+			ResetAfter: tat.Sub(now),
+			RetryAfter: allowAt.Sub(now),
+		}
+	}
+
+	return next, Result{
+		Allowed:    true,
+		Limit:      burst,
+		Remaining:  int(now.Sub(allowAt) / interval),
+		ResetAfter: next.Sub(now),
+	}
+}
+
+// MemoryLimiter is a Limiter for a single server. Each server counts its own
+// requests, so clients spread over several get more.
+type MemoryLimiter struct {
+	mu         sync.Mutex
+	tats       map[string]time.Time
+	lastPruned time.Time
+}
+
+// NewMemoryLimiter creates a MemoryLimiter
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		tats:       make(map[string]time.Time),
+		lastPruned: time.Now(),
+	}
+}
+
+// Allow takes a request off the key's limit
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	return l.allow(key, limit, time.Now()), nil
+}
+
+func (l *MemoryLimiter) allow(key string, limit Limit, now time.Time) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	tat, result := gcra(l.tats[key], now, limit)
+	l.tats[key] = tat
+	return result
+}
+
+// prune drops the keys that are back to a full burst. Caller must hold l.mu.
+func (l *MemoryLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPruned) < time.Minute {
+		return
+	}
+	l.lastPruned = now
+
+	for key, tat := range l.tats {
+		if !tat.After(now) {
+			delete(l.tats, key)
+		}
+	}
+}
+
+// How long FallbackLimiter stays on its fallback after the primary fails
+const fallbackRetry = 10 * time.Second
+
+// FallbackLimiter uses its primary limiter, and its fallback while the primary
+// is failing, so requests stay limited when Redis goes down
+type FallbackLimiter struct {
+	primary  Limiter
+	fallback Limiter
+
+	mu         sync.Mutex
+	retryAfter time.Time // the primary is skipped until then
+}
+
+// NewFallbackLimiter creates a FallbackLimiter
+func NewFallbackLimiter(primary, fallback Limiter) *FallbackLimiter {
+	return &FallbackLimiter{
+		primary:  primary,
+		fallback: fallback,
+	}
+}
+
+// Allow takes a request off the key's limit
+func (l *FallbackLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	failing := now.Before(l.retryAfter)
+	l.mu.Unlock()
+
+	if !failing {
+		result, err := l.primary.Allow(ctx, key, limit)
+		if err == nil {
+			return result, nil
+		}
+
+		l.mu.Lock()
+		if now.After(l.retryAfter) {
+			log.Printf("Rate limiter failed, limiting in memory for %s: %v", fallbackRetry, err)
+			l.retryAfter = now.Add(fallbackRetry)
+		}
+		l.mu.Unlock()
+	}
+
+	return l.fallback.Allow(ctx, key, limit)
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	limit := PerMinute(60, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		result := limiter.allow("ip:1.2.3.4", limit, now)
+		if !result.Allowed {
+			t.Fatalf("expected request %d of the burst to be allowed", i+1)
+		}
+		if result.Limit != 3 || result.Remaining != 2-i {
+			t.Errorf("request %d: expected limit 3 and %d remaining, got %+v", i+1, 2-i, result)
+		}
+	}
+
+	result := limiter.allow("ip:1.2.3.4", limit, now)
+	if result.Allowed {
+		t.Fatalf("expected the request after the burst to be refused")
+	}
+	if result.RetryAfter != time.Second || result.ResetAfter != 3*time.Second {
+		t.Errorf("expected to retry in a second and reset in three, got %+v", result)
+	}
+
+	// Other keys have their own limits
+	if !limiter.allow("ip:5.6.7.8", limit, now).Allowed {
+		t.Errorf("expected another key to be allowed")
+	}
+
+	// One request a second is paid back, never more than the burst
+	if !limiter.allow("ip:1.2.3.4", limit, now.Add(time.Second)).Allowed {
+		t.Errorf("expected a request to be allowed a second later")
+	}
+	if limiter.allow("ip:1.2.3.4", limit, now.Add(1500*time.Millisecond)).Allowed {
+		t.Errorf("expected no request to be allowed half a second after that")
+	}
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		limiter.allow("ip:1.2.3.4", limit, later)
+	}
+	if limiter.allow("ip:1.2.3.4", limit, later).Allowed {
+		t.Errorf("expected the burst not to grow past its size")
+	}
+}
+
+func TestMemoryLimiter_Prunes(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	now := time.Now()
+
+	limiter.allow("ip:1.2.3.4", PerMinute(60, 3), now)
+	limiter.allow("ip:5.6.7.8", Limit{Rate: 1, Period: time.Hour, Burst: 1}, now)
+	limiter.allow("ip:9.9.9.9", PerMinute(60, 3), now.Add(2*time.Minute))
+
+	if _, ok := limiter.tats["ip:1.2.3.4"]; ok {
+		t.Errorf("expected the key back at a full burst to be pruned")
+	}
+	if _, ok := limiter.tats["ip:5.6.7.8"]; !ok {
+		t.Errorf("expected the key still paying back to be kept")
+	}
+}
+
+// failingLimiter fails every request
+type failingLimiter struct {
+	calls int
+}
+
+func (l *failingLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	l.calls++
+	return Result{}, errors.New("connection refused")
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	primary := &failingLimiter{}
+	limiter := NewFallbackLimiter(primary, NewMemoryLimiter())
+	limit := PerMinute(60, 2)
+
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(context.Background(), "ip:1.2.3.4", limit)
+		if err != nil || !result.Allowed {
+			t.Fatalf("expected the fallback to allow request %d, got %+v %v", i+1, result, err)
+		}
+	}
+
+	// Requests stay limited while the primary is down
+	result, err := limiter.Allow(context.Background(), "ip:1.2.3.4", limit)
+	if err != nil || result.Allowed {
+		t.Fatalf("expected the fallback to refuse the request, got %+v %v", result, err)
+	}
+
+	// and the primary is not tried on every request
+	if primary.calls != 1 {
+		t.Errorf("expected the primary to be tried once, got %d", primary.calls)
+	}
+
+	limiter.retryAfter = time.Now().Add(-time.Second)
+	limiter.Allow(context.Background(), "ip:1.2.3.4", limit)
+	if primary.calls != 2 {
+		t.Errorf("expected the primary to be retried, got %d calls", primary.calls)
+	}
+}
@@ -0,0 +1,29 @@
+// Package scanner checks uploaded files for malware before they are stored.
+package scanner
+
+import (
+	"context"
+	"io"
+
+	"chatelly-backend/internal/config"
+)
+
+// Result is the outcome of a scan
+type Result struct {
+	Infected  bool
+	Signature string // name of the detected malware
+}
+
+// Scanner scans file contents
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// New creates the scanner selected in the configuration, or nil when virus
+// scanning is not configured
+func New(cfg *config.Config) Scanner {
+	if cfg.Attachments.ClamdAddr == "" {
+		return nil
+	}
+	return NewClamd(cfg.Attachments.ClamdAddr)
+}
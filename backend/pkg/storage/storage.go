@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"chatelly-backend/internal/config"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Storage stores binary objects such as exports and attachments
+type Storage interface {
+	// Put stores an object. size may be -1 when unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens an object for reading
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes an object
+	Delete(ctx context.Context, key string) error
+	// URL returns a time-limited download URL, or an empty string when
+	// objects must be served through the application
+	URL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// New creates the storage backend selected in the configuration
+func New(cfg *config.Config) (Storage, error) {
+	switch cfg.Storage.Driver {
+	case "", "local":
+		return NewLocal(cfg.Storage.LocalPath)
+	case "s3":
+		return NewS3(cfg.Storage)
+	default:
+		return nil, fmt.Errorf("unknown storage driver '%s'", cfg.Storage.Driver)
+	}
+}
@@ -0,0 +1,92 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA1 secret of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Code() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Errorf("expected an invalid secret to fail")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, Counter(now))
+
+	counter, ok := Validate(rfcSecret, code, now, 0)
+	if !ok || counter != Counter(now) {
+		t.Fatalf("expected the current code to be valid")
+	}
+
+	// Codes from the neighbouring steps are accepted for clock drift
+	if _, ok := Validate(rfcSecret, code, now.Add(Period), 0); !ok {
+		t.Errorf("expected the code to be accepted a step later")
+	}
+	if _, ok := Validate(rfcSecret, code, now.Add(2*Period), 0); ok {
+		t.Errorf("expected the code to expire two steps later")
+	}
+
+	// but each only once
+	if _, ok := Validate(rfcSecret, code, now, counter); ok {
+		t.Errorf("expected a used code to be refused")
+	}
+
+	if _, ok := Validate(rfcSecret, "12345", now, 0); ok {
+		t.Errorf("expected a short code to be refused")
+	}
+	if _, ok := Validate(rfcSecret, code[:3]+" "+code[3:], now, 0); !ok {
+		t.Errorf("expected spaces in the code to be ignored")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("expected a 160-bit secret, got %q", secret)
+	}
+
+	uri, err := url.Parse(ProvisioningURI("Chatelly", "jane@example.com", secret))
+	if err != nil {
+		t.Fatalf("invalid URI: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || !strings.HasPrefix(uri.Path, "/Chatelly:jane@example.com") {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	if uri.Query().Get("secret") != secret || uri.Query().Get("issuer") != "Chatelly" || uri.Query().Get("digits") != "6" {
+		t.Errorf("unexpected parameters %v", uri.Query())
+	}
+}
@@ -33,15 +33,15 @@ type TokenPair struct {
 }
 
 // GenerateTokenPair generates both access and refresh tokens for a user
-func GenerateTokenPair(user *models.User, cfg *config.Config) (*TokenPair, error) {
+func GenerateTokenPair(user *models.User, cfg *config.Config, keys *KeySet) (*TokenPair, error) {
 	// Generate access token
-	accessToken, err := GenerateAccessToken(user, cfg)
+	accessToken, err := GenerateAccessToken(user, cfg, keys)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate refresh token
-	refreshToken, err := GenerateRefreshToken(user, cfg)
+	refreshToken, err := GenerateRefreshToken(user, keys)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +54,7 @@ func GenerateTokenPair(user *models.User, cfg *config.Config) (*TokenPair, error
 }
 
 // GenerateAccessToken generates a JWT access token for a user
-func GenerateAccessToken(user *models.User, cfg *config.Config) (string, error) {
+func GenerateAccessToken(user *models.User, cfg *config.Config, keys *KeySet) (string, error) {
 	expirationTime := time.Now().Add(time.Duration(cfg.JWT.Expiration) * time.Hour)
 
 	claims := &JWTClaims{
@@ -70,17 +70,11 @@ func GenerateAccessToken(user *models.User, cfg *config.Config) (string, error)
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(cfg.JWT.Secret))
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
+	return keys.Sign(claims)
 }
 
 // GenerateRefreshToken generates a JWT refresh token for a user
-func GenerateRefreshToken(user *models.User, cfg *config.Config) (string, error) {
+func GenerateRefreshToken(user *models.User, keys *KeySet) (string, error) {
 	// Refresh tokens have longer expiration (7 days)
 	expirationTime := time.Now().Add(7 * 24 * time.Hour)
 
@@ -96,25 +90,12 @@ func GenerateRefreshToken(user *models.User, cfg *config.Config) (string, error)
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(cfg.JWT.Secret))
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
+	return keys.Sign(claims)
 }
 
 // ValidateAccessToken validates and parses an access token
-func ValidateAccessToken(tokenString string, cfg *config.Config) (*JWTClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
-		return []byte(cfg.JWT.Secret), nil
-	})
-
+func ValidateAccessToken(tokenString string, keys *KeySet) (*JWTClaims, error) {
+	token, err := keys.Parse(tokenString, &JWTClaims{})
 	if err != nil {
 		return nil, err
 	}
@@ -131,15 +112,8 @@ func ValidateAccessToken(tokenString string, cfg *config.Config) (*JWTClaims, er
 }
 
 // ValidateRefreshToken validates and parses a refresh token
-func ValidateRefreshToken(tokenString string, cfg *config.Config) (*RefreshTokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &RefreshTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
-		return []byte(cfg.JWT.Secret), nil
-	})
-
+func ValidateRefreshToken(tokenString string, keys *KeySet) (*RefreshTokenClaims, error) {
+	token, err := keys.Parse(tokenString, &RefreshTokenClaims{})
 	if err != nil {
 		return nil, err
 	}
@@ -184,4 +158,51 @@ func IsTokenExpired(claims jwt.Claims) bool {
 		return time.Now().After(exp.ExpiresAt.Time)
 	}
 	return true
-}
\ No newline at end of file
+}
+
+// Step token types, each for one step of a sign-in or of confirming a
+// sensitive action
+const (
+	StepTokenMFAChallenge  = "mfa_challenge"  // the password was right; verify a code next
+	StepTokenMFAEnrollment = "mfa_enrollment" // the password was right; set up 2FA next
+	StepTokenReauth        = "reauth"         // the user re-entered their credentials
+)
+
+// StepTokenClaims represents the claims of a short-lived step token
+type StepTokenClaims struct {
+	UserID uint `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+// GenerateStepToken generates a step token of a type for a user
+func GenerateStepToken(userID uint, tokenType string, ttl time.Duration, keys *KeySet) (string, error) {
+	claims := &StepTokenClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "chatelly-backend",
+			Subject:   tokenType,
+		},
+	}
+
+	return keys.Sign(claims)
+}
+
+// ValidateStepToken validates and parses a step token of a type
+func ValidateStepToken(tokenString, tokenType string, keys *KeySet) (*StepTokenClaims, error) {
+	token, err := keys.Parse(tokenString, &StepTokenClaims{})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*StepTokenClaims); ok && token.Valid {
+		if claims.Subject != tokenType {
+			return nil, errors.New("invalid token type")
+		}
+		return claims, nil
+	}
+
+	return nil, errors.New("invalid token")
+}
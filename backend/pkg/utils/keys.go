@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/pkg/jwk"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Smallest RSA key accepted for signing tokens
+const minRSAKeyBits = 2048
+
+// signingKey is a key tokens are signed or verified with
+type signingKey struct {
+	id     string
+	method jwt.SigningMethod
+	sign   interface{} // []byte, *rsa.PrivateKey or ed25519.PrivateKey
+	verify interface{} // []byte, *rsa.PublicKey or ed25519.PublicKey
+}
+
+// KeySet holds the keys tokens are signed and verified with. Tokens are
+// signed with one key and name it in their kid header; any key in the set
+// verifies the tokens naming it, so keys are rotated without signing anyone
+// out:
+//
+//  1. Add the new key to every server, still signing with the old one
+//  2. Once all servers and the services using the JWKS have it, sign with
+//     the new key
+//  3. Once the longest-lived tokens signed with the old key have expired
+//     (7 days, for refresh tokens), remove it
+//
+// HMAC secrets are rotated the same way, through JWT_PREVIOUS_SECRETS.
+type KeySet struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+
+	// Verifies tokens from before they carried key IDs, which were all
+	// signed with JWT_SECRET
+	legacy *signingKey
+}
+
+// LoadKeys loads the HMAC secrets and the private keys in the keys directory,
+// and picks the key to sign with
+func LoadKeys(cfg config.JWTConfig) (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]*signingKey)}
+
+	if cfg.Secret != "" {
+		set.legacy = hmacKey(cfg.Secret)
+		set.signing = set.legacy
+		if err := set.add(set.legacy); err != nil {
+			return nil, err
+		}
+	}
+	for _, secret := range cfg.PreviousSecrets {
+		if secret == cfg.Secret {
+			continue
+		}
+		if err := set.add(hmacKey(secret)); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.KeysDir != "" {
+		paths, err := filepath.Glob(filepath.Join(cfg.KeysDir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			key, err := loadPrivateKey(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := set.add(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.SigningKey != "" {
+		key, ok := set.keys[cfg.SigningKey]
+		if !ok || key.method == jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("signing key %q not found in %q", cfg.SigningKey, cfg.KeysDir)
+		}
+		set.signing = key
+	}
+	if set.signing == nil {
+		return nil, errors.New("no key to sign tokens with: set JWT_SECRET or JWT_SIGNING_KEY")
+	}
+
+	return set, nil
+}
+
+func (s *KeySet) add(key *signingKey) error {
+	if _, ok := s.keys[key.id]; ok {
+		return fmt.Errorf("duplicate signing key %q", key.id)
+	}
+	s.keys[key.id] = key
+	return nil
+}
+
+// hmacKey returns the HS256 key for a secret. Its ID is derived from the
+// secret, so secrets need no names and servers sharing one agree on its ID.
+func hmacKey(secret string) *signingKey {
+	sum := sha256.Sum256([]byte("chatelly-backend jwt key id\x00" + secret))
+	return &signingKey{
+		id:     "hs-" + hex.EncodeToString(sum[:8]),
+		method: jwt.SigningMethodHS256,
+		sign:   []byte(secret),
+		verify: []byte(secret),
+	}
+}
+
+// loadPrivateKey reads an RSA or Ed25519 private key from a PEM file named
+// after the key's ID
+func loadPrivateKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key := &signingKey{id: strings.TrimSuffix(filepath.Base(path), ".pem"), sign: private}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("%s: RSA keys must have at least %d bits", path, minRSAKeyBits)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.verify = &private.PublicKey
+	case ed25519.PrivateKey:
+		key.method = jwt.SigningMethodEdDSA
+		key.verify = private.Public()
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, private)
+	}
+	return key, nil
+}
+
+// SigningKeyID returns the ID of the key new tokens are signed with
+func (s *KeySet) SigningKeyID() string {
+	return s.signing.id
+}
+
+// Sign signs claims with the signing key
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.method, claims)
+	token.Header["kid"] = s.signing.id
+	return token.SignedString(s.signing.sign)
+}
+
+// Parse verifies a token with the key it names and parses its claims
+func (s *KeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, s.keyFunc)
+}
+
+func (s *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	key := s.legacy
+	if kid, ok := token.Header["kid"]; ok {
+		id, _ := kid.(string)
+		key = s.keys[id]
+	}
+	if key == nil {
+		return nil, errors.New("unknown signing key")
+	}
+
+	// A key only verifies tokens signed with its own algorithm, so a public
+	// key can never be used as an HMAC secret
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("invalid signing method")
+	}
+	return key.verify, nil
+}
+
+// JWKS returns the public keys, for other services to verify tokens with.
+// HMAC secrets are never published, so tokens they sign only verify here.
+func (s *KeySet) JWKS() (jwk.Set, error) {
+	set := jwk.Set{Keys: []jwk.Key{}}
+	for _, key := range s.keys {
+		if key.method == jwt.SigningMethodHS256 {
+			continue
+		}
+		public, err := jwk.FromPublicKey(key.id, key.method.Alg(), key.verify)
+		if err != nil {
+			return jwk.Set{}, err
+		}
+		set.Keys = append(set.Keys, public)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set, nil
+}
@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"chatelly-backend/internal/config"
+	"chatelly-backend/internal/models"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var testUser = &models.User{ID: 7, Email: "jane@example.com", Plan: "pro"}
+
+// writeKey saves a private key to a keys directory as <kid>.pem
+func writeKey(t *testing.T, dir, kid string, key interface{}) {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+func loadKeys(t *testing.T, cfg config.JWTConfig) *KeySet {
+	t.Helper()
+	keys, err := LoadKeys(cfg)
+	if err != nil {
+		t.Fatalf("LoadKeys() error = %v", err)
+	}
+	return keys
+}
+
+func TestKeys_RotatesSecretsWithoutSigningAnyoneOut(t *testing.T) {
+	old := loadKeys(t, config.JWTConfig{Secret: "old-secret"})
+
+	// Tokens from before key IDs
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &JWTClaims{
+		UserID: testUser.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Subject:   "access_token",
+		},
+	}).SignedString([]byte("old-secret"))
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	if claims, err := ValidateAccessToken(legacy, old); err != nil || claims.UserID != testUser.ID {
+		t.Fatalf("expected a token without a key ID to verify, got %v", err)
+	}
+
+	signed, err := GenerateRefreshToken(testUser, old)
+	if err != nil {
+		t.Fatalf("GenerateRefreshToken() error = %v", err)
+	}
+
+	rotated := loadKeys(t, config.JWTConfig{Secret: "new-secret", PreviousSecrets: []string{"old-secret"}})
+	if _, err := ValidateRefreshToken(signed, rotated); err != nil {
+		t.Errorf("expected the retired secret to still verify, got %v", err)
+	}
+	fresh, _ := GenerateRefreshToken(testUser, rotated)
+	if _, err := ValidateRefreshToken(fresh, old); err == nil {
+		t.Errorf("expected new tokens to be signed with the new secret")
+	}
+
+	retired := loadKeys(t, config.JWTConfig{Secret: "new-secret"})
+	if _, err := ValidateRefreshToken(signed, retired); err == nil {
+		t.Errorf("expected a removed secret to stop verifying")
+	}
+}
+
+func TestKeys_SignsWithPrivateKeys(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey := newRSAKey(t)
+	writeKey(t, dir, "rsa-2026", rsaKey)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	writeKey(t, dir, "ed-2026", edKey)
+
+	for kid, alg := range map[string]string{"rsa-2026": "RS256", "ed-2026": "EdDSA"} {
+		cfg := &config.Config{JWT: config.JWTConfig{Secret: "secret", KeysDir: dir, SigningKey: kid, Expiration: 1}}
+
+		keys := loadKeys(t, cfg.JWT)
+		token, err := GenerateAccessToken(testUser, cfg, keys)
+		if err != nil {
+			t.Fatalf("GenerateAccessToken() error = %v", err)
+		}
+		parsed, _, _ := new(jwt.Parser).ParseUnverified(token, &JWTClaims{})
+		if parsed.Header["kid"] != kid || parsed.Method.Alg() != alg {
+			t.Errorf("expected %s signed with %s, got %v", alg, kid, parsed.Header)
+		}
+		if claims, err := ValidateAccessToken(token, keys); err != nil || claims.Email != testUser.Email {
+			t.Errorf("ValidateAccessToken() error = %v", err)
+		}
+	}
+
+	// The JWKS verifies tokens without the secret or private keys
+	keys := loadKeys(t, config.JWTConfig{Secret: "secret", KeysDir: dir, SigningKey: "rsa-2026"})
+	set, err := keys.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS() error = %v", err)
+	}
+	if len(set.Keys) != 2 || set.Keys[0].Kid != "ed-2026" || set.Keys[1].Kid != "rsa-2026" {
+		t.Fatalf("expected only the two public keys, got %+v", set.Keys)
+	}
+	public, err := set.Keys[1].PublicKey()
+	if err != nil {
+		t.Fatalf("PublicKey() error = %v", err)
+	}
+	token, _ := keys.Sign(&JWTClaims{UserID: testUser.ID})
+	if _, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return public, nil }); err != nil {
+		t.Errorf("expected the published key to verify tokens, got %v", err)
+	}
+}
+
+func TestKeys_RefusesOtherAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey := newRSAKey(t)
+	writeKey(t, dir, "rsa-2026", rsaKey)
+	keys := loadKeys(t, config.JWTConfig{Secret: "secret", KeysDir: dir, SigningKey: "rsa-2026"})
+
+	// Signed with the published public key as an HMAC secret
+	public, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	claims := &JWTClaims{
+		UserID: testUser.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Subject:   "access_token",
+		},
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "rsa-2026"
+	signed, _ := forged.SignedString(public)
+	if _, err := ValidateAccessToken(signed, keys); err == nil {
+		t.Errorf("expected a token signed with another algorithm to be refused")
+	}
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	unknown.Header["kid"] = "rsa-2025"
+	signed, _ = unknown.SignedString([]byte("secret"))
+	if _, err := ValidateAccessToken(signed, keys); err == nil {
+		t.Errorf("expected a token naming an unknown key to be refused")
+	}
+}
+
+func TestLoadKeys_Errors(t *testing.T) {
+	dir := t.TempDir()
+	weak, _ := rsa.GenerateKey(rand.Reader, 1024)
+	writeKey(t, dir, "weak", weak)
+
+	tests := []struct {
+		name string
+		cfg  config.JWTConfig
+	}{
+		{"no keys", config.JWTConfig{}},
+		{"unknown signing key", config.JWTConfig{Secret: "secret", SigningKey: "missing"}},
+		{"weak RSA key", config.JWTConfig{KeysDir: dir, SigningKey: "weak"}},
+	}
+	for _, tt := range tests {
+		if _, err := LoadKeys(tt.cfg); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
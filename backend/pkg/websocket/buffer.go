@@ -0,0 +1,243 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// Events kept per visitor session for replay after a reconnect
+	DefaultSessionBufferSize = 200
+
+	// How long a session's events are kept after the last one
+	DefaultSessionBufferTTL = time.Hour
+)
+
+// BufferedEvent is an event pushed to a visitor session, numbered in the
+// order the session received it
+type BufferedEvent struct {
+	Seq  int64
+	Data []byte
+}
+
+// SessionBuffer keeps the recent events pushed to each visitor session so a
+// reconnecting widget can catch up on what it missed
+type SessionBuffer interface {
+	// NextSeq numbers the session's next event
+	NextSeq(sessionID string) (int64, error)
+
+	// Add stores a numbered event
+	Add(sessionID string, event BufferedEvent) error
+
+	// Since returns the session's events after seq in order, along with the
+	// latest sequence. complete is false when some of the events after seq are
+	// no longer buffered.
+	Since(sessionID string, seq int64) (events []BufferedEvent, latest int64, complete bool, err error)
+}
+
+// contiguous reports whether events hold every event after seq up to latest
+func contiguous(events []BufferedEvent, seq, latest int64) bool {
+	if latest < seq {
+		// The buffer lost the session, so its numbering started over
+		return false
+	}
+	if int64(len(events)) != latest-seq {
+		return false
+	}
+	for i, event := range events {
+		if event.Seq != seq+int64(i)+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// memorySession is one session's events in a MemoryBuffer
+type memorySession struct {
+	seq     int64
+	events  []BufferedEvent
+	touched time.Time
+}
+
+// MemoryBuffer is a SessionBuffer for a single server. Events are lost on
+// restart, after which reconnecting widgets resync from the database.
+type MemoryBuffer struct {
+	mu         sync.Mutex
+	sessions   map[string]*memorySession
+	size       int
+	ttl        time.Duration
+	lastPruned time.Time
+}
+
+// NewMemoryBuffer creates a MemoryBuffer keeping size events per session
+func NewMemoryBuffer(size int, ttl time.Duration) *MemoryBuffer {
+	return &MemoryBuffer{
+		sessions:   make(map[string]*memorySession),
+		size:       size,
+		ttl:        ttl,
+		lastPruned: time.Now(),
+	}
+}
+
+// NextSeq numbers the session's next event
+func (b *MemoryBuffer) NextSeq(sessionID string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.prune(now)
+
+	session, ok := b.sessions[sessionID]
+	if !ok {
+		session = &memorySession{}
+		b.sessions[sessionID] = session
+	}
+	session.seq++
+	session.touched = now
+	return session.seq, nil
+}
+
+// Add stores a numbered event, dropping the session's oldest beyond the size
+func (b *MemoryBuffer) Add(sessionID string, event BufferedEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	session, ok := b.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+
+	// Events numbered together may be added out of order
+	i := len(session.events)
+	for i > 0 && session.events[i-1].Seq > event.Seq {
+		i--
+	}
+	session.events = append(session.events, BufferedEvent{})
+	copy(session.events[i+1:], session.events[i:])
+	session.events[i] = event
+
+	if len(session.events) > b.size {
+		session.events = session.events[len(session.events)-b.size:]
+	}
+	return nil
+}
+
+// Since returns the session's events after seq
+func (b *MemoryBuffer) Since(sessionID string, seq int64) ([]BufferedEvent, int64, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	session, ok := b.sessions[sessionID]
+	if !ok {
+		return nil, 0, seq == 0, nil
+	}
+
+	var events []BufferedEvent
+	for _, event := range session.events {
+		if event.Seq > seq {
+			events = append(events, event)
+		}
+	}
+	return events, session.seq, contiguous(events, seq, session.seq), nil
+}
+
+// prune drops sessions without events for longer than the TTL. Caller must
+// hold b.mu.
+func (b *MemoryBuffer) prune(now time.Time) {
+	if now.Sub(b.lastPruned) < b.ttl {
+		return
+	}
+	b.lastPruned = now
+
+	for sessionID, session := range b.sessions {
+		if now.Sub(session.touched) > b.ttl {
+			delete(b.sessions, sessionID)
+		}
+	}
+}
+
+// RedisBuffer is a SessionBuffer shared by every server. Each session's
+// events are a sorted set scored by sequence, so events numbered together but
+// stored out of order still replay in order.
+type RedisBuffer struct {
+	client *redis.Client
+	size   int
+	ttl    time.Duration
+}
+
+// NewRedisBuffer creates a RedisBuffer keeping size events per session
+func NewRedisBuffer(client *redis.Client, size int, ttl time.Duration) *RedisBuffer {
+	return &RedisBuffer{
+		client: client,
+		size:   size,
+		ttl:    ttl,
+	}
+}
+
+func sessionSeqKey(sessionID string) string {
+	return fmt.Sprintf("ws:session:%s:seq", sessionID)
+}
+
+func sessionEventsKey(sessionID string) string {
+	return fmt.Sprintf("ws:session:%s:events", sessionID)
+}
+
+// NextSeq numbers the session's next event
+func (b *RedisBuffer) NextSeq(sessionID string) (int64, error) {
+	ctx := context.Background()
+	key := sessionSeqKey(sessionID)
+
+	pipe := b.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, b.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// Add stores a numbered event, dropping the session's oldest beyond the size
+func (b *RedisBuffer) Add(sessionID string, event BufferedEvent) error {
+	ctx := context.Background()
+	key := sessionEventsKey(sessionID)
+
+	pipe := b.client.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(event.Seq), Member: event.Data})
+	pipe.ZRemRangeByRank(ctx, key, 0, int64(-b.size-1))
+	pipe.Expire(ctx, key, b.ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Since returns the session's events after seq
+func (b *RedisBuffer) Since(sessionID string, seq int64) ([]BufferedEvent, int64, bool, error) {
+	ctx := context.Background()
+
+	latest, err := b.client.Get(ctx, sessionSeqKey(sessionID)).Int64()
+	if err == redis.Nil {
+		return nil, 0, seq == 0, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	members, err := b.client.ZRangeByScoreWithScores(ctx, sessionEventsKey(sessionID), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(seq, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, latest, false, err
+	}
+
+	events := make([]BufferedEvent, 0, len(members))
+	for _, member := range members {
+		data, _ := member.Member.(string)
+		events = append(events, BufferedEvent{Seq: int64(member.Score), Data: []byte(data)})
+	}
+	return events, latest, contiguous(events, seq, latest), nil
+}
@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryBuffer_Since(t *testing.T) {
+	buffer := NewMemoryBuffer(3, time.Hour)
+
+	// Nothing was pushed to a new session
+	if events, latest, complete, _ := buffer.Since("s1", 0); len(events) != 0 || latest != 0 || !complete {
+		t.Fatalf("Since() on a new session = %v, %d, %v", events, latest, complete)
+	}
+
+	for i := 0; i < 4; i++ {
+		seq, err := buffer.NextSeq("s1")
+		if err != nil || seq != int64(i+1) {
+			t.Fatalf("NextSeq() = %d, %v, want %d", seq, err, i+1)
+		}
+	}
+	// Events numbered together may be added out of order
+	for _, seq := range []int64{1, 3, 2, 4} {
+		if err := buffer.Add("s1", BufferedEvent{Seq: seq, Data: []byte{byte(seq)}}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	events, latest, complete, _ := buffer.Since("s1", 2)
+	if !complete || latest != 4 || len(events) != 2 || events[0].Seq != 3 || events[1].Seq != 4 {
+		t.Errorf("Since(2) = %v, %d, %v", events, latest, complete)
+	}
+	if events, _, complete, _ := buffer.Since("s1", 4); !complete || len(events) != 0 {
+		t.Errorf("expected nothing missed at the latest sequence, got %v, %v", events, complete)
+	}
+
+	// The first event was dropped to keep three
+	if _, _, complete, _ := buffer.Since("s1", 0); complete {
+		t.Errorf("expected a gap once the oldest events are dropped")
+	}
+
+	// A client ahead of the buffer saw a numbering the buffer has lost
+	if _, _, complete, _ := buffer.Since("s1", 9); complete {
+		t.Errorf("expected a gap for a sequence the buffer never reached")
+	}
+	if _, _, complete, _ := buffer.Since("unknown", 3); complete {
+		t.Errorf("expected a gap for a session the buffer does not know")
+	}
+}
@@ -0,0 +1,264 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Transports a widget visitor can be connected over
+const (
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+	TransportPolling   = "polling"
+)
+
+const (
+	// How long a long poll waits for a message before returning empty
+	pollWait = 25 * time.Second
+
+	// A polling stream that is not polled again within this time is closed
+	pollIdleTimeout = pongWait
+
+	// Send a comment on an idle event stream this often, so proxies keep it open
+	sseKeepAlive = 25 * time.Second
+)
+
+// pollResponse is the body of a long poll
+type pollResponse struct {
+	StreamID string            `json:"stream_id"`
+	Messages []json.RawMessage `json:"messages"`
+	Closed   bool              `json:"closed,omitempty"` // the stream is gone; open a new one
+	Reason   string            `json:"reason,omitempty"` // why the hub closed it
+}
+
+// newStreamClient registers a widget visitor on a fallback transport. Until a
+// request takes its messages, they queue on the client like a socket's do.
+func newStreamClient(hub *Hub, r *http.Request, visitor Visitor, transport string) (*Client, error) {
+	streamID, err := newStreamID()
+	if err != nil {
+		return nil, err
+	}
+
+	client := newVisitorClient(hub, nil, r, visitor)
+	client.StreamID = streamID
+	client.Transport = transport
+
+	hub.register <- client
+	return client, nil
+}
+
+func newStreamID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// stream looks up the fallback stream of a widget visitor
+func (h *Hub) stream(streamID string, websiteID uint) (*Client, bool) {
+	shard := h.shard(websiteID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	client, ok := shard.streams[streamID]
+	if !ok || client.WebsiteID != websiteID {
+		return nil, false
+	}
+	return client, true
+}
+
+// ServeSSE streams a widget visitor's messages as Server-Sent Events, for
+// networks that do not let websockets through. The visitor sends with
+// ServeSend, naming the stream from the connection_established message.
+func ServeSSE(hub *Hub, w http.ResponseWriter, r *http.Request, visitor Visitor) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "Streaming is not supported"})
+		return
+	}
+
+	client, err := newStreamClient(hub, r, visitor, TransportSSE)
+	if err != nil {
+		log.Printf("Failed to open event stream: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "Failed to open stream"})
+		return
+	}
+	defer func() {
+		hub.unregister <- client
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stop nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-client.done:
+			writeCloseEvent(w, client)
+			flusher.Flush()
+			return
+
+		case message, ok := <-client.send:
+			if !ok {
+				writeCloseEvent(w, client)
+				flusher.Flush()
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", message); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ServePoll returns a widget visitor's queued messages, waiting for one if
+// none are queued. Without a stream ID it opens a new polling stream; the
+// response names it for the next poll.
+func ServePoll(hub *Hub, w http.ResponseWriter, r *http.Request, visitor Visitor, streamID string) {
+	var client *Client
+	if streamID == "" {
+		var err error
+		client, err = newStreamClient(hub, r, visitor, TransportPolling)
+		if err != nil {
+			log.Printf("Failed to open polling stream: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "Failed to open stream"})
+			return
+		}
+		client.idle = time.AfterFunc(pollIdleTimeout, func() {
+			hub.unregister <- client
+		})
+	} else {
+		var ok bool
+		client, ok = hub.stream(streamID, visitor.WebsiteID)
+		if !ok || client.SessionID != visitor.SessionID || client.Transport != TransportPolling {
+			writeJSON(w, http.StatusOK, pollResponse{StreamID: streamID, Messages: []json.RawMessage{}, Closed: true})
+			return
+		}
+	}
+
+	// Two polls would split the messages between them
+	if !client.polling.TryLock() {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"error": "Stream is already being polled"})
+		return
+	}
+	defer client.polling.Unlock()
+
+	// The stream stays open while it is polled, and for a while after
+	client.idle.Stop()
+	defer client.idle.Reset(pollIdleTimeout)
+
+	response := pollResponse{StreamID: client.StreamID, Messages: []json.RawMessage{}}
+	timer := time.NewTimer(pollWait)
+	defer timer.Stop()
+
+	select {
+	case <-client.done:
+		// Evicted while nobody was polling; what it missed is replayed on resume
+		response.Closed = true
+	default:
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				response.Closed = true
+				break
+			}
+			response.Messages = append(response.Messages, message)
+			response.Closed = drain(client, &response)
+		case <-client.done:
+			response.Closed = true
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if response.Closed {
+		response.Reason = client.reason()
+		hub.unregister <- client
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// drain adds the messages already queued on a polling stream to the response
+// and reports whether the stream was closed
+func drain(client *Client, response *pollResponse) bool {
+	for {
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				return true
+			}
+			response.Messages = append(response.Messages, message)
+		default:
+			return false
+		}
+	}
+}
+
+// ServeSend takes a message a widget visitor sends on a fallback stream. It is
+// handled like one read from a websocket. Only the session the stream was
+// opened for may send on it.
+func ServeSend(hub *Hub, w http.ResponseWriter, r *http.Request, visitor Visitor, streamID string) {
+	client, ok := hub.stream(streamID, visitor.WebsiteID)
+	if !ok || client.SessionID != visitor.SessionID || (client.Transport != TransportSSE && client.Transport != TransportPolling) {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"error": "Stream not found"})
+		return
+	}
+
+	var msg Message
+	body := http.MaxBytesReader(w, r.Body, maxMessageSize)
+	if err := json.NewDecoder(body).Decode(&msg); err != nil || msg.Type == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "Invalid message"})
+		return
+	}
+
+	// Handle a visitor's messages one at a time, in the order they arrive
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	client.dispatch(&msg)
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "accepted"})
+}
+
+// writeCloseEvent tells an event stream why the hub closed it
+func writeCloseEvent(w http.ResponseWriter, client *Client) {
+	message, err := json.Marshal(Message{
+		Type:      "connection_closed",
+		Data:      map[string]interface{}{"reason": client.reason()},
+		Timestamp: getCurrentTimestamp(),
+	})
+	if err == nil {
+		fmt.Fprintf(w, "data: %s\n\n", message)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
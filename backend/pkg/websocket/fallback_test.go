@@ -0,0 +1,169 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamMessage is a message read from a fallback transport
+type streamMessage struct {
+	Type string                 `json:"type"`
+	Data map[string]interface{} `json:"data"`
+}
+
+func postMessage(t *testing.T, server *httptest.Server, streamID, msgType string, data map[string]interface{}) int {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{"type": msgType, "data": data})
+	resp, err := http.Post(server.URL+"/send?stream_id="+streamID, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("failed to send %s: %v", msgType, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func poll(t *testing.T, server *httptest.Server, streamID string) pollResponse {
+	t.Helper()
+
+	resp, err := http.Get(server.URL + "/poll?stream_id=" + streamID)
+	if err != nil {
+		t.Fatalf("failed to poll: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result pollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode poll: %v", err)
+	}
+	return result
+}
+
+func decodeMessages(t *testing.T, raw []json.RawMessage) []streamMessage {
+	t.Helper()
+
+	messages := make([]streamMessage, len(raw))
+	for i, message := range raw {
+		if err := json.Unmarshal(message, &messages[i]); err != nil {
+			t.Fatalf("failed to decode message: %v", err)
+		}
+	}
+	return messages
+}
+
+func TestServePoll(t *testing.T) {
+	server, hub := newTestServer(t, newFakeStore())
+
+	opened := poll(t, server, "")
+	messages := decodeMessages(t, opened.Messages)
+	if opened.StreamID == "" || len(messages) != 1 || messages[0].Type != "connection_established" {
+		t.Fatalf("unexpected first poll %+v", opened)
+	}
+	if messages[0].Data["stream_id"] != opened.StreamID || messages[0].Data["transport"] != TransportPolling {
+		t.Errorf("unexpected welcome %v", messages[0].Data)
+	}
+
+	status := postMessage(t, server, opened.StreamID, "chat_message", map[string]interface{}{"client_message_id": "c-1", "content": "Hello"})
+	if status != http.StatusAccepted {
+		t.Fatalf("expected the message to be accepted, got %d", status)
+	}
+	messages = decodeMessages(t, poll(t, server, opened.StreamID).Messages)
+	if len(messages) != 1 || messages[0].Type != "ack" || messages[0].Data["client_message_id"] != "c-1" {
+		t.Fatalf("expected an ack, got %+v", messages)
+	}
+
+	// Events pushed while no poll is waiting queue for the next one
+	hub.SendToSession(testSessionID, "message_received", map[string]interface{}{"content": "First"})
+	hub.SendToSession(testSessionID, "message_received", map[string]interface{}{"content": "Second"})
+	messages = decodeMessages(t, poll(t, server, opened.StreamID).Messages)
+	if len(messages) != 2 || messages[0].Data["content"] != "First" || messages[1].Data["content"] != "Second" {
+		t.Errorf("expected both queued events, got %+v", messages)
+	}
+
+	if status := postMessage(t, server, "unknown", "chat_message", map[string]interface{}{"content": "Hi"}); status != http.StatusNotFound {
+		t.Errorf("expected an unknown stream to be rejected, got %d", status)
+	}
+	resp, err := http.Post(server.URL+"/send?session_id=someone-else&stream_id="+opened.StreamID, "application/json", strings.NewReader(`{"type":"chat_message","data":{"content":"Hi"}}`))
+	if err != nil {
+		t.Fatalf("failed to send: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected another session's send to be rejected, got %d", resp.StatusCode)
+	}
+	if status := postMessage(t, server, opened.StreamID, "", nil); status != http.StatusBadRequest {
+		t.Errorf("expected a message without a type to be rejected, got %d", status)
+	}
+	if result := poll(t, server, "unknown"); !result.Closed {
+		t.Errorf("expected polling an unknown stream to report it closed, got %+v", result)
+	}
+}
+
+func TestServeSSE(t *testing.T) {
+	server, _ := newTestServer(t, newFakeStore())
+
+	resp, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan streamMessage)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var message streamMessage
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &message); err == nil {
+				events <- message
+			}
+		}
+		close(events)
+	}()
+	next := func(msgType string) map[string]interface{} {
+		t.Helper()
+		select {
+		case message := <-events:
+			if message.Type != msgType {
+				t.Fatalf("expected a %s event, got %s %v", msgType, message.Type, message.Data)
+			}
+			return message.Data
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for a %s event", msgType)
+		}
+		return nil
+	}
+
+	welcome := next("connection_established")
+	streamID, _ := welcome["stream_id"].(string)
+	if streamID == "" || welcome["transport"] != TransportSSE {
+		t.Fatalf("unexpected welcome %v", welcome)
+	}
+
+	// Messages posted to the stream are handled like ones from a socket
+	agent := dial(t, server, "/agent")
+	postMessage(t, server, streamID, "chat_message", map[string]interface{}{"client_message_id": "c-1", "content": "Hello"})
+	if ack := next("ack"); ack["client_message_id"] != "c-1" {
+		t.Errorf("unexpected ack %v", ack)
+	}
+	if received := agent.expect("message_received"); received["content"] != "Hello" {
+		t.Errorf("unexpected message to the agent %v", received)
+	}
+
+	agent.send("chat_message", map[string]interface{}{"chat_id": testChatID, "content": "Hi there"})
+	agent.expect("ack")
+	if received := next("message_received"); received["content"] != "Hi there" {
+		t.Errorf("unexpected reply %v", received)
+	}
+}
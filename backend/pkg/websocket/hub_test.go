@@ -0,0 +1,230 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Simulated clients spread over this many websites in the benchmarks
+const benchWebsites = 100
+
+// newSimulatedClient registers a visitor without a connection; its messages
+// are read straight off its send channel
+func newSimulatedClient(t testing.TB, hub *Hub, websiteID uint, sessionID string, buffer int) *Client {
+	t.Helper()
+
+	client := newClient(hub, nil, httptest.NewRequest("GET", "/", nil))
+	client.send = make(chan []byte, buffer)
+	client.WebsiteID = websiteID
+	client.SessionID = sessionID
+
+	hub.register <- client
+	select {
+	case <-client.send:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("client %s was not welcomed", sessionID)
+	}
+	return client
+}
+
+// waitClosed waits for the hub to close a client's send channel
+func waitClosed(t *testing.T, client *Client) {
+	t.Helper()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-client.send:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("client %s was not unregistered", client.SessionID)
+		}
+	}
+}
+
+func TestHub_EvictsSlowConsumers(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	slow := newSimulatedClient(t, hub, testWebsiteID, "slow", 2)
+	other := newSimulatedClient(t, hub, testWebsiteID, "other", sendBufferSize)
+
+	for i := 0; i < 3; i++ {
+		hub.SendToSession("slow", "message_received", map[string]interface{}{"content": i})
+	}
+
+	select {
+	case <-slow.done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the slow client to be evicted")
+	}
+	if slow.reason() != closeSlowConsumer || slow.closeCode != websocket.CloseTryAgainLater {
+		t.Errorf("unexpected close %d %q", slow.closeCode, slow.reason())
+	}
+	waitClosed(t, slow)
+
+	if count := hub.GetWebsiteClientCount(testWebsiteID); count != 1 {
+		t.Errorf("expected only the other client to stay registered, got %d", count)
+	}
+	if other.reason() != "" {
+		t.Errorf("expected the other client to be left alone")
+	}
+
+	// Sending to an evicted session is a no-op, not a send on a closed channel
+	hub.SendToSession("slow", "message_received", map[string]interface{}{"content": "late"})
+}
+
+func TestHub_EvictionClosesWithReason(t *testing.T) {
+	server, hub := newTestServer(t, nil)
+	visitor := dial(t, server, "/visitor")
+
+	shard := hub.shard(testWebsiteID)
+	shard.mu.RLock()
+	var client *Client
+	for c := range shard.sessions[testSessionID] {
+		client = c
+	}
+	shard.mu.RUnlock()
+	if client == nil {
+		t.Fatalf("expected the visitor to be registered")
+	}
+
+	client.evict(websocket.CloseTryAgainLater, closeSlowConsumer)
+
+	visitor.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := visitor.conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater || closeErr.Text != closeSlowConsumer {
+		t.Fatalf("expected a slow consumer close, got %v", err)
+	}
+}
+
+func TestHub_ConcurrentTrafficAndChurn(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+
+	const websites, perWebsite = 4, 25
+	var wg sync.WaitGroup
+	var clients []*Client
+	for w := 1; w <= websites; w++ {
+		for i := 0; i < perWebsite; i++ {
+			client := newSimulatedClient(t, hub, uint(w), fmt.Sprintf("s-%d-%d", w, i), sendBufferSize)
+			clients = append(clients, client)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range client.send {
+				}
+			}()
+		}
+	}
+
+	// Senders of every kind run while clients come and go
+	stop := make(chan struct{})
+	var senders sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		senders.Add(1)
+		go func(g int) {
+			defer senders.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				client := clients[(g*31+i)%len(clients)]
+				hub.SendToSession(client.SessionID, "message_received", map[string]interface{}{"n": i})
+				hub.handleMessage(client, &Message{Type: "typing_start"})
+				hub.SendToAgent(testAgentID, "chat_updated", nil)
+				client.SendMessage("pong", nil)
+			}
+		}(g)
+	}
+
+	for i := 0; i < 50; i++ {
+		churn := newSimulatedClient(t, hub, uint(i%websites+1), fmt.Sprintf("churn-%d", i), 1)
+		hub.unregister <- churn
+		if i%10 == 0 {
+			clients[i].evict(websocket.CloseTryAgainLater, closeSlowConsumer)
+		}
+	}
+	close(stop)
+	senders.Wait()
+
+	for _, client := range clients {
+		hub.unregister <- client
+	}
+	wg.Wait()
+	if count := hub.GetClientCount(); count != 0 {
+		t.Errorf("expected every client to be unregistered, got %d", count)
+	}
+}
+
+// newBenchHub registers n simulated clients spread over benchWebsites websites
+func newBenchHub(b *testing.B, n int) (*Hub, []*Client) {
+	b.Helper()
+
+	// Every registration is logged; keep that out of the results
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	hub := NewHub()
+	hub.SetSessionBuffer(NewMemoryBuffer(16, time.Hour))
+	go hub.Run()
+
+	clients := make([]*Client, n)
+	for i := range clients {
+		clients[i] = newSimulatedClient(b, hub, uint(i%benchWebsites+1), fmt.Sprintf("session-%d", i), sendBufferSize)
+	}
+	return hub, clients
+}
+
+func BenchmarkHub_SendToSession(b *testing.B) {
+	hub, clients := newBenchHub(b, 10000)
+	var next atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			client := clients[next.Add(1)%int64(len(clients))]
+			hub.SendToSession(client.SessionID, "message_received", map[string]interface{}{"content": "Hello"})
+			<-client.send
+		}
+	})
+}
+
+func BenchmarkHub_BroadcastToWebsite(b *testing.B) {
+	hub, clients := newBenchHub(b, 10000)
+	message := &Message{Type: "user_typing", Data: map[string]interface{}{"session_id": "session-0"}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		websiteID := uint(i%benchWebsites + 1)
+		hub.broadcastToWebsite(websiteID, message)
+		for j := int(websiteID) - 1; j < len(clients); j += benchWebsites {
+			<-clients[j].send
+		}
+	}
+}
+
+func BenchmarkHub_RegisterUnregister(b *testing.B) {
+	hub, _ := newBenchHub(b, 10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client := newSimulatedClient(b, hub, uint(i%benchWebsites+1), fmt.Sprintf("churn-%d", i), 1)
+		hub.unregister <- client
+	}
+}
@@ -0,0 +1,325 @@
+package websocket
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The limits a client can hit
+const (
+	LimitMessageRate = "message_rate"
+	LimitConnections = "connections"
+	LimitBanned      = "banned"
+)
+
+// Why the hub refused a client
+const (
+	closeBanned             = "banned"
+	closeTooManyConnections = "too many connections"
+)
+
+// Visitor identifies a widget visitor connecting to the hub
+type Visitor struct {
+	SessionID string
+	WebsiteID uint
+	VisitorID string
+
+	// Address the visitor connects from, as resolved through the router's
+	// trusted proxies; visitors are banned by it
+	IP string
+
+	// Concurrent visitor connections the website allows; zero for no cap
+	MaxConnections int
+}
+
+// MessageLimit is a token bucket: Rate messages a second on average, and up
+// to Burst at once
+type MessageLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limits protect the hub from clients that flood it
+type Limits struct {
+	// Per connection, by message type; Default covers the other types
+	Messages map[string]MessageLimit
+	Default  MessageLimit
+
+	// Agent consoles carry every chat an agent has open, so their limits
+	// are scaled up by this much
+	AgentScale float64
+
+	// A visitor who hits a limit Strikes times within StrikeWindow is banned,
+	// by IP and visitor ID, for BanDuration
+	Strikes      int
+	StrikeWindow time.Duration
+	BanDuration  time.Duration
+}
+
+// DefaultLimits returns the limits a new hub applies
+func DefaultLimits() Limits {
+	return Limits{
+		Messages: map[string]MessageLimit{
+			"chat_message": {Rate: 1, Burst: 5},
+			"typing_start": {Rate: 2, Burst: 5},
+			"typing_stop":  {Rate: 2, Burst: 5},
+			"delivered":    {Rate: 5, Burst: 20},
+			"read":         {Rate: 5, Burst: 20},
+			"join_chat":    {Rate: 0.2, Burst: 3},
+			"resume":       {Rate: 0.2, Burst: 3},
+		},
+		Default:      MessageLimit{Rate: 5, Burst: 10},
+		AgentScale:   5,
+		Strikes:      20,
+		StrikeWindow: time.Minute,
+		BanDuration:  10 * time.Minute,
+	}
+}
+
+// message returns the limit of a message type for a client
+func (l Limits) message(msgType string, agent bool) MessageLimit {
+	limit, ok := l.Messages[msgType]
+	if !ok {
+		limit = l.Default
+	}
+	if agent && l.AgentScale > 0 {
+		limit.Rate *= l.AgentScale
+		limit.Burst = int(float64(limit.Burst) * l.AgentScale)
+	}
+	return limit
+}
+
+// LimitHit is a message or connection the hub refused
+type LimitHit struct {
+	Limit       string
+	MessageType string // for message rate limits
+	WebsiteID   uint
+	SessionID   string
+	VisitorID   string
+	IP          string
+	UserAgent   string
+	At          time.Time
+}
+
+// tokenBucket rate limits one message type on one connection
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token if one is left
+func (b *tokenBucket) allow(limit MessageLimit, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(limit.Burst)
+	} else {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// banList keeps the strikes against visitors and who is banned. Keys are
+// "ip:<address>" and "visitor:<ID>".
+type banList struct {
+	mu         sync.Mutex
+	strikes    map[string][]time.Time
+	bans       map[string]time.Time
+	lastPruned time.Time
+}
+
+func newBanList() *banList {
+	return &banList{
+		strikes:    make(map[string][]time.Time),
+		bans:       make(map[string]time.Time),
+		lastPruned: time.Now(),
+	}
+}
+
+// banned reports whether any of the keys is banned
+func (b *banList) banned(keys []string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range keys {
+		if until, ok := b.bans[key]; ok && now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
+
+// strike counts a limit hit against the keys and reports whether it got them
+// banned
+func (b *banList) strike(keys []string, limits Limits, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.prune(limits, now)
+
+	banned := false
+	for _, key := range keys {
+		strikes := recent(b.strikes[key], now.Add(-limits.StrikeWindow))
+		strikes = append(strikes, now)
+		if len(strikes) < limits.Strikes {
+			b.strikes[key] = strikes
+			continue
+		}
+
+		delete(b.strikes, key)
+		b.bans[key] = now.Add(limits.BanDuration)
+		banned = true
+	}
+	return banned
+}
+
+// prune drops expired strikes and bans. Caller must hold b.mu.
+func (b *banList) prune(limits Limits, now time.Time) {
+	if now.Sub(b.lastPruned) < limits.StrikeWindow {
+		return
+	}
+	b.lastPruned = now
+
+	for key, strikes := range b.strikes {
+		if len(recent(strikes, now.Add(-limits.StrikeWindow))) == 0 {
+			delete(b.strikes, key)
+		}
+	}
+	for key, until := range b.bans {
+		if !now.Before(until) {
+			delete(b.bans, key)
+		}
+	}
+}
+
+// recent returns the times after since
+func recent(times []time.Time, since time.Time) []time.Time {
+	for i, t := range times {
+		if t.After(since) {
+			return times[i:]
+		}
+	}
+	return times[:0]
+}
+
+// SetLimits sets the message rate limits and ban thresholds
+func (h *Hub) SetLimits(limits Limits) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.limits = limits
+}
+
+// SetLimitHandler sets the callback told of every message or connection the
+// hub refuses. It runs off the hub, so it may touch the database.
+func (h *Hub) SetLimitHandler(handler func(hit LimitHit)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.limitHandler = handler
+}
+
+func (h *Hub) currentLimits() Limits {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.limits
+}
+
+// allowMessage rate limits a message a client sent. Visitors who keep hitting
+// the limits are banned and disconnected.
+func (h *Hub) allowMessage(client *Client, message *Message) bool {
+	now := time.Now()
+	limits := h.currentLimits()
+	if client.allow(message.Type, limits.message(message.Type, client.UserID != 0), now) {
+		return true
+	}
+
+	clientMessageID := ""
+	if payload, ok := decodePayload(message); ok {
+		clientMessageID = payload.ClientMessageID
+	}
+	h.sendError(client, message.Type, clientMessageID, "rate limit exceeded")
+
+	// Agents are signed in; the limit is enough for them
+	if client.UserID != 0 {
+		return false
+	}
+
+	h.reportLimit(client, LimitMessageRate, message.Type)
+	if h.bans.strike(client.banKeys(), limits, now) {
+		h.reportLimit(client, LimitBanned, message.Type)
+		client.evict(websocket.ClosePolicyViolation, closeBanned)
+	}
+	return false
+}
+
+// admit checks a visitor may connect: they are not banned and the website is
+// under its connection cap. Caller must hold the shard's lock.
+func (h *Hub) admit(client *Client, shard *shard) bool {
+	if h.bans.banned(client.banKeys(), time.Now()) {
+		h.reportLimit(client, LimitBanned, "")
+		client.evict(websocket.ClosePolicyViolation, closeBanned)
+		return false
+	}
+
+	if client.maxConnections > 0 && len(shard.clients[client.WebsiteID]) >= client.maxConnections {
+		h.reportLimit(client, LimitConnections, "")
+		client.evict(websocket.CloseTryAgainLater, closeTooManyConnections)
+		return false
+	}
+	return true
+}
+
+// reportLimit tells the limit handler about a refused message or connection
+func (h *Hub) reportLimit(client *Client, limit, msgType string) {
+	log.Printf("Client %s of website %d hit the %s limit", client.SessionID, client.WebsiteID, limit)
+
+	h.mu.RLock()
+	handler := h.limitHandler
+	h.mu.RUnlock()
+	if handler == nil {
+		return
+	}
+
+	go handler(LimitHit{
+		Limit:       limit,
+		MessageType: msgType,
+		WebsiteID:   client.WebsiteID,
+		SessionID:   client.SessionID,
+		VisitorID:   client.VisitorID,
+		IP:          client.IP,
+		UserAgent:   client.UserAgent,
+		At:          time.Now(),
+	})
+}
+
+// allow takes a token from the client's bucket for a message type
+func (c *Client) allow(msgType string, limit MessageLimit, now time.Time) bool {
+	c.bucketsMu.Lock()
+	defer c.bucketsMu.Unlock()
+
+	bucket, ok := c.buckets[msgType]
+	if !ok {
+		bucket = &tokenBucket{}
+		c.buckets[msgType] = bucket
+	}
+	return bucket.allow(limit, now)
+}
+
+// banKeys are the keys a visitor is banned by
+func (c *Client) banKeys() []string {
+	var keys []string
+	if c.IP != "" {
+		keys = append(keys, "ip:"+c.IP)
+	}
+	if c.VisitorID != "" {
+		keys = append(keys, "visitor:"+c.VisitorID)
+	}
+	return keys
+}
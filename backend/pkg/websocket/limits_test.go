@@ -0,0 +1,223 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := MessageLimit{Rate: 2, Burst: 3}
+	bucket := &tokenBucket{}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !bucket.allow(limit, now) {
+			t.Fatalf("expected message %d of the burst to be allowed", i+1)
+		}
+	}
+	if bucket.allow(limit, now) {
+		t.Fatalf("expected the bucket to be empty after the burst")
+	}
+
+	// Two tokens a second refill
+	if !bucket.allow(limit, now.Add(500*time.Millisecond)) {
+		t.Errorf("expected a token after half a second")
+	}
+	if bucket.allow(limit, now.Add(600*time.Millisecond)) {
+		t.Errorf("expected no token a tenth of a second later")
+	}
+
+	// Never more than the burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		bucket.allow(limit, later)
+	}
+	if bucket.allow(limit, later) {
+		t.Errorf("expected the refill to stop at the burst")
+	}
+}
+
+func TestBanList(t *testing.T) {
+	limits := Limits{Strikes: 3, StrikeWindow: time.Minute, BanDuration: 10 * time.Minute}
+	bans := newBanList()
+	keys := []string{"ip:1.2.3.4", "visitor:v-1"}
+	now := time.Now()
+
+	// Strikes spread over more than the window do not add up
+	bans.strike(keys, limits, now)
+	bans.strike(keys, limits, now.Add(90*time.Second))
+	if bans.strike(keys, limits, now.Add(100*time.Second)) {
+		t.Fatalf("expected strikes outside the window to be forgotten")
+	}
+	if !bans.strike(keys, limits, now.Add(110*time.Second)) {
+		t.Fatalf("expected the third strike in the window to ban")
+	}
+
+	banTime := now.Add(110 * time.Second)
+	if !bans.banned([]string{"ip:1.2.3.4"}, banTime.Add(time.Minute)) {
+		t.Errorf("expected the IP to be banned")
+	}
+	if !bans.banned([]string{"ip:5.6.7.8", "visitor:v-1"}, banTime.Add(time.Minute)) {
+		t.Errorf("expected the visitor to be banned from another IP")
+	}
+	if bans.banned(keys, banTime.Add(11*time.Minute)) {
+		t.Errorf("expected the ban to expire")
+	}
+}
+
+func TestClient_BanKeysIgnoreForwardedHeaders(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	r.Header.Set("X-Real-IP", "203.0.113.9")
+
+	client := newVisitorClient(NewHub(), nil, r, Visitor{SessionID: "s-1", VisitorID: "v-1", IP: "192.0.2.1"})
+	keys := client.banKeys()
+	if len(keys) != 2 || keys[0] != "ip:192.0.2.1" || keys[1] != "visitor:v-1" {
+		t.Errorf("banKeys() = %v, want the visitor's resolved IP", keys)
+	}
+
+	// Without an address, visitors are only banned by their own ID
+	client = newVisitorClient(NewHub(), nil, r, Visitor{SessionID: "s-2"})
+	if keys := client.banKeys(); len(keys) != 0 {
+		t.Errorf("banKeys() = %v, want none", keys)
+	}
+}
+
+// limitHits collects the hits the hub reports
+type limitHits struct {
+	mu   sync.Mutex
+	hits []LimitHit
+}
+
+func (l *limitHits) record(hit LimitHit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hits = append(l.hits, hit)
+}
+
+// wait waits for a hit of the given limit
+func (l *limitHits) wait(t *testing.T, limit string) LimitHit {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		for _, hit := range l.hits {
+			if hit.Limit == limit {
+				l.mu.Unlock()
+				return hit
+			}
+		}
+		l.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected a %s limit hit", limit)
+	return LimitHit{}
+}
+
+// expectClose reads until the hub closes the connection, which must be for
+// the given reason
+func expectClose(t *testing.T, conn *websocket.Conn, code int, reason string) {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != code || closeErr.Text != reason {
+			t.Fatalf("expected a %q close, got %v", reason, err)
+		}
+		return
+	}
+}
+
+func TestHub_RateLimitsAndBansVisitors(t *testing.T) {
+	server, hub := newTestServer(t, newFakeStore())
+	limits := DefaultLimits()
+	limits.Messages["typing_start"] = MessageLimit{Rate: 0.001, Burst: 2}
+	limits.Strikes = 3
+	hub.SetLimits(limits)
+	hits := &limitHits{}
+	hub.SetLimitHandler(hits.record)
+
+	visitor := dial(t, server, "/visitor")
+	visitor.send("typing_start", nil)
+	visitor.send("typing_start", nil)
+	visitor.send("typing_start", nil)
+	rejected := visitor.expect("error")
+	if rejected["type"] != "typing_start" || rejected["error"] != "rate limit exceeded" {
+		t.Fatalf("unexpected error %v", rejected)
+	}
+	hit := hits.wait(t, LimitMessageRate)
+	if hit.WebsiteID != testWebsiteID || hit.SessionID != testSessionID || hit.MessageType != "typing_start" {
+		t.Errorf("unexpected hit %+v", hit)
+	}
+
+	// Other message types have their own buckets
+	visitor.send("chat_message", map[string]interface{}{"client_message_id": "c-1", "content": "Hello"})
+	visitor.expect("ack")
+
+	// Repeat offenders are banned and disconnected
+	visitor.send("typing_start", nil)
+	visitor.send("typing_start", nil)
+	expectClose(t, visitor.conn, websocket.ClosePolicyViolation, closeBanned)
+	hits.wait(t, LimitBanned)
+
+	// and cannot come straight back
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/visitor"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	expectClose(t, conn, websocket.ClosePolicyViolation, closeBanned)
+}
+
+func TestHub_CapsWebsiteConnections(t *testing.T) {
+	hub := NewHub()
+	go hub.Run()
+	hits := &limitHits{}
+	hub.SetLimitHandler(hits.record)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeWS(hub, w, r, Visitor{
+			SessionID:      r.URL.Query().Get("session_id"),
+			WebsiteID:      testWebsiteID,
+			MaxConnections: 1,
+		})
+	}))
+	defer server.Close()
+
+	first := dial(t, server, "/?session_id=first")
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/?session_id=second"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+	expectClose(t, conn, websocket.CloseTryAgainLater, closeTooManyConnections)
+	if hit := hits.wait(t, LimitConnections); hit.SessionID != "second" {
+		t.Errorf("unexpected hit %+v", hit)
+	}
+
+	// The first visitor is unaffected, and their slot frees up when they leave
+	first.send("ping", nil)
+	first.expect("pong")
+	first.conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.GetWebsiteClientCount(testWebsiteID) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	dial(t, server, "/?session_id=second")
+}
@@ -0,0 +1,80 @@
+package websocket
+
+import "sync"
+
+// Number of shards the hub spreads websites over
+const numShards = 32
+
+// shard holds the widget visitors of the websites that hash to it. Only the
+// hub's Run goroutine adds or removes clients, under the write lock; senders
+// look clients up under the read lock, so a client they find stays registered
+// and its send channel open until they release it.
+type shard struct {
+	mu sync.RWMutex
+
+	// Visitors grouped by website ID
+	clients map[uint]map[*Client]bool
+
+	// Visitors grouped by session ID
+	sessions map[string]map[*Client]bool
+
+	// Visitors on a fallback transport, by stream ID
+	streams map[string]*Client
+}
+
+func newShard() *shard {
+	return &shard{
+		clients:  make(map[uint]map[*Client]bool),
+		sessions: make(map[string]map[*Client]bool),
+		streams:  make(map[string]*Client),
+	}
+}
+
+// add registers a visitor. Caller must hold s.mu.
+func (s *shard) add(client *Client) {
+	if s.clients[client.WebsiteID] == nil {
+		s.clients[client.WebsiteID] = make(map[*Client]bool)
+	}
+	s.clients[client.WebsiteID][client] = true
+
+	if s.sessions[client.SessionID] == nil {
+		s.sessions[client.SessionID] = make(map[*Client]bool)
+	}
+	s.sessions[client.SessionID][client] = true
+
+	if client.StreamID != "" {
+		s.streams[client.StreamID] = client
+	}
+}
+
+// remove unregisters a visitor and reports whether it was registered. Caller
+// must hold s.mu.
+func (s *shard) remove(client *Client) bool {
+	websiteClients, ok := s.clients[client.WebsiteID]
+	if !ok || !websiteClients[client] {
+		return false
+	}
+
+	delete(websiteClients, client)
+	// Clean up empty website groups
+	if len(websiteClients) == 0 {
+		delete(s.clients, client.WebsiteID)
+	}
+
+	if sessionClients, ok := s.sessions[client.SessionID]; ok {
+		delete(sessionClients, client)
+		if len(sessionClients) == 0 {
+			delete(s.sessions, client.SessionID)
+		}
+	}
+
+	if client.StreamID != "" && s.streams[client.StreamID] == client {
+		delete(s.streams, client.StreamID)
+	}
+	return true
+}
+
+// has reports whether a visitor is registered. Caller must hold s.mu.
+func (s *shard) has(client *Client) bool {
+	return s.clients[client.WebsiteID][client]
+}
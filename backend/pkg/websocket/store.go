@@ -0,0 +1,376 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Messages resent to a widget that missed more than its session buffer holds
+const resyncHistoryLimit = 50
+
+// Receipt statuses
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// StoredMessage is a chat message as persisted by the MessageStore
+type StoredMessage struct {
+	ID        uint
+	ChatID    uint
+	SessionID string // the visitor's session
+	AgentID   *uint  // the agent the chat is assigned to
+	Sender    string
+	Content   string
+	Timestamp time.Time
+	EditedAt  *time.Time
+	Duplicate bool // stored by an earlier send with the same client message ID
+}
+
+// Receipt reports the messages a side of a chat marked delivered or read
+type Receipt struct {
+	ChatID     uint
+	SessionID  string
+	AgentID    *uint
+	Status     string
+	MessageIDs []uint
+	At         time.Time
+}
+
+// MessageStore persists what visitors and agents send over the hub. Errors are
+// reported back to the client that sent the message.
+type MessageStore interface {
+	SaveVisitorMessage(websiteID uint, sessionID, clientMessageID, content string) (*StoredMessage, error)
+	SaveAgentMessage(userID, chatID uint, clientMessageID, content string) (*StoredMessage, error)
+	EditAgentMessage(userID, chatID, messageID uint, content string) (*StoredMessage, error)
+	DeleteAgentMessage(userID, chatID, messageID uint) (*StoredMessage, error)
+	MarkVisitorReceipt(websiteID uint, sessionID, status string, upToID uint) (*Receipt, error)
+	MarkAgentReceipt(userID, chatID uint, status string, upToID uint) (*Receipt, error)
+
+	// VisitorHistory returns the latest messages of a visitor's chat, oldest
+	// first, for widgets that missed more than the session buffer holds
+	VisitorHistory(websiteID uint, sessionID string, limit int) ([]map[string]interface{}, error)
+}
+
+// clientPayload is the data of a message a client sends. Agents name the
+// chat; a visitor's chat is their session.
+type clientPayload struct {
+	ChatID          uint   `json:"chat_id"`
+	MessageID       uint   `json:"message_id"`
+	ClientMessageID string `json:"client_message_id"`
+	Content         string `json:"content"`
+	LastSeq         int64  `json:"last_seq"`
+}
+
+// SetMessageStore sets where chat messages and receipts are persisted
+func (h *Hub) SetMessageStore(store MessageStore) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.store = store
+}
+
+func (h *Hub) messageStore() MessageStore {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.store
+}
+
+// registerStoreHandlers registers the handlers for messages that are
+// persisted. They run on the sending client's goroutine, which keeps the
+// database off the hub and a client's messages in order.
+func (h *Hub) registerStoreHandlers() {
+	h.storeHandlers["chat_message"] = h.handleChatMessage
+	h.storeHandlers["delivered"] = h.handleReceipt
+	h.storeHandlers["read"] = h.handleReceipt
+	h.storeHandlers["edit_message"] = h.handleEditMessage
+	h.storeHandlers["delete_message"] = h.handleDeleteMessage
+	h.storeHandlers["resume"] = h.handleResume
+}
+
+// decodePayload reads a client message's data
+func decodePayload(message *Message) (*clientPayload, bool) {
+	raw, err := json.Marshal(message.Data)
+	if err != nil {
+		return nil, false
+	}
+	var payload clientPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, false
+	}
+	return &payload, true
+}
+
+// handleChatMessage stores a chat message, acknowledges it to the sender with
+// the stored ID and passes it to the other side of the chat. Resends of a
+// stored message are acknowledged again but not passed on.
+func (h *Hub) handleChatMessage(client *Client, message *Message) {
+	payload, ok := decodePayload(message)
+	if !ok {
+		h.sendError(client, message.Type, "", "invalid message data")
+		return
+	}
+
+	store := h.messageStore()
+	if store == nil {
+		h.sendError(client, message.Type, payload.ClientMessageID, "messages cannot be stored")
+		return
+	}
+
+	var stored *StoredMessage
+	var err error
+	if client.UserID != 0 {
+		stored, err = store.SaveAgentMessage(client.UserID, payload.ChatID, payload.ClientMessageID, payload.Content)
+	} else {
+		stored, err = store.SaveVisitorMessage(client.WebsiteID, client.SessionID, payload.ClientMessageID, payload.Content)
+	}
+	if err != nil {
+		h.sendError(client, message.Type, payload.ClientMessageID, err.Error())
+		return
+	}
+
+	h.sendToClient(client, "ack", map[string]interface{}{
+		"client_message_id": payload.ClientMessageID,
+		"message_id":        stored.ID,
+		"chat_id":           stored.ChatID,
+		"timestamp":         stored.Timestamp.Unix(),
+		"duplicate":         stored.Duplicate,
+	})
+	if stored.Duplicate {
+		return
+	}
+
+	data := map[string]interface{}{
+		"id":         stored.ID,
+		"chat_id":    stored.ChatID,
+		"session_id": stored.SessionID,
+		"content":    stored.Content,
+		"sender":     stored.Sender,
+		"timestamp":  stored.Timestamp.Unix(),
+	}
+
+	if client.UserID != 0 {
+		h.SendToSession(stored.SessionID, "message_received", data)
+		return
+	}
+
+	if stored.AgentID != nil {
+		h.SendToAgent(*stored.AgentID, "message_received", data)
+	}
+
+	// The bot may answer; it looks up replies off the client's goroutine
+	h.mu.RLock()
+	responder := h.botResponder
+	h.mu.RUnlock()
+	if responder != nil {
+		websiteID, sessionID, content := client.WebsiteID, client.SessionID, stored.Content
+		go func() {
+			reply, ok := responder(websiteID, sessionID, content)
+			if !ok {
+				return
+			}
+			h.SendToSession(sessionID, "bot_message", map[string]interface{}{
+				"content":   reply,
+				"timestamp": time.Now().Unix(),
+				"sender":    "bot",
+			})
+		}()
+	}
+}
+
+// handleReceipt marks the other side's messages, up to the given message ID,
+// delivered or read and tells the other side which ones changed
+func (h *Hub) handleReceipt(client *Client, message *Message) {
+	payload, ok := decodePayload(message)
+	if !ok || payload.MessageID == 0 {
+		h.sendError(client, message.Type, "", "invalid receipt data")
+		return
+	}
+
+	store := h.messageStore()
+	if store == nil {
+		return
+	}
+
+	var receipt *Receipt
+	var err error
+	if client.UserID != 0 {
+		receipt, err = store.MarkAgentReceipt(client.UserID, payload.ChatID, message.Type, payload.MessageID)
+	} else {
+		receipt, err = store.MarkVisitorReceipt(client.WebsiteID, client.SessionID, message.Type, payload.MessageID)
+	}
+	if err != nil {
+		h.sendError(client, message.Type, "", err.Error())
+		return
+	}
+	if len(receipt.MessageIDs) == 0 {
+		return
+	}
+
+	data := map[string]interface{}{
+		"chat_id":     receipt.ChatID,
+		"message_ids": receipt.MessageIDs,
+		"timestamp":   receipt.At.Unix(),
+	}
+	if client.UserID != 0 {
+		h.SendToSession(receipt.SessionID, receipt.Status, data)
+	} else if receipt.AgentID != nil {
+		h.SendToAgent(*receipt.AgentID, receipt.Status, data)
+	}
+}
+
+// handleEditMessage lets an agent change the text of a message they sent;
+// the visitor sees the new text
+func (h *Hub) handleEditMessage(client *Client, message *Message) {
+	payload, ok := decodePayload(message)
+	if !ok || client.UserID == 0 || payload.MessageID == 0 {
+		h.sendError(client, message.Type, "", "invalid edit data")
+		return
+	}
+
+	store := h.messageStore()
+	if store == nil {
+		return
+	}
+
+	stored, err := store.EditAgentMessage(client.UserID, payload.ChatID, payload.MessageID, payload.Content)
+	if err != nil {
+		h.sendError(client, message.Type, "", err.Error())
+		return
+	}
+
+	data := map[string]interface{}{
+		"id":        stored.ID,
+		"chat_id":   stored.ChatID,
+		"content":   stored.Content,
+		"edited_at": stored.EditedAt.Unix(),
+	}
+	h.sendToClient(client, "message_edited", data)
+	h.SendToSession(stored.SessionID, "message_edited", data)
+}
+
+// handleDeleteMessage lets an agent delete a message they sent; it is
+// removed from the visitor's chat
+func (h *Hub) handleDeleteMessage(client *Client, message *Message) {
+	payload, ok := decodePayload(message)
+	if !ok || client.UserID == 0 || payload.MessageID == 0 {
+		h.sendError(client, message.Type, "", "invalid delete data")
+		return
+	}
+
+	store := h.messageStore()
+	if store == nil {
+		return
+	}
+
+	stored, err := store.DeleteAgentMessage(client.UserID, payload.ChatID, payload.MessageID)
+	if err != nil {
+		h.sendError(client, message.Type, "", err.Error())
+		return
+	}
+
+	data := map[string]interface{}{
+		"id":      stored.ID,
+		"chat_id": stored.ChatID,
+	}
+	h.sendToClient(client, "message_deleted", data)
+	h.SendToSession(stored.SessionID, "message_deleted", data)
+}
+
+// handleResume catches a reconnecting visitor up on the events pushed to
+// their session after the last one they saw. When some of those events are no
+// longer buffered, the chat history is resent from the store instead.
+func (h *Hub) handleResume(client *Client, message *Message) {
+	payload, ok := decodePayload(message)
+	if !ok || client.UserID != 0 || payload.LastSeq < 0 {
+		h.sendError(client, message.Type, "", "invalid resume data")
+		return
+	}
+
+	// Events pushed while replaying wait, so they arrive after the replay
+	lock := h.sessionLock(client.SessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var latest int64
+	if buffer := h.sessionBuffer(); buffer != nil {
+		events, seq, complete, err := buffer.Since(client.SessionID, payload.LastSeq)
+		if err != nil {
+			log.Printf("Failed to read buffered events for session %s: %v", client.SessionID, err)
+		} else if complete {
+			for _, event := range events {
+				h.deliver(client, event.Data)
+			}
+			h.sendToClient(client, "resumed", map[string]interface{}{
+				"seq":      seq,
+				"replayed": len(events),
+			})
+			return
+		}
+		latest = seq
+	}
+
+	store := h.messageStore()
+	if store == nil {
+		h.sendError(client, message.Type, "", "messages cannot be stored")
+		return
+	}
+
+	history, err := store.VisitorHistory(client.WebsiteID, client.SessionID, resyncHistoryLimit)
+	if err != nil {
+		h.sendError(client, message.Type, "", err.Error())
+		return
+	}
+
+	h.sendToClient(client, "chat_history", map[string]interface{}{
+		"messages": history,
+		"seq":      latest,
+	})
+}
+
+// sendError reports a message the hub could not handle back to its sender
+func (h *Hub) sendError(client *Client, msgType, clientMessageID, reason string) {
+	log.Printf("Rejected %s message: %s", msgType, reason)
+	data := map[string]interface{}{
+		"type":  msgType,
+		"error": reason,
+	}
+	if clientMessageID != "" {
+		data["client_message_id"] = clientMessageID
+	}
+	h.sendToClient(client, "error", data)
+}
+
+// sendToClient pushes a message to one connection while it is registered
+func (h *Hub) sendToClient(client *Client, msgType string, data interface{}) {
+	messageBytes, err := json.Marshal(Message{
+		Type:      msgType,
+		Data:      data,
+		Timestamp: getCurrentTimestamp(),
+	})
+	if err != nil {
+		log.Printf("Error marshaling %s message: %v", msgType, err)
+		return
+	}
+
+	h.deliver(client, messageBytes)
+}
+
+// deliver queues an encoded message on one connection while it is registered
+func (h *Hub) deliver(client *Client, messageBytes []byte) {
+	if client.UserID != 0 {
+		h.agentsMu.RLock()
+		defer h.agentsMu.RUnlock()
+		if h.agents[client.UserID][client] {
+			client.queue(messageBytes)
+		}
+		return
+	}
+
+	shard := h.shard(client.WebsiteID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	if shard.has(client) {
+		client.queue(messageBytes)
+	}
+}
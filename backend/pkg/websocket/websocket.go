@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"encoding/json"
+	"hash/fnv"
 	"log"
 	"net/http"
 	"sync"
@@ -29,17 +30,30 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer
-	maxMessageSize = 512
+	// Maximum message size allowed from peer. Files are uploaded over HTTP,
+	// so this only has to fit a chat message and its envelope.
+	maxMessageSize = 16 * 1024
+
+	// Messages queued for a client before it is evicted as too slow
+	sendBufferSize = 256
+)
+
+// Why the hub closed a client's connection
+const (
+	closeSlowConsumer = "slow consumer"
 )
 
-// Hub maintains the set of active clients and broadcasts messages to the clients
+// Hub maintains the set of active clients and routes messages between them.
+// Its Run goroutine is the only one that registers and unregisters clients
+// and closes their send channels; everything else reads the registry under
+// the read locks and never blocks on a client.
 type Hub struct {
-	// Registered clients grouped by website ID
-	clients map[uint]map[*Client]bool
+	// Widget visitors, sharded by website ID
+	shards [numShards]*shard
 
-	// Inbound messages from the clients
-	broadcast chan *Message
+	// Agent console clients grouped by user ID
+	agents   map[uint]map[*Client]bool
+	agentsMu sync.RWMutex
 
 	// Register requests from the clients
 	register chan *Client
@@ -47,28 +61,81 @@ type Hub struct {
 	// Unregister requests from clients
 	unregister chan *Client
 
-	// Mutex for thread safety
+	// Guards the hub's settings below
 	mu sync.RWMutex
 
-	// Message handlers
+	// Message handlers, run by the sending client
 	messageHandlers map[string]func(*Client, *Message)
+
+	// Handlers for messages that are persisted
+	storeHandlers map[string]func(*Client, *Message)
+
+	// Persists chat messages and receipts
+	store MessageStore
+
+	// Recent events of each visitor session, replayed on reconnect
+	buffer SessionBuffer
+
+	// Serialize numbering and delivery per visitor session, so replayed and
+	// live events reach a reconnecting widget in order
+	sessionLocks [64]sync.Mutex
+
+	// Called when an agent's first console connects or last console disconnects
+	agentPresenceHandler func(userID uint, connected bool)
+
+	// Called with each visitor message; returns an automatic reply, if any
+	botResponder func(websiteID uint, sessionID, content string) (string, bool)
+
+	// Message rate limits and ban thresholds
+	limits Limits
+
+	// Called with each message or connection refused by a limit
+	limitHandler func(hit LimitHit)
+
+	// Visitors banned for hitting the limits too often
+	bans *banList
 }
 
 // Client is a middleman between the websocket connection and the hub
 type Client struct {
 	hub *Hub
 
-	// The websocket connection
+	// The websocket connection, nil on a fallback transport
 	conn *websocket.Conn
 
+	// Identifies a fallback transport's stream to the requests that send on it
+	StreamID  string
+	Transport string
+
+	// Fallback transports: one poll at a time, messages sent in order, and a
+	// polling stream closed once it stops being polled
+	polling sync.Mutex
+	sending sync.Mutex
+	idle    *time.Timer
+
+	// Concurrent visitor connections the website allows; zero for no cap
+	maxConnections int
+
+	// Rate limits of the messages the client sends, by type
+	buckets   map[string]*tokenBucket
+	bucketsMu sync.Mutex
+
+	// Closed when the hub evicts the client; closeCode and closeReason say why
+	done        chan struct{}
+	evictOnce   sync.Once
+	closeCode   int
+	closeReason string
+
 	// Buffered channel of outbound messages
 	send chan []byte
 
 	// Client metadata
 	SessionID string
 	WebsiteID uint
+	VisitorID string
+	UserID    uint // set for agent consoles, zero for widget visitors
 	UserAgent string
-	IP        string
+	IP        string // set for widget visitors
 	Language  string
 	ConnectedAt time.Time
 
@@ -84,6 +151,7 @@ type Message struct {
 	SessionID string      `json:"session_id,omitempty"`
 	WebsiteID uint        `json:"website_id,omitempty"`
 	Timestamp int64       `json:"timestamp"`
+	Seq       int64       `json:"seq,omitempty"` // numbers the events pushed to a visitor session
 	Client    *Client     `json:"-"` // Reference to sender client
 }
 
@@ -98,22 +166,28 @@ type ChatMessage struct {
 // NewHub creates a new Hub
 func NewHub() *Hub {
 	hub := &Hub{
-		broadcast:       make(chan *Message),
 		register:        make(chan *Client),
 		unregister:      make(chan *Client),
-		clients:         make(map[uint]map[*Client]bool),
+		agents:          make(map[uint]map[*Client]bool),
 		messageHandlers: make(map[string]func(*Client, *Message)),
+		storeHandlers:   make(map[string]func(*Client, *Message)),
+		buffer:          NewMemoryBuffer(DefaultSessionBufferSize, DefaultSessionBufferTTL),
+		limits:          DefaultLimits(),
+		bans:            newBanList(),
+	}
+	for i := range hub.shards {
+		hub.shards[i] = newShard()
 	}
 
 	// Register default message handlers
 	hub.registerMessageHandlers()
+	hub.registerStoreHandlers()
 	
 	return hub
 }
 
 // registerMessageHandlers registers default message handlers
 func (h *Hub) registerMessageHandlers() {
-	h.messageHandlers["chat_message"] = h.handleChatMessage
 	h.messageHandlers["typing_start"] = h.handleTypingStart
 	h.messageHandlers["typing_stop"] = h.handleTypingStop
 	h.messageHandlers["join_chat"] = h.handleJoinChat
@@ -121,7 +195,8 @@ func (h *Hub) registerMessageHandlers() {
 	h.messageHandlers["ping"] = h.handlePing
 }
 
-// Run starts the hub
+// Run starts the hub. It owns the lifecycle of the clients; messages are
+// handled by the clients that send them.
 func (h *Hub) Run() {
 	for {
 		select {
@@ -130,100 +205,152 @@ func (h *Hub) Run() {
 
 		case client := <-h.unregister:
 			h.unregisterClient(client)
-
-		case message := <-h.broadcast:
-			h.handleBroadcast(message)
 		}
 	}
 }
 
-// registerClient registers a new client
-func (h *Hub) registerClient(client *Client) {
+// shard returns the shard holding a website's visitors
+func (h *Hub) shard(websiteID uint) *shard {
+	return h.shards[websiteID%numShards]
+}
+
+// SetAgentPresenceHandler sets the callback for agent console connection changes
+func (h *Hub) SetAgentPresenceHandler(handler func(userID uint, connected bool)) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.agentPresenceHandler = handler
+}
+
+// SetBotResponder sets the callback that lets the bot answer visitor messages
+func (h *Hub) SetBotResponder(responder func(websiteID uint, sessionID, content string) (string, bool)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.botResponder = responder
+}
 
-	if h.clients[client.WebsiteID] == nil {
-		h.clients[client.WebsiteID] = make(map[*Client]bool)
+// registerClient registers a new client
+func (h *Hub) registerClient(client *Client) {
+	if client.UserID != 0 {
+		h.registerAgent(client)
+		return
+	}
+
+	welcome := map[string]interface{}{
+		"session_id": client.SessionID,
+		"timestamp":  time.Now().Unix(),
 	}
-	h.clients[client.WebsiteID][client] = true
+	if client.StreamID != "" {
+		welcome["stream_id"] = client.StreamID
+		welcome["transport"] = client.Transport
+	}
+
+	shard := h.shard(client.WebsiteID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if !h.admit(client, shard) {
+		return
+	}
+	shard.add(client)
 
 	log.Printf("Client registered: %s for website %d", client.SessionID, client.WebsiteID)
 
 	// Send welcome message
-	client.SendMessage("connection_established", map[string]interface{}{
-		"session_id": client.SessionID,
-		"timestamp":  time.Now().Unix(),
+	client.queueMessage("connection_established", welcome)
+}
+
+// registerAgent registers an agent console
+func (h *Hub) registerAgent(client *Client) {
+	h.agentsMu.Lock()
+	defer h.agentsMu.Unlock()
+
+	first := len(h.agents[client.UserID]) == 0
+	if first {
+		h.agents[client.UserID] = make(map[*Client]bool)
+	}
+	h.agents[client.UserID][client] = true
+
+	log.Printf("Agent console registered for user %d", client.UserID)
+
+	client.queueMessage("connection_established", map[string]interface{}{
+		"user_id":   client.UserID,
+		"timestamp": time.Now().Unix(),
 	})
+
+	// The handler touches the database, so keep it off the hub goroutine
+	if handler := h.presenceHandler(); first && handler != nil {
+		go handler(client.UserID, true)
+	}
 }
 
 // unregisterClient unregisters a client
 func (h *Hub) unregisterClient(client *Client) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	if client.UserID != 0 {
+		h.unregisterAgent(client)
+		return
+	}
 
-	if websiteClients, ok := h.clients[client.WebsiteID]; ok {
-		if _, ok := websiteClients[client]; ok {
-			delete(websiteClients, client)
-			close(client.send)
+	shard := h.shard(client.WebsiteID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
 
-			// Clean up empty website groups
-			if len(websiteClients) == 0 {
-				delete(h.clients, client.WebsiteID)
-			}
-		}
+	if !shard.remove(client) {
+		return
 	}
+	close(client.send)
 
 	log.Printf("Client unregistered: %s from website %d", client.SessionID, client.WebsiteID)
 }
 
-// handleBroadcast handles message broadcasting
-func (h *Hub) handleBroadcast(message *Message) {
+// unregisterAgent unregisters an agent console
+func (h *Hub) unregisterAgent(client *Client) {
+	h.agentsMu.Lock()
+	defer h.agentsMu.Unlock()
+
+	consoles, ok := h.agents[client.UserID]
+	if !ok {
+		return
+	}
+	if _, ok := consoles[client]; !ok {
+		return
+	}
+
+	delete(consoles, client)
+	close(client.send)
+
+	log.Printf("Agent console unregistered for user %d", client.UserID)
+
+	if len(consoles) == 0 {
+		delete(h.agents, client.UserID)
+		if handler := h.presenceHandler(); handler != nil {
+			go handler(client.UserID, false)
+		}
+	}
+}
+
+func (h *Hub) presenceHandler() func(userID uint, connected bool) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
+	return h.agentPresenceHandler
+}
+
+// handleMessage runs the handler for a message a client sent
+func (h *Hub) handleMessage(client *Client, message *Message) {
+	// Agent consoles only send stored messages; these handlers assume a widget visitor
+	if client.UserID != 0 && message.Type != "ping" {
+		log.Printf("Ignoring %s message from agent console %d", message.Type, client.UserID)
+		return
+	}
 
 	// Handle message based on type
 	if handler, exists := h.messageHandlers[message.Type]; exists {
-		handler(message.Client, message)
+		handler(client, message)
 	} else {
 		log.Printf("Unknown message type: %s", message.Type)
 	}
 }
 
 // Message handlers
-func (h *Hub) handleChatMessage(client *Client, message *Message) {
-	// Extract message data
-	data, ok := message.Data.(map[string]interface{})
-	if !ok {
-		log.Printf("Invalid message data format from %s", client.SessionID)
-		return
-	}
-	
-	content, ok := data["content"].(string)
-	if !ok || content == "" {
-		log.Printf("Invalid message content from %s", client.SessionID)
-		return
-	}
-	
-	// TODO: Save message to database using ChatService
-	// For now, just log and broadcast
-	log.Printf("Chat message from %s: %s", client.SessionID, content)
-	
-	// Create response message
-	responseMessage := &Message{
-		Type: "message_received",
-		Data: map[string]interface{}{
-			"session_id": client.SessionID,
-			"content":    content,
-			"timestamp":  time.Now().Unix(),
-			"sender":     "user",
-		},
-		Timestamp: time.Now().Unix(),
-	}
-	
-	// Broadcast to all clients in the same website
-	h.broadcastToWebsite(client.WebsiteID, responseMessage)
-}
-
 func (h *Hub) handleTypingStart(client *Client, message *Message) {
 	// Broadcast typing indicator to other clients
 	typingMessage := &Message{
@@ -275,105 +402,206 @@ func (h *Hub) handlePing(client *Client, message *Message) {
 
 // BroadcastToSession sends a message to a specific session
 func (h *Hub) BroadcastToSession(sessionID string, message []byte) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	// Sessions are not keyed by website, so look in every shard
+	for _, shard := range h.shards {
+		shard.mu.RLock()
+		for client := range shard.sessions[sessionID] {
+			client.queue(message)
+		}
+		shard.mu.RUnlock()
+	}
+}
 
-	for _, websiteClients := range h.clients {
-		for client := range websiteClients {
-			if client.SessionID == sessionID {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(websiteClients, client)
-				}
-				return
-			}
+// SendToSession pushes a message to a widget visitor's connection. Messages
+// are numbered and buffered first, so a visitor who is reconnecting gets them
+// when the session resumes.
+func (h *Hub) SendToSession(sessionID string, msgType string, data interface{}) {
+	lock := h.sessionLock(sessionID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	message := Message{
+		Type:      msgType,
+		Data:      data,
+		SessionID: sessionID,
+		Timestamp: getCurrentTimestamp(),
+	}
+
+	buffer := h.sessionBuffer()
+	if buffer != nil {
+		seq, err := buffer.NextSeq(sessionID)
+		if err != nil {
+			log.Printf("Failed to number %s message for session %s: %v", msgType, sessionID, err)
+		}
+		message.Seq = seq
+	}
+
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling session message: %v", err)
+		return
+	}
+
+	if message.Seq != 0 {
+		if err := buffer.Add(sessionID, BufferedEvent{Seq: message.Seq, Data: messageBytes}); err != nil {
+			log.Printf("Failed to buffer %s message for session %s: %v", msgType, sessionID, err)
 		}
 	}
+
+	h.BroadcastToSession(sessionID, messageBytes)
+}
+
+// SetSessionBuffer sets where the events of visitor sessions are kept for
+// replay; nil turns replay off
+func (h *Hub) SetSessionBuffer(buffer SessionBuffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buffer = buffer
+}
+
+func (h *Hub) sessionBuffer() SessionBuffer {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.buffer
+}
+
+func (h *Hub) sessionLock(sessionID string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(sessionID))
+	return &h.sessionLocks[hash.Sum32()%uint32(len(h.sessionLocks))]
 }
 
 // broadcastToWebsite sends a message to all clients of a website
 func (h *Hub) broadcastToWebsite(websiteID uint, message *Message) {
-	if websiteClients, ok := h.clients[websiteID]; ok {
-		messageBytes, _ := json.Marshal(message)
-		for client := range websiteClients {
-			select {
-			case client.send <- messageBytes:
-			default:
-				close(client.send)
-				delete(websiteClients, client)
-			}
-		}
-	}
+	h.broadcastToWebsiteExcept(websiteID, nil, message)
 }
 
 // broadcastToWebsiteExcept sends a message to all clients of a website except one
 func (h *Hub) broadcastToWebsiteExcept(websiteID uint, exceptClient *Client, message *Message) {
-	if websiteClients, ok := h.clients[websiteID]; ok {
-		messageBytes, _ := json.Marshal(message)
-		for client := range websiteClients {
-			if client != exceptClient {
-				select {
-				case client.send <- messageBytes:
-				default:
-					close(client.send)
-					delete(websiteClients, client)
-				}
-			}
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling %s message: %v", message.Type, err)
+		return
+	}
+
+	shard := h.shard(websiteID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	for client := range shard.clients[websiteID] {
+		if client != exceptClient {
+			client.queue(messageBytes)
 		}
 	}
 }
 
+// SendToAgent pushes a message to every console an agent has open
+func (h *Hub) SendToAgent(userID uint, msgType string, data interface{}) {
+	messageBytes, err := json.Marshal(Message{
+		Type:      msgType,
+		Data:      data,
+		Timestamp: getCurrentTimestamp(),
+	})
+	if err != nil {
+		log.Printf("Error marshaling agent message: %v", err)
+		return
+	}
+
+	h.agentsMu.RLock()
+	defer h.agentsMu.RUnlock()
+
+	for client := range h.agents[userID] {
+		client.queue(messageBytes)
+	}
+}
+
+// IsAgentConnected reports whether an agent has a console open
+func (h *Hub) IsAgentConnected(userID uint) bool {
+	h.agentsMu.RLock()
+	defer h.agentsMu.RUnlock()
+	return len(h.agents[userID]) > 0
+}
+
 // GetClientCount returns the number of connected clients
 func (h *Hub) GetClientCount() int {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	
 	total := 0
-	for _, websiteClients := range h.clients {
-		total += len(websiteClients)
+	for _, shard := range h.shards {
+		shard.mu.RLock()
+		for _, websiteClients := range shard.clients {
+			total += len(websiteClients)
+		}
+		shard.mu.RUnlock()
 	}
 	return total
 }
 
 // GetWebsiteClientCount returns the number of connected clients for a website
 func (h *Hub) GetWebsiteClientCount(websiteID uint) int {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	
-	if websiteClients, ok := h.clients[websiteID]; ok {
-		return len(websiteClients)
-	}
-	return 0
+	shard := h.shard(websiteID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return len(shard.clients[websiteID])
 }
 
 // ServeWS handles websocket requests from the peer
-func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request, sessionID string, websiteID uint) {
+func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request, visitor Visitor) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("WebSocket upgrade error:", err)
+		return
+	}
+
+	client := newVisitorClient(hub, conn, r, visitor)
+	client.Transport = TransportWebSocket
+
+	client.hub.register <- client
+
+	// Allow collection of memory referenced by the caller by doing all work in new goroutines
+	go client.writePump()
+	go client.readPump()
+}
+
+// ServeAgentWS handles websocket requests from an authenticated agent console
+func ServeAgentWS(hub *Hub, w http.ResponseWriter, r *http.Request, userID uint) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
 		return
 	}
 
-	client := &Client{
+	client := newClient(hub, conn, r)
+	client.UserID = userID
+
+	client.hub.register <- client
+
+	go client.writePump()
+	go client.readPump()
+}
+
+// newClient creates a client for a request; callers say who it is
+func newClient(hub *Hub, conn *websocket.Conn, r *http.Request) *Client {
+	return &Client{
 		hub:         hub,
 		conn:        conn,
-		send:        make(chan []byte, 256),
-		SessionID:   sessionID,
-		WebsiteID:   websiteID,
+		send:        make(chan []byte, sendBufferSize),
+		done:        make(chan struct{}),
+		buckets:     make(map[string]*tokenBucket),
 		UserAgent:   r.UserAgent(),
-		IP:          getClientIP(r),
 		Language:    r.Header.Get("Accept-Language"),
 		ConnectedAt: time.Now(),
 		isActive:    true,
 	}
+}
 
-	client.hub.register <- client
-
-	// Allow collection of memory referenced by the caller by doing all work in new goroutines
-	go client.writePump()
-	go client.readPump()
+// newVisitorClient creates a widget visitor's client
+func newVisitorClient(hub *Hub, conn *websocket.Conn, r *http.Request, visitor Visitor) *Client {
+	client := newClient(hub, conn, r)
+	client.SessionID = visitor.SessionID
+	client.WebsiteID = visitor.WebsiteID
+	client.VisitorID = visitor.VisitorID
+	client.IP = visitor.IP
+	client.maxConnections = visitor.MaxConnections
+	return client
 }
 
 // readPump pumps messages from the websocket connection to the hub
@@ -406,15 +634,28 @@ func (c *Client) readPump() {
 			continue
 		}
 
-		// Add metadata to message
-		msg.SessionID = c.SessionID
-		msg.WebsiteID = c.WebsiteID
-		msg.Timestamp = time.Now().Unix()
-		msg.Client = c
+		c.dispatch(&msg)
+	}
+}
 
-		// Send to hub for processing
-		c.hub.broadcast <- &msg
+// dispatch routes a message the client sent, whatever its transport
+func (c *Client) dispatch(msg *Message) {
+	// Add metadata to message
+	msg.SessionID = c.SessionID
+	msg.WebsiteID = c.WebsiteID
+	msg.Timestamp = time.Now().Unix()
+	msg.Client = c
+
+	if !c.hub.allowMessage(c, msg) {
+		return
+	}
+
+	// Handlers run here, so a slow one only holds up its own client
+	if handler, ok := c.hub.storeHandlers[msg.Type]; ok {
+		handler(c, msg)
+		return
 	}
+	c.hub.handleMessage(c, msg)
 }
 
 // writePump pumps messages from the hub to the websocket connection
@@ -430,7 +671,7 @@ func (c *Client) writePump() {
 		case message, ok := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeMessage())
 				return
 			}
 
@@ -439,6 +680,12 @@ func (c *Client) writePump() {
 				return
 			}
 
+		case <-c.done:
+			// Evicted; tell the peer why before hanging up
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, c.closeMessage())
+			return
+
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -448,6 +695,70 @@ func (c *Client) writePump() {
 	}
 }
 
+// queue hands a message to the client's writer without blocking. A client
+// that has fallen a whole buffer behind is evicted rather than holding up the
+// sender. Callers must have found the client registered, under its lock, so
+// the send channel is still open.
+func (c *Client) queue(message []byte) bool {
+	select {
+	case c.send <- message:
+		return true
+	default:
+		c.evict(websocket.CloseTryAgainLater, closeSlowConsumer)
+		return false
+	}
+}
+
+// queueMessage encodes and queues a message; see queue
+func (c *Client) queueMessage(msgType string, data interface{}) error {
+	message, err := json.Marshal(Message{
+		Type:      msgType,
+		Data:      data,
+		Timestamp: getCurrentTimestamp(),
+	})
+	if err != nil {
+		return err
+	}
+	c.queue(message)
+	return nil
+}
+
+// closeMessage is the close frame for the client: the eviction reason, if any
+func (c *Client) closeMessage() []byte {
+	select {
+	case <-c.done:
+		return websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+	default:
+		return []byte{}
+	}
+}
+
+// reason says why the hub closed the client, if it did
+func (c *Client) reason() string {
+	select {
+	case <-c.done:
+		return c.closeReason
+	default:
+		return ""
+	}
+}
+
+// evict disconnects the client with a close code and reason. Its writer
+// sends the reason, and the hub unregisters it.
+func (c *Client) evict(code int, reason string) {
+	c.evictOnce.Do(func() {
+		log.Printf("Evicting client %s (user %d): %s", c.SessionID, c.UserID, reason)
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+
+		// The caller may be the hub itself, or hold a lock the hub needs
+		go func() {
+			c.hub.unregister <- c
+		}()
+	})
+}
+
 // IsActive returns whether the client is active
 func (c *Client) IsActive() bool {
 	c.mu.RLock()
@@ -462,7 +773,7 @@ func (c *Client) SetActive(active bool) {
 	c.isActive = active
 }
 
-// SendMessage sends a message to the client
+// SendMessage sends a message to the client while it is registered
 func (c *Client) SendMessage(msgType string, data interface{}) error {
 	msg := Message{
 		Type:      msgType,
@@ -475,28 +786,11 @@ func (c *Client) SendMessage(msgType string, data interface{}) error {
 		return err
 	}
 
-	select {
-	case c.send <- message:
-	default:
-		close(c.send)
-		return err
-	}
-
+	c.hub.deliver(c, message)
 	return nil
 }
 
 // Helper functions
-func getClientIP(r *http.Request) string {
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip == "" {
-		ip = r.Header.Get("X-Real-IP")
-	}
-	if ip == "" {
-		ip = r.RemoteAddr
-	}
-	return ip
-}
-
 func getCurrentTimestamp() int64 {
 	return time.Now().Unix()
 }
\ No newline at end of file
@@ -0,0 +1,388 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	testWebsiteID = 1
+	testChatID    = 10
+	testSessionID = "session-1"
+	testAgentID   = 7
+)
+
+var testVisitor = Visitor{SessionID: testSessionID, WebsiteID: testWebsiteID, IP: "192.0.2.1"}
+
+// fakeStore keeps one chat in memory
+type fakeStore struct {
+	mu       sync.Mutex
+	messages []*StoredMessage
+	clientID map[string]*StoredMessage
+	readUpTo uint
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{clientID: make(map[string]*StoredMessage)}
+}
+
+func (s *fakeStore) save(sender, clientMessageID, content string) (*StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if content == "" {
+		return nil, errors.New("message content cannot be empty")
+	}
+	if existing, ok := s.clientID[clientMessageID]; ok {
+		duplicate := *existing
+		duplicate.Duplicate = true
+		return &duplicate, nil
+	}
+
+	agentID := uint(testAgentID)
+	message := &StoredMessage{
+		ID:        uint(len(s.messages) + 1),
+		ChatID:    testChatID,
+		SessionID: testSessionID,
+		AgentID:   &agentID,
+		Sender:    sender,
+		Content:   content,
+		Timestamp: time.Now(),
+	}
+	s.messages = append(s.messages, message)
+	if clientMessageID != "" {
+		s.clientID[clientMessageID] = message
+	}
+	return message, nil
+}
+
+func (s *fakeStore) SaveVisitorMessage(websiteID uint, sessionID, clientMessageID, content string) (*StoredMessage, error) {
+	return s.save("user", clientMessageID, content)
+}
+
+func (s *fakeStore) SaveAgentMessage(userID, chatID uint, clientMessageID, content string) (*StoredMessage, error) {
+	if chatID != testChatID {
+		return nil, errors.New("chat not found or access denied")
+	}
+	return s.save("agent", clientMessageID, content)
+}
+
+func (s *fakeStore) agentMessage(userID, messageID uint) (*StoredMessage, error) {
+	if messageID == 0 || int(messageID) > len(s.messages) || s.messages[messageID-1].Sender != "agent" {
+		return nil, errors.New("message not found")
+	}
+	return s.messages[messageID-1], nil
+}
+
+func (s *fakeStore) EditAgentMessage(userID, chatID, messageID uint, content string) (*StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, err := s.agentMessage(userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	message.Content = content
+	message.EditedAt = &now
+	return message, nil
+}
+
+func (s *fakeStore) DeleteAgentMessage(userID, chatID, messageID uint) (*StoredMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.agentMessage(userID, messageID)
+}
+
+func (s *fakeStore) receipt(status string, upToID uint, sender string) *Receipt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agentID := uint(testAgentID)
+	receipt := &Receipt{ChatID: testChatID, SessionID: testSessionID, AgentID: &agentID, Status: status, At: time.Now()}
+	for _, message := range s.messages {
+		if message.ID > s.readUpTo && message.ID <= upToID && message.Sender == sender {
+			receipt.MessageIDs = append(receipt.MessageIDs, message.ID)
+		}
+	}
+	if upToID > s.readUpTo {
+		s.readUpTo = upToID
+	}
+	return receipt
+}
+
+func (s *fakeStore) MarkVisitorReceipt(websiteID uint, sessionID, status string, upToID uint) (*Receipt, error) {
+	return s.receipt(status, upToID, "agent"), nil
+}
+
+func (s *fakeStore) MarkAgentReceipt(userID, chatID uint, status string, upToID uint) (*Receipt, error) {
+	return s.receipt(status, upToID, "user"), nil
+}
+
+func (s *fakeStore) VisitorHistory(websiteID uint, sessionID string, limit int) ([]map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := []map[string]interface{}{}
+	for _, message := range s.messages {
+		history = append(history, map[string]interface{}{"id": message.ID, "content": message.Content, "sender": message.Sender})
+	}
+	return history, nil
+}
+
+// testConn is a client connection to the hub under test
+type testConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+	seq  int64 // sequence of the last message read
+}
+
+func newTestServer(t *testing.T, store MessageStore) (*httptest.Server, *Hub) {
+	t.Helper()
+
+	hub := NewHub()
+	if store != nil {
+		hub.SetMessageStore(store)
+	}
+	go hub.Run()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/agent":
+			ServeAgentWS(hub, w, r, testAgentID)
+			return
+		case "/stream":
+			ServeSSE(hub, w, r, testVisitor)
+			return
+		case "/poll":
+			ServePoll(hub, w, r, testVisitor, r.URL.Query().Get("stream_id"))
+			return
+		case "/send":
+			visitor := testVisitor
+			if sessionID := r.URL.Query().Get("session_id"); sessionID != "" {
+				visitor.SessionID = sessionID
+			}
+			ServeSend(hub, w, r, visitor, r.URL.Query().Get("stream_id"))
+			return
+		}
+		ServeWS(hub, w, r, testVisitor)
+	}))
+	t.Cleanup(server.Close)
+	return server, hub
+}
+
+func dial(t *testing.T, server *httptest.Server, path string) *testConn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", path, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testConn{t: t, conn: conn}
+	c.expect("connection_established")
+	return c
+}
+
+func (c *testConn) send(msgType string, data map[string]interface{}) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(map[string]interface{}{"type": msgType, "data": data}); err != nil {
+		c.t.Fatalf("failed to send %s: %v", msgType, err)
+	}
+}
+
+// expect reads the next message, which must be of the given type
+func (c *testConn) expect(msgType string) map[string]interface{} {
+	c.t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg struct {
+		Type string                 `json:"type"`
+		Data map[string]interface{} `json:"data"`
+		Seq  int64                  `json:"seq"`
+	}
+	if err := c.conn.ReadJSON(&msg); err != nil {
+		c.t.Fatalf("failed to read %s message: %v", msgType, err)
+	}
+	if msg.Type != msgType {
+		c.t.Fatalf("expected a %s message, got %s %v", msgType, msg.Type, msg.Data)
+	}
+	c.seq = msg.Seq
+	return msg.Data
+}
+
+func TestHub_MessageAcksAndResends(t *testing.T) {
+	server, _ := newTestServer(t, newFakeStore())
+	visitor := dial(t, server, "/visitor")
+	agent := dial(t, server, "/agent")
+
+	visitor.send("chat_message", map[string]interface{}{"content": "Hello?", "client_message_id": "c-1"})
+	ack := visitor.expect("ack")
+	if ack["client_message_id"] != "c-1" || ack["message_id"] != float64(1) || ack["duplicate"] != false {
+		t.Fatalf("unexpected ack %v", ack)
+	}
+	received := agent.expect("message_received")
+	if received["id"] != float64(1) || received["content"] != "Hello?" || received["chat_id"] != float64(testChatID) {
+		t.Fatalf("unexpected message for the agent %v", received)
+	}
+
+	// A resend is acknowledged with the stored ID but not passed on again
+	visitor.send("chat_message", map[string]interface{}{"content": "Hello?", "client_message_id": "c-1"})
+	ack = visitor.expect("ack")
+	if ack["message_id"] != float64(1) || ack["duplicate"] != true {
+		t.Fatalf("unexpected resend ack %v", ack)
+	}
+
+	visitor.send("chat_message", map[string]interface{}{"content": "", "client_message_id": "c-2"})
+	rejected := visitor.expect("error")
+	if rejected["client_message_id"] != "c-2" || rejected["type"] != "chat_message" {
+		t.Fatalf("unexpected error %v", rejected)
+	}
+
+	agent.send("chat_message", map[string]interface{}{"chat_id": testChatID, "content": "Hi there", "client_message_id": "a-1"})
+	ack = agent.expect("ack")
+	if ack["message_id"] != float64(2) {
+		t.Fatalf("unexpected agent ack %v", ack)
+	}
+	received = visitor.expect("message_received")
+	if received["id"] != float64(2) || received["sender"] != "agent" || received["content"] != "Hi there" {
+		t.Fatalf("unexpected message for the visitor %v", received)
+	}
+
+	// Had the resend been passed on, the agent would read it before this error
+	agent.send("chat_message", map[string]interface{}{"chat_id": 99, "content": "Wrong chat"})
+	agent.expect("error")
+}
+
+func TestHub_ReceiptsEditsAndDeletes(t *testing.T) {
+	server, _ := newTestServer(t, newFakeStore())
+	visitor := dial(t, server, "/visitor")
+	agent := dial(t, server, "/agent")
+
+	agent.send("chat_message", map[string]interface{}{"chat_id": testChatID, "content": "Hi there", "client_message_id": "a-1"})
+	agent.expect("ack")
+	visitor.expect("message_received")
+
+	visitor.send("read", map[string]interface{}{"message_id": 1})
+	receipt := agent.expect("read")
+	ids, ok := receipt["message_ids"].([]interface{})
+	if !ok || len(ids) != 1 || ids[0] != float64(1) {
+		t.Fatalf("unexpected read receipt %v", receipt)
+	}
+
+	agent.send("edit_message", map[string]interface{}{"chat_id": testChatID, "message_id": 1, "content": "Hi!"})
+	if edited := agent.expect("message_edited"); edited["content"] != "Hi!" {
+		t.Fatalf("unexpected edit confirmation %v", edited)
+	}
+	edited := visitor.expect("message_edited")
+	if edited["id"] != float64(1) || edited["content"] != "Hi!" || edited["edited_at"] == nil {
+		t.Fatalf("unexpected edit for the visitor %v", edited)
+	}
+
+	agent.send("delete_message", map[string]interface{}{"chat_id": testChatID, "message_id": 1})
+	agent.expect("message_deleted")
+	if deleted := visitor.expect("message_deleted"); deleted["id"] != float64(1) {
+		t.Fatalf("unexpected delete for the visitor %v", deleted)
+	}
+
+	// Visitors cannot edit, and agents only change their own messages
+	visitor.send("edit_message", map[string]interface{}{"message_id": 1, "content": "Changed"})
+	visitor.expect("error")
+	visitor.send("chat_message", map[string]interface{}{"content": "Thanks", "client_message_id": "c-1"})
+	visitor.expect("ack")
+	agent.expect("message_received")
+	agent.send("delete_message", map[string]interface{}{"chat_id": testChatID, "message_id": 2})
+	if rejected := agent.expect("error"); rejected["error"] != "message not found" {
+		t.Fatalf("unexpected error %v", rejected)
+	}
+}
+
+func TestHub_WithoutStore(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+	visitor := dial(t, server, "/visitor")
+
+	visitor.send("chat_message", map[string]interface{}{"content": "Hello?", "client_message_id": "c-1"})
+	if rejected := visitor.expect("error"); rejected["error"] != "messages cannot be stored" {
+		t.Fatalf("unexpected error %v", rejected)
+	}
+
+	// Other messages still go through the hub
+	visitor.send("ping", nil)
+	visitor.expect("pong")
+}
+
+func TestHub_ResumeReplaysMissedEvents(t *testing.T) {
+	server, _ := newTestServer(t, newFakeStore())
+	visitor := dial(t, server, "/visitor")
+	agent := dial(t, server, "/agent")
+
+	agent.send("chat_message", map[string]interface{}{"chat_id": testChatID, "content": "First"})
+	agent.expect("ack")
+	visitor.expect("message_received")
+	if visitor.seq != 1 {
+		t.Fatalf("expected the first session event to be numbered 1, got %d", visitor.seq)
+	}
+
+	// The visitor drops; the agent carries on
+	visitor.conn.Close()
+	for _, content := range []string{"Second", "Third"} {
+		agent.send("chat_message", map[string]interface{}{"chat_id": testChatID, "content": content})
+		agent.expect("ack")
+	}
+
+	visitor = dial(t, server, "/visitor")
+	visitor.send("resume", map[string]interface{}{"last_seq": 1})
+	for i, want := range []string{"Second", "Third"} {
+		replayed := visitor.expect("message_received")
+		if replayed["content"] != want || visitor.seq != int64(i+2) {
+			t.Fatalf("unexpected replayed event %d: %v (seq %d)", i, replayed, visitor.seq)
+		}
+	}
+	resumed := visitor.expect("resumed")
+	if resumed["seq"] != float64(3) || resumed["replayed"] != float64(2) {
+		t.Fatalf("unexpected resume %v", resumed)
+	}
+
+	// Live events carry on from the replayed sequence
+	agent.send("chat_message", map[string]interface{}{"chat_id": testChatID, "content": "Fourth"})
+	agent.expect("ack")
+	visitor.expect("message_received")
+	if visitor.seq != 4 {
+		t.Errorf("expected the next event to be numbered 4, got %d", visitor.seq)
+	}
+}
+
+func TestHub_ResumeResyncsFromStore(t *testing.T) {
+	server, hub := newTestServer(t, newFakeStore())
+	hub.SetSessionBuffer(NewMemoryBuffer(2, time.Hour))
+	visitor := dial(t, server, "/visitor")
+	agent := dial(t, server, "/agent")
+
+	visitor.conn.Close()
+	for _, content := range []string{"First", "Second", "Third"} {
+		agent.send("chat_message", map[string]interface{}{"chat_id": testChatID, "content": content})
+		agent.expect("ack")
+	}
+
+	// The first event is no longer buffered, so the history comes from the store
+	visitor = dial(t, server, "/visitor")
+	visitor.send("resume", map[string]interface{}{"last_seq": 0})
+	history := visitor.expect("chat_history")
+	messages, ok := history["messages"].([]interface{})
+	if !ok || len(messages) != 3 || history["seq"] != float64(3) {
+		t.Fatalf("unexpected history %v", history)
+	}
+
+	// Agents cannot resume visitor sessions
+	agent.send("resume", map[string]interface{}{"last_seq": 0})
+	agent.expect("error")
+}